@@ -35,44 +35,97 @@ document.getElementById('input-text').addEventListener('keypress', function(even
     }
 })
 
+// Version of the native messaging schema, see device/src/message
+const messageVersion = 2
+
+// Show the structured error returned by the native host, if any
+function showError(response) {
+    if (response['error']) {
+        document.getElementById('tpm-message-text').value =
+            response['error']['code'] + ": " + response['error']['message']
+        return true
+    }
+    return false
+}
+
 document.getElementById('get-ak-button').addEventListener('click', function() {
     let query = {
+        "version": messageVersion,
         "query": "get-ak-pub"
     }
     chrome.runtime.sendMessage(query, (response) => {
+        if (showError(response)) {
+            return
+        }
         document.getElementById('ak-text').value = response['ak-pub']
     });
 })
 
-document.getElementById('get-tpm-quote-button').addEventListener('click', function() {
+// Events log and PCR values returned along with the last quote
+let quoteEvidence = {}
+
+// Send a JSON request to the remote verifier
+async function callVerifier(endpoint, request) {
+    let url = document.getElementById('verifier-text').value.trim() + endpoint
+    let response = await fetch(url, {
+        method: "POST",
+        headers: {"Content-Type": "application/json"},
+        body: JSON.stringify(request)
+    })
+    return response.json()
+}
+
+document.getElementById('get-tpm-quote-button').addEventListener('click', async function() {
+    // The verifier picks the nonce the TPM quotes with
+    // The PCRs are those selected at onboarding, unless some are named, e.g.
+    // {"sha256": [0, 7]}
+    let pcrs = document.getElementById('pcrs-text').value.trim()
+    let challenge = await callVerifier("/api/challenge", pcrs ? {"pcr-selection": JSON.parse(pcrs)} : {})
+    if (!challenge['nonce']) {
+        document.getElementById('tpm-message-text').value = challenge['message']
+        return
+    }
+    document.getElementById('pcrs-text').value = JSON.stringify(challenge['pcr-selection'])
+    document.getElementById('nonce-text').value = challenge['nonce']
     let query = {
+        "version": messageVersion,
         "query": "get-tpm-quote",
-        "pcrs": JSON.parse(document.getElementById('pcrs-text').value)
+        "pcr-selection": challenge['pcr-selection'],
+        "nonce": challenge['nonce'],
+        "with-events-log": document.getElementById('with-events-log').checked,
+        "with-pcr-values": document.getElementById('with-events-log').checked,
+        "with-ima-log": document.getElementById('with-ima-log').checked
     }
     chrome.runtime.sendMessage(query, (response) => {
-        document.getElementById('nonce-text').value = JSON.stringify(response['nonce'])
-        document.getElementById('tpm-attestation-text').value = JSON.stringify(response['attestation'])
-        document.getElementById('tpm-signature-text').value = JSON.stringify(response['signature'])
+        if (showError(response)) {
+            return
+        }
+        document.getElementById('tpm-attestation-text').value = response['attestation']
+        document.getElementById('tpm-signature-text').value = response['signature']
+        // Too large to display, forwarded as is to the verifier
+        quoteEvidence = {
+            "events-log": response['events-log'],
+            "pcr-values": response['pcr-values'],
+            "ima-log": response['ima-log']
+        }
     });
 })
 
-document.getElementById('verify-tpm-quote-button').addEventListener('click', function() {
-    let query = {
-        "query": "verify-tpm-quote",
-        "pcrs": JSON.parse(document.getElementById('pcrs-text').value),
-        "nonce": JSON.parse(document.getElementById('nonce-text').value),
-        "attestation": JSON.parse(document.getElementById('tpm-attestation-text').value),
-        "signature": JSON.parse(document.getElementById('tpm-signature-text').value),
-        "ak-pub": document.getElementById('ak-text').value
+document.getElementById('verify-tpm-quote-button').addEventListener('click', async function() {
+    let response = await callVerifier("/api/verify", {
+        "nonce": document.getElementById('nonce-text').value.trim(),
+        "attestation": document.getElementById('tpm-attestation-text').value.trim(),
+        "signature": document.getElementById('tpm-signature-text').value.trim(),
+        "events-log": quoteEvidence['events-log'],
+        "pcr-values": quoteEvidence['pcr-values'],
+        "ima-log": quoteEvidence['ima-log']
+    })
+    if (response['is-legit']) {
+        document.getElementById('verification').value = "OK"
+    } else {
+        document.getElementById('verification').value = "/!\\/!\\/!\\ KO /!\\/!\\/!\\"
     }
-    chrome.runtime.sendMessage(query, (response) => {
-        if (response['is-legit']) {
-            document.getElementById('verification').value = "OK"
-        } else {
-            document.getElementById('verification').value = "/!\\/!\\/!\\ KO /!\\/!\\/!\\"
-        }
-        document.getElementById('tpm-message-text').value = response['message']
-    });
+    document.getElementById('tpm-message-text').value = response['message']
 })
 
 updateUiState()
@@ -2,15 +2,18 @@ module main
 
 go 1.19
 
-require github.com/google/go-attestation v0.4.4-0.20220404204839-8820d49b18d9
+require (
+	github.com/golang/glog v1.0.0
+	github.com/google/go-attestation v0.4.4-0.20220404204839-8820d49b18d9
+	github.com/google/go-tpm v0.3.3
+	github.com/google/go-tpm-tools v0.3.10
+	google.golang.org/protobuf v1.28.0
+)
 
 require (
-	github.com/golang/glog v1.0.0 // indirect
 	github.com/golang/protobuf v1.5.2 // indirect
 	github.com/google/certificate-transparency-go v1.1.2 // indirect
 	github.com/google/go-sev-guest v0.4.1 // indirect
-	github.com/google/go-tpm v0.3.3 // indirect
-	github.com/google/go-tpm-tools v0.3.10 // indirect
 	github.com/google/go-tspi v0.2.1-0.20190423175329-115dea689aad // indirect
 	github.com/google/logger v1.1.1 // indirect
 	github.com/google/uuid v1.1.2 // indirect
@@ -20,5 +23,4 @@ require (
 	go.uber.org/multierr v1.8.0 // indirect
 	golang.org/x/crypto v0.0.0-20220525230936-793ad666bf5e // indirect
 	golang.org/x/sys v0.0.0-20220608164250-635b8c9b7f68 // indirect
-	google.golang.org/protobuf v1.28.0 // indirect
 )
@@ -9,20 +9,27 @@
 package main
 
 import (
-	"bufio"
-	"bytes"
-	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"flag"
+	"fmt"
 	"io"
 	"log"
 	"os"
 	"runtime/debug"
-	"unsafe"
+	"strings"
 
+	"github.com/google/go-tpm/tpm2"
+
+	"main/src/certs"
 	"main/src/lib"
+	"main/src/message"
+	"main/src/nativemsg"
+	"main/src/pcrsel"
+	"main/src/refvalues"
 	"main/src/steps"
 	"main/src/teepeem"
+	"main/src/verifier"
 )
 
 var (
@@ -32,9 +39,16 @@ var (
 	// - in firefox, the host process is started with the current directory
 	//   set to the home directory of the process owner
 	devicePath = "ATTESTER_DEVICE_PATH/"  // use absolute path so that both chromium and firefox will work
-	tpmPath = flag.String("tpm-path", "/dev/tpmrm0", "Path to the TPM device (character device or a Unix socket).")
+	tpmPath = flag.String("tpm-path", "/dev/tpmrm0", "Path to the TPM device (character device or a Unix socket), or simulator[:seed=<seed>][:events-log=<path>].")
 	flush   = flag.String("flush", "all", "Flush contexts, must be oneof transient|saved|loaded|all")
+	eventsLogPath = flag.String("events-log", teepeem.KernelEventsLogPath, "Path to the TCG events log returned with quotes.")
+	imaLogPath = flag.String("ima-log", "/sys/kernel/security/ima/binary_runtime_measurements", "Path to the IMA runtime measurement list returned with quotes.")
+	pcrsSpec   = flag.String("pcrs", "", "PCRs to quote, per bank, e.g. sha256:0,1,2, in place of the selection recorded at onboarding.")
+	pcrsConfig = flag.String("pcrs-config", devicePath+pcrsel.DefaultPath, "JSON file holding the PCR selection recorded at onboarding.")
+	selfCheck  = flag.Bool("self-check", false, "Check that the events log replays to the live PCR values, report mismatching PCRs and exit.")
+	selection pcrsel.Selection
 	rwc     io.ReadWriteCloser
+	conn    = nativemsg.New(os.Stdin, os.Stdout)
 )
 
 //// constants for Logger
@@ -45,49 +59,17 @@ var (
 //	Error *log.Logger
 //)
 
-// nativeEndian used to detect native byte order
-var nativeEndian binary.ByteOrder
-
-// bufferSize used to set size of IO buffer - adjust to accommodate message payloads
-var bufferSize = 8192
-
-// IncomingMessage represents a message sent to the native host.
-type IncomingMessage struct {
-	Query       string    `json:"query"`
-	Nonce       [32]byte  `json:"nonce"`
-	Attestation [145]byte `json:"attestation"`
-	Signature   [256]byte `json:"signature"`
-	AkPub       string    `json:"ak-pub"`
-	Pcrs        []int     `json:"pcrs"`
-}
-
-// OutgoingMessage respresents a response to an incoming message query.
-type OutgoingMessage struct {
-	Query       string    `json:"query"`
-	Nonce       [32]byte  `json:"nonce"`
-	Attestation [145]byte `json:"attestation"`
-	Signature   [256]byte `json:"signature"`
-	AkPub       string    `json:"ak-pub"`
-	IsLegit     bool      `json:"is-legit"`
-	Message     string    `json:"message"`
-}
-
-// Init initializes logger and determines native byte order.
+// Init initializes logger.
 func Init(traceHandle io.Writer, errorHandle io.Writer) {
 	lib.Trace = log.New(traceHandle, "TRACE: ", log.Ldate|log.Ltime|log.Lshortfile)
 	lib.Error = log.New(errorHandle, "ERROR: ", log.Ldate|log.Ltime|log.Lshortfile)
-
-	// determine native byte order so that we can read message size correctly
-	var one int16 = 1
-	b := (*byte)(unsafe.Pointer(&one))
-	if *b == 0 {
-		nativeEndian = binary.BigEndian
-	} else {
-		nativeEndian = binary.LittleEndian
-	}
 }
 
 func main() {
+	// Browsers only pass positional arguments (the caller origin), which
+	// flag.Parse() leaves alone
+	flag.Parse()
+
 	// force all output through "log" (including "main/src/lib"'s)
 	lib.UseLog = true
 
@@ -110,43 +92,56 @@ func main() {
 	}
 
 	// Open TPM and Flush handles
-	rwc = teepeem.OpenFlush(*tpmPath, *flush)
+	rwc, err = teepeem.OpenFlush(*tpmPath, *flush)
+	if err != nil {
+		lib.Fatal("%v", err)
+	}
 	defer rwc.Close()
 
-	lib.Trace.Printf("Chrome native messaging host started. Native byte order: %v.", nativeEndian)
+	// Run from a terminal, to learn whether a verifier can make sense of
+	// the quotes of this device
+	if *selfCheck {
+		if !runSelfCheck() {
+			rwc.Close()
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Quotes are only ever made and verified on the selected PCRs
+	selection, err = pcrsel.Load(*pcrsSpec, *pcrsConfig)
+	if err != nil {
+		lib.Fatal("%v", err)
+	}
+	lib.Trace.Printf("PCR selection: %s", selection)
+
+	lib.Trace.Printf("Chrome native messaging host started. Native byte order: %v.", nativemsg.NativeEndian)
 	read()
 	lib.Trace.Print("Chrome native messaging host exited.")
 }
 
-// read Creates a new buffered I/O reader and reads messages from Stdin.
+// read reads messages from Stdin until the browser closes it.
 func read() {
-	v := bufio.NewReader(os.Stdin)
-	// adjust buffer size to accommodate your json payload size limits; default is 4096
-	s := bufio.NewReaderSize(v, bufferSize)
-	lib.Trace.Printf("IO buffer reader created with buffer size of %v.", s.Size())
-
-	lengthBytes := make([]byte, 4)
-	lengthNum := int(0)
-
-	// we're going to indefinitely read the first 4 bytes in buffer, which gives us the message length.
-	// if stdIn is closed we'll exit the loop and shut down host
-	for b, err := s.Read(lengthBytes); b > 0 && err == nil; b, err = s.Read(lengthBytes) {
-		// convert message length bytes to integer value
-		lengthNum = readMessageLength(lengthBytes)
-		lib.Trace.Printf("Message size in bytes: %v", lengthNum)
-
-		// If message length exceeds size of buffer, the message will be truncated.
-		// This will likely cause an error when we attempt to unmarshal message to JSON.
-		if lengthNum > bufferSize {
-			lib.Error.Printf("Message size of %d exceeds buffer size of %d. Message will be truncated and is unlikely to unmarshal to JSON.", lengthNum, bufferSize)
+	for {
+		content, err := conn.Read()
+		if err == io.EOF {
+			break
 		}
-
-		// read the content of the message from buffer
-		content := make([]byte, lengthNum)
-		_, err := s.Read(content)
-		if err != nil && err != io.EOF {
-			lib.Error.Fatal(err)
+		if err != nil {
+			// Tell the browser what went wrong with its frame. An oversized
+			// frame has been skipped, but a truncated one leaves the stream
+			// out of sync.
+			lib.Error.Printf("Bad frame: %v", err)
+			send(message.Fail("", &message.Error{
+				Code:    message.ErrCodeProtocol,
+				Message: err.Error(),
+			}))
+			if errors.Is(err, nativemsg.ErrFrameTooLarge) {
+				continue
+			}
+			break
 		}
+		lib.Trace.Printf("Message size in bytes: %v", len(content))
 
 		// message has been read, now parse and process
 		parseMessage(content)
@@ -155,21 +150,15 @@ func read() {
 	lib.Trace.Print("Stdin closed.")
 }
 
-// readMessageLength reads and returns the message length value in native byte order.
-func readMessageLength(msg []byte) int {
-	var length uint32
-	buf := bytes.NewBuffer(msg)
-	err := binary.Read(buf, nativeEndian, &length)
-	if err != nil {
-		lib.Error.Printf("Unable to read bytes representing message length: %v", err)
-	}
-	return int(length)
-}
-
 // parseMessage parses incoming message
 func parseMessage(msg []byte) {
-	iMsg := decodeMessage(msg)
 	lib.Trace.Printf("Message received: %s", msg)
+	iMsg, err := message.Decode(msg)
+	if err != nil {
+		lib.Error.Printf("Rejected message: %v", err)
+		send(message.Fail(iMsg.Query, err))
+		return
+	}
 	path, err := os.Getwd()
 	if err != nil {
 		lib.Print("%v", err)
@@ -177,77 +166,239 @@ func parseMessage(msg []byte) {
 	lib.Print("%s", path)
 
 	// start building outgoing json message
-	oMsg := OutgoingMessage{
-		Query: iMsg.Query,
+	oMsg := message.Outgoing{
+		Version: message.Version,
+		Query:   iMsg.Query,
 	}
 
 	switch iMsg.Query {
-	case "get-ak-pub":
-		oMsg.AkPub = string(steps.ExtGetAkPub(devicePath+"Verifier/ak"))
-	case "get-tpm-quote":
-		nonce, attestation, signature := steps.ExtGetTpmQuote(
-			rwc,
-			devicePath,
-			iMsg.Pcrs,
-		)
-		var byteArray [32]byte
-		copy(byteArray[:], nonce)
-		oMsg.Nonce = byteArray
-		copy(oMsg.Attestation[:], attestation)
-		copy(oMsg.Signature[:], signature)
-	case "verify-tpm-quote":
-		oMsg.IsLegit, oMsg.Message = steps.ExtVerifyTpmQuote(
-			devicePath+"CICD/cicd-prediction", // IN
-			iMsg.Pcrs,              // IN
-			iMsg.Nonce[:],          // IN
-			iMsg.Attestation[:],    // IN
-			iMsg.Signature[:],      // IN
-			iMsg.AkPub,             // IN
+	case message.QueryGetAkPub:
+		akPub, err := steps.ExtGetAkPub(devicePath + "Verifier/ak")
+		if err != nil {
+			oMsg = message.Fail(iMsg.Query, err)
+			break
+		}
+		oMsg.AkPub = string(akPub)
+	case message.QueryGetTpmQuote:
+		sels, err := selectPcrs(iMsg)
+		if err != nil {
+			oMsg = message.Fail(iMsg.Query, err)
+			break
+		}
+		akPub, err := steps.ExtGetAkPub(devicePath + "Verifier/ak")
+		if err != nil {
+			oMsg = message.Fail(iMsg.Query, err)
+			break
+		}
+		sigAlg, err := akSigAlg(iMsg, akPub)
+		if err != nil {
+			oMsg = message.Fail(iMsg.Query, err)
+			break
+		}
+		var attestation, signature []byte
+		var pcrValues verifier.Banks
+		if iMsg.WithPcrValues {
+			// The values must be those quoted, whatever IMA measures meanwhile
+			attestation, signature, pcrValues, err = steps.ExtGetTpmQuotePcrValues(
+				rwc,
+				devicePath,
+				sels,
+				iMsg.Nonce,
+			)
+		} else {
+			attestation, signature, err = steps.ExtGetTpmQuote(
+				rwc,
+				devicePath,
+				sels,
+				iMsg.Nonce,
+			)
+		}
+		if err != nil {
+			oMsg = message.Fail(iMsg.Query, err)
+			break
+		}
+		oMsg.PcrSelection = selection
+		oMsg.Nonce = iMsg.Nonce
+		oMsg.Attestation = attestation
+		oMsg.Signature = signature
+		oMsg.SigAlg = sigAlg
+		if pcrValues != nil {
+			oMsg.PcrValues = map[string]map[int]message.Bytes{}
+			for alg, values := range pcrValues {
+				bank := map[int]message.Bytes{}
+				for pcr, value := range values {
+					bank[pcr] = value
+				}
+				oMsg.PcrValues[pcrsel.Name(alg)] = bank
+			}
+		}
+		if iMsg.WithEventsLog {
+			eventsLog, err := steps.ExtGetEventsLog(*eventsLogPath)
+			if err != nil {
+				oMsg = message.Fail(iMsg.Query, err)
+				break
+			}
+			oMsg.EventsLog = eventsLog
+		}
+		// Read after quoting, the IMA log holds every quoted entry
+		if iMsg.WithImaLog {
+			imaLog, err := steps.ExtGetImaLog(*imaLogPath)
+			if err != nil {
+				oMsg = message.Fail(iMsg.Query, err)
+				break
+			}
+			oMsg.ImaLog = imaLog
+		}
+	case message.QueryVerifyTpmQuote:
+		sels, err := selectPcrs(iMsg)
+		if err != nil {
+			oMsg = message.Fail(iMsg.Query, err)
+			break
+		}
+		if iMsg.SigAlg != "" {
+			if _, err := akSigAlg(iMsg, []byte(iMsg.AkPub)); err != nil {
+				oMsg = message.Fail(iMsg.Query, err)
+				break
+			}
+		}
+		isLegit, msg, result := steps.ExtVerifyTpmQuote(
+			devicePath+refvalues.DefaultPath,       // IN
+			devicePath+refvalues.DefaultSignerPath, // IN
+			sels,                                   // IN
+			iMsg.Nonce,                             // IN
+			iMsg.Attestation,                       // IN
+			iMsg.Signature,                         // IN
+			iMsg.EventsLog,                         // IN
+			iMsg.ImaLog,                            // IN
+			iMsg.AkPub,                             // IN
 		)
+		oMsg.IsLegit, oMsg.Message = isLegit, msg
+		if result == nil {
+			break
+		}
+		for _, v := range result.SecureBoot {
+			oMsg.SecureBoot = append(oMsg.SecureBoot, message.Verdict{
+				Rule:    v.Rule,
+				Subject: v.Subject,
+				Passed:  v.Passed,
+				Message: v.Message,
+			})
+		}
+		for _, v := range result.EventsPolicy {
+			oMsg.EventsPolicy = append(oMsg.EventsPolicy, message.Verdict{
+				Rule:    v.Rule,
+				Subject: v.Subject,
+				Passed:  v.Passed,
+				Message: v.Message,
+			})
+		}
+		if result.IMA != nil {
+			oMsg.Ima = &message.ImaReport{
+				Measurements: result.IMA.Measurements,
+				Unknown:      []message.ImaFile{},
+				Denied:       []message.ImaFile{},
+			}
+			for _, f := range result.IMA.Unknown {
+				oMsg.Ima.Unknown = append(oMsg.Ima.Unknown, message.ImaFile{Path: f.Path, Digest: f.Digest})
+			}
+			for _, f := range result.IMA.Denied {
+				oMsg.Ima.Denied = append(oMsg.Ima.Denied, message.ImaFile{Path: f.Path, Digest: f.Digest})
+			}
+		}
+	case message.QuerySelfCheck:
+		selfCheck, err := steps.ExtSelfCheck(rwc, *eventsLogPath)
+		if err != nil {
+			oMsg = message.Fail(iMsg.Query, err)
+			break
+		}
+		oMsg.IsLegit, oMsg.Message = selfCheck.OK(), selfCheck.Summary()
+		oMsg.SelfCheck = &message.SelfCheck{
+			PcrSelection: selfCheck.Selection,
+			Mismatches:   []message.PcrMismatch{},
+			Uncovered:    selfCheck.Uncovered,
+		}
+		for _, d := range selfCheck.Mismatches {
+			oMsg.SelfCheck.Mismatches = append(oMsg.SelfCheck.Mismatches, message.PcrMismatch{
+				Bank:     d.Bank,
+				Index:    d.Index,
+				Replayed: d.Expected,
+				Live:     d.Got,
+			})
+		}
 	}
 	send(oMsg)
 }
 
-// decodeMessage unmarshals incoming json request and returns query value.
-func decodeMessage(msg []byte) IncomingMessage {
-	var iMsg IncomingMessage
-	err := json.Unmarshal(msg, &iMsg)
+// runSelfCheck prints whether the events log replays to the live PCR values,
+// and the PCRs that do not, and tells whether all of them do.
+func runSelfCheck() bool {
+	result, err := steps.ExtSelfCheck(rwc, *eventsLogPath)
 	if err != nil {
-		lib.Error.Printf("Unable to unmarshal json to struct: %v", err)
+		fmt.Fprintf(os.Stderr, "self-check failed: %v\n", err)
+		return false
+	}
+	for _, d := range result.Mismatches {
+		fmt.Printf("%s PCR[%2d]: replayed %x, live %x\n", d.Bank, d.Index, d.Expected, d.Got)
 	}
-	return iMsg
+	if len(result.Uncovered) != 0 {
+		fmt.Printf("not compared, the events log has no digests for: %s\n", strings.Join(result.Uncovered, ", "))
+	}
+	fmt.Println(result.Summary())
+	return result.OK()
 }
 
-// send sends an OutgoingMessage to os.Stdout.
-func send(msg OutgoingMessage) {
-	byteMsg := dataToBytes(msg)
-	writeMessageLength(byteMsg)
-
-	var msgBuf bytes.Buffer
-	_, err := msgBuf.Write(byteMsg)
-	if err != nil {
-		lib.Error.Printf("Unable to write message length to message buffer: %v", err)
+// selectPcrs returns the selected PCRs, bank by bank. A query need not name
+// them, but must not ask for others.
+func selectPcrs(iMsg message.Incoming) ([]tpm2.PCRSelection, error) {
+	if iMsg.PcrSelection != nil {
+		requested, err := pcrsel.New(iMsg.PcrSelection)
+		if err != nil {
+			return nil, &message.Error{
+				Code:    message.ErrCodeBadRequest,
+				Message: fmt.Sprintf("%s: %v", iMsg.Query, err),
+			}
+		}
+		if !requested.Equal(selection) {
+			return nil, &message.Error{
+				Code:    message.ErrCodeBadRequest,
+				Message: fmt.Sprintf("%s: pcr-selection %s differs from the selected PCRs %s", iMsg.Query, requested, selection),
+			}
+		}
 	}
+	return selection.TPM(), nil
+}
 
-	_, err = msgBuf.WriteTo(os.Stdout)
+// akSigAlg returns the signature scheme of an AK, which a query must not name
+// another one than.
+func akSigAlg(iMsg message.Incoming, akPubPEM []byte) (string, error) {
+	akPublicKey, err := certs.ReadPublicKeyPEM(akPubPEM)
 	if err != nil {
-		lib.Error.Printf("Unable to write message buffer to Stdout: %v", err)
+		return "", fmt.Errorf("AK: %w", err)
 	}
+	return iMsg.SigAlgFor(akPublicKey)
 }
 
-// dataToBytes marshals OutgoingMessage struct to slice of bytes
-func dataToBytes(msg OutgoingMessage) []byte {
-	byteMsg, err := json.Marshal(msg)
+// send sends an outgoing message to os.Stdout.
+func send(msg message.Outgoing) {
+	err := conn.Write(dataToBytes(msg))
+	if errors.Is(err, nativemsg.ErrFrameTooLarge) {
+		// Replace the response with an error the browser can receive
+		lib.Error.Printf("Response too large: %v", err)
+		err = conn.Write(dataToBytes(message.Fail(msg.Query, &message.Error{
+			Code:    message.ErrCodeProtocol,
+			Message: err.Error(),
+		})))
+	}
 	if err != nil {
-		lib.Error.Printf("Unable to marshal OutgoingMessage struct to slice of bytes: %v", err)
+		lib.Error.Printf("Unable to write message to Stdout: %v", err)
 	}
-	return byteMsg
 }
 
-// writeMessageLength determines length of message and writes it to os.Stdout.
-func writeMessageLength(msg []byte) {
-	err := binary.Write(os.Stdout, nativeEndian, uint32(len(msg)))
+// dataToBytes marshals outgoing message struct to slice of bytes
+func dataToBytes(msg message.Outgoing) []byte {
+	byteMsg, err := json.Marshal(msg)
 	if err != nil {
-		lib.Error.Printf("Unable to write message length to Stdout: %v", err)
+		lib.Error.Printf("Unable to marshal outgoing message struct to slice of bytes: %v", err)
 	}
+	return byteMsg
 }
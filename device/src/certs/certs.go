@@ -41,6 +41,7 @@ func CreateCACert(
 ) (
 	x509.Certificate,
 	rsa.PrivateKey,
+	error,
 ) {
 
 	// Inspired by:
@@ -51,7 +52,7 @@ func CreateCACert(
 
 	caPrivKey, err := rsa.GenerateKey(rand.Reader, 4096)
 	if err != nil {
-		lib.Fatal("rsa.GenerateKey() failed: %v", err)
+		return x509.Certificate{}, rsa.PrivateKey{}, fmt.Errorf("rsa.GenerateKey() failed: %w", err)
 	}
 
 	caPrivKeyPEM := []byte(pem.EncodeToMemory(
@@ -61,7 +62,10 @@ func CreateCACert(
 		},
 	))
 
-	lib.Write(fmt.Sprintf("%s.key", certPath), caPrivKeyPEM, 0600)
+	err = lib.Write(fmt.Sprintf("%s.key", certPath), caPrivKeyPEM, 0600)
+	if err != nil {
+		return x509.Certificate{}, rsa.PrivateKey{}, err
+	}
 
 	// --- Create Certificate for TPM CA ---------------------------------------
 
@@ -89,7 +93,7 @@ func CreateCACert(
 		&caPrivKey.PublicKey,
 		caPrivKey)
 	if err != nil {
-		lib.Fatal("x509.CreateCertificate() failed: %v", err)
+		return x509.Certificate{}, rsa.PrivateKey{}, fmt.Errorf("x509.CreateCertificate() failed: %w", err)
 	}
 
 	// pem encode
@@ -100,7 +104,10 @@ func CreateCACert(
 		},
 	))
 
-	lib.Write(fmt.Sprintf("%s.crt", certPath), caPEM, 0644)
+	err = lib.Write(fmt.Sprintf("%s.crt", certPath), caPEM, 0644)
+	if err != nil {
+		return x509.Certificate{}, rsa.PrivateKey{}, err
+	}
 
 	// --- Verify TPM CA cert --------------------------------------------------
 
@@ -111,7 +118,7 @@ func CreateCACert(
 
 	caCert, err := x509.ParseCertificate(caBytes)
 	if err != nil {
-		lib.Fatal("x509.ParseCertificate() failed: %v", err)
+		return x509.Certificate{}, rsa.PrivateKey{}, fmt.Errorf("x509.ParseCertificate() failed: %w", err)
 	}
 
 	roots := x509.NewCertPool()
@@ -121,16 +128,15 @@ func CreateCACert(
 	}
 
 	if _, err := caCert.Verify(opts); err != nil {
-		lib.Fatal("caCert.Verify() failed: %v", err)
-	} else {
-		lib.Print("Verified %s.crt", certPath)
+		return x509.Certificate{}, rsa.PrivateKey{}, fmt.Errorf("caCert.Verify() failed: %w", err)
 	}
+	lib.Print("Verified %s.crt", certPath)
 
-	return *caCert, *caPrivKey
+	return *caCert, *caPrivKey, nil
 }
 
 // This func must be Exported, Capitalized, and comment added.
-func CreateSubjectAltName(tpmManufacturer, tpmModel, tpmFirmwareVersion []byte) *pkix.Extension {
+func CreateSubjectAltName(tpmManufacturer, tpmModel, tpmFirmwareVersion []byte) (*pkix.Extension, error) {
 
 	// Inspired by:
 	// https://gist.github.com/shaneutt/5e1995295cff6721c89a71d13a71c251
@@ -175,7 +181,7 @@ func CreateSubjectAltName(tpmManufacturer, tpmModel, tpmFirmwareVersion []byte)
 		Bytes: []byte{103, 129, 5, 2, 1}, // ASN1 encoding for 2.23.133.2.1
 	})
 	if err != nil {
-		lib.Fatal("asn1.Marshal() failed: %v", err)
+		return nil, fmt.Errorf("asn1.Marshal() failed: %w", err)
 	}
 	b1, err := asn1.Marshal(asn1.RawValue{
 		Class: asn1.ClassUniversal,
@@ -183,7 +189,7 @@ func CreateSubjectAltName(tpmManufacturer, tpmModel, tpmFirmwareVersion []byte)
 		Bytes: tpmManufacturer,
 	})
 	if err != nil {
-		lib.Fatal("asn1.Marshal() failed: %v", err)
+		return nil, fmt.Errorf("asn1.Marshal() failed: %w", err)
 	}
 	c1, err := asn1.Marshal(asn1.RawValue{
 		Class:      asn1.ClassUniversal,
@@ -192,7 +198,7 @@ func CreateSubjectAltName(tpmManufacturer, tpmModel, tpmFirmwareVersion []byte)
 		Bytes:      append(a1[:], b1[:]...),
 	})
 	if err != nil {
-		lib.Fatal("asn1.Marshal() failed: %v", err)
+		return nil, fmt.Errorf("asn1.Marshal() failed: %w", err)
 	}
 	a2, err := asn1.Marshal(asn1.RawValue{
 		Class: asn1.ClassUniversal,
@@ -200,7 +206,7 @@ func CreateSubjectAltName(tpmManufacturer, tpmModel, tpmFirmwareVersion []byte)
 		Bytes: []byte{103, 129, 5, 2, 2}, // ASN1 encoding for 2.23.133.2.2
 	})
 	if err != nil {
-		lib.Fatal("asn1.Marshal() failed: %v", err)
+		return nil, fmt.Errorf("asn1.Marshal() failed: %w", err)
 	}
 	b2, err := asn1.Marshal(asn1.RawValue{
 		Class: asn1.ClassUniversal,
@@ -208,7 +214,7 @@ func CreateSubjectAltName(tpmManufacturer, tpmModel, tpmFirmwareVersion []byte)
 		Bytes: tpmModel,
 	})
 	if err != nil {
-		lib.Fatal("asn1.Marshal() failed: %v", err)
+		return nil, fmt.Errorf("asn1.Marshal() failed: %w", err)
 	}
 	c2, err := asn1.Marshal(asn1.RawValue{
 		Class:      asn1.ClassUniversal,
@@ -216,7 +222,7 @@ func CreateSubjectAltName(tpmManufacturer, tpmModel, tpmFirmwareVersion []byte)
 		IsCompound: true, Bytes: append(a2[:], b2[:]...),
 	})
 	if err != nil {
-		lib.Fatal("asn1.Marshal() failed: %v", err)
+		return nil, fmt.Errorf("asn1.Marshal() failed: %w", err)
 	}
 	a3, err := asn1.Marshal(asn1.RawValue{
 		Class: asn1.ClassUniversal,
@@ -224,7 +230,7 @@ func CreateSubjectAltName(tpmManufacturer, tpmModel, tpmFirmwareVersion []byte)
 		Bytes: []byte{103, 129, 5, 2, 3}, // ASN1 encoding for 2.23.133.2.3
 	})
 	if err != nil {
-		lib.Fatal("asn1.Marshal() failed: %v", err)
+		return nil, fmt.Errorf("asn1.Marshal() failed: %w", err)
 	}
 	b3, err := asn1.Marshal(asn1.RawValue{
 		Class: asn1.ClassUniversal,
@@ -232,7 +238,7 @@ func CreateSubjectAltName(tpmManufacturer, tpmModel, tpmFirmwareVersion []byte)
 		Bytes: []byte(tpmFirmwareVersion),
 	})
 	if err != nil {
-		lib.Fatal("asn1.Marshal() failed: %v", err)
+		return nil, fmt.Errorf("asn1.Marshal() failed: %w", err)
 	}
 	c3, err := asn1.Marshal(asn1.RawValue{
 		Class:      asn1.ClassUniversal,
@@ -240,7 +246,7 @@ func CreateSubjectAltName(tpmManufacturer, tpmModel, tpmFirmwareVersion []byte)
 		IsCompound: true, Bytes: append(a3[:], b3[:]...),
 	})
 	if err != nil {
-		lib.Fatal("asn1.Marshal() failed: %v", err)
+		return nil, fmt.Errorf("asn1.Marshal() failed: %w", err)
 	}
 	d, err := asn1.Marshal(asn1.RawValue{
 		Class:      asn1.ClassUniversal,
@@ -249,7 +255,7 @@ func CreateSubjectAltName(tpmManufacturer, tpmModel, tpmFirmwareVersion []byte)
 		Bytes:      append(append(c1[:], c2[:]...), c3[:]...),
 	})
 	if err != nil {
-		lib.Fatal("asn1.Marshal() failed: %v", err)
+		return nil, fmt.Errorf("asn1.Marshal() failed: %w", err)
 	}
 	e, err := asn1.Marshal(asn1.RawValue{
 		Class:      asn1.ClassUniversal,
@@ -258,7 +264,7 @@ func CreateSubjectAltName(tpmManufacturer, tpmModel, tpmFirmwareVersion []byte)
 		Bytes:      d,
 	})
 	if err != nil {
-		lib.Fatal("asn1.Marshal() failed: %v", err)
+		return nil, fmt.Errorf("asn1.Marshal() failed: %w", err)
 	}
 	f, err := asn1.Marshal(asn1.RawValue{
 		Class:      asn1.ClassContextSpecific,
@@ -267,7 +273,7 @@ func CreateSubjectAltName(tpmManufacturer, tpmModel, tpmFirmwareVersion []byte)
 		Bytes:      e,
 	})
 	if err != nil {
-		lib.Fatal("asn1.Marshal() failed: %v", err)
+		return nil, fmt.Errorf("asn1.Marshal() failed: %w", err)
 	}
 	values, err := asn1.Marshal(asn1.RawValue{
 		Class:      asn1.ClassUniversal,
@@ -276,7 +282,7 @@ func CreateSubjectAltName(tpmManufacturer, tpmModel, tpmFirmwareVersion []byte)
 		Bytes:      f,
 	})
 	if err != nil {
-		lib.Fatal("asn1.Marshal() failed: %v", err)
+		return nil, fmt.Errorf("asn1.Marshal() failed: %w", err)
 	}
 
 	return &pkix.Extension{
@@ -284,5 +290,5 @@ func CreateSubjectAltName(tpmManufacturer, tpmModel, tpmFirmwareVersion []byte)
 		Id:       asn1.ObjectIdentifier{2, 5, 29, 17},
 		Critical: true,
 		Value:    values,
-	}
+	}, nil
 }
@@ -19,20 +19,42 @@ import (
 func CreateAKCert(
 	publicKeyPath string,
 	certName string,
+	manufacturerID string, // IN
+	modelName string, // IN
+	version string, // IN
 	caCertPath string,
 	certPath string,
-) {
+) error {
 
 	lib.PRINT("=== VERIFIER: CREATE AK CERT ===================================================")
 
 	// Retrieve AK public key
-	publicKey := ReadPublicKey(publicKeyPath)
+	publicKey, err := ReadPublicKey(publicKeyPath)
+	if err != nil {
+		return err
+	}
 
 	// Retrieve CA certificate
-	caCert := ReadCert(caCertPath)
+	caCert, err := ReadCert(caCertPath)
+	if err != nil {
+		return err
+	}
 
 	// Retrieve ca private key
-	caKey := ReadKey(caCertPath)
+	caKey, err := ReadSigner(caCertPath)
+	if err != nil {
+		return err
+	}
+
+	// Like EK certificates, AK certificates tell the TPM they are issued for
+	san, err := CreateSubjectAltName(
+		[]byte(manufacturerID),
+		[]byte(modelName),
+		[]byte(version),
+	)
+	if err != nil {
+		return err
+	}
 
 	now := time.Now()
 	certTemplate := x509.Certificate{
@@ -40,9 +62,12 @@ func CreateAKCert(
 		Subject: pkix.Name{
 			CommonName: certName,
 		},
-		NotBefore:             now,
-		NotAfter:              now.AddDate(10, 0, 0),
-		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		NotBefore: now,
+		NotAfter:  now.AddDate(10, 0, 0),
+		KeyUsage:  x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtraExtensions: []pkix.Extension{
+			*san,
+		},
 		BasicConstraintsValid: true,
 		IsCA:                  false,
 	}
@@ -51,10 +76,10 @@ func CreateAKCert(
 		rand.Reader,
 		&certTemplate,
 		&caCert,
-		&publicKey,
-		&caKey)
+		publicKey,
+		caKey)
 	if err != nil {
-		lib.Fatal("x509.CreateCertificate() failed: %v", err)
+		return fmt.Errorf("x509.CreateCertificate() failed: %w", err)
 	}
 
 	// pem encode
@@ -66,13 +91,16 @@ func CreateAKCert(
 	))
 
 	// Write AK Cert to disk
-	lib.Write(fmt.Sprintf("%s.crt", certPath), certPEM, 0644)
+	err = lib.Write(fmt.Sprintf("%s.crt", certPath), certPEM, 0644)
+	if err != nil {
+		return err
+	}
 
 	// Verify Cert
 	cert, err := x509.ParseCertificate(certBytes)
 	if err != nil {
-		lib.Fatal("x509.ParseCertificate() failed: %v", err)
+		return fmt.Errorf("x509.ParseCertificate() failed: %w", err)
 	}
 
-	VerifyCert(*cert, caCert)
+	return VerifyCert(*cert, caCert)
 }
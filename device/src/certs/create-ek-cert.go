@@ -3,9 +3,11 @@
 package certs
 
 import (
+	"crypto/ecdsa"
 	"crypto/rand"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/asn1"
 	"encoding/pem"
 	"fmt"
 	"math/big"
@@ -14,6 +16,9 @@ import (
 	"main/src/lib"
 )
 
+// TCG EK certificate extended key usage (tcg-kp-EKCertificate)
+var OIDEKCertificate = asn1.ObjectIdentifier{2, 23, 133, 8, 1}
+
 // === Verifier: create TPM EK certificate =====================================
 
 func CreateEKCert(
@@ -25,18 +30,42 @@ func CreateEKCert(
 	version string,
 	caCertPath string, // IN
 	certPath string, // OUT
-) {
+) error {
 
 	lib.PRINT("=== VERIFIER: CREATE EK CERT ===================================================")
 
 	// Retrieve EK public key
-	publicKey := ReadPublicKey(publicKeyPath)
+	publicKey, err := ReadPublicKey(publicKeyPath)
+	if err != nil {
+		return err
+	}
 
 	// Retrieve ca certificate
-	caCert := ReadCert(caCertPath)
+	caCert, err := ReadCert(caCertPath)
+	if err != nil {
+		return err
+	}
 
 	// Retrieve ca private key
-	caKey := ReadKey(caCertPath)
+	caKey, err := ReadSigner(caCertPath)
+	if err != nil {
+		return err
+	}
+
+	san, err := CreateSubjectAltName(
+		[]byte(manufacturerID),
+		[]byte(modelName),
+		[]byte(version),
+	)
+	if err != nil {
+		return err
+	}
+
+	// RSA EKs decrypt the seeds of credentials, ECC EKs agree on them
+	keyUsage := x509.KeyUsageKeyEncipherment
+	if _, ok := publicKey.(*ecdsa.PublicKey); ok {
+		keyUsage = x509.KeyUsageKeyAgreement
+	}
 
 	now := time.Now()
 	certTemplate := x509.Certificate{
@@ -44,13 +73,12 @@ func CreateEKCert(
 		Subject:      pkix.Name{},
 		NotBefore:    now,
 		NotAfter:     now.AddDate(10, 0, 0),
-		KeyUsage:     x509.KeyUsageKeyEncipherment,
+		KeyUsage:     keyUsage,
+		UnknownExtKeyUsage: []asn1.ObjectIdentifier{
+			OIDEKCertificate,
+		},
 		ExtraExtensions: []pkix.Extension{
-			*CreateSubjectAltName(
-				[]byte(manufacturerID),
-				[]byte(modelName),
-				[]byte(version),
-			),
+			*san,
 		},
 		BasicConstraintsValid: true,
 		IsCA:                  false,
@@ -60,10 +88,10 @@ func CreateEKCert(
 		rand.Reader,
 		&certTemplate,
 		&caCert,
-		&publicKey,
-		&caKey)
+		publicKey,
+		caKey)
 	if err != nil {
-		lib.Fatal("x509.CreateCertificate() failed: %v", err)
+		return fmt.Errorf("x509.CreateCertificate() failed: %w", err)
 	}
 
 	// pem encode
@@ -74,16 +102,19 @@ func CreateEKCert(
 		},
 	))
 
-	lib.Write(fmt.Sprintf("%s.crt", certPath), certPEM, 0644) // "TPM-CA/tpm"
+	err = lib.Write(fmt.Sprintf("%s.crt", certPath), certPEM, 0644) // "TPM-CA/tpm"
+	if err != nil {
+		return err
+	}
 
 	// --- Verify TPM cert -----------------------------------------------------
 
 	cert, err := x509.ParseCertificate(certBytes)
 	if err != nil {
-		lib.Fatal("x509.ParseCertificate() failed: %v", err)
+		return fmt.Errorf("x509.ParseCertificate() failed: %w", err)
 	}
 
-	VerifyCert(*cert, caCert)
+	return VerifyCert(*cert, caCert)
 }
 
 // --- Snippet: parse a certificate extensions -----------------------------
@@ -101,7 +132,7 @@ func CreateEKCert(
 //		lib.Print("Block has type CERTIFICATE")
 //		certificate, err := x509.ParseCertificate(block.Bytes)
 //		if err != nil {
-//			lib.Fatal("x509.ParseCertificate() failed: %v", err)
+//			return fmt.Errorf("x509.ParseCertificate() failed: %w", err)
 //		}
 //		for _, ext := range certificate.Extensions {
 //			// filter the custom extensions by customOID
@@ -0,0 +1,56 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package certs
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+
+	"main/src/lib"
+)
+
+// === Create a signing key pair ===============================================
+
+// CreateSigningKey creates an RSA key pair, e.g. for the CICD to sign the
+// reference values it publishes. The private key goes to <keyPath>.key, the
+// public key to <keyPath>.pub.
+func CreateSigningKey(
+	keyPath string, // OUT
+) (*rsa.PrivateKey, error) {
+
+	key, err := rsa.GenerateKey(rand.Reader, 3072)
+	if err != nil {
+		return nil, fmt.Errorf("rsa.GenerateKey() failed: %w", err)
+	}
+
+	keyPEM := pem.EncodeToMemory(
+		&pem.Block{
+			Type:  "RSA PRIVATE KEY",
+			Bytes: x509.MarshalPKCS1PrivateKey(key),
+		},
+	)
+	err = lib.Write(fmt.Sprintf("%s.key", keyPath), keyPEM, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	publicKeyDER, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("x509.MarshalPKIXPublicKey() failed: %w", err)
+	}
+	publicKeyPEM := pem.EncodeToMemory(
+		&pem.Block{
+			Type:  "PUBLIC KEY",
+			Bytes: publicKeyDER,
+		},
+	)
+	err = lib.Write(fmt.Sprintf("%s.pub", keyPath), publicKeyPEM, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
@@ -21,18 +21,27 @@ func CreateSRKCert(
 	certName string,
 	caCertPath string,
 	certPath string,
-) {
+) error {
 
 	lib.PRINT("=== VERIFIER: CREATE SRK CERT ==================================================")
 
 	// Retrieve SRK public key
-	publicKey := ReadPublicKey(publicKeyPath)
+	publicKey, err := ReadPublicKey(publicKeyPath)
+	if err != nil {
+		return err
+	}
 
 	// Retrieve CA certificate
-	caCert := ReadCert(caCertPath)
+	caCert, err := ReadCert(caCertPath)
+	if err != nil {
+		return err
+	}
 
 	// Retrieve ca private key
-	caKey := ReadKey(caCertPath)
+	caKey, err := ReadSigner(caCertPath)
+	if err != nil {
+		return err
+	}
 
 	now := time.Now()
 	certTemplate := x509.Certificate{
@@ -51,10 +60,10 @@ func CreateSRKCert(
 		rand.Reader,
 		&certTemplate,
 		&caCert,
-		&publicKey,
-		&caKey)
+		publicKey,
+		caKey)
 	if err != nil {
-		lib.Fatal("x509.CreateCertificate() failed: %v", err)
+		return fmt.Errorf("x509.CreateCertificate() failed: %w", err)
 	}
 
 	// pem encode
@@ -66,18 +75,26 @@ func CreateSRKCert(
 	))
 
 	// Write AK Cert to disk
-	lib.Write(fmt.Sprintf("%s.crt", certPath), certPEM, 0644)
+	err = lib.Write(fmt.Sprintf("%s.crt", certPath), certPEM, 0644)
+	if err != nil {
+		return err
+	}
 
 	// Verify Cert
 	cert, err := x509.ParseCertificate(certBytes)
 	if err != nil {
-		lib.Fatal("x509.ParseCertificate() failed: %v", err)
+		return fmt.Errorf("x509.ParseCertificate() failed: %w", err)
 	}
 
-	VerifyCert(*cert, caCert)
+	err = VerifyCert(*cert, caCert)
+	if err != nil {
+		return err
+	}
 
 	// Copy SRK public key to cert path
-	publicKeyPEM := lib.Read(fmt.Sprintf("%s.pub", publicKeyPath))
-	lib.Write(fmt.Sprintf("%s.pub", certPath), publicKeyPEM, 0644)
-
+	publicKeyPEM, err := lib.Read(fmt.Sprintf("%s.pub", publicKeyPath))
+	if err != nil {
+		return err
+	}
+	return lib.Write(fmt.Sprintf("%s.pub", certPath), publicKeyPEM, 0644)
 }
@@ -0,0 +1,72 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package certs
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/binary"
+	"fmt"
+)
+
+// === Parse an EK certificate read from NV ====================================
+
+// Header some platforms prepend to certificates in NV (TCG PC Client Platform
+// TPM Profile, section 7.3.2): 0x1001 tag, 0x00, then the certificate size.
+var nvCertHeader = []byte{0x10, 0x01, 0x00}
+
+// ParseEKCert decodes an EK certificate as stored in NV, and returns it with
+// its plain DER encoding. Besides the optional header, the DER is often
+// padded up to the size of the index (with zeros or 0xff), which the outer
+// SEQUENCE length tells apart.
+func ParseEKCert(
+	nvData []byte, // IN
+) (*x509.Certificate, error) {
+
+	der := nvData
+	if len(der) > 5 && bytes.Equal(der[:3], nvCertHeader) {
+		size := int(binary.BigEndian.Uint16(der[3:5]))
+		if len(der) < 5+size {
+			return nil, fmt.Errorf("NV header tells %d bytes, only %d follow", size, len(der)-5)
+		}
+		der = der[5 : 5+size]
+	}
+
+	// Drop the padding after the certificate
+	var raw asn1.RawValue
+	if _, err := asn1.Unmarshal(der, &raw); err != nil {
+		return nil, fmt.Errorf("asn1.Unmarshal() failed: %w", err)
+	}
+
+	cert, err := x509.ParseCertificate(raw.FullBytes)
+	if err != nil {
+		return nil, fmt.Errorf("x509.ParseCertificate() failed: %w", err)
+	}
+
+	return cert, nil
+}
+
+// ParseCertChain decodes the certificates of an EK certificate chain index:
+// DER certificates one after the other, possibly padded.
+func ParseCertChain(
+	nvData []byte, // IN
+) ([]*x509.Certificate, error) {
+
+	chain := []*x509.Certificate{}
+	for rest := nvData; len(rest) > 0 && rest[0] == 0x30; { // SEQUENCE
+		var raw asn1.RawValue
+		var err error
+		rest, err = asn1.Unmarshal(rest, &raw)
+		if err != nil {
+			return nil, fmt.Errorf("asn1.Unmarshal() failed: %w", err)
+		}
+		cert, err := x509.ParseCertificate(raw.FullBytes)
+		if err != nil {
+			return nil, fmt.Errorf("x509.ParseCertificate() failed: %w", err)
+		}
+		chain = append(chain, cert)
+	}
+
+	return chain, nil
+}
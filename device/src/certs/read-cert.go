@@ -4,6 +4,7 @@ package certs
 
 import (
 	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/asn1"
 	"encoding/pem"
 	"fmt"
@@ -15,24 +16,58 @@ import (
 
 func ReadCert(
 	pathPrefix string,
-) x509.Certificate {
+) (x509.Certificate, error) {
 
-	certPEM := lib.Read(fmt.Sprintf("%s.crt", pathPrefix))
+	certPEM, err := lib.Read(fmt.Sprintf("%s.crt", pathPrefix))
+	if err != nil {
+		return x509.Certificate{}, err
+	}
 
 	certBlock, _ := pem.Decode([]byte(certPEM))
 	if certBlock == nil {
-		lib.Fatal("pem.Decode() failed")
+		return x509.Certificate{}, fmt.Errorf("pem.Decode() failed")
 	}
 	if certBlock.Type != "CERTIFICATE" {
-		lib.Fatal("Block is not of type CERTIFICATE: %v", certBlock.Type)
+		return x509.Certificate{}, fmt.Errorf("Block is not of type CERTIFICATE: %v", certBlock.Type)
 	}
 
 	cert, err := x509.ParseCertificate(certBlock.Bytes)
 	if err != nil {
-		lib.Fatal("x509.ParseCertificate() failed: %v", err)
+		return x509.Certificate{}, fmt.Errorf("x509.ParseCertificate() failed: %w", err)
+	}
+
+	return *cert, nil
+}
+
+// === Read x509 certificates from disk
+
+// ReadCerts reads all the certificates of a PEM file, e.g. an EK certificate
+// followed by the intermediate CAs it is shipped with.
+func ReadCerts(
+	pathPrefix string,
+) ([]*x509.Certificate, error) {
+
+	certPEM, err := lib.Read(fmt.Sprintf("%s.crt", pathPrefix))
+	if err != nil {
+		return nil, err
+	}
+
+	certs := []*x509.Certificate{}
+	for block, rest := pem.Decode(certPEM); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			return nil, fmt.Errorf("Block is not of type CERTIFICATE: %v", block.Type)
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("x509.ParseCertificate() failed: %w", err)
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("pem.Decode() failed")
 	}
 
-	return *cert
+	return certs, nil
 }
 
 // === Verify an x509 certificate ==============================================
@@ -40,17 +75,21 @@ func ReadCert(
 func VerifyCert(
 	cert x509.Certificate,
 	parent x509.Certificate,
-) {
+) error {
 	// Note: equivalently with openssl:
 	// openssl verify -CAfile TPM-CA/tpm-ca.crt TPM-CA/tpm.crt
 	// openssl x509 -noout -ext subjectAltName -in TPM-CA/tpm.crt
 
-	// Remove SAN (2.5.29.17) from unhandled critical extensions
+	// Remove SAN (2.5.29.17) from unhandled critical extensions, once checked
 	uhce := []asn1.ObjectIdentifier{}
 	for _, ext := range cert.UnhandledCriticalExtensions {
 		lib.Comment("extension %s", ext.String())
-		if !ext.Equal(asn1.ObjectIdentifier{2, 5, 29, 17}) {
+		if !ext.Equal(OIDSubjectAltName) {
 			uhce = append(uhce, ext)
+			continue
+		}
+		if err := CheckDirectoryNameSAN(cert); err != nil {
+			return fmt.Errorf("subject alternative name: %w", err)
 		}
 	}
 	cert.UnhandledCriticalExtensions = uhce
@@ -59,9 +98,46 @@ func VerifyCert(
 	tpmRoots.AddCert(&parent)
 	tpmOpts := x509.VerifyOptions{
 		Roots: tpmRoots,
+		// EK certificates have the TCG EK certificate extended key usage
+		// (2.23.133.8.1), or none
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
 	}
 
 	if _, err := cert.Verify(tpmOpts); err != nil {
-		lib.Fatal("cert.Verify() failed: %v", err)
+		return fmt.Errorf("cert.Verify() failed: %w", err)
+	}
+
+	return nil
+}
+
+// Subject alternative name extension
+var OIDSubjectAltName = asn1.ObjectIdentifier{2, 5, 29, 17}
+
+// CheckDirectoryNameSAN checks that the subject alternative name of a
+// certificate holds directory names only, as that of EK certificates does,
+// which Go does not decode.
+func CheckDirectoryNameSAN(
+	cert x509.Certificate,
+) error {
+
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(OIDSubjectAltName) {
+			continue
+		}
+		var names []asn1.RawValue
+		if rest, err := asn1.Unmarshal(ext.Value, &names); err != nil || len(rest) != 0 {
+			return fmt.Errorf("malformed GeneralNames")
+		}
+		for _, name := range names {
+			var rdns pkix.RDNSequence
+			if name.Class != asn1.ClassContextSpecific || name.Tag != 4 {
+				return fmt.Errorf("name of tag [%d], expected directory names [4]", name.Tag)
+			}
+			if rest, err := asn1.Unmarshal(name.Bytes, &rdns); err != nil || len(rest) != 0 {
+				return fmt.Errorf("malformed directory name")
+			}
+		}
+		return nil
 	}
+	return fmt.Errorf("not found")
 }
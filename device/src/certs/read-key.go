@@ -3,6 +3,7 @@
 package certs
 
 import (
+	"crypto"
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/pem"
@@ -15,22 +16,57 @@ import (
 
 func ReadKey(
 	pathPrefix string,
-) rsa.PrivateKey {
+) (rsa.PrivateKey, error) {
 
-	keyPEM := lib.Read(fmt.Sprintf("%s.key", pathPrefix))
+	keyPEM, err := lib.Read(fmt.Sprintf("%s.key", pathPrefix))
+	if err != nil {
+		return rsa.PrivateKey{}, err
+	}
 
 	keyBlock, _ := pem.Decode(keyPEM)
 	if keyBlock == nil {
-		lib.Fatal("pem.Decode() failed")
+		return rsa.PrivateKey{}, fmt.Errorf("pem.Decode() failed")
 	}
 	if keyBlock.Type != "RSA PRIVATE KEY" {
-		lib.Fatal("Block is not of type RSA PRIVATE KEY: %v", keyBlock.Type)
+		return rsa.PrivateKey{}, fmt.Errorf("Block is not of type RSA PRIVATE KEY: %v", keyBlock.Type)
 	}
 
 	key, err := x509.ParsePKCS1PrivateKey(keyBlock.Bytes)
 	if err != nil {
-		lib.Fatal("x509.ParsePKCS1PrivateKey() failed: %v", err)
+		return rsa.PrivateKey{}, fmt.Errorf("x509.ParsePKCS1PrivateKey() failed: %w", err)
 	}
 
-	return *key
+	return *key, nil
+}
+
+// ReadSigner reads the RSA or ECC private key of a CA, e.g. to issue
+// certificates with.
+func ReadSigner(
+	pathPrefix string,
+) (crypto.Signer, error) {
+
+	keyPEM, err := lib.Read(fmt.Sprintf("%s.key", pathPrefix))
+	if err != nil {
+		return nil, err
+	}
+
+	keyBlock, _ := pem.Decode(keyPEM)
+	if keyBlock == nil {
+		return nil, fmt.Errorf("pem.Decode() failed")
+	}
+	switch keyBlock.Type {
+	case "RSA PRIVATE KEY":
+		key, err := x509.ParsePKCS1PrivateKey(keyBlock.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("x509.ParsePKCS1PrivateKey() failed: %w", err)
+		}
+		return key, nil
+	case "EC PRIVATE KEY":
+		key, err := x509.ParseECPrivateKey(keyBlock.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("x509.ParseECPrivateKey() failed: %w", err)
+		}
+		return key, nil
+	}
+	return nil, fmt.Errorf("Block is neither of type RSA PRIVATE KEY nor EC PRIVATE KEY: %v", keyBlock.Type)
 }
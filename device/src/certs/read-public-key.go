@@ -3,6 +3,8 @@
 package certs
 
 import (
+	"crypto"
+	"crypto/ecdsa"
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/pem"
@@ -15,63 +17,42 @@ import (
 
 func ReadPublicKey(
 	publicKeyPath string,
-) rsa.PublicKey {
+) (crypto.PublicKey, error) {
 
-	publicKeyPEM := lib.Read(fmt.Sprintf("%s.pub", publicKeyPath))
-
-	publicKeyBlock, _ := pem.Decode(publicKeyPEM)
-	if publicKeyBlock == nil {
-		lib.Fatal("pem.Decode() failed")
-	}
-	if publicKeyBlock.Type != "PUBLIC KEY" {
-		lib.Fatal("Block is not of type PUBLIC KEY: %v", publicKeyBlock.Type)
-	}
-
-	pubKey, err := x509.ParsePKIXPublicKey(publicKeyBlock.Bytes)
+	publicKeyPEM, err := lib.Read(fmt.Sprintf("%s.pub", publicKeyPath))
 	if err != nil {
-		lib.Fatal("x509.ParsePKIXPublicKey() failed: %v", err)
+		return nil, err
 	}
 
-	// Retrieve EK Pub as *rsa.PublicKey
-	// See https://stackoverflow.com/a/44317246
-	switch ekPubTyp := pubKey.(type) {
-	case *rsa.PublicKey:
-	default:
-		lib.Fatal("ekPublicKey is not of type RSA: %v", ekPubTyp)
-	}
-	publicKey, _ := pubKey.(*rsa.PublicKey)
-	lib.Verbose("publicKey %v", publicKey)
-
-	return *publicKey
+	return ReadPublicKeyPEM(publicKeyPEM)
 }
 
+// ReadPublicKeyPEM decodes an RSA or ECC public key, as *rsa.PublicKey or
+// *ecdsa.PublicKey.
 func ReadPublicKeyPEM(
 	publicKeyPEM []byte,
 ) (
-	key rsa.PublicKey,
+	key crypto.PublicKey,
+	err error,
 ) {
 	publicKeyBlock, _ := pem.Decode(publicKeyPEM)
 	if publicKeyBlock == nil {
-		lib.Fatal("pem.Decode() failed")
+		return nil, fmt.Errorf("pem.Decode() failed")
 	}
 	if publicKeyBlock.Type != "PUBLIC KEY" {
-		lib.Fatal("Block is not of type PUBLIC KEY: %v", publicKeyBlock.Type)
+		return nil, fmt.Errorf("Block is not of type PUBLIC KEY: %v", publicKeyBlock.Type)
 	}
 
 	pubKey, err := x509.ParsePKIXPublicKey(publicKeyBlock.Bytes)
 	if err != nil {
-		lib.Fatal("x509.ParsePKIXPublicKey() failed: %v", err)
+		return nil, fmt.Errorf("x509.ParsePKIXPublicKey() failed: %w", err)
 	}
 
-	// Retrieve EK Pub as *rsa.PublicKey
-	// See https://stackoverflow.com/a/44317246
-	switch ekPubTyp := pubKey.(type) {
-	case *rsa.PublicKey:
-	default:
-		lib.Fatal("ekPublicKey is not of type RSA: %v", ekPubTyp)
+	// TPM keys are either RSA or ECC
+	switch publicKey := pubKey.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey:
+		lib.Verbose("publicKey %v", publicKey)
+		return publicKey, nil
 	}
-	publicKey, _ := pubKey.(*rsa.PublicKey)
-	lib.Verbose("publicKey %v", publicKey)
-
-	return *publicKey
+	return nil, fmt.Errorf("publicKey is neither of type RSA nor ECC: %T", pubKey)
 }
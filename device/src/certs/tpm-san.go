@@ -0,0 +1,199 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package certs
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// === Parse the TPM identity of an EK certificate =============================
+
+// Attributes of the directory name of EK certificates SANs (TCG EK Credential
+// Profile 2.5, section 3.2.9)
+var (
+	OIDTPMManufacturer = asn1.ObjectIdentifier{2, 23, 133, 2, 1}
+	OIDTPMModel        = asn1.ObjectIdentifier{2, 23, 133, 2, 2}
+	OIDTPMVersion      = asn1.ObjectIdentifier{2, 23, 133, 2, 3}
+)
+
+// TPM vendor names, by vendor code (TCG TPM Vendor ID Registry)
+var vendorNames = map[string]string{
+	"AMD":  "AMD",
+	"ATML": "Atmel",
+	"BRCM": "Broadcom",
+	"CSCO": "Cisco",
+	"FLYS": "Flyslice Technologies",
+	"GOOG": "Google",
+	"HISI": "Huawei",
+	"HPE":  "HPE",
+	"IBM":  "IBM",
+	"IFX":  "Infineon",
+	"INTC": "Intel",
+	"LEN":  "Lenovo",
+	"MSFT": "Microsoft",
+	"NSM":  "National Semiconductor",
+	"NTC":  "Nuvoton Technology",
+	"NTZ":  "Nationz",
+	"QCOM": "Qualcomm",
+	"ROCC": "Fuzhou Rockchip",
+	"SECE": "SecEdge",
+	"SMSC": "SMSC",
+	"SMSN": "Samsung",
+	"SNS":  "Sinosun",
+	"STM":  "STMicroelectronics",
+	"TXN":  "Texas Instruments",
+	"WEC":  "Winbond",
+}
+
+// VendorCode returns a TCG vendor ID as the registry writes it, e.g. "INTC"
+// for 0x494E5443.
+func VendorCode(
+	manufacturerID uint32, // IN
+) string {
+
+	b := []byte{byte(manufacturerID >> 24), byte(manufacturerID >> 16),
+		byte(manufacturerID >> 8), byte(manufacturerID)}
+	return strings.TrimRight(string(b), "\x00 ")
+}
+
+// VendorName returns the name of the vendor of a TCG vendor ID, or tells its
+// code if unknown.
+func VendorName(
+	manufacturerID uint32, // IN
+) string {
+
+	if name, ok := vendorNames[VendorCode(manufacturerID)]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown vendor %q", VendorCode(manufacturerID))
+}
+
+// TPMIdentity is the TPM an EK certificate is issued for, as its SAN tells.
+type TPMIdentity struct {
+	// TCG vendor ID: 4 ASCII characters, e.g. 0x494E5443 for "INTC"
+	ManufacturerID uint32
+	// Model, free-form
+	Model string
+	// Firmware version, e.g. 0x00070055, and its number of hex digits
+	FirmwareVersion       uint64
+	FirmwareVersionDigits int
+}
+
+// VendorCode returns the vendor ID as the TCG registry writes it, e.g.
+// "INTC" or "IFX".
+func (id *TPMIdentity) VendorCode() string {
+	return VendorCode(id.ManufacturerID)
+}
+
+// VendorName returns the name of the vendor, or tells its code if unknown.
+func (id *TPMIdentity) VendorName() string {
+	return VendorName(id.ManufacturerID)
+}
+
+// Manufacturer returns the vendor ID as EK certificates write it, e.g.
+// "id:494E5443".
+func (id *TPMIdentity) Manufacturer() string {
+	return fmt.Sprintf("id:%08X", id.ManufacturerID)
+}
+
+// Version returns the firmware version as EK certificates write it, e.g.
+// "id:00070055".
+func (id *TPMIdentity) Version() string {
+	return fmt.Sprintf("id:%0*X", id.FirmwareVersionDigits, id.FirmwareVersion)
+}
+
+func (id *TPMIdentity) String() string {
+	return fmt.Sprintf("%s (%s) model %q firmware %s",
+		id.VendorName(), id.Manufacturer(), id.Model, id.Version())
+}
+
+// ParseTPMIdentity decodes the TCG directory name of the SAN of an EK
+// certificate, which must give the TPM manufacturer, model and version.
+func ParseTPMIdentity(
+	cert x509.Certificate, // IN
+) (*TPMIdentity, error) {
+
+	if err := CheckDirectoryNameSAN(cert); err != nil {
+		return nil, fmt.Errorf("SAN: %w", err)
+	}
+	var san []byte
+	for _, ext := range cert.Extensions {
+		if ext.Id.Equal(OIDSubjectAltName) {
+			san = ext.Value
+		}
+	}
+	var names []asn1.RawValue
+	if _, err := asn1.Unmarshal(san, &names); err != nil {
+		return nil, fmt.Errorf("asn1.Unmarshal() failed: %w", err)
+	}
+
+	values := map[string]string{}
+	for _, name := range names {
+		var rdns pkix.RDNSequence
+		if _, err := asn1.Unmarshal(name.Bytes, &rdns); err != nil {
+			return nil, fmt.Errorf("asn1.Unmarshal() failed: %w", err)
+		}
+		for _, rdn := range rdns {
+			for _, atv := range rdn {
+				value, ok := atv.Value.(string)
+				if !ok {
+					return nil, fmt.Errorf("SAN attribute %v is not a string", atv.Type)
+				}
+				values[atv.Type.String()] = value
+			}
+		}
+	}
+
+	id := &TPMIdentity{}
+	for _, oid := range []asn1.ObjectIdentifier{OIDTPMManufacturer, OIDTPMModel, OIDTPMVersion} {
+		if _, ok := values[oid.String()]; !ok {
+			return nil, fmt.Errorf("SAN has no attribute %v", oid)
+		}
+	}
+	manufacturer, digits, err := parseHexID(values[OIDTPMManufacturer.String()])
+	if err != nil || digits != 8 {
+		return nil, fmt.Errorf("SAN TPM manufacturer %q, expected id:<8 hex digits>",
+			values[OIDTPMManufacturer.String()])
+	}
+	id.ManufacturerID = uint32(manufacturer)
+	id.Model = values[OIDTPMModel.String()]
+	id.FirmwareVersion, id.FirmwareVersionDigits, err = parseHexID(values[OIDTPMVersion.String()])
+	if err != nil {
+		return nil, fmt.Errorf("SAN TPM version %q, expected id:<hex digits>: %v",
+			values[OIDTPMVersion.String()], err)
+	}
+
+	return id, nil
+}
+
+// ParseFirmwareVersion decodes a firmware version as EK certificates write
+// it, e.g. "id:00070055"; the "id:" prefix is optional.
+func ParseFirmwareVersion(
+	version string, // IN
+) (uint64, error) {
+
+	if !strings.HasPrefix(version, "id:") {
+		version = "id:" + version
+	}
+	value, _, err := parseHexID(version)
+	return value, err
+}
+
+// parseHexID decodes "id:" followed by up to 16 hex digits, and returns their
+// value and number.
+func parseHexID(s string) (uint64, int, error) {
+	digits := strings.TrimPrefix(s, "id:")
+	if digits == s || len(digits) == 0 || len(digits) > 16 {
+		return 0, 0, fmt.Errorf("expected id:<1 to 16 hex digits>")
+	}
+	value, err := strconv.ParseUint(digits, 16, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("strconv.ParseUint() failed: %w", err)
+	}
+	return value, len(digits), nil
+}
@@ -0,0 +1,51 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"runtime/debug"
+
+	"github.com/golang/glog"
+
+	"main/src/lib"
+	"main/src/teepeem"
+)
+
+var (
+	tpmPath = flag.String("tpm-path", "/dev/tpmrm0", "Path to the TPM device (character device or a Unix socket), or simulator[:seed=<seed>][:events-log=<path>].")
+	flush   = flag.String("flush", "all", "Flush contexts, must be oneof transient|saved|loaded|all")
+)
+
+// ### Main ####################################################################
+
+func main() {
+	flag.Parse()
+
+	// Global panic handler
+	defer func() {
+		if message := recover(); message != nil {
+			glog.V(0).Infof("%s%s%s", lib.RED, message, lib.RESET)
+			glog.V(0).Infof("%s%s%s", lib.PURPLE, debug.Stack(), lib.RESET)
+		}
+	}()
+
+	lib.PRINT("### ATTESTOR: READ TPM PROPERTIES ##############################################")
+
+	rwc, err := teepeem.OpenFlush(*tpmPath, *flush)
+	if err != nil {
+		lib.Fatal("%v", err)
+	}
+	defer rwc.Close()
+
+	info, err := teepeem.ReadInfo(rwc)
+	if err != nil {
+		lib.Fatal("%v", err)
+	}
+
+	fmt.Printf("Manufacturer:     %s (%s)\n", info.VendorName(), info.ManufacturerID())
+	fmt.Printf("Model:            %s\n", info.Model())
+	fmt.Printf("Firmware version: %s (%s)\n", info.FirmwareVersion(), info.Version())
+	fmt.Printf("Specification:    %s\n", info.SpecVersion())
+}
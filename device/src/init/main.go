@@ -3,6 +3,7 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"runtime/debug"
 
@@ -10,13 +11,17 @@ import (
 
 	"main/src/certs"
 	"main/src/lib"
+	"main/src/refvalues"
 	"main/src/steps"
 	"main/src/teepeem"
+	"main/src/truststore"
 )
 
 var (
-	tpmPath = flag.String("tpm-path", "/dev/tpmrm0", "Path to the TPM device (character device or a Unix socket).")
+	tpmPath = flag.String("tpm-path", "/dev/tpmrm0", "Path to the TPM device (character device or a Unix socket), or simulator[:seed=<seed>][:events-log=<path>].")
 	flush   = flag.String("flush", "all", "Flush contexts, must be oneof transient|saved|loaded|all")
+	ekType  = flag.String("ek-type", teepeem.KeyRSA, "Type of the EK, must be oneof rsa|ecc-p256|ecc-p384")
+	akType  = flag.String("ak-type", teepeem.KeyRSA, "Type of the AK, must be oneof rsa|ecc-p256|ecc-p384")
 )
 
 func main() {
@@ -30,122 +35,207 @@ func main() {
 		}
 	}()
 
-	lib.PRINT("### INIT: CREATE CA ROOT FOR MANUFACTURER AND OWNER ############################")
-
-	// Create certificate for Manufacturer CA
-	lib.PRINT("=== MANUFACTURER: CREATE MANUFACTURER CA CERT ==================================")
-	certs.CreateCACert(
-		"Manufacturer",
-		"Manufacturer/manufacturer-ca",
-	)
+	lib.PRINT("### INIT: CREATE CA ROOT FOR OWNER #############################################")
+	var err error
 
 	// Create certificate for Owner CA
 	lib.PRINT("=== OWNER: CREATE OWNER CA CERT ================================================")
-	certs.CreateCACert(
+	_, _, err = certs.CreateCACert(
 		"Owner",
 		"Owner/owner-ca",
 	)
-
-	lib.PRINT("### CICD: PREDICT DIGESTS ######################################################")
-	// In this mock-up we cheat by reading the digests from the events log.
-	// Normally the CICD should predict the digests from the assets it builds.
-
-	// Retrieve and save TPM events log
-	lib.PRINT("=== INIT: RETRIEVE EVENTS LOG ==================================================")
-	//eventsLog, err := client.GetEventLog(rwc)
-	//if err != nil {
-	//	lib.Fatal("client.GetEventLog(): %v", err)
-	//}
-	eventsLog := lib.Read("/sys/kernel/security/tpm0/binary_bios_measurements")
-	lib.Write("CICD/cicd-prediction.bin", eventsLog, 0644)
-
-	lib.PRINT("### MANUFACTURER: CREATE TPM CERT ##############################################")
+	if err != nil {
+		lib.Fatal("%v", err)
+	}
+
+	lib.PRINT("### CICD: CREATE SIGNING KEY ###################################################")
+	// The CICD signs the reference values it publishes (see ./publish), which
+	// the Verifier checks with the public key
+
+	// Create CICD signing key
+	lib.PRINT("=== CICD: CREATE CICD SIGNING KEY ==============================================")
+	_, err = certs.CreateSigningKey(
+		refvalues.DefaultSignerPath, // OUT
+	)
+	if err != nil {
+		lib.Fatal("%v", err)
+	}
 
 	// Open TPM
 	lib.PRINT("=== INIT: OPEN TPM =============================================================")
-	rwc := teepeem.OpenFlush(*tpmPath, *flush)
+	rwc, err := teepeem.OpenFlush(*tpmPath, *flush)
+	if err != nil {
+		lib.Fatal("%v", err)
+	}
 	defer rwc.Close()
 
-	// Read and save TPM EK Pub
-	lib.PRINT("=== INIT: RETRIEVE EK PUB ======================================================")
-	steps.GetEKPub(
-		rwc,
-		"Manufacturer/ek", // OUT
-	)
+	// Read TPM properties, that certificates tell
+	lib.PRINT("=== INIT: READ TPM PROPERTIES ==================================================")
+	info, err := teepeem.ReadInfo(rwc)
+	if err != nil {
+		lib.Fatal("%v", err)
+	}
+	lib.Print("TPM: %s (%s) model %q firmware %s", info.VendorName(), info.ManufacturerID(), info.Model(), info.Version())
+
+	// The simulator has no EK certificate in NV: play the Manufacturer,
+	// certify its EK with a CA of our own, and trust that CA
+	if teepeem.IsSimulator(*tpmPath) {
+		lib.PRINT("### MANUFACTURER: CREATE TPM CERT ##############################################")
+
+		// Create certificate for Manufacturer CA
+		lib.PRINT("=== MANUFACTURER: CREATE MANUFACTURER CA CERT ==================================")
+		_, _, err = certs.CreateCACert(
+			"Manufacturer",
+			"Manufacturer/manufacturer-ca",
+		)
+		if err != nil {
+			lib.Fatal("%v", err)
+		}
+		store, err := truststore.Open(truststore.DefaultPath)
+		if err != nil {
+			lib.Fatal("%v", err)
+		}
+		caPEM, err := lib.Read("Manufacturer/manufacturer-ca.crt")
+		if err != nil {
+			lib.Fatal("%v", err)
+		}
+		_, err = store.Import(caPEM)
+		if err != nil {
+			lib.Fatal("%v", err)
+		}
 
-	// Create TPM EK Cert
-	lib.PRINT("=== INIT: CREATE EK CERT =======================================================")
-	certs.CreateEKCert(
-		"Manufacturer/ek", // IN
-		"id: Google",
-		"Shielded VM vTPM",
-		"id: 00010001",
-		"Manufacturer/manufacturer-ca", // IN
-		"Manufacturer/ek",              // OUT
-	)
+		// Read and save TPM EK Pub
+		lib.PRINT("=== INIT: RETRIEVE EK PUB ======================================================")
+		_, _, err = steps.GetEKPub(
+			rwc,
+			*ekType,           // IN
+			"Manufacturer/ek", // OUT
+		)
+		if err != nil {
+			lib.Fatal("%v", err)
+		}
+
+		// Create TPM EK Cert
+		lib.PRINT("=== INIT: CREATE EK CERT =======================================================")
+		err = certs.CreateEKCert(
+			"Manufacturer/ek",              // IN
+			info.ManufacturerID(),          // IN
+			info.Model(),                   // IN
+			info.Version(),                 // IN
+			"Manufacturer/manufacturer-ca", // IN
+			"Manufacturer/ek",              // OUT
+		)
+		if err != nil {
+			lib.Fatal("%v", err)
+		}
+	}
 
 	// Attestor: retrieve EK Pub from TPM
-	steps.GetEKPub(
+	ekPublicKey, _, err := steps.GetEKPub(
 		rwc,
+		*ekType,       // IN
 		"Attestor/ek", // OUT
 	)
-
-	// Verifier: verify EK Pub with Manufacturer EK Cert
-	steps.VerifyEKPub(
-		"Attestor/ek",                  // IN
-		"Manufacturer/ek",              // IN
-		"Manufacturer/manufacturer-ca", // IN
-		"Verifier/ek",                  // OUT
+	if err != nil {
+		lib.Fatal("%v", err)
+	}
+
+	// Attestor: retrieve EK Cert from TPM NV, or from the Manufacturer on the
+	// simulator
+	ekCertPath := "Attestor/ek"
+	err = steps.GetEKCert(
+		rwc,
+		ekPublicKey, // IN
+		ekCertPath,  // OUT
 	)
+	if errors.Is(err, teepeem.ErrNoEKCert) && teepeem.IsSimulator(*tpmPath) {
+		lib.Comment("%v, using the Manufacturer EK Cert of the simulator", err)
+		ekCertPath = "Manufacturer/ek"
+	} else if err != nil {
+		lib.Fatal("%v", err)
+	}
+
+	// Verifier: verify EK Pub with EK Cert, chained up to a TPM vendor root
+	err = steps.VerifyEKPub(
+		"Attestor/ek",          // IN
+		ekCertPath,             // IN
+		truststore.DefaultPath, // IN
+		"Verifier/ek",          // OUT
+	)
+	if err != nil {
+		lib.Fatal("%v", err)
+	}
 
 	// Verifier/Owner: create Owner EK Cert
-	certs.CreateEKCert(
-		"Verifier/ek",      // IN
-		"id: Google",       // IN
-		"Shielded VM vTPM", // IN
-		"id: 00010001",     // IN
-		"Owner/owner-ca",   // IN
-		"Verifier/ek",      // OUT
+	err = certs.CreateEKCert(
+		"Verifier/ek",         // IN
+		info.ManufacturerID(), // IN
+		info.Model(),          // IN
+		info.Version(),        // IN
+		"Owner/owner-ca",      // IN
+		"Verifier/ek",         // OUT
 	)
+	if err != nil {
+		lib.Fatal("%v", err)
+	}
 
 	// Attestor: create AK
-	steps.CreateAK(
+	err = steps.CreateAK(
 		rwc,
+		*akType,       // IN
 		"Attestor/ek", // IN
 		"Attestor/ak", // OUT
 	)
+	if err != nil {
+		lib.Fatal("%v", err)
+	}
 
 	// Verifier: generate credential challenge
-	steps.GenerateCredential(
+	err = steps.GenerateCredential(
 		"Attestor/ak",         // IN
 		"Verifier/ek",         // IN
 		"Verifier/nonce",      // OUT
 		"Verifier/credential", // OUT
 	)
+	if err != nil {
+		lib.Fatal("%v", err)
+	}
 
 	// Attestor: activate credential
-	steps.ActivateCredential(
+	err = steps.ActivateCredential(
 		rwc,                   // IN
 		"Verifier/credential", // IN
 		"Attestor/ek",         // IN
 		"Attestor/ak",         // IN
 		"Attestor/attempt",    // OUT
 	)
+	if err != nil {
+		lib.Fatal("%v", err)
+	}
 
 	// Verifier: verify credential
-	steps.VerifyCredential(
+	err = steps.VerifyCredential(
 		"Attestor/attempt", // IN
 		"Verifier/nonce",   // IN
 		"Attestor/ak",      // IN
 		"Verifier/ak",      // OUT
 	)
+	if err != nil {
+		lib.Fatal("%v", err)
+	}
 
 	// Verifier/Owner: create Owner AK Cert
-	certs.CreateAKCert(
-		"Verifier/ak",    // IN
-		"TPM AK",         // IN
-		"Owner/owner-ca", // IN
-		"Verifier/ak",    // OUT
+	err = certs.CreateAKCert(
+		"Verifier/ak",         // IN
+		"TPM AK",              // IN
+		info.ManufacturerID(), // IN
+		info.Model(),          // IN
+		info.Version(),        // IN
+		"Owner/owner-ca",      // IN
+		"Verifier/ak",         // OUT
 	)
+	if err != nil {
+		lib.Fatal("%v", err)
+	}
 
 }
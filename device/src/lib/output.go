@@ -80,25 +80,28 @@ func Read(
 	path string,
 ) (
 	data []byte,
+	err error,
 ) {
-	data, err := ioutil.ReadFile(path)
+	data, err = ioutil.ReadFile(path)
 	if err != nil {
-		Fatal("ioutil.ReadFile() failed: %v", err)
+		return nil, fmt.Errorf("ioutil.ReadFile() failed: %w", err)
 	}
 
 	Comment("Read %s", path)
 
-	return data
+	return data, nil
 }
 
 func Write(
 	path string,
 	data []byte,
 	perm fs.FileMode,
-) {
-	err := ioutil.WriteFile(path, data, 0644)
+) error {
+	err := ioutil.WriteFile(path, data, perm)
 	if err != nil {
-		Fatal("ioutil.WriteFile() failed: %v", err)
+		return fmt.Errorf("ioutil.WriteFile() failed: %w", err)
 	}
 	Comment("Wrote %s", path)
+
+	return nil
 }
@@ -0,0 +1,278 @@
+// SPDX-License-Identifier: Apache-2.0
+
+// Package message defines the JSON messages exchanged between the browser
+// extension and the native messaging host.
+package message
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"main/src/pcrsel"
+)
+
+// Version of the message schema implemented by the host. Requests carrying
+// any other version are rejected. Version 2 replaced the single-bank pcrs and
+// hash-alg with a per-bank pcr-selection, whose keys name the hash algorithm
+// of each bank as hash-alg did.
+const Version = 2
+
+// Queries understood by the host
+const (
+	QueryGetAkPub       = "get-ak-pub"
+	QueryGetTpmQuote    = "get-tpm-quote"
+	QueryVerifyTpmQuote = "verify-tpm-quote"
+	QuerySelfCheck      = "self-check"
+)
+
+// Signature scheme names, after the TPM_ALG_ID they stand for
+const (
+	SigAlgRSASSA = "rsassa"
+	SigAlgECDSA  = "ecdsa"
+)
+
+// Error codes of structured error responses
+const (
+	ErrCodeProtocol           = "protocol-error"
+	ErrCodeMalformed          = "malformed-message"
+	ErrCodeUnsupportedVersion = "unsupported-version"
+	ErrCodeUnknownQuery       = "unknown-query"
+	ErrCodeBadRequest         = "bad-request"
+	ErrCodeUnsupportedAlg     = "unsupported-algorithm"
+	ErrCodeFailed             = "query-failed"
+)
+
+// === Byte strings ============================================================
+
+// Bytes is a byte string of any length, carried as standard base64. Unlike
+// []byte, it refuses JSON arrays of numbers.
+type Bytes []byte
+
+func (b Bytes) MarshalJSON() ([]byte, error) {
+	return json.Marshal(base64.StdEncoding.EncodeToString(b))
+}
+
+func (b *Bytes) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return fmt.Errorf("base64 string expected: %w", err)
+	}
+	decoded, err := base64.StdEncoding.DecodeString(text)
+	if err != nil {
+		return fmt.Errorf("base64.DecodeString() failed: %w", err)
+	}
+	*b = decoded
+	return nil
+}
+
+// === Messages ================================================================
+
+// Incoming is a query sent to the native host. PcrSelection, PCR indexes by
+// bank name (e.g. "sha256"), may be omitted: the host quotes and verifies the
+// PCRs it is configured with, and rejects queries naming others.
+type Incoming struct {
+	Version      int              `json:"version"`
+	Query        string           `json:"query"`
+	PcrSelection map[string][]int `json:"pcr-selection,omitempty"`
+	Nonce        Bytes            `json:"nonce,omitempty"`
+	Attestation  Bytes            `json:"attestation,omitempty"`
+	Signature    Bytes            `json:"signature,omitempty"`
+	SigAlg       string           `json:"sig-alg,omitempty"`
+	AkPub        string           `json:"ak-pub,omitempty"`
+	// Ask get-tpm-quote to also return the events log, the PCR values and
+	// the IMA log
+	WithEventsLog bool `json:"with-events-log,omitempty"`
+	WithPcrValues bool `json:"with-pcr-values,omitempty"`
+	WithImaLog    bool `json:"with-ima-log,omitempty"`
+	// Events log returned along with the quote, for verify-tpm-quote to
+	// check the Secure Boot state and the events policy against
+	EventsLog Bytes `json:"events-log,omitempty"`
+	// IMA log returned along with the quote, for verify-tpm-quote to replay
+	// PCR 10 from and check the measured files against
+	ImaLog Bytes `json:"ima-log,omitempty"`
+}
+
+// Outgoing is the response to an incoming query.
+type Outgoing struct {
+	Version      int              `json:"version"`
+	Query        string           `json:"query"`
+	PcrSelection map[string][]int `json:"pcr-selection,omitempty"`
+	Nonce        Bytes            `json:"nonce,omitempty"`
+	Attestation  Bytes            `json:"attestation,omitempty"`
+	Signature    Bytes            `json:"signature,omitempty"`
+	SigAlg       string           `json:"sig-alg,omitempty"`
+	AkPub        string           `json:"ak-pub,omitempty"`
+	IsLegit      bool             `json:"is-legit"`
+	Message      string           `json:"message,omitempty"`
+	Error        *Error           `json:"error,omitempty"`
+	// Raw TCG events log, and PCR values by bank name and PCR index
+	EventsLog Bytes                    `json:"events-log,omitempty"`
+	PcrValues map[string]map[int]Bytes `json:"pcr-values,omitempty"`
+	// IMA runtime measurement list, in the binary format
+	ImaLog Bytes `json:"ima-log,omitempty"`
+	// Verdicts of the Secure Boot and events policies of verify-tpm-quote
+	SecureBoot   []Verdict `json:"secure-boot,omitempty"`
+	EventsPolicy []Verdict `json:"events-policy,omitempty"`
+	// Files the IMA policy of verify-tpm-quote does not accept
+	Ima *ImaReport `json:"ima,omitempty"`
+	// Outcome of self-check
+	SelfCheck *SelfCheck `json:"self-check,omitempty"`
+}
+
+// Verdict is the outcome of one rule of a policy, e.g. that images were
+// verified with a permitted certificate, or that the kernel command line
+// holds some argument.
+type Verdict struct {
+	Rule    string `json:"rule"`
+	Subject string `json:"subject,omitempty"`
+	Passed  bool   `json:"passed"`
+	Message string `json:"message"`
+}
+
+// ImaReport lists the measured files the IMA policy does not accept, out of
+// the measurements the quote covers.
+type ImaReport struct {
+	Measurements int       `json:"measurements"`
+	Unknown      []ImaFile `json:"unknown"`
+	Denied       []ImaFile `json:"denied"`
+}
+
+// ImaFile is a measured file, with its digest as "<algorithm>:<hex>".
+type ImaFile struct {
+	Path   string `json:"path"`
+	Digest string `json:"digest"`
+}
+
+// SelfCheck tells whether the events log of the host replays to its live PCR
+// values. Banks the events log has no digests for are not compared.
+type SelfCheck struct {
+	PcrSelection map[string][]int `json:"pcr-selection"`
+	Mismatches   []PcrMismatch    `json:"mismatches"`
+	Uncovered    []string         `json:"uncovered"`
+}
+
+// PcrMismatch is a PCR whose live value is not the one the events log
+// replays to.
+type PcrMismatch struct {
+	Bank     string `json:"bank"`
+	Index    int    `json:"index"`
+	Replayed Bytes  `json:"replayed"`
+	Live     Bytes  `json:"live"`
+}
+
+// Error tells why a query could not be served.
+type Error struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+func newError(code string, format string, a ...interface{}) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, a...)}
+}
+
+// Fail returns the response to a query that could not be served.
+func Fail(
+	query string, // IN
+	err error, // IN
+) Outgoing {
+
+	var e *Error
+	if !errors.As(err, &e) {
+		e = newError(ErrCodeFailed, "%v", err)
+	}
+	return Outgoing{
+		Version: Version,
+		Query:   query,
+		Error:   e,
+	}
+}
+
+// === Decoding ================================================================
+
+// Decode parses and validates a query. Errors are of type *Error.
+func Decode(
+	data []byte, // IN
+) (Incoming, error) {
+
+	var in Incoming
+	if err := json.Unmarshal(data, &in); err != nil {
+		return in, newError(ErrCodeMalformed, "json.Unmarshal() failed: %v", err)
+	}
+	if in.Version != Version {
+		return in, newError(ErrCodeUnsupportedVersion, "version %d, expected %d", in.Version, Version)
+	}
+
+	switch in.Query {
+	case QueryGetAkPub, QuerySelfCheck:
+	case QueryGetTpmQuote:
+		if len(in.Nonce) == 0 {
+			return in, newError(ErrCodeBadRequest, "%s: missing nonce", in.Query)
+		}
+		if err := checkAlgs(&in); err != nil {
+			return in, err
+		}
+	case QueryVerifyTpmQuote:
+		if len(in.Nonce) == 0 || len(in.Attestation) == 0 ||
+			len(in.Signature) == 0 || in.AkPub == "" {
+			return in, newError(ErrCodeBadRequest,
+				"%s: nonce, attestation, signature and ak-pub are required", in.Query)
+		}
+		if err := checkAlgs(&in); err != nil {
+			return in, err
+		}
+	default:
+		return in, newError(ErrCodeUnknownQuery, "unknown query %q", in.Query)
+	}
+
+	return in, nil
+}
+
+// checkAlgs validates the algorithms of a query: the hash algorithms of the
+// PCR banks, and the signature scheme. The latter may be omitted: it is that
+// of the AK.
+func checkAlgs(
+	in *Incoming, // IN
+) error {
+
+	for hash := range in.PcrSelection {
+		if _, err := pcrsel.Alg(hash); err != nil {
+			return newError(ErrCodeUnsupportedAlg, "pcr-selection: %v, expected %q, %q or %q",
+				err, pcrsel.SHA1, pcrsel.SHA256, pcrsel.SHA384)
+		}
+	}
+	if in.SigAlg != "" && in.SigAlg != SigAlgRSASSA && in.SigAlg != SigAlgECDSA {
+		return newError(ErrCodeUnsupportedAlg, "sig-alg %q, expected %q or %q",
+			in.SigAlg, SigAlgRSASSA, SigAlgECDSA)
+	}
+	return nil
+}
+
+// SigAlgFor returns the signature scheme of an AK, RSASSA for RSA keys and
+// ECDSA for ECC keys, and rejects a query naming another one.
+func (in *Incoming) SigAlgFor(
+	akPublicKey crypto.PublicKey, // IN
+) (string, error) {
+
+	var sigAlg string
+	switch akPublicKey.(type) {
+	case *rsa.PublicKey:
+		sigAlg = SigAlgRSASSA
+	case *ecdsa.PublicKey:
+		sigAlg = SigAlgECDSA
+	default:
+		return "", newError(ErrCodeUnsupportedAlg, "%s: unsupported AK type %T", in.Query, akPublicKey)
+	}
+	if in.SigAlg != "" && in.SigAlg != sigAlg {
+		return "", newError(ErrCodeUnsupportedAlg, "%s: sig-alg %q, the AK signs with %q", in.Query, in.SigAlg, sigAlg)
+	}
+	return sigAlg, nil
+}
@@ -0,0 +1,50 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package message
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		data string
+		code string // Empty if the query is valid
+	}{
+		{"get-ak-pub", `{"version": 2, "query": "get-ak-pub"}`, ""},
+		{"get-tpm-quote", `{"version": 2, "query": "get-tpm-quote", "nonce": "AAEC",
+			"pcr-selection": {"sha1": [0], "sha256": [0, 7], "sha384": [7]}, "sig-alg": "ecdsa"}`, ""},
+		{"default selection", `{"version": 2, "query": "get-tpm-quote", "nonce": "AAEC"}`, ""},
+		{"version 1", `{"version": 1, "query": "get-tpm-quote", "nonce": "AAEC",
+			"pcrs": [0, 7], "hash-alg": "sha256"}`, ErrCodeUnsupportedVersion},
+		{"unknown bank", `{"version": 2, "query": "get-tpm-quote", "nonce": "AAEC",
+			"pcr-selection": {"sm3_256": [0]}}`, ErrCodeUnsupportedAlg},
+		{"unknown bank to verify", `{"version": 2, "query": "verify-tpm-quote", "nonce": "AAEC",
+			"attestation": "AAEC", "signature": "AAEC", "ak-pub": "PEM", "pcr-selection": {"md5": [0]}}`,
+			ErrCodeUnsupportedAlg},
+		{"unknown sig-alg", `{"version": 2, "query": "get-tpm-quote", "nonce": "AAEC",
+			"sig-alg": "rsapss"}`, ErrCodeUnsupportedAlg},
+		{"missing nonce", `{"version": 2, "query": "get-tpm-quote"}`, ErrCodeBadRequest},
+		{"number array", `{"version": 2, "query": "get-tpm-quote", "nonce": [0, 1, 2]}`, ErrCodeMalformed},
+		{"unknown query", `{"version": 2, "query": "get-ek-pub"}`, ErrCodeUnknownQuery},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Decode([]byte(tc.data))
+			if tc.code == "" {
+				if err != nil {
+					t.Fatalf("Decode() failed: %v", err)
+				}
+				return
+			}
+			var e *Error
+			if !errors.As(err, &e) {
+				t.Fatalf("Decode() returned %v, expected a %s error", err, tc.code)
+			}
+			if e.Code != tc.code {
+				t.Errorf("Decode() returned %v, expected a %s error", err, tc.code)
+			}
+		})
+	}
+}
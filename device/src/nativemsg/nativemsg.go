@@ -0,0 +1,118 @@
+// SPDX-License-Identifier: Apache-2.0
+
+// Package nativemsg frames messages for the browsers' Native Messaging API:
+// each message is preceded by its length, a 32-bit integer in native byte
+// order.
+package nativemsg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"unsafe"
+)
+
+const (
+	// Largest message the host may send to the browser (1 MB)
+	MaxOutgoingSize = 1024 * 1024
+	// Largest message the browser may send to the host (4 GB)
+	MaxIncomingSize = math.MaxUint32
+)
+
+var (
+	// A frame was cut short by the end of the stream
+	ErrTruncatedFrame = errors.New("truncated frame")
+	// A frame exceeds the size limit in its direction
+	ErrFrameTooLarge = errors.New("frame too large")
+)
+
+// NativeEndian is the byte order of the length prefix.
+var NativeEndian binary.ByteOrder
+
+func init() {
+	var one int16 = 1
+	b := (*byte)(unsafe.Pointer(&one))
+	if *b == 0 {
+		NativeEndian = binary.BigEndian
+	} else {
+		NativeEndian = binary.LittleEndian
+	}
+}
+
+// Conn reads and writes framed messages.
+type Conn struct {
+	r io.Reader
+	w io.Writer
+	// Incoming frames larger than this are discarded, and reported with
+	// ErrFrameTooLarge
+	MaxIncoming uint32
+}
+
+func New(
+	r io.Reader, // IN
+	w io.Writer, // OUT
+) *Conn {
+
+	return &Conn{
+		r:           r,
+		w:           w,
+		MaxIncoming: MaxIncomingSize,
+	}
+}
+
+// Read returns the next message. It returns io.EOF when the stream ends
+// between two frames, and ErrTruncatedFrame when it ends within a frame.
+// After ErrFrameTooLarge, the oversized frame has been skipped and the next
+// one can be read.
+func (c *Conn) Read() ([]byte, error) {
+
+	header := make([]byte, 4)
+	n, err := io.ReadFull(c.r, header)
+	if err == io.EOF {
+		return nil, io.EOF
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: read %d of 4 length bytes: %v", ErrTruncatedFrame, n, err)
+	}
+	length := NativeEndian.Uint32(header)
+
+	if length > c.MaxIncoming {
+		skipped, err := io.CopyN(io.Discard, c.r, int64(length))
+		if err != nil {
+			return nil, fmt.Errorf("%w: read %d of %d bytes: %v", ErrTruncatedFrame, skipped, length, err)
+		}
+		return nil, fmt.Errorf("%w: %d bytes, limit is %d", ErrFrameTooLarge, length, c.MaxIncoming)
+	}
+
+	// Let the buffer grow with the data actually received, rather than
+	// trusting the length prefix for the allocation
+	var content bytes.Buffer
+	copied, err := io.CopyN(&content, c.r, int64(length))
+	if err != nil {
+		return nil, fmt.Errorf("%w: read %d of %d bytes: %v", ErrTruncatedFrame, copied, length, err)
+	}
+
+	return content.Bytes(), nil
+}
+
+// Write sends a message in a single frame.
+func (c *Conn) Write(
+	msg []byte, // IN
+) error {
+
+	if len(msg) > MaxOutgoingSize {
+		return fmt.Errorf("%w: %d bytes, limit is %d", ErrFrameTooLarge, len(msg), MaxOutgoingSize)
+	}
+
+	frame := make([]byte, 4+len(msg))
+	NativeEndian.PutUint32(frame, uint32(len(msg)))
+	copy(frame[4:], msg)
+	if _, err := c.w.Write(frame); err != nil {
+		return fmt.Errorf("Write() failed: %w", err)
+	}
+
+	return nil
+}
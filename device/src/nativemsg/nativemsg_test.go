@@ -0,0 +1,121 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package nativemsg
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+// frame prefixes a message with its length, the way browsers do.
+func frame(length uint32, msg []byte) []byte {
+	header := make([]byte, 4)
+	NativeEndian.PutUint32(header, length)
+	return append(header, msg...)
+}
+
+// feed writes chunks into a pipe, one Write() per chunk, then closes it.
+func feed(chunks ...[]byte) *io.PipeReader {
+	r, w := io.Pipe()
+	go func() {
+		for _, chunk := range chunks {
+			if _, err := w.Write(chunk); err != nil {
+				return
+			}
+		}
+		w.Close()
+	}()
+	return r
+}
+
+// split cuts data into chunks of at most size bytes.
+func split(data []byte, size int) [][]byte {
+	chunks := [][]byte{}
+	for len(data) > size {
+		chunks = append(chunks, data[:size])
+		data = data[size:]
+	}
+	return append(chunks, data)
+}
+
+func TestReadFragmented(t *testing.T) {
+	msg := bytes.Repeat([]byte(`{"query":"get-ak-pub"}`), 1000)
+	data := append(frame(uint32(len(msg)), msg), frame(2, []byte("{}"))...)
+
+	// Deliver the frames a few bytes at a time, splitting the length prefix
+	conn := New(feed(split(data, 3)...), io.Discard)
+	for _, expected := range [][]byte{msg, []byte("{}")} {
+		got, err := conn.Read()
+		if err != nil {
+			t.Fatalf("Read() failed: %v", err)
+		}
+		if !bytes.Equal(got, expected) {
+			t.Fatalf("Read() returned %d bytes, expected %d", len(got), len(expected))
+		}
+	}
+	if _, err := conn.Read(); err != io.EOF {
+		t.Errorf("Read() returned %v at end of stream, expected io.EOF", err)
+	}
+}
+
+func TestReadTruncated(t *testing.T) {
+	for name, data := range map[string][]byte{
+		"header": frame(10, nil)[:2],
+		"body":   frame(10, []byte("12345")),
+	} {
+		conn := New(feed(data), io.Discard)
+		if _, err := conn.Read(); !errors.Is(err, ErrTruncatedFrame) {
+			t.Errorf("truncated %s: Read() returned %v, expected %v", name, err, ErrTruncatedFrame)
+		}
+	}
+}
+
+func TestReadTooLarge(t *testing.T) {
+	data := append(frame(8, []byte("12345678")), frame(2, []byte("{}"))...)
+	conn := New(feed(data), io.Discard)
+	conn.MaxIncoming = 4
+
+	if _, err := conn.Read(); !errors.Is(err, ErrFrameTooLarge) {
+		t.Fatalf("Read() returned %v, expected %v", err, ErrFrameTooLarge)
+	}
+	// The oversized frame is skipped, the next one is still readable
+	got, err := conn.Read()
+	if err != nil || string(got) != "{}" {
+		t.Errorf("Read() returned %q, %v after an oversized frame", got, err)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	r, w := io.Pipe()
+	conn := New(r, w)
+	msg := bytes.Repeat([]byte("x"), MaxOutgoingSize)
+
+	go func() {
+		if err := conn.Write(msg); err != nil {
+			t.Errorf("Write() failed: %v", err)
+		}
+		w.Close()
+	}()
+	got, err := conn.Read()
+	if err != nil {
+		t.Fatalf("Read() failed: %v", err)
+	}
+	if !bytes.Equal(got, msg) {
+		t.Errorf("Read() returned %d bytes, expected %d", len(got), len(msg))
+	}
+}
+
+func TestWriteTooLarge(t *testing.T) {
+	var out bytes.Buffer
+	conn := New(nil, &out)
+
+	err := conn.Write(make([]byte, MaxOutgoingSize+1))
+	if !errors.Is(err, ErrFrameTooLarge) {
+		t.Errorf("Write() returned %v, expected %v", err, ErrFrameTooLarge)
+	}
+	if out.Len() != 0 {
+		t.Errorf("Write() sent %d bytes of an oversized message", out.Len())
+	}
+}
@@ -3,22 +3,29 @@
 package main
 
 import (
-	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"flag"
-
-	"github.com/google/go-attestation/attest"
-	"github.com/google/go-tpm/tpm2"
+	"time"
 
 	"main/src/certs"
 	"main/src/lib"
+	"main/src/pcrsel"
+	"main/src/refvalues"
 	"main/src/steps"
 	"main/src/teepeem"
+	"main/src/truststore"
 )
 
 var (
-	tpmPath = flag.String("tpm-path", "/dev/tpmrm0", "Path to the TPM device (character device or a Unix socket).")
-	flush   = flag.String("flush", "all", "Flush contexts, must be oneof transient|saved|loaded|all")
+	tpmPath    = flag.String("tpm-path", "/dev/tpmrm0", "Path to the TPM device (character device or a Unix socket), or simulator[:seed=<seed>][:events-log=<path>].")
+	flush      = flag.String("flush", "all", "Flush contexts, must be oneof transient|saved|loaded|all")
+	ekType     = flag.String("ek-type", teepeem.KeyRSA, "Type of the EK, must be oneof rsa|ecc-p256|ecc-p384: its certificate is read from the NV indexes of that type, or is the one init created on the simulator.")
+	akType     = flag.String("ak-type", teepeem.KeyRSA, "Type of the AK, must be oneof rsa|ecc-p256|ecc-p384")
+	pcrsSpec   = flag.String("pcrs", "", "PCRs to quote and seal to, per bank, e.g. sha1:0,7+sha256:0,1,2 (default: the usual PCRs the reference values hold, in every bank active on the TPM).")
+	pcrsConfig = flag.String("pcrs-config", "", "JSON file to read the PCR selection from, in place of --pcrs.")
+	eventsLog  = flag.String("events-log", "", "Path to the TCG events log sent along with the onboarding quote, that the Secure Boot and events policies of the reference values are checked against (default: the events-log of the simulator, or "+teepeem.KernelEventsLogPath+").")
+	imaLog     = flag.String("ima-log", "", "Path to the IMA runtime measurement list sent along with the onboarding quote, that the IMA policy of the reference values is checked against; PCR 10 must then be selected.")
 )
 
 // ### Main ####################################################################
@@ -26,143 +33,227 @@ var (
 func main() {
 	flag.Parse()
 
-	lib.PRINT("=== CICD: PREDICT EXPECTED PCRS VALUES =========================================")
-
-	// Retrieve events log
-	eventsLog := lib.Read("CICD/cicd-prediction.bin")
-	parsedEventsLog, err := attest.ParseEventLog(eventsLog)
+	// Open TPM and Flush handles
+	rwc, err := teepeem.OpenFlush(*tpmPath, *flush)
 	if err != nil {
-		lib.Fatal("attest.ParseEventLog() failed: %v", err)
+		lib.Fatal("%v", err)
 	}
+	defer rwc.Close()
 
-	// Compute expected PCR values
-	pcrs := [][32]byte{}
-	for i := 0; i < 24; i++ {
-		pcrs = append(pcrs, [32]byte{})
-		lib.Verbose("PCR[%2d]: 0x%s", i, hex.EncodeToString(pcrs[i][:]))
-	}
-	for _, e := range parsedEventsLog.Events(attest.HashAlg(tpm2.AlgSHA256)) {
-		// sudo cat pcr.bin digest.bin | openssl dgst -sha256 -binary > futurepcr.bin
-		i := e.Index
-		pcrs[i] = sha256.Sum256(append(pcrs[i][:], e.Digest...))
-		lib.Verbose("PCR[%2d]+0x%s => 0x%s", i,
-			hex.EncodeToString(e.Digest), hex.EncodeToString(pcrs[i][:]))
+	// Read TPM properties, that certificates tell
+	lib.PRINT("=== ONBOARD: READ TPM PROPERTIES ===============================================")
+	info, err := teepeem.ReadInfo(rwc)
+	if err != nil {
+		lib.Fatal("%v", err)
 	}
+	lib.Print("TPM: %s (%s) model %q firmware %s", info.VendorName(), info.ManufacturerID(), info.Model(), info.Version())
 
-	// Compute attestation digest
-	lib.PRINT("=== INIT: PREDICT ATTESTATION DIGEST ===========================================")
+	lib.PRINT("=== CICD: RETRIEVE REFERENCE VALUES ============================================")
 
-	pcrsConcat := []byte{}
-	for _, i := range []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 14} {
-		pcrsConcat = append(pcrsConcat, pcrs[i][:]...)
+	// Retrieve the PCR values the CICD signed
+	manifest, err := refvalues.Read(refvalues.DefaultPath, refvalues.DefaultSignerPath)
+	if err != nil {
+		lib.Fatal("%v", err)
+	}
+	set, err := manifest.Latest(time.Now())
+	if err != nil {
+		lib.Fatal("%v", err)
+	}
+	lib.Print("Reference values: %q", set.Name)
+	pcrs := set.Banks()
+	for alg, values := range pcrs {
+		for i, value := range values {
+			lib.Verbose("%v PCR[%2d]: 0x%s", alg, i, hex.EncodeToString(value))
+		}
 	}
-	pcrsDigest := sha256.Sum256(pcrsConcat)
-
-	// Write attestation digest to disk
-	lib.Write("CICD/cicd-digest.bin", pcrsDigest[:], 0644)
 
-	// Open TPM and Flush handles
-	rwc := teepeem.OpenFlush(*tpmPath, *flush)
-	defer rwc.Close()
+	// Select PCRs, and record the selection for seal, the Attester daemon
+	// and the Verifier. By default, select the usual PCRs in every bank active
+	// on the TPM, among the PCRs the reference values hold.
+	var selection pcrsel.Selection
+	if *pcrsSpec != "" || *pcrsConfig != "" {
+		selection, err = pcrsel.Load(*pcrsSpec, *pcrsConfig)
+	} else {
+		selection, err = steps.DefaultSelection(rwc, pcrs)
+	}
+	if err != nil {
+		lib.Fatal("%v", err)
+	}
+	err = selection.Write(pcrsel.DefaultPath)
+	if err != nil {
+		lib.Fatal("%v", err)
+	}
+	lib.Print("PCR selection: %s", selection)
 
 	// Attestor: retrieve EK Pub from TPM
-	steps.GetEKPub(
+	ekPublicKey, _, err := steps.GetEKPub(
 		rwc,
+		*ekType,       // IN
 		"Attestor/ek", // OUT
 	)
+	if err != nil {
+		lib.Fatal("%v", err)
+	}
+
+	// Attestor: retrieve EK Cert from TPM NV, or from the Manufacturer on the
+	// simulator
+	ekCertPath := "Attestor/ek"
+	err = steps.GetEKCert(
+		rwc,
+		ekPublicKey, // IN
+		ekCertPath,  // OUT
+	)
+	if errors.Is(err, teepeem.ErrNoEKCert) && teepeem.IsSimulator(*tpmPath) {
+		lib.Comment("%v, using the Manufacturer EK Cert of the simulator", err)
+		ekCertPath = "Manufacturer/ek"
+	} else if err != nil {
+		lib.Fatal("%v", err)
+	}
 
-	// Verifier: verify EK Pub with Manufacturer EK Cert
-	steps.VerifyEKPub(
-		"Attestor/ek",                  // IN
-		"Manufacturer/ek",              // IN
-		"Manufacturer/manufacturer-ca", // IN
-		"Verifier/ek",                  // OUT
+	// Verifier: verify EK Pub with EK Cert, chained up to a TPM vendor root
+	err = steps.VerifyEKPub(
+		"Attestor/ek",          // IN
+		ekCertPath,             // IN
+		truststore.DefaultPath, // IN
+		"Verifier/ek",          // OUT
 	)
+	if err != nil {
+		lib.Fatal("%v", err)
+	}
 
 	// Verifier/Owner: create Owner EK Cert
-	certs.CreateEKCert(
-		"Verifier/ek",      // IN
-		"id: Google",       // IN
-		"Shielded VM vTPM", // IN
-		"id: 00010001",     // IN
-		"Owner/owner-ca",   // IN
-		"Verifier/ek",      // OUT
+	err = certs.CreateEKCert(
+		"Verifier/ek",         // IN
+		info.ManufacturerID(), // IN
+		info.Model(),          // IN
+		info.Version(),        // IN
+		"Owner/owner-ca",      // IN
+		"Verifier/ek",         // OUT
 	)
+	if err != nil {
+		lib.Fatal("%v", err)
+	}
 
 	// Attestor: create AK
-	steps.CreateAK(
+	err = steps.CreateAK(
 		rwc,
+		*akType,       // IN
 		"Attestor/ek", // IN
 		"Attestor/ak", // OUT
 	)
+	if err != nil {
+		lib.Fatal("%v", err)
+	}
 
 	// Verifier: generate credential challenge
-	steps.GenerateCredential(
+	err = steps.GenerateCredential(
 		"Attestor/ak",         // IN
 		"Verifier/ek",         // IN
 		"Verifier/nonce",      // OUT
 		"Verifier/credential", // OUT
 	)
+	if err != nil {
+		lib.Fatal("%v", err)
+	}
 
 	// Attestor: activate credential
-	steps.ActivateCredential(
+	err = steps.ActivateCredential(
 		rwc,                   // IN
 		"Verifier/credential", // IN
 		"Attestor/ek",         // IN
 		"Attestor/ak",         // IN
 		"Attestor/attempt",    // OUT
 	)
+	if err != nil {
+		lib.Fatal("%v", err)
+	}
 
 	// Verifier: verify credential
-	steps.VerifyCredential(
+	err = steps.VerifyCredential(
 		"Attestor/attempt", // IN
 		"Verifier/nonce",   // IN
 		"Attestor/ak",      // IN
 		"Verifier/ak",      // OUT
 	)
+	if err != nil {
+		lib.Fatal("%v", err)
+	}
 
 	// Verifier: request PCR quote
-	steps.RequestQuote(
+	_, err = steps.RequestQuote(
 		"Verifier/nonce-quote", // OUT
 	)
+	if err != nil {
+		lib.Fatal("%v", err)
+	}
 
 	// Attestor: perform PCR quote
-	steps.PerformQuote(
+	_, _, err = steps.PerformQuote(
 		rwc,
-		"Attestor/ek",                           // IN
-		"Attestor/ak",                           // IN
-		[]int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 14}, // IN
-		"Verifier/nonce-quote",                  // IN
-		"Attestor/quote",                        // OUT
-	)
-
-	// Verifier: verify PCR quote
-	steps.VerifyQuote(
-		"Verifier/ak",          // IN
+		"Attestor/ek",          // IN
+		"Attestor/ak",          // IN
+		selection.TPM(),        // IN
 		"Verifier/nonce-quote", // IN
-		"CICD/cicd-digest",     // IN
 		"Attestor/quote",       // OUT
 	)
+	if err != nil {
+		lib.Fatal("%v", err)
+	}
+
+	// Verifier: verify PCR quote, along with the logs the policies of the
+	// reference values read
+	eventsLogPath := *eventsLog
+	if eventsLogPath == "" {
+		eventsLogPath, err = teepeem.EventsLogPath(*tpmPath)
+		if err != nil {
+			lib.Fatal("%v", err)
+		}
+	}
+	err = steps.VerifyQuote(
+		"Verifier/ak",               // IN
+		"Verifier/nonce-quote",      // IN
+		refvalues.DefaultPath,       // IN
+		refvalues.DefaultSignerPath, // IN
+		selection.TPM(),             // IN
+		"Attestor/quote",            // IN
+		eventsLogPath,               // IN
+		*imaLog,                     // IN
+	)
+	if err != nil {
+		lib.Fatal("%v", err)
+	}
 
 	// Verifier/Owner: create Owner AK Cert
-	certs.CreateAKCert(
-		"Verifier/ak",    // IN
-		"TPM AK",         // IN
-		"Owner/owner-ca", // IN
-		"Verifier/ak",    // OUT
+	err = certs.CreateAKCert(
+		"Verifier/ak",         // IN
+		"TPM AK",              // IN
+		info.ManufacturerID(), // IN
+		info.Model(),          // IN
+		info.Version(),        // IN
+		"Owner/owner-ca",      // IN
+		"Verifier/ak",         // OUT
 	)
+	if err != nil {
+		lib.Fatal("%v", err)
+	}
 
 	// Attestor: create SRK
-	steps.CreateSRK(
+	err = steps.CreateSRK(
 		rwc,
 		"Attestor/srk", // OUT
 	)
+	if err != nil {
+		lib.Fatal("%v", err)
+	}
 
 	// Verifier/Owner: create Owner SRT Cert
-	certs.CreateSRKCert(
+	err = certs.CreateSRKCert(
 		"Attestor/srk",   // IN
 		"TPM SRK",        // IN
 		"Owner/owner-ca", // IN
 		"Verifier/srk",   // OUT
 	)
+	if err != nil {
+		lib.Fatal("%v", err)
+	}
 }
@@ -0,0 +1,255 @@
+// SPDX-License-Identifier: Apache-2.0
+
+// Package pcrsel describes the PCRs that are quoted, sealed to and verified,
+// bank by bank. Onboarding records the selection next to the CICD reference
+// values, so that sealing, quoting and verification all use the same one.
+package pcrsel
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-tpm/tpm2"
+
+	"main/src/lib"
+)
+
+const (
+	// Where onboarding records the selection
+	DefaultPath = "CICD/pcr-selection.json"
+	// PCRs a TPM implements in each bank
+	NumPCRs = 24
+)
+
+// PCRs selected in each bank when none are configured
+var DefaultPCRs = []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 14}
+
+// Bank names
+const (
+	SHA1   = "sha1"
+	SHA256 = "sha256"
+	SHA384 = "sha384"
+)
+
+var algs = map[string]tpm2.Algorithm{
+	SHA1:   tpm2.AlgSHA1,
+	SHA256: tpm2.AlgSHA256,
+	SHA384: tpm2.AlgSHA384,
+}
+
+// Alg returns the hash algorithm of a bank.
+func Alg(
+	hash string, // IN
+) (tpm2.Algorithm, error) {
+
+	alg, ok := algs[hash]
+	if !ok {
+		return tpm2.AlgUnknown, fmt.Errorf("unknown PCR bank %q", hash)
+	}
+	return alg, nil
+}
+
+// Name returns the name of the bank of a hash algorithm.
+func Name(
+	alg tpm2.Algorithm, // IN
+) string {
+
+	for hash, a := range algs {
+		if a == alg {
+			return hash
+		}
+	}
+	return fmt.Sprintf("alg-0x%x", uint16(alg))
+}
+
+// Selection maps bank names to sorted, distinct PCR indexes. In a config
+// file, it reads as {"sha256": [0, 1, 2]}.
+type Selection map[string][]int
+
+// Parse reads a selection in the tpm2-tools format, e.g.
+// "sha1:0,7+sha256:0,1,2".
+func Parse(
+	spec string, // IN
+) (Selection, error) {
+
+	s := Selection{}
+	for _, bank := range strings.Split(spec, "+") {
+		hash, list, found := strings.Cut(bank, ":")
+		if !found {
+			return nil, fmt.Errorf("PCR selection %q: %q lacks a bank name", spec, bank)
+		}
+		pcrs := []int{}
+		for _, index := range strings.Split(list, ",") {
+			i, err := strconv.Atoi(strings.TrimSpace(index))
+			if err != nil {
+				return nil, fmt.Errorf("PCR selection %q: bad PCR index %q", spec, index)
+			}
+			pcrs = append(pcrs, i)
+		}
+		s[strings.TrimSpace(hash)] = append(s[strings.TrimSpace(hash)], pcrs...)
+	}
+
+	return s, s.normalize()
+}
+
+// Read reads a selection from a JSON config file.
+func Read(
+	path string, // IN
+) (Selection, error) {
+
+	data, err := lib.Read(path)
+	if err != nil {
+		return nil, err
+	}
+	s := Selection{}
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("json.Unmarshal() failed: %w", err)
+	}
+
+	return s, s.normalize()
+}
+
+// Write records a selection in a JSON config file.
+func (s Selection) Write(
+	path string, // OUT
+) error {
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("json.MarshalIndent() failed: %w", err)
+	}
+	return lib.Write(path, append(data, '\n'), 0644)
+}
+
+// Load returns the selection given by spec if any, else the one recorded in
+// the config file at path.
+func Load(
+	spec string, // IN
+	path string, // IN
+) (Selection, error) {
+
+	if spec != "" {
+		return Parse(spec)
+	}
+	s, err := Read(path)
+	if err != nil {
+		return nil, fmt.Errorf("no PCR selection given, and none recorded: %w", err)
+	}
+	return s, nil
+}
+
+// String renders the selection in the format Parse() reads.
+func (s Selection) String() string {
+	specs := []string{}
+	for _, hash := range s.Banks() {
+		indexes := []string{}
+		for _, i := range s[hash] {
+			indexes = append(indexes, strconv.Itoa(i))
+		}
+		specs = append(specs, hash+":"+strings.Join(indexes, ","))
+	}
+	return strings.Join(specs, "+")
+}
+
+// Banks lists the selected banks, in alphabetical order.
+func (s Selection) Banks() []string {
+	hashes := []string{}
+	for hash := range s {
+		hashes = append(hashes, hash)
+	}
+	sort.Strings(hashes)
+	return hashes
+}
+
+// TPM returns the selection as TPM2_Quote() takes it, one PCRSelection per
+// bank, from the weakest to the strongest hash.
+func (s Selection) TPM() []tpm2.PCRSelection {
+	sels := []tpm2.PCRSelection{}
+	for _, hash := range []string{SHA1, SHA256, SHA384} {
+		if len(s[hash]) != 0 {
+			sels = append(sels, tpm2.PCRSelection{
+				Hash: algs[hash],
+				PCRs: append([]int{}, s[hash]...),
+			})
+		}
+	}
+	return sels
+}
+
+// New validates a selection received from a peer, and returns a normalized
+// copy.
+func New(
+	m map[string][]int, // IN
+) (Selection, error) {
+
+	s := Selection{}
+	for hash, pcrs := range m {
+		s[hash] = append([]int{}, pcrs...)
+	}
+	return s, s.normalize()
+}
+
+// Default selects DefaultPCRs in each of some banks, among the PCRs available
+// there. Banks left without any PCR are not selected.
+func Default(
+	banks map[tpm2.Algorithm][]int, // IN
+) (Selection, error) {
+
+	s := Selection{}
+	for alg, available := range banks {
+		if _, ok := algs[Name(alg)]; !ok {
+			continue
+		}
+		pcrs := []int{}
+		for _, i := range DefaultPCRs {
+			for _, j := range available {
+				if i == j {
+					pcrs = append(pcrs, i)
+					break
+				}
+			}
+		}
+		if len(pcrs) != 0 {
+			s[Name(alg)] = pcrs
+		}
+	}
+	return s, s.normalize()
+}
+
+// Equal tells whether two normalized selections select the same PCRs.
+func (s Selection) Equal(
+	other Selection, // IN
+) bool {
+
+	return s.String() == other.String()
+}
+
+// normalize validates the selection, and sorts and deduplicates its indexes.
+func (s Selection) normalize() error {
+	if len(s) == 0 {
+		return fmt.Errorf("empty PCR selection")
+	}
+	for hash, pcrs := range s {
+		if _, err := Alg(hash); err != nil {
+			return fmt.Errorf("PCR selection: %w", err)
+		}
+		sort.Ints(pcrs)
+		distinct := []int{}
+		for k, i := range pcrs {
+			if i < 0 || i >= NumPCRs {
+				return fmt.Errorf("PCR selection: %s PCR[%d] is out of range", hash, i)
+			}
+			if k == 0 || i != pcrs[k-1] {
+				distinct = append(distinct, i)
+			}
+		}
+		if len(distinct) == 0 {
+			return fmt.Errorf("PCR selection: no %s PCRs selected", hash)
+		}
+		s[hash] = distinct
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package pcrsel
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/google/go-tpm/tpm2"
+)
+
+func TestParse(t *testing.T) {
+	s, err := Parse("sha256:14,0,7,0+sha1:7")
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+	expected := Selection{SHA1: {7}, SHA256: {0, 7, 14}}
+	if !reflect.DeepEqual(s, expected) {
+		t.Errorf("Parse() returned %v, expected %v", s, expected)
+	}
+	if s.String() != "sha1:7+sha256:0,7,14" {
+		t.Errorf("String() returned %q", s.String())
+	}
+	peer, err := New(map[string][]int{SHA256: {14, 7, 0}, SHA1: {7}})
+	if err != nil || !s.Equal(peer) {
+		t.Errorf("New() returned %v, %v, expected %v", peer, err, s)
+	}
+	if s.Equal(Selection{SHA256: {0, 7, 14}}) {
+		t.Errorf("Equal() ignores the %s bank", SHA1)
+	}
+	sels := s.TPM()
+	if len(sels) != 2 || sels[0].Hash != tpm2.AlgSHA1 || sels[1].Hash != tpm2.AlgSHA256 ||
+		!reflect.DeepEqual(sels[1].PCRs, []int{0, 7, 14}) {
+		t.Errorf("TPM() returned %v", sels)
+	}
+
+	for _, spec := range []string{"", "0,1", "md5:0", "sha256:24", "sha256:-1", "sha256:", "sha256:x"} {
+		if s, err := Parse(spec); err == nil {
+			t.Errorf("Parse(%q) returned %v, expected an error", spec, s)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pcr-selection.json")
+	if _, err := Load("", path); err == nil {
+		t.Errorf("Load() succeeded without a selection")
+	}
+
+	all := []int{}
+	for i := 0; i < NumPCRs; i++ {
+		all = append(all, i)
+	}
+	s, err := Default(map[tpm2.Algorithm][]int{tpm2.AlgSHA1: {4, 11}, tpm2.AlgSHA512: all})
+	if err != nil {
+		t.Fatalf("Default() failed: %v", err)
+	}
+	if s.String() != "sha1:4" {
+		t.Errorf("Default() returned %v, expected sha1:4", s)
+	}
+	if s, err := Default(map[tpm2.Algorithm][]int{tpm2.AlgSHA256: {11}}); err == nil {
+		t.Errorf("Default() returned %v without any default PCR, expected an error", s)
+	}
+	s, err = Default(map[tpm2.Algorithm][]int{tpm2.AlgSHA256: all})
+	if err != nil {
+		t.Fatalf("Default() failed: %v", err)
+	}
+	if s.String() != "sha256:0,1,2,3,4,5,6,7,8,9,14" {
+		t.Errorf("Default() returned %v", s)
+	}
+	if err := s.Write(path); err != nil {
+		t.Fatalf("Write() failed: %v", err)
+	}
+	got, err := Load("", path)
+	if err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+	if !reflect.DeepEqual(got, s) {
+		t.Errorf("Load() returned %v, expected %v", got, s)
+	}
+
+	// A selection given on the command line wins over the recorded one
+	got, err = Load("sha256:7", path)
+	if err != nil || got.String() != "sha256:7" {
+		t.Errorf("Load() returned %v, %v, expected sha256:7", got, err)
+	}
+}
@@ -0,0 +1,220 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"path/filepath"
+	"runtime/debug"
+	"strconv"
+	"strings"
+
+	"github.com/golang/glog"
+
+	"main/src/lib"
+	"main/src/pcrsel"
+	"main/src/refvalues"
+	"main/src/uefi"
+)
+
+// list is a repeatable flag.
+type list []string
+
+func (l *list) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *list) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+var (
+	applications     list
+	authorities      list
+	secureBoot       = flag.Bool("secure-boot", true, "Whether Secure Boot is enabled.")
+	pkPath           = flag.String("pk", "", "EFI signature list enrolled as PK (.esl), if any.")
+	kekPath          = flag.String("kek", "", "EFI signature list enrolled as KEK (.esl), if any.")
+	dbPath           = flag.String("db", "", "EFI signature list enrolled as db (.esl), if any.")
+	dbxPath          = flag.String("dbx", "", "EFI signature list enrolled as dbx (.esl), if any.")
+	bootOptionAction = flag.Bool("boot-option-action", true, "Whether firmware measures \"Calling EFI Application from Boot Option\" into PCR 4.")
+	ukiPath          = flag.String("uki", "", "Unified Kernel Image whose sections systemd-stub measures into PCR 11, if any.")
+	phases           = flag.String("phases", strings.Join(uefi.BootedPhases, ":"), "Boot phases measured into PCR 11 after the UKI sections, colon-separated, as systemd-measure(1) takes them.")
+	pcrsSpec         = flag.String("pcrs", "", "PCRs to predict, comma-separated (default: 4 and 7, and 11 with --uki).")
+	banks            = flag.String("banks", "sha1,sha256,sha384", "PCR banks to predict, comma-separated.")
+	outPath          = flag.String("out", "CICD/predicted-pcrs.json", "JSON file to write the predicted PCR values to, as ./publish --pcr-values reads them.")
+)
+
+func init() {
+	flag.Var(&applications, "app", "EFI application (shim, GRUB, kernel, UKI...), repeated in the order they are loaded.")
+	flag.Var(&authorities, "authority", "Entry an application is verified with, repeated in the order they are measured: db:<DER certificate>, shim:<DER certificate> or sbat:<SbatLevel file>.")
+}
+
+// ### Main ####################################################################
+
+func main() {
+	flag.Parse()
+
+	// Global panic handler
+	defer func() {
+		if message := recover(); message != nil {
+			glog.V(0).Infof("%s%s%s", lib.RED, message, lib.RESET)
+			glog.V(0).Infof("%s%s%s", lib.PURPLE, debug.Stack(), lib.RESET)
+		}
+	}()
+
+	lib.PRINT("### CICD: PREDICT PCR VALUES ###################################################")
+
+	// Read the assets the build pipeline produced
+	lib.PRINT("=== CICD: READ BOOT ASSETS =====================================================")
+	boot := uefi.Boot{
+		BootOptionAction: *bootOptionAction,
+		SecureBoot:       *secureBoot,
+		PK:               readOptional(*pkPath),
+		KEK:              readOptional(*kekPath),
+		DB:               readOptional(*dbPath),
+		DBX:              readOptional(*dbxPath),
+	}
+	for _, path := range applications {
+		image, err := lib.Read(path)
+		if err != nil {
+			lib.Fatal("%v", err)
+		}
+		boot.Applications = append(boot.Applications, uefi.Application{
+			Name:  filepath.Base(path),
+			Image: image,
+		})
+	}
+	for _, spec := range authorities {
+		authority, err := readAuthority(spec, boot.DB)
+		if err != nil {
+			lib.Fatal("--authority=%s: %v", spec, err)
+		}
+		boot.Authorities = append(boot.Authorities, authority)
+	}
+	indexes := []int{4, 7}
+	events := boot.Events()
+	if *ukiPath != "" {
+		image, err := lib.Read(*ukiPath)
+		if err != nil {
+			lib.Fatal("%v", err)
+		}
+		ukiEvents, err := uefi.UKIEvents(image, splitPhases(*phases))
+		if err != nil {
+			lib.Fatal("%v", err)
+		}
+		indexes = append(indexes, 11)
+		events = append(events, ukiEvents...)
+	}
+	if *pcrsSpec != "" {
+		indexes = []int{}
+		for _, index := range strings.Split(*pcrsSpec, ",") {
+			i, err := strconv.Atoi(strings.TrimSpace(index))
+			if err != nil || (i != 4 && i != 7 && i != 11) {
+				lib.Fatal("--pcrs: cannot predict PCR %q", index)
+			}
+			indexes = append(indexes, i)
+		}
+	}
+
+	// Replay the measurements firmware, shim and systemd-stub make, in each
+	// bank
+	lib.PRINT("=== CICD: PREDICT PCR VALUES ===================================================")
+	pcrValues := map[string]map[int]refvalues.Hex{}
+	for _, bank := range strings.Split(*banks, ",") {
+		alg, err := pcrsel.Alg(strings.TrimSpace(bank))
+		if err != nil {
+			lib.Fatal("%v", err)
+		}
+		hash, err := alg.Hash()
+		if err != nil {
+			lib.Fatal("%s bank: %v", bank, err)
+		}
+		for k := range events {
+			digest, err := events[k].Digest(hash)
+			if err != nil {
+				lib.Fatal("%v", err)
+			}
+			lib.Verbose("%s PCR[%d] %s %s: 0x%s", pcrsel.Name(alg), events[k].PCR,
+				uefi.TypeName(events[k].Type), events[k].Description, hex.EncodeToString(digest))
+		}
+		pcrs, err := uefi.Replay(events, hash)
+		if err != nil {
+			lib.Fatal("%v", err)
+		}
+		pcrValues[pcrsel.Name(alg)] = map[int]refvalues.Hex{}
+		for _, i := range indexes {
+			if pcrs[i] == nil {
+				lib.Fatal("no measurements into PCR %d", i)
+			}
+			pcrValues[pcrsel.Name(alg)][i] = pcrs[i]
+			lib.Print("%s PCR[%d]: 0x%s", pcrsel.Name(alg), i, hex.EncodeToString(pcrs[i]))
+		}
+	}
+
+	data, err := json.MarshalIndent(pcrValues, "", "  ")
+	if err != nil {
+		lib.Fatal("json.MarshalIndent() failed: %v", err)
+	}
+	err = lib.Write(*outPath, append(data, '\n'), 0644)
+	if err != nil {
+		lib.Fatal("%v", err)
+	}
+}
+
+// readOptional reads a file, if any.
+func readOptional(
+	path string, // IN
+) []byte {
+
+	if path == "" {
+		return nil
+	}
+	data, err := lib.Read(path)
+	if err != nil {
+		lib.Fatal("%v", err)
+	}
+	return data
+}
+
+// splitPhases reads a --phases flag.
+func splitPhases(
+	spec string, // IN
+) []string {
+
+	phases := []string{}
+	for _, phase := range strings.Split(spec, ":") {
+		if phase != "" {
+			phases = append(phases, phase)
+		}
+	}
+	return phases
+}
+
+// readAuthority reads an --authority flag.
+func readAuthority(
+	spec string, // IN
+	db []byte, // IN
+) (uefi.Authority, error) {
+
+	kind, path, found := strings.Cut(spec, ":")
+	if !found {
+		return uefi.Authority{}, fmt.Errorf("<kind>:<path> expected")
+	}
+	data, err := lib.Read(path)
+	if err != nil {
+		return uefi.Authority{}, err
+	}
+	switch kind {
+	case "db":
+		return uefi.DBAuthority(db, data)
+	case "shim":
+		return uefi.ShimAuthority(data), nil
+	case "sbat":
+		return uefi.SbatLevelAuthority(data), nil
+	}
+	return uefi.Authority{}, fmt.Errorf("unknown authority kind %q", kind)
+}
@@ -0,0 +1,180 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"runtime/debug"
+	"time"
+
+	"github.com/golang/glog"
+
+	"main/src/lib"
+	"main/src/refvalues"
+	"main/src/verifier"
+)
+
+var (
+	name             = flag.String("name", "", "Name of the set of reference values, e.g. the release it describes.")
+	eventsLogPath    = flag.String("events-log", "", "TCG events log of a reference boot of the assets the build pipeline produced, to replay into PCR values.")
+	pcrValuesPath    = flag.String("pcr-values", "", "JSON file holding PCR values by bank and index, e.g. {\"sha256\": {\"4\": \"<hex>\"}}, as ./predict writes them; they take precedence over the ones replayed from --events-log.")
+	notBefore        = flag.String("not-before", "", "Start of validity of the set (RFC 3339), if any.")
+	notAfter         = flag.String("not-after", "", "End of validity of the set (RFC 3339), if any.")
+	appendSet        = flag.Bool("append", false, "Keep the sets of the current manifest, but for one of the same name, and its Secure Boot, events and IMA policies.")
+	policyPath       = flag.String("secure-boot-policy", "", "JSON file holding the Secure Boot policy, e.g. {\"variables\": {\"db\": [\"<SHA-256 of db>\"]}, \"authorities\": [\"<SHA-256 of a DER certificate>\"]}.")
+	eventsPolicyPath = flag.String("events-policy", "", "JSON file holding the events policy, e.g. {\"pcrs\": [8], \"rules\": [{\"name\": \"lockdown\", \"kind\": \"kernel-cmdline\", \"contains\": [\"lockdown=integrity\"]}]}.")
+	imaPolicyPath    = flag.String("ima-policy", "", "JSON file holding the IMA policy, e.g. {\"allowlist\": {\"/usr/bin/bash\": [\"<SHA-256 of the file>\"]}, \"denylist\": [\"<hex>\"], \"exclude\": [\"/var/log/\"]}.")
+	manifestPath     = flag.String("reference-values", refvalues.DefaultPath, "Path prefix of the signed manifest.")
+	signerPath       = flag.String("cicd-signer", refvalues.DefaultSignerPath, "Path prefix of the CICD signing key.")
+)
+
+// ### Main ####################################################################
+
+func main() {
+	flag.Parse()
+
+	// Global panic handler
+	defer func() {
+		if message := recover(); message != nil {
+			glog.V(0).Infof("%s%s%s", lib.RED, message, lib.RESET)
+			glog.V(0).Infof("%s%s%s", lib.PURPLE, debug.Stack(), lib.RESET)
+		}
+	}()
+
+	lib.PRINT("### CICD: PUBLISH REFERENCE VALUES #############################################")
+
+	if *name == "" {
+		lib.Fatal("--name is required")
+	}
+
+	// Compute the PCR values of the assets, from a reference boot and/or as
+	// the build pipeline predicted them (see ./predict)
+	lib.PRINT("=== CICD: PREDICT PCR VALUES ===================================================")
+	if *eventsLogPath == "" && *pcrValuesPath == "" {
+		lib.Fatal("--events-log or --pcr-values is required")
+	}
+	set := refvalues.Set{Name: *name, PCRs: map[string]map[int]refvalues.Hex{}}
+	if *eventsLogPath != "" {
+		eventsLog, err := lib.Read(*eventsLogPath)
+		if err != nil {
+			lib.Fatal("%v", err)
+		}
+		pcrs, err := verifier.ReplayEventsLog(eventsLog)
+		if err != nil {
+			lib.Fatal("%v", err)
+		}
+		set = refvalues.NewSet(*name, pcrs)
+	}
+	if *pcrValuesPath != "" {
+		data, err := lib.Read(*pcrValuesPath)
+		if err != nil {
+			lib.Fatal("%v", err)
+		}
+		predicted := map[string]map[int]refvalues.Hex{}
+		if err := json.Unmarshal(data, &predicted); err != nil {
+			lib.Fatal("json.Unmarshal() failed: %v", err)
+		}
+		// Predicted values take precedence over replayed ones
+		for bank, values := range predicted {
+			if set.PCRs[bank] == nil {
+				set.PCRs[bank] = map[int]refvalues.Hex{}
+			}
+			for i, value := range values {
+				set.PCRs[bank][i] = value
+			}
+		}
+	}
+	for bank, values := range set.PCRs {
+		for i, value := range values {
+			lib.Verbose("%s PCR[%2d]: 0x%s", bank, i, hex.EncodeToString(value))
+		}
+	}
+	set.NotBefore = parseTime("not-before", *notBefore)
+	set.NotAfter = parseTime("not-after", *notAfter)
+
+	// Add the set to the current manifest, or start a new one
+	lib.PRINT("=== CICD: SIGN REFERENCE VALUES ================================================")
+	manifest := &refvalues.Manifest{Version: refvalues.Version}
+	if *appendSet {
+		current, err := refvalues.Read(*manifestPath, *signerPath)
+		if err != nil {
+			lib.Fatal("%v", err)
+		}
+		for _, s := range current.Sets {
+			if s.Name != *name {
+				manifest.Sets = append(manifest.Sets, s)
+			}
+		}
+		manifest.SecureBoot = current.SecureBoot
+		manifest.EventsPolicy = current.EventsPolicy
+		manifest.IMA = current.IMA
+	}
+	manifest.Sets = append(manifest.Sets, set)
+	if *policyPath != "" {
+		data, err := lib.Read(*policyPath)
+		if err != nil {
+			lib.Fatal("%v", err)
+		}
+		manifest.SecureBoot = &refvalues.SecureBootPolicy{}
+		if err := json.Unmarshal(data, manifest.SecureBoot); err != nil {
+			lib.Fatal("json.Unmarshal() failed: %v", err)
+		}
+	}
+	if *eventsPolicyPath != "" {
+		data, err := lib.Read(*eventsPolicyPath)
+		if err != nil {
+			lib.Fatal("%v", err)
+		}
+		manifest.EventsPolicy = &verifier.EventsPolicy{}
+		if err := json.Unmarshal(data, manifest.EventsPolicy); err != nil {
+			lib.Fatal("json.Unmarshal() failed: %v", err)
+		}
+	}
+	if *imaPolicyPath != "" {
+		data, err := lib.Read(*imaPolicyPath)
+		if err != nil {
+			lib.Fatal("%v", err)
+		}
+		manifest.IMA = &refvalues.IMAPolicy{}
+		if err := json.Unmarshal(data, manifest.IMA); err != nil {
+			lib.Fatal("json.Unmarshal() failed: %v", err)
+		}
+	}
+
+	err := manifest.Write(*manifestPath, *signerPath)
+	if err != nil {
+		lib.Fatal("%v", err)
+	}
+	for _, s := range manifest.Sets {
+		lib.Print("Reference values %q", s.Name)
+	}
+	if manifest.SecureBoot != nil {
+		lib.Print("Secure Boot policy: %d variables, %d authorities",
+			len(manifest.SecureBoot.Variables), len(manifest.SecureBoot.Authorities))
+	}
+	if manifest.EventsPolicy != nil {
+		lib.Print("Events policy: %d rules for PCRs %v", len(manifest.EventsPolicy.Rules), manifest.EventsPolicy.PCRs)
+	}
+	if manifest.IMA != nil {
+		lib.Print("IMA policy: %d allowed files, %d denied digests, %d excluded prefixes",
+			len(manifest.IMA.Allowlist), len(manifest.IMA.Denylist), len(manifest.IMA.Exclude))
+	}
+}
+
+// parseTime parses an optional RFC 3339 time flag.
+func parseTime(
+	flagName string, // IN
+	value string, // IN
+) *time.Time {
+
+	if value == "" {
+		return nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		lib.Fatal("--%s: time.Parse() failed: %v", flagName, err)
+	}
+	return &t
+}
@@ -0,0 +1,498 @@
+// SPDX-License-Identifier: Apache-2.0
+
+// Package refvalues handles the reference-value manifest the CICD publishes:
+// the PCR values, or PCRs digests, that quotes and sealing policies are
+// checked against, the Secure Boot configurations devices may boot with, the
+// rules the events of some PCRs must comply with, and the files IMA may
+// measure.
+// The CICD derives them from the assets its build pipeline produces, and signs
+// the manifest; verifiers only use a manifest whose signature checks.
+package refvalues
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"main/src/certs"
+	"main/src/lib"
+	"main/src/pcrsel"
+	"main/src/verifier"
+)
+
+const (
+	// Where the CICD publishes the manifest (.json) and its signature (.sig)
+	DefaultPath = "CICD/reference-values"
+	// Where the CICD keeps its signing key (.key) and publishes the public
+	// key verifiers check the manifest with (.pub)
+	DefaultSignerPath = "CICD/cicd-signer"
+	// Manifest format version
+	Version = 1
+)
+
+// === Manifest ================================================================
+
+// Manifest lists the accepted sets of reference values. In JSON, it reads as:
+//
+//	{"version": 1, "sets": [{"name": "release-42",
+//	  "not-before": "2023-06-01T00:00:00Z", "not-after": "2023-12-01T00:00:00Z",
+//	  "pcrs": {"sha256": {"0": "<hex>", ...}},
+//	  "digests": [{"pcr-selection": {"sha256": [0, 7]}, "digest": "<hex>"}]}],
+//	 "secure-boot": {"variables": {"db": ["<hex>"]}, "authorities": ["<hex>"]},
+//	 "events-policy": {"pcrs": [8, 9], "rules": [{"name": "lockdown",
+//	  "kind": "kernel-cmdline", "contains": ["lockdown=integrity"]},
+//	  {"name": "kernel", "kind": "file", "path": "*/boot/vmlinuz-*",
+//	  "digests": ["<hex>"]}]},
+//	 "ima": {"allowlist": {"/usr/bin/bash": ["<hex>"]}, "denylist": ["<hex>"],
+//	  "exclude": ["/var/log/"]}}
+type Manifest struct {
+	Version int   `json:"version"`
+	Sets    []Set `json:"sets"`
+	// Secure Boot configurations devices may boot with, when checked
+	SecureBoot *SecureBootPolicy `json:"secure-boot,omitempty"`
+	// Rules the events of some PCRs must comply with, in place of reference
+	// values for them
+	EventsPolicy *verifier.EventsPolicy `json:"events-policy,omitempty"`
+	// Files IMA may measure, when checked
+	IMA *IMAPolicy `json:"ima,omitempty"`
+}
+
+// Set is one accepted set of reference values, e.g. for one firmware release.
+// It is only valid within its validity window, when it has one.
+type Set struct {
+	Name      string     `json:"name"`
+	NotBefore *time.Time `json:"not-before,omitempty"`
+	NotAfter  *time.Time `json:"not-after,omitempty"`
+	// PCR values, by bank name and PCR index
+	PCRs map[string]map[int]Hex `json:"pcrs,omitempty"`
+	// PCRs digests of some selections, for PCRs whose values are not
+	// published
+	Digests []Digest `json:"digests,omitempty"`
+}
+
+// Digest is the PCRs digest of a quote over some selection.
+type Digest struct {
+	PCRSelection pcrsel.Selection `json:"pcr-selection"`
+	Digest       Hex              `json:"digest"`
+}
+
+// SecureBootPolicy is a verifier.SecureBootPolicy, with SHA-256 digests of
+// the accepted contents of PK, KEK, db and dbx by variable name, and of the
+// DER certificates images may be verified with.
+type SecureBootPolicy struct {
+	Variables   map[string][]Hex `json:"variables,omitempty"`
+	Authorities []Hex            `json:"authorities,omitempty"`
+}
+
+// IMAPolicy is a verifier.IMAPolicy: the accepted digests of files by path,
+// digests of files that must not be loaded, and path prefixes not checked.
+type IMAPolicy struct {
+	Allowlist map[string][]Hex `json:"allowlist,omitempty"`
+	Denylist  []Hex            `json:"denylist,omitempty"`
+	Exclude   []string         `json:"exclude,omitempty"`
+}
+
+// Hex is a byte string carried as a hex string.
+type Hex []byte
+
+func (h Hex) MarshalJSON() ([]byte, error) {
+	return json.Marshal(hex.EncodeToString(h))
+}
+
+func (h *Hex) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return fmt.Errorf("hex string expected: %w", err)
+	}
+	decoded, err := hex.DecodeString(text)
+	if err != nil {
+		return fmt.Errorf("hex.DecodeString() failed: %w", err)
+	}
+	*h = decoded
+	return nil
+}
+
+// NewSet returns a set of reference values holding PCR values, e.g. replayed
+// from a reference events log.
+func NewSet(
+	name string, // IN
+	pcrs verifier.Banks, // IN
+) Set {
+
+	s := Set{Name: name, PCRs: map[string]map[int]Hex{}}
+	for alg, values := range pcrs {
+		bank := map[int]Hex{}
+		for i, value := range values {
+			bank[i] = value
+		}
+		s.PCRs[pcrsel.Name(alg)] = bank
+	}
+	return s
+}
+
+// ValidAt tells whether a set is within its validity window.
+func (s *Set) ValidAt(
+	at time.Time, // IN
+) bool {
+
+	return (s.NotBefore == nil || !at.Before(*s.NotBefore)) &&
+		(s.NotAfter == nil || !at.After(*s.NotAfter))
+}
+
+// Banks returns the PCR values of a set by bank.
+func (s *Set) Banks() verifier.Banks {
+	banks := verifier.Banks{}
+	for bank, values := range s.PCRs {
+		// validate() made sure every bank is known
+		alg, _ := pcrsel.Alg(bank)
+		banks[alg] = map[int][]byte{}
+		for i, value := range values {
+			banks[alg][i] = value
+		}
+	}
+	return banks
+}
+
+// Reference returns a set as the verifier takes it.
+func (s *Set) Reference() verifier.Reference {
+	r := verifier.Reference{Name: s.Name}
+	if len(s.PCRs) != 0 {
+		r.PCRs = s.Banks()
+	}
+	for _, d := range s.Digests {
+		r.Digests = append(r.Digests, verifier.Digest{
+			Selection: d.PCRSelection.TPM(),
+			Value:     d.Digest,
+		})
+	}
+	return r
+}
+
+// Policy returns the Secure Boot policy as the verifier takes it.
+func (p *SecureBootPolicy) Policy() *verifier.SecureBootPolicy {
+	policy := &verifier.SecureBootPolicy{Variables: map[string][][]byte{}}
+	for name, digests := range p.Variables {
+		for _, d := range digests {
+			policy.Variables[name] = append(policy.Variables[name], d)
+		}
+	}
+	for _, d := range p.Authorities {
+		policy.Authorities = append(policy.Authorities, d)
+	}
+	return policy
+}
+
+// Policy returns the IMA policy as the verifier takes it.
+func (p *IMAPolicy) Policy() *verifier.IMAPolicy {
+	policy := &verifier.IMAPolicy{Allowlist: map[string][][]byte{}, Exclude: p.Exclude}
+	for path, digests := range p.Allowlist {
+		for _, d := range digests {
+			policy.Allowlist[path] = append(policy.Allowlist[path], d)
+		}
+	}
+	for _, d := range p.Denylist {
+		policy.Denylist = append(policy.Denylist, d)
+	}
+	return policy
+}
+
+// References returns the sets valid at some time, as the verifier takes them.
+func (m *Manifest) References(
+	at time.Time, // IN
+) ([]verifier.Reference, error) {
+
+	references := []verifier.Reference{}
+	for k := range m.Sets {
+		if m.Sets[k].ValidAt(at) {
+			references = append(references, m.Sets[k].Reference())
+		}
+	}
+	if len(references) == 0 {
+		return nil, fmt.Errorf("none of the %d sets of reference values is valid at %v",
+			len(m.Sets), at.Format(time.RFC3339))
+	}
+	return references, nil
+}
+
+// Latest returns the set of PCR values valid at some time that became valid
+// last, e.g. to seal to. Sets without a start of validity come first, then
+// sets are taken in the order of the manifest.
+func (m *Manifest) Latest(
+	at time.Time, // IN
+) (*Set, error) {
+
+	var latest *Set
+	for k := range m.Sets {
+		s := &m.Sets[k]
+		if !s.ValidAt(at) || len(s.PCRs) == 0 {
+			continue
+		}
+		if latest == nil || latest.NotBefore == nil ||
+			(s.NotBefore != nil && !s.NotBefore.Before(*latest.NotBefore)) {
+			latest = s
+		}
+	}
+	if latest == nil {
+		return nil, fmt.Errorf("no set of PCR values is valid at %v", at.Format(time.RFC3339))
+	}
+	return latest, nil
+}
+
+// validate checks that a manifest is well-formed.
+func (m *Manifest) validate() error {
+	if m.Version != Version {
+		return fmt.Errorf("manifest version is %d, expected %d", m.Version, Version)
+	}
+	if len(m.Sets) == 0 {
+		return fmt.Errorf("manifest has no set of reference values")
+	}
+	names := map[string]bool{}
+	for k := range m.Sets {
+		s := &m.Sets[k]
+		if s.Name == "" {
+			return fmt.Errorf("set #%d has no name", k)
+		}
+		if names[s.Name] {
+			return fmt.Errorf("set %q is listed twice", s.Name)
+		}
+		names[s.Name] = true
+		if err := s.validate(); err != nil {
+			return fmt.Errorf("set %q: %w", s.Name, err)
+		}
+	}
+	if m.SecureBoot != nil {
+		if err := m.SecureBoot.validate(); err != nil {
+			return fmt.Errorf("Secure Boot policy: %w", err)
+		}
+	}
+	if m.EventsPolicy != nil {
+		if err := m.EventsPolicy.Validate(); err != nil {
+			return fmt.Errorf("events policy: %w", err)
+		}
+	}
+	if m.IMA != nil {
+		if err := m.IMA.validate(); err != nil {
+			return fmt.Errorf("IMA policy: %w", err)
+		}
+	}
+	return nil
+}
+
+func (s *Set) validate() error {
+	if s.NotBefore != nil && s.NotAfter != nil && s.NotAfter.Before(*s.NotBefore) {
+		return fmt.Errorf("not-after %v is before not-before %v",
+			s.NotAfter.Format(time.RFC3339), s.NotBefore.Format(time.RFC3339))
+	}
+	if len(s.PCRs) == 0 && len(s.Digests) == 0 {
+		return fmt.Errorf("no PCR values nor digests")
+	}
+	for bank, values := range s.PCRs {
+		alg, err := pcrsel.Alg(bank)
+		if err != nil {
+			return err
+		}
+		hash, err := alg.Hash()
+		if err != nil {
+			return fmt.Errorf("%s bank: %w", bank, err)
+		}
+		for i, value := range values {
+			if i < 0 || i >= pcrsel.NumPCRs {
+				return fmt.Errorf("%s PCR[%d] is out of range", bank, i)
+			}
+			if len(value) != hash.Size() {
+				return fmt.Errorf("%s PCR[%d] is %d bytes long, expected %d", bank, i, len(value), hash.Size())
+			}
+		}
+	}
+	for k := range s.Digests {
+		d := &s.Digests[k]
+		selection, err := pcrsel.New(d.PCRSelection)
+		if err != nil {
+			return fmt.Errorf("digest #%d: %w", k, err)
+		}
+		d.PCRSelection = selection
+		// Quotes are signed with SHA-256, which also hashes the PCRs
+		if len(d.Digest) != sha256.Size {
+			return fmt.Errorf("digest of PCRs %s is %d bytes long, expected %d",
+				selection, len(d.Digest), sha256.Size)
+		}
+	}
+	return nil
+}
+
+func (p *SecureBootPolicy) validate() error {
+	for name, digests := range p.Variables {
+		known := false
+		for _, v := range verifier.SecureBootVariables {
+			known = known || v == name
+		}
+		if !known {
+			return fmt.Errorf("unknown variable %q, expected one of %v", name, verifier.SecureBootVariables)
+		}
+		for _, d := range digests {
+			if len(d) != sha256.Size {
+				return fmt.Errorf("%s digest is %d bytes long, expected %d", name, len(d), sha256.Size)
+			}
+		}
+	}
+	for _, d := range p.Authorities {
+		if len(d) != sha256.Size {
+			return fmt.Errorf("authority digest is %d bytes long, expected %d", len(d), sha256.Size)
+		}
+	}
+	return nil
+}
+
+func (p *IMAPolicy) validate() error {
+	// IMA measures files with SHA-1, SHA-256, SHA-384 or SHA-512
+	validDigest := func(d Hex) bool {
+		return len(d) == 20 || len(d) == 32 || len(d) == 48 || len(d) == 64
+	}
+	for path, digests := range p.Allowlist {
+		if !strings.HasPrefix(path, "/") {
+			return fmt.Errorf("path %q is not absolute", path)
+		}
+		for _, d := range digests {
+			if !validDigest(d) {
+				return fmt.Errorf("%s digest is %d bytes long", path, len(d))
+			}
+		}
+	}
+	for _, d := range p.Denylist {
+		if !validDigest(d) {
+			return fmt.Errorf("denied digest is %d bytes long", len(d))
+		}
+	}
+	for _, prefix := range p.Exclude {
+		if !strings.HasPrefix(prefix, "/") {
+			return fmt.Errorf("excluded prefix %q is not absolute", prefix)
+		}
+	}
+	return nil
+}
+
+// === Signature ===============================================================
+
+// Sign encodes a manifest and signs it (RSASSA-PKCS1-v1_5 with SHA-256).
+func (m *Manifest) Sign(
+	key *rsa.PrivateKey, // IN
+) (
+	data []byte,
+	signature []byte,
+	err error,
+) {
+
+	if err := m.validate(); err != nil {
+		return nil, nil, err
+	}
+	data, err = json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, nil, fmt.Errorf("json.MarshalIndent() failed: %w", err)
+	}
+	data = append(data, '\n')
+	digest := sha256.Sum256(data)
+	signature, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return nil, nil, fmt.Errorf("rsa.SignPKCS1v15() failed: %w", err)
+	}
+	return data, signature, nil
+}
+
+// Parse checks the signature of an encoded manifest with the PEM-encoded
+// public key of the CICD, then decodes it. Nothing in the manifest is looked
+// at before the signature checks.
+func Parse(
+	data []byte, // IN
+	signature []byte, // IN
+	signerPubPEM []byte, // IN
+) (*Manifest, error) {
+
+	signerPublicKey, err := verifier.ParsePublicKeyPEM(signerPubPEM)
+	if err != nil {
+		return nil, fmt.Errorf("CICD public key: %w", err)
+	}
+	rsaPublicKey, ok := signerPublicKey.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("unsupported CICD key type %T", signerPublicKey)
+	}
+	digest := sha256.Sum256(data)
+	if err := rsa.VerifyPKCS1v15(rsaPublicKey, crypto.SHA256, digest[:], signature); err != nil {
+		return nil, fmt.Errorf("manifest signature does not verify: rsa.VerifyPKCS1v15() failed: %w", err)
+	}
+
+	m := &Manifest{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("json.Unmarshal() failed: %w", err)
+	}
+	if err := m.validate(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// === Files ===================================================================
+
+// Read reads the manifest at <path>.json and its signature at <path>.sig, and
+// checks the signature with the CICD public key at <signerPath>.pub.
+func Read(
+	path string, // IN
+	signerPath string, // IN
+) (*Manifest, error) {
+
+	data, signature, signerPubPEM, err := ReadFiles(path, signerPath)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data, signature, signerPubPEM)
+}
+
+// ReadFiles reads an encoded manifest, its signature and the CICD public key,
+// for Parse() to check.
+func ReadFiles(
+	path string, // IN
+	signerPath string, // IN
+) (
+	data []byte,
+	signature []byte,
+	signerPubPEM []byte,
+	err error,
+) {
+
+	if data, err = lib.Read(fmt.Sprintf("%s.json", path)); err != nil {
+		return nil, nil, nil, err
+	}
+	if signature, err = lib.Read(fmt.Sprintf("%s.sig", path)); err != nil {
+		return nil, nil, nil, err
+	}
+	if signerPubPEM, err = lib.Read(fmt.Sprintf("%s.pub", signerPath)); err != nil {
+		return nil, nil, nil, err
+	}
+	return data, signature, signerPubPEM, nil
+}
+
+// Write signs a manifest with the CICD private key at <signerPath>.key, and
+// writes it to <path>.json and its signature to <path>.sig.
+func (m *Manifest) Write(
+	path string, // OUT
+	signerPath string, // IN
+) error {
+
+	key, err := certs.ReadKey(signerPath)
+	if err != nil {
+		return err
+	}
+	data, signature, err := m.Sign(&key)
+	if err != nil {
+		return err
+	}
+	if err := lib.Write(fmt.Sprintf("%s.json", path), data, 0644); err != nil {
+		return err
+	}
+	return lib.Write(fmt.Sprintf("%s.sig", path), signature, 0644)
+}
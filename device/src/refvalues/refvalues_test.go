@@ -0,0 +1,216 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package refvalues
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/google/go-tpm/tpm2"
+
+	"main/src/verifier"
+)
+
+func signer(t *testing.T) (*rsa.PrivateKey, []byte) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey() failed: %v", err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("x509.MarshalPKIXPublicKey() failed: %v", err)
+	}
+	return key, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
+
+func TestSignParse(t *testing.T) {
+	key, pubPEM := signer(t)
+	set := NewSet("release-1", verifier.Banks{
+		tpm2.AlgSHA256: {0: make([]byte, 32), 7: bytes.Repeat([]byte{7}, 32)},
+	})
+	m := &Manifest{Version: Version, Sets: []Set{set}}
+	data, signature, err := m.Sign(key)
+	if err != nil {
+		t.Fatalf("Sign() failed: %v", err)
+	}
+
+	got, err := Parse(data, signature, pubPEM)
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+	banks := got.Sets[0].Banks()
+	if len(banks) != 1 || !bytes.Equal(banks[tpm2.AlgSHA256][7], bytes.Repeat([]byte{7}, 32)) {
+		t.Errorf("Parse() returned PCR values %v", banks)
+	}
+
+	// Anything the CICD did not sign is rejected
+	if _, err := Parse(append(data, ' '), signature, pubPEM); err == nil {
+		t.Errorf("Parse() accepted a modified manifest")
+	}
+	_, otherPubPEM := signer(t)
+	if _, err := Parse(data, signature, otherPubPEM); err == nil {
+		t.Errorf("Parse() accepted a manifest signed by another key")
+	}
+
+	// Malformed manifests are not signed
+	for _, bad := range []Set{
+		{Name: "no-values"},
+		{Name: "short", PCRs: map[string]map[int]Hex{"sha256": {0: make([]byte, 20)}}},
+		{Name: "out-of-range", PCRs: map[string]map[int]Hex{"sha1": {24: make([]byte, 20)}}},
+		{Name: "unknown-bank", PCRs: map[string]map[int]Hex{"md5": {0: make([]byte, 16)}}},
+	} {
+		if _, _, err := (&Manifest{Version: Version, Sets: []Set{bad}}).Sign(key); err == nil {
+			t.Errorf("Sign() accepted set %q", bad.Name)
+		}
+	}
+}
+
+func TestValidity(t *testing.T) {
+	now := time.Now()
+	past, earlier, future := now.Add(-time.Hour), now.Add(-2*time.Hour), now.Add(time.Hour)
+	pcrs := map[string]map[int]Hex{"sha256": {0: make([]byte, 32)}}
+	m := &Manifest{Version: Version, Sets: []Set{
+		{Name: "current", NotBefore: &past, PCRs: pcrs},
+		{Name: "expired", NotAfter: &past, PCRs: pcrs},
+		{Name: "previous", NotBefore: &earlier, NotAfter: &future, PCRs: pcrs},
+		{Name: "upcoming", NotBefore: &future, PCRs: pcrs},
+	}}
+
+	references, err := m.References(now)
+	if err != nil {
+		t.Fatalf("References() failed: %v", err)
+	}
+	if len(references) != 2 || references[0].Name != "current" || references[1].Name != "previous" {
+		t.Errorf("References() returned %v, expected current and previous", references)
+	}
+	latest, err := m.Latest(now)
+	if err != nil || latest.Name != "current" {
+		t.Errorf("Latest() returned %v, %v, expected current", latest, err)
+	}
+	if _, err := m.References(now.Add(-3 * time.Hour)); err != nil {
+		t.Errorf("References() failed: %v", err)
+	}
+	if _, err := (&Manifest{Version: Version, Sets: m.Sets[1:2]}).Latest(now); err == nil {
+		t.Errorf("Latest() returned an expired set")
+	}
+}
+
+func TestSecureBootPolicy(t *testing.T) {
+	key, pubPEM := signer(t)
+	set := NewSet("release-1", verifier.Banks{tpm2.AlgSHA256: {7: make([]byte, 32)}})
+	policy := &SecureBootPolicy{
+		Variables:   map[string][]Hex{"db": {bytes.Repeat([]byte{1}, 32), bytes.Repeat([]byte{2}, 32)}},
+		Authorities: []Hex{bytes.Repeat([]byte{3}, 32)},
+	}
+	data, signature, err := (&Manifest{Version: Version, Sets: []Set{set}, SecureBoot: policy}).Sign(key)
+	if err != nil {
+		t.Fatalf("Sign() failed: %v", err)
+	}
+	m, err := Parse(data, signature, pubPEM)
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+	if p := m.SecureBoot.Policy(); len(p.Variables["db"]) != 2 || !bytes.Equal(p.Authorities[0], policy.Authorities[0]) {
+		t.Errorf("Parse() returned Secure Boot policy %+v", p)
+	}
+
+	for _, bad := range []*SecureBootPolicy{
+		{Variables: map[string][]Hex{"MokList": {make([]byte, 32)}}},
+		{Variables: map[string][]Hex{"db": {make([]byte, 20)}}},
+		{Authorities: []Hex{make([]byte, 20)}},
+	} {
+		if _, _, err := (&Manifest{Version: Version, Sets: []Set{set}, SecureBoot: bad}).Sign(key); err == nil {
+			t.Errorf("Sign() accepted Secure Boot policy %+v", bad)
+		}
+	}
+}
+
+func TestIMAPolicy(t *testing.T) {
+	key, pubPEM := signer(t)
+	set := NewSet("release-1", verifier.Banks{tpm2.AlgSHA256: {7: make([]byte, 32)}})
+	policy := &IMAPolicy{
+		Allowlist: map[string][]Hex{"/usr/bin/bash": {bytes.Repeat([]byte{1}, 32), bytes.Repeat([]byte{2}, 20)}},
+		Denylist:  []Hex{bytes.Repeat([]byte{3}, 32)},
+		Exclude:   []string{"/var/log/"},
+	}
+	data, signature, err := (&Manifest{Version: Version, Sets: []Set{set}, IMA: policy}).Sign(key)
+	if err != nil {
+		t.Fatalf("Sign() failed: %v", err)
+	}
+	m, err := Parse(data, signature, pubPEM)
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+	if p := m.IMA.Policy(); len(p.Allowlist["/usr/bin/bash"]) != 2 || !bytes.Equal(p.Denylist[0], policy.Denylist[0]) ||
+		p.Exclude[0] != "/var/log/" {
+		t.Errorf("Parse() returned IMA policy %+v", p)
+	}
+
+	for _, bad := range []*IMAPolicy{
+		{Allowlist: map[string][]Hex{"bash": {make([]byte, 32)}}},
+		{Allowlist: map[string][]Hex{"/usr/bin/bash": {make([]byte, 31)}}},
+		{Denylist: []Hex{make([]byte, 16)}},
+		{Exclude: []string{"tmp/"}},
+	} {
+		if _, _, err := (&Manifest{Version: Version, Sets: []Set{set}, IMA: bad}).Sign(key); err == nil {
+			t.Errorf("Sign() accepted IMA policy %+v", bad)
+		}
+	}
+}
+
+func TestEventsPolicy(t *testing.T) {
+	key, pubPEM := signer(t)
+	set := NewSet("release-1", verifier.Banks{tpm2.AlgSHA256: {7: make([]byte, 32)}})
+	policy := &verifier.EventsPolicy{PCRs: []int{8}, Rules: []verifier.EventRule{
+		{Name: "no-init", Kind: verifier.KindKernelCmdline, Forbids: []string{"init=*"}},
+		{Name: "kernel", Kind: verifier.KindKernel, Required: true, Match: "/boot/vmlinuz-6.*"},
+	}}
+	data, signature, err := (&Manifest{Version: Version, Sets: []Set{set}, EventsPolicy: policy}).Sign(key)
+	if err != nil {
+		t.Fatalf("Sign() failed: %v", err)
+	}
+	m, err := Parse(data, signature, pubPEM)
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+	if p := m.EventsPolicy; len(p.PCRs) != 1 || len(p.Rules) != 2 || !p.Rules[1].Required ||
+		p.Rules[0].Forbids[0] != "init=*" {
+		t.Errorf("Parse() returned events policy %+v", p)
+	}
+
+	// PCR 9 is vouched for by file rules
+	files := verifier.EventRule{Name: "kernel-file", Kind: verifier.KindFile, Path: "*/boot/vmlinuz-*",
+		Digests: []string{hex.EncodeToString(bytes.Repeat([]byte{0xab}, 32))}}
+	policy = &verifier.EventsPolicy{PCRs: []int{8, 9}, Rules: append(policy.Rules, files)}
+	if _, _, err := (&Manifest{Version: Version, Sets: []Set{set}, EventsPolicy: policy}).Sign(key); err != nil {
+		t.Errorf("Sign() failed for events policy %+v: %v", policy, err)
+	}
+
+	rule := verifier.EventRule{Name: "kernel", Kind: verifier.KindKernel, Match: "/boot/*"}
+	for _, bad := range []*verifier.EventsPolicy{
+		{PCRs: []int{8}, Rules: []verifier.EventRule{rule, files}},
+		{PCRs: []int{8, 9}, Rules: []verifier.EventRule{rule}},
+		{PCRs: []int{8}, Rules: []verifier.EventRule{{Name: "kernel", Kind: verifier.KindKernel, Path: "*", Match: "*"}}},
+		{PCRs: []int{9}, Rules: []verifier.EventRule{{Name: "kernel", Kind: verifier.KindFile, Digests: []string{"xyz"}}}},
+		{PCRs: []int{9}, Rules: []verifier.EventRule{{Name: "kernel", Kind: verifier.KindFile, Path: "*"}}},
+		{Rules: []verifier.EventRule{rule}},
+		{PCRs: []int{9}, Rules: []verifier.EventRule{rule}},
+		{PCRs: []int{8, 24}, Rules: []verifier.EventRule{rule}},
+		{PCRs: []int{0, 8, 9}, Rules: []verifier.EventRule{rule}},
+		{PCRs: []int{8}, Rules: []verifier.EventRule{rule, rule}},
+		{PCRs: []int{8}, Rules: []verifier.EventRule{{Name: "kernel", Kind: "initrd", Match: "*"}}},
+		{PCRs: []int{8}, Rules: []verifier.EventRule{{Name: "kernel", Kind: verifier.KindKernel}}},
+		{PCRs: []int{8}, Rules: []verifier.EventRule{{Name: verifier.RuleEvents, Kind: verifier.KindKernel, Match: "*"}}},
+	} {
+		if _, _, err := (&Manifest{Version: Version, Sets: []Set{set}, EventsPolicy: bad}).Sign(key); err == nil {
+			t.Errorf("Sign() accepted events policy %+v", bad)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+// SPDX-License-Identifier: Apache-2.0
+
+// Package replay computes the PCR values a TCG events log leads to, bank by
+// bank. PCRs start at zero, but for PCR 0 on platforms that issue
+// TPM2_Startup() from another locality than 0 (H-CRTM), as the
+// StartupLocality event tells, and for PCRs 17 to 22, which only a dynamic
+// launch resets. Events that are not measured (EV_NO_ACTION) are skipped, and
+// errors tell which event a replay stops on.
+package replay
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/google/go-attestation/attest"
+	"github.com/google/go-tpm/tpm2"
+
+	"main/src/pcrsel"
+)
+
+// EvNoAction is the type of EV_NO_ACTION events: information that is logged,
+// never extended
+const EvNoAction = attest.EventType(0x03)
+
+// Signature of the StartupLocality EV_NO_ACTION event, followed by the
+// locality TPM2_Startup() was issued from
+var startupLocalitySignature = []byte("StartupLocality\x00")
+
+// Replay failures, that EventError wraps
+var (
+	// The event is for a PCR the TPM does not implement
+	ErrPCROutOfRange = errors.New("PCR index out of range")
+	// The event has no digest in the bank, or a digest of the wrong size
+	ErrBadDigest = errors.New("bad digest")
+	// The StartupLocality event is malformed, or comes too late
+	ErrBadStartupLocality = errors.New("bad StartupLocality event")
+)
+
+// EventError tells which event a replay stops on.
+type EventError struct {
+	// Position of the event in the log, from 0, not counting the Spec ID
+	// Event header of crypto-agile logs
+	Number int
+	PCR    int
+	Type   attest.EventType
+	// Bank the event is replayed in, if the failure is bank-specific
+	Bank tpm2.Algorithm
+	Err  error
+}
+
+func (e *EventError) Error() string {
+	if e.Bank == tpm2.AlgUnknown {
+		return fmt.Sprintf("event #%d (%v for PCR[%d]): %v", e.Number, e.Type, e.PCR, e.Err)
+	}
+	return fmt.Sprintf("event #%d (%v for PCR[%d], %s bank): %v",
+		e.Number, e.Type, e.PCR, pcrsel.Name(e.Bank), e.Err)
+}
+
+func (e *EventError) Unwrap() error {
+	return e.Err
+}
+
+// Banks holds PCR values by bank and PCR index.
+type Banks map[tpm2.Algorithm]map[int][]byte
+
+// === Replay ==================================================================
+
+// EventsLog replays an events log in every bank it has digests for. All the
+// PCRs of a bank are returned, extended or not.
+func EventsLog(
+	eventsLog []byte, // IN
+) (Banks, error) {
+
+	parsedEventsLog, err := attest.ParseEventLog(eventsLog)
+	if err != nil {
+		return nil, fmt.Errorf("attest.ParseEventLog() failed: %w", err)
+	}
+
+	banks := Banks{}
+	for _, alg := range parsedEventsLog.Algs {
+		pcrs, err := Events(parsedEventsLog.Events(alg), tpm2.Algorithm(alg))
+		if err != nil {
+			return nil, err
+		}
+		banks[tpm2.Algorithm(alg)] = pcrs
+	}
+	return banks, nil
+}
+
+// Events replays the events of a log, with their digests in one bank.
+func Events(
+	events []attest.Event, // IN
+	alg tpm2.Algorithm, // IN
+) (map[int][]byte, error) {
+
+	hash, err := alg.Hash()
+	if err != nil {
+		return nil, fmt.Errorf("%v bank: %w", alg, err)
+	}
+	locality, err := StartupLocality(events)
+	if err != nil {
+		return nil, err
+	}
+
+	pcrs := map[int][]byte{}
+	for i := 0; i < pcrsel.NumPCRs; i++ {
+		pcrs[i] = make([]byte, hash.Size())
+		if i >= 17 && i <= 22 {
+			pcrs[i] = bytes.Repeat([]byte{0xff}, hash.Size())
+		}
+	}
+	pcrs[0][hash.Size()-1] = locality
+
+	for k, e := range events {
+		if e.Type == EvNoAction {
+			continue
+		}
+		fail := func(err error, format string, a ...interface{}) error {
+			return &EventError{Number: k, PCR: e.Index, Type: e.Type, Bank: alg,
+				Err: fmt.Errorf("%w: %s", err, fmt.Sprintf(format, a...))}
+		}
+		if e.Index < 0 || e.Index >= pcrsel.NumPCRs {
+			return nil, fail(ErrPCROutOfRange, "PCRs are 0 to %d", pcrsel.NumPCRs-1)
+		}
+		if len(e.Digest) != hash.Size() {
+			return nil, fail(ErrBadDigest, "digest is %d bytes long, expected %d", len(e.Digest), hash.Size())
+		}
+		h := hash.New()
+		h.Write(pcrs[e.Index])
+		h.Write(e.Digest)
+		pcrs[e.Index] = h.Sum(nil)
+	}
+	return pcrs, nil
+}
+
+// Measured returns the PCRs the events of a log extend, in increasing order.
+func Measured(
+	events []attest.Event, // IN
+) []int {
+
+	measured := map[int]bool{}
+	for _, e := range events {
+		if e.Type != EvNoAction {
+			measured[e.Index] = true
+		}
+	}
+	pcrs := []int{}
+	for i := 0; i < pcrsel.NumPCRs; i++ {
+		if measured[i] {
+			pcrs = append(pcrs, i)
+		}
+	}
+	return pcrs
+}
+
+// StartupLocality returns the locality TPM2_Startup() was issued from, which
+// PCR 0 is initialised to: 0 unless a StartupLocality event, which must come
+// before any PCR 0 measurement, tells otherwise (3 or 4).
+func StartupLocality(
+	events []attest.Event, // IN
+) (byte, error) {
+
+	locality := byte(0)
+	found := false
+	extended := false
+	for k, e := range events {
+		if e.Index != 0 {
+			continue
+		}
+		if e.Type != EvNoAction {
+			extended = true
+			continue
+		}
+		if !bytes.HasPrefix(e.Data, startupLocalitySignature) {
+			continue
+		}
+		fail := func(format string, a ...interface{}) error {
+			return &EventError{Number: k, PCR: e.Index, Type: e.Type,
+				Err: fmt.Errorf("%w: %s", ErrBadStartupLocality, fmt.Sprintf(format, a...))}
+		}
+		switch {
+		case len(e.Data) != len(startupLocalitySignature)+1:
+			return 0, fail("%d bytes long, expected %d", len(e.Data), len(startupLocalitySignature)+1)
+		case found:
+			return 0, fail("the log has several")
+		case extended:
+			return 0, fail("it comes after PCR[0] is extended")
+		}
+		locality = e.Data[len(startupLocalitySignature)]
+		if locality != 0 && locality != 3 && locality != 4 {
+			return 0, fail("locality %d, expected 0, 3 or 4", locality)
+		}
+		found = true
+	}
+	return locality, nil
+}
@@ -0,0 +1,145 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package replay
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"github.com/google/go-tpm/tpm2"
+)
+
+// testEvent is an event of a test events log, whose digests are those of its
+// data but for EV_NO_ACTION events, whose digests are zeros.
+type testEvent struct {
+	pcr  uint32
+	typ  uint32
+	data []byte
+}
+
+// buildEventsLog builds a crypto-agile (SHA1 + SHA256) TCG events log.
+func buildEventsLog(events []testEvent) []byte {
+	var buf bytes.Buffer
+	le := binary.LittleEndian
+
+	// TCG_PCR_EVENT header carrying the TCG_EfiSpecIDEventStruct
+	var specID bytes.Buffer
+	specID.WriteString("Spec ID Event03\x00")
+	binary.Write(&specID, le, uint32(0))            // platformClass
+	specID.Write([]byte{0, 2, 0, 2})                // minor, major, errata, uintnSize
+	binary.Write(&specID, le, uint32(2))            // numberOfAlgorithms
+	binary.Write(&specID, le, []uint16{0x0004, 20}) // SHA1
+	binary.Write(&specID, le, []uint16{0x000b, 32}) // SHA256
+	specID.WriteByte(0)                             // vendorInfoSize
+	binary.Write(&buf, le, uint32(0))               // pcrIndex
+	binary.Write(&buf, le, uint32(0x03))            // EV_NO_ACTION
+	buf.Write(make([]byte, 20))                     // digest
+	binary.Write(&buf, le, uint32(specID.Len()))    // eventSize
+	buf.Write(specID.Bytes())
+
+	// TCG_PCR_EVENT2 entries
+	for _, e := range events {
+		s1, s256 := sha1.Sum(e.data), sha256.Sum256(e.data)
+		if e.typ == 0x03 {
+			s1, s256 = [20]byte{}, [32]byte{}
+		}
+		binary.Write(&buf, le, []uint32{e.pcr, e.typ, 2})
+		binary.Write(&buf, le, uint16(0x0004))
+		buf.Write(s1[:])
+		binary.Write(&buf, le, uint16(0x000b))
+		buf.Write(s256[:])
+		binary.Write(&buf, le, uint32(len(e.data)))
+		buf.Write(e.data)
+	}
+
+	return buf.Bytes()
+}
+
+// extend replays measurements of data into a PCR value.
+func extend(value []byte, data ...[]byte) []byte {
+	for _, d := range data {
+		digest := sha256.Sum256(d)
+		v := sha256.Sum256(append(append([]byte{}, value...), digest[:]...))
+		value = v[:]
+	}
+	return value
+}
+
+func startupLocality(locality byte) testEvent {
+	return testEvent{0, 0x03, append([]byte("StartupLocality\x00"), locality)}
+}
+
+func TestEventsLog(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		locality byte
+		events   []testEvent
+	}{
+		{"locality 0", 0, nil},
+		{"locality 3", 3, []testEvent{startupLocality(3)}},
+		{"locality 4", 4, []testEvent{startupLocality(4)}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			events := append(tc.events,
+				testEvent{0, 0x08, []byte("S-CRTM version")},                   // EV_S_CRTM_VERSION
+				testEvent{0, 0x03, []byte("not measured")},                     // EV_NO_ACTION
+				testEvent{7, 0x80000001, []byte("SecureBoot")},                 // EV_EFI_VARIABLE_DRIVER_CONFIG
+				testEvent{0, 0x04, []byte{0, 0, 0, 0}},                         // EV_SEPARATOR
+				testEvent{7, 0x04, []byte{0, 0, 0, 0}},                         // EV_SEPARATOR
+				testEvent{23, 0x0d, []byte("application support measurement")}, // EV_IPL
+			)
+			banks, err := EventsLog(buildEventsLog(events))
+			if err != nil {
+				t.Fatalf("EventsLog() failed: %v", err)
+			}
+			if len(banks) != 2 || len(banks[tpm2.AlgSHA1]) != 24 || len(banks[tpm2.AlgSHA256]) != 24 {
+				t.Fatalf("EventsLog() returned %d banks, expected 2 of 24 PCRs", len(banks))
+			}
+
+			pcrs := banks[tpm2.AlgSHA256]
+			start := make([]byte, 32)
+			start[31] = tc.locality
+			expected := map[int][]byte{
+				0:  extend(start, []byte("S-CRTM version"), []byte{0, 0, 0, 0}),
+				1:  make([]byte, 32),
+				7:  extend(make([]byte, 32), []byte("SecureBoot"), []byte{0, 0, 0, 0}),
+				17: bytes.Repeat([]byte{0xff}, 32),
+				22: bytes.Repeat([]byte{0xff}, 32),
+				23: extend(make([]byte, 32), []byte("application support measurement")),
+			}
+			for i, value := range expected {
+				if !bytes.Equal(pcrs[i], value) {
+					t.Errorf("PCR[%d] is %x, expected %x", i, pcrs[i], value)
+				}
+			}
+		})
+	}
+}
+
+func TestEventsLogErrors(t *testing.T) {
+	measurement := testEvent{0, 0x0d, []byte("measurement")}
+	for _, tc := range []struct {
+		name   string
+		events []testEvent
+		number int
+		err    error
+	}{
+		{"PCR out of range", []testEvent{measurement, {24, 0x0d, []byte("measurement")}}, 1, ErrPCROutOfRange},
+		{"late StartupLocality", []testEvent{measurement, startupLocality(3)}, 1, ErrBadStartupLocality},
+		{"bad locality", []testEvent{startupLocality(2), measurement}, 0, ErrBadStartupLocality},
+		{"several StartupLocality", []testEvent{startupLocality(3), startupLocality(3)}, 1, ErrBadStartupLocality},
+		{"short StartupLocality", []testEvent{{0, 0x03, []byte("StartupLocality\x00")}}, 0, ErrBadStartupLocality},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := EventsLog(buildEventsLog(tc.events))
+			var eventErr *EventError
+			if !errors.Is(err, tc.err) || !errors.As(err, &eventErr) || eventErr.Number != tc.number {
+				t.Errorf("EventsLog() returned %v, expected %v for event #%d", err, tc.err, tc.number)
+			}
+		})
+	}
+}
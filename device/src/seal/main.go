@@ -7,13 +7,17 @@ import (
 	"flag"
 
 	"main/src/lib"
+	"main/src/pcrsel"
+	"main/src/refvalues"
 	"main/src/steps"
 	"main/src/teepeem"
 )
 
 var (
-	tpmPath = flag.String("tpm-path", "/dev/tpmrm0", "Path to the TPM device (character device or a Unix socket).")
-	flush   = flag.String("flush", "all", "Flush contexts, must be oneof transient|saved|loaded|all")
+	tpmPath    = flag.String("tpm-path", "/dev/tpmrm0", "Path to the TPM device (character device or a Unix socket), or simulator[:seed=<seed>][:events-log=<path>].")
+	flush      = flag.String("flush", "all", "Flush contexts, must be oneof transient|saved|loaded|all")
+	pcrsSpec   = flag.String("pcrs", "", "PCRs to seal to, per bank, e.g. sha256:0,1,2, in place of the selection recorded at onboarding.")
+	pcrsConfig = flag.String("pcrs-config", pcrsel.DefaultPath, "JSON file holding the PCR selection recorded at onboarding.")
 )
 
 // ### Main ####################################################################
@@ -21,29 +25,44 @@ var (
 func main() {
 	flag.Parse()
 
+	selection, err := pcrsel.Load(*pcrsSpec, *pcrsConfig)
+	if err != nil {
+		lib.Fatal("%v", err)
+	}
 	// Generate random AES26 key
 	aesKey := make([]byte, 32)
-	_, err := rand.Read(aesKey)
+	_, err = rand.Read(aesKey)
 	if err != nil {
 		lib.Fatal("rand.Read() failed: %v", err)
 	}
 
 	// CICD: seal secret key
-	steps.SealKey(
-		aesKey,                 // AES256 key
-		"Verifier/srk",         // IN
-		"CICD/cicd-prediction", // IN
-		"CICD/sealed-key",      // OUT
+	err = steps.SealKey(
+		aesKey,                      // AES256 key
+		selection.TPM(),             // IN
+		"Verifier/srk",              // IN
+		refvalues.DefaultPath,       // IN
+		refvalues.DefaultSignerPath, // IN
+		"CICD/sealed-key",           // OUT
 	)
+	if err != nil {
+		lib.Fatal("%v", err)
+	}
 
 	// Open TPM and Flush handles
-	rwc := teepeem.OpenFlush(*tpmPath, *flush)
+	rwc, err := teepeem.OpenFlush(*tpmPath, *flush)
+	if err != nil {
+		lib.Fatal("%v", err)
+	}
 	defer rwc.Close()
 
 	// Attestor: unseal secret key
-	steps.UnsealKey(
+	_, err = steps.UnsealKey(
 		rwc,
 		"CICD/sealed-key",       // IN
 		"Attestor/unsealed-key", // OUT
 	)
+	if err != nil {
+		lib.Fatal("%v", err)
+	}
 }
@@ -9,6 +9,7 @@ import (
 	"github.com/google/go-tpm/tpm2"
 
 	"main/src/lib"
+	"main/src/teepeem"
 )
 
 // ### Attestor: activate credential ###########################################
@@ -19,121 +20,59 @@ func ActivateCredential(
 	attestorEkPath string, // IN
 	attestorAkPath string, // IN
 	attestorAttemptPath string, // OUT
-) {
+) error {
 
 	lib.PRINT("=== ATTESTOR: ACTIVATE CREDENTIAL ==============================================")
 
 	// Retrieve credential challenge TPM2B_ID_OBJECT
-	idObject := lib.Read(fmt.Sprintf("%s-object.blob", verifierCredentialPath))
-
-	// Retrieve credential challenge TPM2B_ENCRYPTED_SECRET
-	encSecret := lib.Read(fmt.Sprintf("%s-secret.blob", verifierCredentialPath))
-
-	// Retrieve EK ctx
-	ekCtx := lib.Read(fmt.Sprintf("%s.ctx", attestorEkPath))
-
-	// Load EK
-	ek, err := tpm2.ContextLoad(rwc, ekCtx)
+	idObject, err := lib.Read(fmt.Sprintf("%s-object.blob", verifierCredentialPath))
 	if err != nil {
-		lib.Fatal("tpm2.ContextLoad() failed for EK: %v", err)
+		return err
 	}
-	defer tpm2.FlushContext(rwc, ek)
 
-	// --- Start auth session for creating AK ----------------------------------
-	// (Auth sessions are required for EK children)
-	loadSession, _, err := tpm2.StartAuthSession(
-		rwc,
-		tpm2.HandleNull,    // tpmKey
-		tpm2.HandleNull,    // bindKey
-		make([]byte, 16),   // nonceCaller
-		nil,                // secret
-		tpm2.SessionPolicy, // sessionType
-		tpm2.AlgNull,       // sym algorithm
-		tpm2.AlgSHA256,     // hash algorithm
-	)
+	// Retrieve credential challenge TPM2B_ENCRYPTED_SECRET
+	encSecret, err := lib.Read(fmt.Sprintf("%s-secret.blob", verifierCredentialPath))
 	if err != nil {
-		lib.Fatal("tpm2.StartAuthSession() failed: %v", err)
+		return err
 	}
-	defer tpm2.FlushContext(rwc, loadSession)
 
-	_, _, err = tpm2.PolicySecret(
+	// Load EK
+	ek, err := teepeem.LoadEK(
 		rwc,
-		tpm2.HandleEndorsement, // entityHandle
-		tpm2.AuthCommand{
-			Session:    tpm2.HandlePasswordSession,
-			Attributes: tpm2.AttrContinueSession,
-		}, // entityAuth
-		loadSession, // sessionHandle
-		nil,         // policyNonce
-		nil,         // cpHash
-		nil,         // policyRef
-		0,           // expiry
+		attestorEkPath, // IN
 	)
 	if err != nil {
-		lib.Fatal("tpm2.PolicySecret() failed: %v", err)
+		return err
 	}
+	defer tpm2.FlushContext(rwc, ek)
 
-	authCommandLoad := tpm2.AuthCommand{Session: loadSession, Attributes: tpm2.AttrContinueSession}
-
-	// Retrieve AK Pub blob
-	akPub := lib.Read(fmt.Sprintf("%s-pub.blob", attestorAkPath))
-
-	// Retrieve AK Priv blob
-	akPriv := lib.Read(fmt.Sprintf("%s-priv.blob", attestorAkPath))
-
-	// Load AK
-	ak, _, err := tpm2.LoadUsingAuth(
+	// --- Load AK -------------------------------------------------------------
+	ak, _, err := teepeem.LoadAK(
 		rwc,
-		ek,              // parentHandle
-		authCommandLoad, // authCommand
-		akPub,           // publicBlob
-		akPriv,          // privateBlob
+		ek,
+		attestorAkPath, // IN
 	)
 	if err != nil {
-		lib.Fatal("tpm2.LoadUsingAuth() failed: %v", err)
+		return err
 	}
 	defer tpm2.FlushContext(rwc, ak)
 
-	err = tpm2.FlushContext(rwc, loadSession)
-	if err != nil {
-		lib.Fatal("tpm2.FlushContext() failed: %v", err)
-	}
-
 	// --- Start auth session for activating credential ------------------------
 	// (Auth sessions are required for EK children)
-	session, _, err := tpm2.StartAuthSession(
+	session, err := teepeem.CreateEKSession(
 		rwc,
-		tpm2.HandleNull,    // tpmKey
-		tpm2.HandleNull,    // bindKey
-		make([]byte, 16),   // nonceCaller
-		nil,                // secret
-		tpm2.SessionPolicy, // sessionType
-		tpm2.AlgNull,       // symmetric algorithm
-		tpm2.AlgSHA256,     // hash algorithm
+		ek, // IN
 	)
 	if err != nil {
-		lib.Fatal("tpm2.StartAuthSession: %v", err)
+		return err
 	}
+	defer teepeem.FlushSession(rwc, session)
 
 	auth := tpm2.AuthCommand{
 		Session:    tpm2.HandlePasswordSession,
 		Attributes: tpm2.AttrContinueSession,
 	}
 
-	_, _, err = tpm2.PolicySecret(
-		rwc,
-		tpm2.HandleEndorsement, // entityHandle
-		auth,                   // authCommand
-		session,                // policyHandle
-		nil,                    // policyNonce
-		nil,                    // cpHash
-		nil,                    // policyRef
-		0,                      // expiry
-	)
-	if err != nil {
-		lib.Fatal("tpm2.AuthCommand: %v", err)
-	}
-
 	auths := []tpm2.AuthCommand{
 		auth,
 		{
@@ -152,8 +91,8 @@ func ActivateCredential(
 		encSecret[2:], // encSecret (skip lenght header)
 	)
 	if err != nil {
-		lib.Fatal("activate credential: %v", err)
+		return fmt.Errorf("activate credential: %w", err)
 	}
 
-	lib.Write(fmt.Sprintf("%s.bin", attestorAttemptPath), attempt, 0644)
+	return lib.Write(fmt.Sprintf("%s.bin", attestorAttemptPath), attempt, 0644)
 }
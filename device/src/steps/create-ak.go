@@ -10,8 +10,8 @@ import (
 	"fmt"
 	"io"
 
-	"github.com/google/go-tpm-tools/client"
 	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpmutil"
 
 	"main/src/lib"
 	"main/src/teepeem"
@@ -21,9 +21,10 @@ import (
 
 func CreateAK(
 	rw io.ReadWriter,
+	keyType string, // IN
 	attestorEkPath string, // IN
 	attestorAkPath string, // OUT
-) {
+) error {
 
 	lib.PRINT("=== ATTESTOR: CREATE AK ========================================================")
 
@@ -50,10 +51,13 @@ func CreateAK(
 	//	defer tpm2.FlushContext(rw, ek)
 
 	// Load EK
-	ek := teepeem.LoadEK(
+	ek, err := teepeem.LoadEK(
 		rw,
 		attestorEkPath, // IN
 	)
+	if err != nil {
+		return err
+	}
 	defer tpm2.FlushContext(rw, ek)
 
 	//	// Write EK Pub to disk
@@ -73,56 +77,29 @@ func CreateAK(
 	//
 	//	lib.Write(fmt.Sprintf("%s.pub", attestorEkPath), ekPublicKeyPEM, 0644)
 
-	// Auth sessions are required for working with EK children...
-
-	// Start auth session for creating AK
-	session := teepeem.CreateSession(
-		rw,
-		tpm2.HandlePasswordSession,
-	)
-	defer tpm2.FlushContext(rw, session)
-
-	// Create AK
-	akPrivateBlob, akPublicBlob, creationData, creationHash, creationTicket,
-		err := tpm2.CreateKeyUsingAuth(
-		rw,
-		ek,                  // owner
-		tpm2.PCRSelection{}, // selection
-		tpm2.AuthCommand{
-			Session:    session,
-			Attributes: tpm2.AttrContinueSession,
-		}, // authCommand
-		"",                     // ownerPassword
-		client.AKTemplateRSA(), // template
-	)
+	template, err := teepeem.AKTemplate(keyType)
 	if err != nil {
-		lib.Fatal("tpm2.CreateKeyUsingAuth() failed: %v", err)
+		return err
+	}
+
+	if teepeem.IsSimulatorTPM(rw) {
+		err = createSimulatorAK(rw, template, attestorAkPath)
+	} else {
+		err = createAKUnderEK(rw, ek, template, attestorAkPath)
 	}
-	lib.Verbose("akPrivateBlob 0x%s", hex.EncodeToString(akPrivateBlob))
-	lib.Verbose("akPublicBlob 0x%s", hex.EncodeToString(akPublicBlob))
-	cr, err := tpm2.DecodeCreationData(creationData)
 	if err != nil {
-		lib.Fatal("tpm2.DecodeCreationData() failed: %v", err)
+		return err
 	}
-	lib.Verbose("CredentialData.ParentName.Digest.Value 0x%s",
-		hex.EncodeToString(cr.ParentName.Digest.Value))
-	lib.Verbose("CredentialHash 0x%s", hex.EncodeToString(creationHash))
-	lib.Verbose("CredentialTicket 0x%s",
-		hex.EncodeToString(creationTicket.Digest))
-
-	// Write AK public and private blobs to disk
-	lib.Write(fmt.Sprintf("%s-pub.blob", attestorAkPath), akPublicBlob, 0644)
-	lib.Write(fmt.Sprintf("%s-priv.blob", attestorAkPath), akPrivateBlob, 0644)
-
-	// Flush Session context
-	teepeem.FlushContext(rw, session)
 
 	// Load AK
-	ak, akName := teepeem.LoadAK(
+	ak, akName, err := teepeem.LoadAK(
 		rw,
 		ek,
 		attestorAkPath, // IN
 	)
+	if err != nil {
+		return err
+	}
 	defer tpm2.FlushContext(rw, ak)
 
 	//	// Start auth session for loading AK
@@ -159,10 +136,13 @@ func CreateAK(
 	//teepeem.FlushContext(rw, session)
 
 	// Read the public part of AK
-	akPublicKey, akName_, akQualName_ := teepeem.ReadPublic(
+	akPublicKey, akName_, akQualName_, err := teepeem.ReadPublic(
 		rw,
 		ak,
 	)
+	if err != nil {
+		return err
+	}
 	//	akPublicKey, akName_, akQualName_, err := tpm2.ReadPublic(
 	//			rw,
 	//		ak, // handle
@@ -179,15 +159,16 @@ func CreateAK(
 
 	akPublicKeyCrypto, err := akPublicKey.Key()
 	if err != nil {
-		lib.Fatal("akTpmPublicKey.Key() failed: %v", err)
+		return fmt.Errorf("akTpmPublicKey.Key() failed: %w", err)
 	}
 	lib.Verbose("akPublicKeyCrypto: %v", akPublicKeyCrypto)
-	lib.Verbose("akPublicKeyCrypto.Modulus: 0x%x",
-		akPublicKeyCrypto.(*rsa.PublicKey).N)
+	if rsaPublicKey, ok := akPublicKeyCrypto.(*rsa.PublicKey); ok {
+		lib.Verbose("akPublicKeyCrypto.Modulus: 0x%x", rsaPublicKey.N)
+	}
 
 	akPublicKeyDER, err := x509.MarshalPKIXPublicKey(akPublicKeyCrypto)
 	if err != nil {
-		lib.Fatal("x509.MarshalPKIXPublicKey() failed: %v", err)
+		return fmt.Errorf("x509.MarshalPKIXPublicKey() failed: %w", err)
 	}
 	lib.Verbose("akPublicKeyDER: 0x%s", hex.EncodeToString(akPublicKeyDER))
 
@@ -199,6 +180,93 @@ func CreateAK(
 	)
 	//	lib.Verbose("akPublicKeyPEM_:\n%v", string(akPublicKeyPEM))
 
-	lib.Write(fmt.Sprintf("%s.pub", attestorAkPath), akPublicKeyPEM, 0644)
-	lib.Write(fmt.Sprintf("%s-name.blob", attestorAkPath), akName, 0644)
+	err = lib.Write(fmt.Sprintf("%s.pub", attestorAkPath), akPublicKeyPEM, 0644)
+	if err != nil {
+		return err
+	}
+	return lib.Write(fmt.Sprintf("%s-name.blob", attestorAkPath), akName, 0644)
+}
+
+// createAKUnderEK creates the AK as a child of the EK, and writes its public
+// and private blobs.
+func createAKUnderEK(
+	rw io.ReadWriter,
+	ek tpmutil.Handle,
+	template tpm2.Public, // IN
+	attestorAkPath string, // OUT
+) error {
+
+	// Auth sessions are required for working with EK children...
+
+	// Start auth session for creating AK
+	session, err := teepeem.CreateEKSession(
+		rw,
+		ek, // IN
+	)
+	if err != nil {
+		return err
+	}
+	defer teepeem.FlushSession(rw, session)
+
+	// Create AK
+	akPrivateBlob, akPublicBlob, creationData, creationHash, creationTicket,
+		err := tpm2.CreateKeyUsingAuth(
+		rw,
+		ek,                  // owner
+		tpm2.PCRSelection{}, // selection
+		tpm2.AuthCommand{
+			Session:    session,
+			Attributes: tpm2.AttrContinueSession,
+		}, // authCommand
+		"",       // ownerPassword
+		template, // template
+	)
+	if err != nil {
+		return fmt.Errorf("tpm2.CreateKeyUsingAuth() failed: %w", err)
+	}
+	lib.Verbose("akPrivateBlob 0x%s", hex.EncodeToString(akPrivateBlob))
+	lib.Verbose("akPublicBlob 0x%s", hex.EncodeToString(akPublicBlob))
+	cr, err := tpm2.DecodeCreationData(creationData)
+	if err != nil {
+		return fmt.Errorf("tpm2.DecodeCreationData() failed: %w", err)
+	}
+	lib.Verbose("CredentialData.ParentName.Digest.Value 0x%s",
+		hex.EncodeToString(cr.ParentName.Digest.Value))
+	lib.Verbose("CredentialHash 0x%s", hex.EncodeToString(creationHash))
+	lib.Verbose("CredentialTicket 0x%s",
+		hex.EncodeToString(creationTicket.Digest))
+
+	// Write AK public and private blobs to disk
+	err = lib.Write(fmt.Sprintf("%s-pub.blob", attestorAkPath), akPublicBlob, 0644)
+	if err != nil {
+		return err
+	}
+	err = lib.Write(fmt.Sprintf("%s-priv.blob", attestorAkPath), akPrivateBlob, 0644)
+	if err != nil {
+		return err
+	}
+
+	// Flush Session context
+	return teepeem.FlushSession(rw, session)
+}
+
+// createSimulatorAK creates the AK as a primary key, which the simulator
+// re-derives in every process, and writes its public blob.
+func createSimulatorAK(
+	rw io.ReadWriter,
+	template tpm2.Public, // IN
+	attestorAkPath string, // OUT
+) error {
+
+	ak, akPublicBlob, _, err := teepeem.CreateSimulatorAK(
+		rw,
+		template, // IN
+	)
+	if err != nil {
+		return err
+	}
+	defer tpm2.FlushContext(rw, ak)
+	lib.Verbose("akPublicBlob 0x%s", hex.EncodeToString(akPublicBlob))
+
+	return lib.Write(fmt.Sprintf("%s-pub.blob", attestorAkPath), akPublicBlob, 0644)
 }
@@ -20,14 +20,17 @@ import (
 func CreateSRK(
 	rw io.ReadWriter,
 	attestorSrkPath string, // OUT
-) {
+) error {
 
 	lib.PRINT("=== ATTESTOR: CREATE SRK =======================================================")
 
 	// Clear TPM owner hierarchy
-	teepeem.Clear(
+	err := teepeem.Clear(
 		rw,
 	)
+	if err != nil {
+		return err
+	}
 
 	//var ownerAuth [20]byte
 	//ownerInput := ""
@@ -82,7 +85,7 @@ func CreateSRK(
 
 	srkClient, err := client.StorageRootKeyRSA(rw)
 	if err != nil {
-		lib.Fatal("client.StorageRootKeyRSA() failed: %v", err)
+		return fmt.Errorf("client.StorageRootKeyRSA() failed: %w", err)
 	}
 	//	type Key struct {
 	//		rw      io.ReadWriter
@@ -111,7 +114,7 @@ func CreateSRK(
 	// Write SRK Pub to disk
 	srkPublicKeyDER, err := x509.MarshalPKIXPublicKey(srkClient.PublicKey())
 	if err != nil {
-		lib.Fatal("x509.MarshalPKIXPublicKey() failed for SRK Pub: %v", err)
+		return fmt.Errorf("x509.MarshalPKIXPublicKey() failed for SRK Pub: %w", err)
 	}
 	lib.Verbose("ekPublicKeyDER: 0x%s", hex.EncodeToString(srkPublicKeyDER))
 
@@ -123,5 +126,5 @@ func CreateSRK(
 	)
 	lib.Verbose("ekPublicKeyPEM:\n%s", string(srkPublicKeyPEM))
 
-	lib.Write(fmt.Sprintf("%s.pub", attestorSrkPath), srkPublicKeyPEM, 0644)
+	return lib.Write(fmt.Sprintf("%s.pub", attestorSrkPath), srkPublicKeyPEM, 0644)
 }
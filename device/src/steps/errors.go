@@ -0,0 +1,32 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package steps
+
+import (
+	"errors"
+
+	"main/src/truststore"
+	"main/src/verifier"
+)
+
+// === Verification errors =====================================================
+
+// Steps wrap these errors (fmt.Errorf("%w: ...")), so that callers embedding
+// the steps as a library can tell verification failures apart with errors.Is.
+var (
+	// Quote verification errors, see the verifier package
+	ErrNonceMismatch     = verifier.ErrNonceMismatch
+	ErrPCRDigestMismatch = verifier.ErrPCRDigestMismatch
+	ErrBadSignature      = verifier.ErrBadSignature
+	// The reference-value manifest is not signed by the CICD, is malformed
+	// or holds no set of reference values valid now
+	ErrBadReferenceValues = errors.New("bad reference values")
+	// The EK certificate does not chain up or does not match the EK
+	ErrEKCertInvalid = errors.New("invalid EK certificate")
+	// The TPM the EK certificate is issued for is not allowed, see the
+	// truststore package
+	ErrManufacturerNotAllowed = truststore.ErrManufacturerNotAllowed
+	ErrFirmwareTooOld         = truststore.ErrFirmwareTooOld
+	// The credential activation attempt does not match the challenge
+	ErrCredentialMismatch = errors.New("credential mismatch")
+)
@@ -14,10 +14,14 @@ func ExtGetAkPub(
 	akPubPath string, // IN
 ) (
 	akPubPEM []byte,
+	err error,
 ) {
 	// Read AK pub from disk
-	publicKeyPEM := lib.Read(fmt.Sprintf("%s.pub", akPubPath))
+	publicKeyPEM, err := lib.Read(fmt.Sprintf("%s.pub", akPubPath))
+	if err != nil {
+		return nil, err
+	}
 	lib.Verbose("publicKeyPEM: %v", publicKeyPEM)
 
-	return publicKeyPEM
+	return publicKeyPEM, nil
 }
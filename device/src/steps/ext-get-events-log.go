@@ -0,0 +1,25 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package steps
+
+import (
+	"main/src/lib"
+)
+
+// === Attestor: get events log ================================================
+
+func ExtGetEventsLog(
+	eventsLogPath string, // IN
+) (
+	eventsLog []byte,
+	err error,
+) {
+	// Read the raw TCG events log, as exposed by the kernel
+	eventsLog, err = lib.Read(eventsLogPath)
+	if err != nil {
+		return nil, err
+	}
+	lib.Verbose("eventsLog: %d bytes", len(eventsLog))
+
+	return eventsLog, nil
+}
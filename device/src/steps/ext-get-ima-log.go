@@ -0,0 +1,26 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package steps
+
+import (
+	"main/src/lib"
+)
+
+// === Attestor: get IMA log ===================================================
+
+func ExtGetImaLog(
+	imaLogPath string, // IN
+) (
+	imaLog []byte,
+	err error,
+) {
+	// Read the IMA runtime measurement list, in the binary format the kernel
+	// exposes it in. Read after quoting, it holds at least the quoted entries.
+	imaLog, err = lib.Read(imaLogPath)
+	if err != nil {
+		return nil, err
+	}
+	lib.Verbose("imaLog: %d bytes", len(imaLog))
+
+	return imaLog, nil
+}
@@ -0,0 +1,33 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package steps
+
+import (
+	"io"
+
+	"github.com/google/go-tpm/tpm2"
+
+	"main/src/teepeem"
+	"main/src/verifier"
+)
+
+// === Attestor: get PCR values ================================================
+
+func ExtGetPcrValues(
+	rwc io.ReadWriter, // IN
+	sels []tpm2.PCRSelection, // IN
+) (
+	pcrValues verifier.Banks,
+	err error,
+) {
+
+	pcrValues = verifier.Banks{}
+	for _, sel := range sels {
+		pcrValues[sel.Hash], err = teepeem.ReadPCRSelection(rwc, sel)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return pcrValues, nil
+}
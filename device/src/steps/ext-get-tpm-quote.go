@@ -3,38 +3,90 @@
 package steps
 
 import (
+	"bytes"
+	"fmt"
 	"io"
 
+	"github.com/google/go-tpm/tpm2"
+
 	"main/src/lib"
+	"main/src/verifier"
 )
 
+// maxQuoteAttempts bounds how many times ExtGetTpmQuotePcrValues() quotes
+// PCRs that keep changing.
+const maxQuoteAttempts = 5
+
 // === Attestor: get TPM quote =================================================
 
 func ExtGetTpmQuote(
 	rwc io.ReadWriteCloser, // IN
 	deviceDir string, // IN
-	pcrs []int, // IN
+	sels []tpm2.PCRSelection, // IN
+	nonce []byte, // IN
 ) (
-	nonce []byte,
 	attestation []byte,
 	signature []byte,
+	err error,
 ) {
 
-	// Verifier: request PCR quote
-	nonce = RequestQuote(
-		deviceDir + "Verifier/nonce-quote", // OUT
-	)
-	lib.Trace.Print("AA")
-	// Attestor: perform PCR quote
-	attestation, signature = PerformQuote(
+	// Attestor: perform PCR quote on the relying party's nonce
+	attestation, signature, err = PerformQuoteNonce(
 		rwc,
-		deviceDir + "Attestor/ek",          // IN
-		deviceDir + "Attestor/ak",          // IN
-		pcrs,                   // IN
-		deviceDir + "Verifier/nonce-quote", // IN
-		deviceDir + "Attestor/quote",       // OUT
+		deviceDir+"Attestor/ek",    // IN
+		deviceDir+"Attestor/ak",    // IN
+		sels,                       // IN
+		nonce,                      // IN
+		deviceDir+"Attestor/quote", // OUT
 	)
-	lib.Trace.Print("BB")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return attestation, signature, nil
+}
+
+// === Attestor: get TPM quote and PCR values ==================================
+
+// ExtGetTpmQuotePcrValues quotes PCRs along with their values. The values are
+// read just before quoting and checked against the quoted digest: should a
+// PCR be extended in between, as PCR 10 is whenever IMA measures a file, the
+// PCRs are read and quoted again.
+func ExtGetTpmQuotePcrValues(
+	rwc io.ReadWriteCloser, // IN
+	deviceDir string, // IN
+	sels []tpm2.PCRSelection, // IN
+	nonce []byte, // IN
+) (
+	attestation []byte,
+	signature []byte,
+	pcrValues verifier.Banks,
+	err error,
+) {
+
+	for attempt := 1; attempt <= maxQuoteAttempts; attempt++ {
+		pcrValues, err = ExtGetPcrValues(rwc, sels)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		attestation, signature, err = ExtGetTpmQuote(rwc, deviceDir, sels, nonce)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		quote, err := verifier.DecodeQuote(attestation)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("verifier.DecodeQuote() failed: %w", err)
+		}
+		digest, err := verifier.PCRsDigest(quote.Selection, pcrValues)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if bytes.Equal(digest, quote.PCRDigest) {
+			return attestation, signature, pcrValues, nil
+		}
+		lib.Comment("PCRs changed while quoting them (attempt %d of %d)", attempt, maxQuoteAttempts)
+	}
 
-	return nonce, attestation, signature
+	return nil, nil, nil, fmt.Errorf("PCRs changed while quoting them, %d times in a row", maxQuoteAttempts)
 }
@@ -0,0 +1,125 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package steps
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/google/go-attestation/attest"
+	"github.com/google/go-tpm/tpm2"
+
+	"main/src/lib"
+	"main/src/pcrsel"
+	"main/src/replay"
+	"main/src/teepeem"
+	"main/src/verifier"
+)
+
+// === Attestor: self-check ====================================================
+
+// PCRs a firmware measures into, that a self-check compares even when the
+// events log has no events for them
+var firmwarePCRs = []int{0, 1, 2, 3, 4, 5, 6, 7}
+
+// SelfCheck tells whether the events log of the device replays to its live
+// PCR values, which a verifier relies on to make sense of a quote.
+type SelfCheck struct {
+	// PCRs compared, by bank: the firmware PCRs and those the events log
+	// extends, in the active banks it has digests for
+	Selection pcrsel.Selection
+	// PCRs whose live value is not the replayed one: Expected holds the
+	// replayed value, Got the live one
+	Mismatches []verifier.PCRDiff
+	// Active banks the events log has no digests for, which are not compared
+	Uncovered []string
+}
+
+// OK tells whether some PCRs were compared, and all of them match.
+func (c *SelfCheck) OK() bool {
+	return len(c.Selection) != 0 && len(c.Mismatches) == 0
+}
+
+// Summary tells the outcome of the self-check in one sentence.
+func (c *SelfCheck) Summary() string {
+	switch {
+	case len(c.Selection) == 0:
+		return fmt.Sprintf("the events log has no digests for the active banks %v", c.Uncovered)
+	case len(c.Mismatches) == 0:
+		return fmt.Sprintf("the events log replays to the live PCRs %s", c.Selection)
+	}
+	mismatches := []string{}
+	for _, d := range c.Mismatches {
+		mismatches = append(mismatches, fmt.Sprintf("%s:%d", d.Bank, d.Index))
+	}
+	return fmt.Sprintf("the events log does not replay to the live PCRs %s",
+		strings.Join(mismatches, ","))
+}
+
+func ExtSelfCheck(
+	rwc io.ReadWriter, // IN
+	eventsLogPath string, // IN
+) (
+	selfCheck *SelfCheck,
+	err error,
+) {
+
+	eventsLog, err := ExtGetEventsLog(eventsLogPath)
+	if err != nil {
+		return nil, err
+	}
+	parsedEventsLog, err := attest.ParseEventLog(eventsLog)
+	if err != nil {
+		return nil, fmt.Errorf("attest.ParseEventLog() failed: %w", err)
+	}
+	active, err := teepeem.PCRBanks(rwc)
+	if err != nil {
+		return nil, err
+	}
+
+	selfCheck = &SelfCheck{Selection: pcrsel.Selection{}, Mismatches: []verifier.PCRDiff{}, Uncovered: []string{}}
+	for _, alg := range active {
+		var events []attest.Event
+		for _, a := range parsedEventsLog.Algs {
+			if tpm2.Algorithm(a) == alg {
+				events = parsedEventsLog.Events(a)
+			}
+		}
+		if events == nil {
+			selfCheck.Uncovered = append(selfCheck.Uncovered, pcrsel.Name(alg))
+			continue
+		}
+
+		// Replay the log, then read the live values of the same PCRs
+		replayed, err := replay.Events(events, alg)
+		if err != nil {
+			return nil, err
+		}
+		pcrs := append([]int{}, firmwarePCRs...)
+		for _, pcr := range replay.Measured(events) {
+			if pcr >= len(firmwarePCRs) {
+				pcrs = append(pcrs, pcr)
+			}
+		}
+		live, _, err := teepeem.ReadPCRs(rwc, alg, pcrs, "")
+		if err != nil {
+			return nil, err
+		}
+		selfCheck.Selection[pcrsel.Name(alg)] = pcrs
+		for k, pcr := range pcrs {
+			if !bytes.Equal(live[k], replayed[pcr]) {
+				selfCheck.Mismatches = append(selfCheck.Mismatches, verifier.PCRDiff{
+					Bank:     pcrsel.Name(alg),
+					Index:    pcr,
+					Expected: replayed[pcr],
+					Got:      live[k],
+				})
+			}
+		}
+	}
+	lib.Verbose("self-check: %s", selfCheck.Summary())
+
+	return selfCheck, nil
+}
@@ -3,50 +3,62 @@
 package steps
 
 import (
-	"fmt"
-
-	"github.com/google/go-tpm/tpmutil"
+	"github.com/google/go-tpm/tpm2"
 
 	"main/src/lib"
+	"main/src/refvalues"
+	"main/src/verifier"
 )
 
 // === Verifier: verify TPM quote ==============================================
 
 func ExtVerifyTpmQuote(
-	cicdPredictionPath string, // IN
-	pcrs []int, // IN
+	cicdReferenceValuesPath string, // IN
+	cicdSignerPath string, // IN
+	sels []tpm2.PCRSelection, // IN
 	nonce []byte, // IN
 	attestation []byte, // IN
-	signature tpmutil.U16Bytes, // IN
+	signature []byte, // IN
+	eventsLog []byte, // IN
+	imaLog []byte, // IN
 	akPub string, // IN
 ) (
 	isLegit bool,
 	message string,
+	result *verifier.Result,
 ) {
-	// Local panic handler
-	defer func() {
-		if e := recover(); e != nil {
-			// In the recovery process, we override the value of ExtVerifyTpmQuote named return parameters
-			// as appropriate to convey the nature of the problem back to the browser window.
-			isLegit = false
-			switch x := e.(type) {
-			case string:
-				message = x
-			default:
-				message = "unknown error"
-			}
-		}
-	}()
-
-	// Retrieve events log
-	eventsLog := lib.Read(fmt.Sprintf("%s.bin", cicdPredictionPath))
+	// Retrieve reference values
+	referenceValues, referenceValuesSignature, cicdPubPEM, err := refvalues.ReadFiles(
+		cicdReferenceValuesPath, cicdSignerPath)
+	if err != nil {
+		return false, err.Error(), nil
+	}
 	lib.Trace.Print("In deeper.")
-	return VerifyQuote2(
-		eventsLog,     // IN
-		pcrs,          // IN
-		nonce,         // IN
-		attestation,   // IN
-		signature,     // IN
-		[]byte(akPub), // IN
+
+	lib.PRINT("=== VERIFIER: VERIFY QUOTE =====================================================")
+
+	v, err := newVerifier(
+		referenceValues,          // IN
+		referenceValuesSignature, // IN
+		cicdPubPEM,               // IN
+		sels,                     // IN
+		[]byte(akPub),            // IN
 	)
+	if err != nil {
+		return false, err.Error(), nil
+	}
+
+	// Convey the nature of any problem back to the browser window
+	result = v.VerifyEvidence(verifier.Evidence{
+		Nonce:       nonce,
+		Attestation: attestation,
+		Signature:   signature,
+		EventsLog:   eventsLog,
+		IMALog:      imaLog,
+	})
+	if err := printResult(result); err != nil {
+		return false, err.Error(), result
+	}
+
+	return true, "All good ;)", result
 }
@@ -4,15 +4,23 @@ package steps
 
 import (
 	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
 	"crypto/rand"
 	"encoding/hex"
 	"fmt"
+	"math/big"
 
 	"github.com/google/go-tpm/tpm2"
 	"github.com/google/go-tpm/tpm2/credactivation"
+	"github.com/google/go-tpm/tpmutil"
 
 	"main/src/certs"
 	"main/src/lib"
+	"main/src/teepeem"
 )
 
 // ### Verifier: generate credential (challenge) ###############################
@@ -22,51 +30,169 @@ func GenerateCredential(
 	verifierEkPath string, // IN
 	verifierNoncePath string, // OUT
 	verifierCredentialPath string, // OUT
-) {
+) error {
 
 	lib.PRINT("=== VERIFIER: GENERATE CRED CHALLENGE ==========================================")
 
 	// Retrieve AK name
-	akName := lib.Read(fmt.Sprintf("%s-name.blob", attestorAkPath))
+	akName, err := lib.Read(fmt.Sprintf("%s-name.blob", attestorAkPath))
+	if err != nil {
+		return err
+	}
 
 	// Verify digest matches the public blob that was provided.
 	name, err := tpm2.DecodeName(bytes.NewBuffer(akName))
 	if err != nil {
-		lib.Fatal("tpm2.DecodeName(): %v", err)
+		return fmt.Errorf("tpm2.DecodeName(): %w", err)
 	}
-	lib.Verbose("akName     : 0x%s", hex.EncodeToString(akName))
-	lib.Verbose("name.Digest: 0x%04x%s", int(name.Digest.Alg), hex.EncodeToString(name.Digest.Value))
-
 	if name.Digest == nil {
-		lib.Fatal("ak.name was not a digest")
+		return fmt.Errorf("ak.name was not a digest")
 	}
+	lib.Verbose("akName     : 0x%s", hex.EncodeToString(akName))
+	lib.Verbose("name.Digest: 0x%04x%s", int(name.Digest.Alg), hex.EncodeToString(name.Digest.Value))
 
 	// Retrieve EK Pub
-	ekPublicKey := certs.ReadPublicKey(verifierEkPath)
+	ekPublicKey, err := certs.ReadPublicKey(verifierEkPath)
+	if err != nil {
+		return err
+	}
 
 	// Generate a nonce for the credential challenge
 	nonce := make([]byte, 32)
 	_, err = rand.Read(nonce)
 	if err != nil {
-		lib.Fatal("rand.Read() failed: %v", err)
+		return fmt.Errorf("rand.Read() failed: %w", err)
 	}
 
 	// Write nonce to disk
-	lib.Write(fmt.Sprintf("%s.bin", verifierNoncePath), nonce, 0600)
+	err = lib.Write(fmt.Sprintf("%s.bin", verifierNoncePath), nonce, 0600)
+	if err != nil {
+		return err
+	}
 
 	// Generate credential challenge for AK name
-	symBlockSize := 16
-	idObject, encSecret, err := credactivation.Generate(
-		name.Digest,  // ak hashed
-		&ekPublicKey, // ek public key
-		symBlockSize, // sym block size
-		nonce,        // secret
-	)
+	var idObject, encSecret []byte
+	if eccPublicKey, ok := ekPublicKey.(*ecdsa.PublicKey); ok {
+		idObject, encSecret, err = generateCredentialECC(
+			name.Digest,  // ak hashed
+			eccPublicKey, // ek public key
+			nonce,        // secret
+		)
+	} else {
+		symBlockSize := 16
+		idObject, encSecret, err = credactivation.Generate(
+			name.Digest,  // ak hashed
+			ekPublicKey,  // ek public key
+			symBlockSize, // sym block size
+			nonce,        // secret
+		)
+	}
 	if err != nil {
-		lib.Fatal("generate credential: %v", err)
+		return fmt.Errorf("generate credential: %w", err)
 	}
 
 	// Write credential challenge to disk
-	lib.Write(fmt.Sprintf("%s-object.blob", verifierCredentialPath), idObject, 0644)
-	lib.Write(fmt.Sprintf("%s-secret.blob", verifierCredentialPath), encSecret, 0644)
+	err = lib.Write(fmt.Sprintf("%s-object.blob", verifierCredentialPath), idObject, 0644)
+	if err != nil {
+		return err
+	}
+	return lib.Write(fmt.Sprintf("%s-secret.blob", verifierCredentialPath), encSecret, 0644)
+}
+
+// generateCredentialECC protects a credential for an ECC EK, which
+// credactivation.Generate() does not support (TPM 2.0 Part 1, section 24).
+// The seed is shared with the EK by ECDH with an ephemeral key (Annex C.6.4),
+// then derives the key the credential is encrypted with, and the key of its
+// HMAC, as for RSA EKs.
+func generateCredentialECC(
+	akName *tpm2.HashValue, // IN
+	ekPublicKey *ecdsa.PublicKey, // IN
+	secret []byte, // IN
+) (
+	idObject []byte,
+	encSecret []byte,
+	err error,
+) {
+
+	keyType, err := teepeem.KeyType(ekPublicKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("EK: %w", err)
+	}
+	template, err := teepeem.EKTemplate(keyType)
+	if err != nil {
+		return nil, nil, err
+	}
+	nameAlg := template.NameAlg
+	hash, err := nameAlg.Hash()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Coordinates are fixed-size, big-endian
+	curve := ekPublicKey.Curve
+	size := (curve.Params().BitSize + 7) / 8
+	coordinate := func(i *big.Int) []byte {
+		return i.FillBytes(make([]byte, size))
+	}
+
+	// Seed: KDFe() of Z, the x coordinate of the shared point, and of the x
+	// coordinates of both public keys
+	d, x, y, err := elliptic.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("elliptic.GenerateKey() failed: %w", err)
+	}
+	z, _ := curve.ScalarMult(ekPublicKey.X, ekPublicKey.Y, d)
+	seed, err := tpm2.KDFe(nameAlg, coordinate(z), "IDENTITY", coordinate(x), coordinate(ekPublicKey.X), hash.Size()*8)
+	if err != nil {
+		return nil, nil, fmt.Errorf("tpm2.KDFe() failed: %w", err)
+	}
+
+	// Credential, as a TPM2B_DIGEST, encrypted with AES-CFB and a zero IV
+	akNameEncoded, err := akName.Encode()
+	if err != nil {
+		return nil, nil, fmt.Errorf("akName.Encode() failed: %w", err)
+	}
+	symmetricKey, err := tpm2.KDFa(nameAlg, seed, "STORAGE", akNameEncoded, nil, int(template.ECCParameters.Symmetric.KeyBits))
+	if err != nil {
+		return nil, nil, fmt.Errorf("tpm2.KDFa() failed: %w", err)
+	}
+	block, err := aes.NewCipher(symmetricKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("aes.NewCipher() failed: %w", err)
+	}
+	cv, err := tpmutil.Pack(tpmutil.U16Bytes(secret))
+	if err != nil {
+		return nil, nil, fmt.Errorf("tpmutil.Pack() failed: %w", err)
+	}
+	encIdentity := make([]byte, len(cv))
+	cipher.NewCFBEncrypter(block, make([]byte, aes.BlockSize)).XORKeyStream(encIdentity, cv)
+
+	// HMAC of the encrypted credential and of the AK name
+	macKey, err := tpm2.KDFa(nameAlg, seed, "INTEGRITY", nil, nil, hash.Size()*8)
+	if err != nil {
+		return nil, nil, fmt.Errorf("tpm2.KDFa() failed: %w", err)
+	}
+	mac := hmac.New(hash.New, macKey)
+	mac.Write(encIdentity)
+	mac.Write(akNameEncoded)
+
+	// TPM2B_ID_OBJECT, and the ephemeral public key as TPM2B_ENCRYPTED_SECRET
+	id, err := tpmutil.Pack(&tpm2.IDObject{IntegrityHMAC: mac.Sum(nil), EncIdentity: encIdentity})
+	if err != nil {
+		return nil, nil, fmt.Errorf("tpmutil.Pack() failed: %w", err)
+	}
+	idObject, err = tpmutil.Pack(tpmutil.U16Bytes(id))
+	if err != nil {
+		return nil, nil, fmt.Errorf("tpmutil.Pack() failed: %w", err)
+	}
+	point, err := tpmutil.Pack(tpmutil.U16Bytes(coordinate(x)), tpmutil.U16Bytes(coordinate(y)))
+	if err != nil {
+		return nil, nil, fmt.Errorf("tpmutil.Pack() failed: %w", err)
+	}
+	encSecret, err = tpmutil.Pack(tpmutil.U16Bytes(point))
+	if err != nil {
+		return nil, nil, fmt.Errorf("tpmutil.Pack() failed: %w", err)
+	}
+
+	return idObject, encSecret, nil
 }
@@ -0,0 +1,100 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package steps
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io"
+
+	"main/src/certs"
+	"main/src/lib"
+	"main/src/teepeem"
+)
+
+// === Attestor: get EK Cert ===================================================
+
+// GetEKCert reads the EK certificate the TPM manufacturer stored in NV, for
+// the verifier to check. Of the certificates stored for the type of the EK,
+// the one certifying the EK is kept. It is written followed by the
+// intermediate CAs of the EK certificate chain indexes, if any, for the
+// verifier to build the chain through. teepeem.ErrNoEKCert is returned if
+// the TPM has no EK certificate, ErrEKCertInvalid if none certifies the EK.
+func GetEKCert(
+	rw io.ReadWriter,
+	ekPublicKey crypto.PublicKey, // IN
+	ekCertPath string, // OUT
+) error {
+
+	lib.PRINT("=== ATTESTOR: GET EK CERT ======================================================")
+
+	keyType, err := teepeem.KeyType(ekPublicKey)
+	if err != nil {
+		return err
+	}
+	ekPublicBytes, err := x509.MarshalPKIXPublicKey(ekPublicKey)
+	if err != nil {
+		return fmt.Errorf("x509.MarshalPKIXPublicKey() failed: %w", err)
+	}
+
+	nvCerts, err := teepeem.ReadEKCerts(rw, keyType)
+	if err != nil {
+		return err
+	}
+
+	var ekCert *x509.Certificate
+	indexes := []string{}
+	for _, nvCert := range nvCerts {
+		indexes = append(indexes, fmt.Sprintf("0x%08x", uint32(nvCert.Index)))
+		cert, err := certs.ParseEKCert(nvCert.Data)
+		if err != nil {
+			return fmt.Errorf("EK certificate at 0x%08x: %w", uint32(nvCert.Index), err)
+		}
+		lib.Verbose("EK certificate at 0x%08x issued by %q", uint32(nvCert.Index), cert.Issuer.String())
+		certPublicBytes, err := x509.MarshalPKIXPublicKey(cert.PublicKey)
+		if err != nil {
+			return fmt.Errorf("x509.MarshalPKIXPublicKey() failed: %w", err)
+		}
+		if bytes.Equal(certPublicBytes, ekPublicBytes) {
+			ekCert = cert
+			break
+		}
+	}
+	if ekCert == nil {
+		return fmt.Errorf("%w: none of the %s EK certificates at %v certifies the EK",
+			ErrEKCertInvalid, keyType, indexes)
+	}
+
+	ekCertPEM := pem.EncodeToMemory(
+		&pem.Block{
+			Type:  "CERTIFICATE",
+			Bytes: ekCert.Raw,
+		},
+	)
+
+	// Append the intermediate CAs
+	nvChain, err := teepeem.ReadEKCertChain(rw)
+	if err != nil {
+		return err
+	}
+	for _, nvCert := range nvChain {
+		chain, err := certs.ParseCertChain(nvCert.Data)
+		if err != nil {
+			return fmt.Errorf("EK certificate chain at 0x%08x: %w", uint32(nvCert.Index), err)
+		}
+		for _, cert := range chain {
+			lib.Verbose("EK certificate chain at 0x%08x holds %q", uint32(nvCert.Index), cert.Subject.String())
+			ekCertPEM = append(ekCertPEM, pem.EncodeToMemory(
+				&pem.Block{
+					Type:  "CERTIFICATE",
+					Bytes: cert.Raw,
+				},
+			)...)
+		}
+	}
+
+	return lib.Write(fmt.Sprintf("%s.crt", ekCertPath), ekCertPEM, 0644)
+}
@@ -3,13 +3,12 @@
 package steps
 
 import (
-	"crypto/rsa"
+	"crypto"
 	"crypto/x509"
 	"encoding/pem"
 	"fmt"
 	"io"
 
-	"github.com/google/go-tpm-tools/client"
 	"github.com/google/go-tpm/tpm2"
 
 	"main/src/lib"
@@ -20,40 +19,46 @@ import (
 
 func GetEKPub(
 	rw io.ReadWriter,
+	keyType string, // IN
 	ekPath string, // OUT
 ) (
-	ekPublicKey *rsa.PublicKey,
+	ekPublicKey crypto.PublicKey,
 	ekPubBytes []byte,
+	err error,
 ) {
 
 	lib.PRINT("=== ATTESTOR: GET EK PUB =======================================================")
 
 	// Create EK and retrieve EK Pub
-	ek, ekPubKey, err := tpm2.CreatePrimary(
-		rw,
-		tpm2.HandleEndorsement,
-		tpm2.PCRSelection{},
-		"",
-		"",
-		client.DefaultEKTemplateRSA(),
-	)
+	ek, ekPublicKey, err := teepeem.CreateEK(rw, keyType)
 	if err != nil {
-		lib.Fatal("tpm2.CreatePrimary() failed: %v", err)
+		return nil, nil, err
 	}
 
 	// Save EK context
-	ekCtx := teepeem.ContextSave(rw, ek)
+	ekCtx, err := teepeem.ContextSave(rw, ek)
+	if err != nil {
+		tpm2.FlushContext(rw, ek)
+		return nil, nil, err
+	}
 
 	// Write EK context to disk
-	lib.Write(fmt.Sprintf("%s.ctx", ekPath), ekCtx, 0644)
+	err = lib.Write(fmt.Sprintf("%s.ctx", ekPath), ekCtx, 0644)
+	if err != nil {
+		tpm2.FlushContext(rw, ek)
+		return nil, nil, err
+	}
 
 	// Flush EK context
-	teepeem.FlushContext(rw, ek)
+	err = teepeem.FlushContext(rw, ek)
+	if err != nil {
+		return nil, nil, err
+	}
 
 	// Convert EK Pub to PEM
-	ekPubBytes, err = x509.MarshalPKIXPublicKey(ekPubKey)
+	ekPubBytes, err = x509.MarshalPKIXPublicKey(ekPublicKey)
 	if err != nil {
-		lib.Fatal("x509.MarshalPKIXPublicKey() failed: %v", err)
+		return nil, nil, fmt.Errorf("x509.MarshalPKIXPublicKey() failed: %w", err)
 	}
 	ekPubPEM := pem.EncodeToMemory(
 		&pem.Block{
@@ -63,19 +68,12 @@ func GetEKPub(
 	)
 
 	// Write EK Pub to disk
-	lib.Write(fmt.Sprintf("%s.pub", ekPath), ekPubPEM, 0644)
-
-	// Assert EK is RSA key
-	// See https://stackoverflow.com/a/44317246
-	switch ekPubTyp := ekPubKey.(type) {
-	case *rsa.PublicKey:
-	default:
-		lib.Fatal("ekPublicKey is not of type RSA: %v", ekPubTyp)
+	err = lib.Write(fmt.Sprintf("%s.pub", ekPath), ekPubPEM, 0644)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	// Convert EK Pub to *rsa.PublicKey
-	ekPublicKey, _ = ekPubKey.(*rsa.PublicKey)
 	lib.Verbose("ekPublicKey %v", ekPublicKey)
 
-	return ekPublicKey, ekPubBytes
+	return ekPublicKey, ekPubBytes, nil
 }
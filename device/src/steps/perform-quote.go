@@ -20,69 +20,111 @@ func PerformQuote(
 	rw io.ReadWriter,
 	attestorEkPath string, // IN
 	attestorAkPath string, // IN
-	pcrs []int, // IN
+	sels []tpm2.PCRSelection, // IN
 	verifierNoncePath string, // IN
 	attestorQuotePath string, // OUT
 ) (
 	attestation []byte,
 	signature tpmutil.U16Bytes,
+	err error,
+) {
+
+	// Load nonce
+	nonce, err := lib.Read(fmt.Sprintf("%s.bin", verifierNoncePath))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return PerformQuoteNonce(
+		rw,
+		attestorEkPath,    // IN
+		attestorAkPath,    // IN
+		sels,              // IN
+		nonce,             // IN
+		attestorQuotePath, // OUT
+	)
+}
+
+// === Attestor: perform quote on a caller nonce ===============================
+
+func PerformQuoteNonce(
+	rw io.ReadWriter,
+	attestorEkPath string, // IN
+	attestorAkPath string, // IN
+	sels []tpm2.PCRSelection, // IN
+	nonce []byte, // IN
+	attestorQuotePath string, // OUT
+) (
+	attestation []byte,
+	signature tpmutil.U16Bytes,
+	err error,
 ) {
 
 	lib.PRINT("=== ATTESTOR: PERFORM QUOTE ====================================================")
-	lib.Print("0")
 	lib.Print("attestorEkPath %s", attestorEkPath)
 
+	// The nonce lands in TPMS_ATTEST.extraData, a TPM2B_DATA
+	maxNonceSize, err := teepeem.MaxNonceSize(rw)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(nonce) == 0 || len(nonce) > maxNonceSize {
+		return nil, nil, fmt.Errorf("nonce is %d bytes long, expected 1 to %d", len(nonce), maxNonceSize)
+	}
+
+	// Fail clearly rather than with a TPM error if a bank is not active
+	err = teepeem.CheckPCRBanks(rw, sels)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	// Load EK
-	ek := teepeem.LoadEK(
+	ek, err := teepeem.LoadEK(
 		rw,
 		attestorEkPath,
 	)
-	lib.Print("1")
+	if err != nil {
+		return nil, nil, err
+	}
 	defer tpm2.FlushContext(rw, ek)
-	lib.Print("2")
 
 	// Load AK
-	ak, _ := teepeem.LoadAK(
+	ak, _, err := teepeem.LoadAK(
 		rw,
 		ek,
 		attestorAkPath, // IN
 	)
-	lib.Print("3")
+	if err != nil {
+		return nil, nil, err
+	}
 	defer tpm2.FlushContext(rw, ak)
-	lib.Print("4")
 
-	// Load nonce
-	nonce := lib.Read(fmt.Sprintf("%s.bin", verifierNoncePath))
-	lib.Print("5")
-
-	// Perform quote
-	pcrSelection := tpm2.PCRSelection{
-		Hash: tpm2.AlgSHA256,
-		PCRs: pcrs,
-	}
-	lib.Print("6")
-	attestation, sig, err := tpm2.Quote(
+	// Perform quote, over all selected banks at once
+	attestation, sig, err := teepeem.Quote(
 		rw,
 		ak,
-		"", // emptyPassword
-		"", // emptyPassword
 		nonce,
-		pcrSelection,
-		tpm2.AlgNull,
+		sels,
 	)
-	lib.Print("7")
 	if err != nil {
-		lib.Fatal("tpm2.Quote() failed: %v", err)
+		return nil, nil, err
+	}
+	signature, err = teepeem.EncodeSignature(sig)
+	if err != nil {
+		return nil, nil, err
 	}
-	lib.Print("8")
-	signature = sig.RSA.Signature
 	lib.Verbose("     Quote Hex %v", hex.EncodeToString(attestation))
 	lib.Verbose("     Quote Sig %v", hex.EncodeToString(signature))
 
-	lib.Print("9")
 	// Write quote to disk
-	lib.Write(fmt.Sprintf("%s-attest.bin", attestorQuotePath), attestation, 0644)
-	lib.Write(fmt.Sprintf("%s-signature.bin", attestorQuotePath), signature, 0644)
+	err = lib.Write(fmt.Sprintf("%s-attest.bin", attestorQuotePath), attestation, 0644)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = lib.Write(fmt.Sprintf("%s-signature.bin", attestorQuotePath), signature, 0644)
+	if err != nil {
+		return nil, nil, err
+	}
 
-	return attestation, signature
+	return attestation, signature, nil
 }
@@ -3,11 +3,11 @@
 package steps
 
 import (
-	"crypto/rand"
 	"encoding/hex"
 	"fmt"
 
 	"main/src/lib"
+	"main/src/verifier"
 )
 
 // === Verifier: generate quote request ========================================
@@ -16,20 +16,23 @@ func RequestQuote(
 	quoteNoncePath string,
 ) (
 	nonce []byte,
+	err error,
 ) {
 
 	lib.PRINT("=== VERIFIER: GENERATE QUOTE REQUEST ===========================================")
 
 	// Generate a nonce for the quote requestk challenge
-	nonce = make([]byte, 32)
-	_, err := rand.Read(nonce)
+	nonce, err = verifier.NewNonce()
 	if err != nil {
-		lib.Fatal("rand.Read() failed: %v", err)
+		return nil, err
 	}
 	lib.Verbose("Quote nonce: 0x%s", hex.EncodeToString(nonce))
 
 	// Write nonce to disk
-	lib.Write(fmt.Sprintf("%s.bin", quoteNoncePath), nonce, 0600)
+	err = lib.Write(fmt.Sprintf("%s.bin", quoteNoncePath), nonce, 0600)
+	if err != nil {
+		return nil, err
+	}
 
-	return nonce
+	return nonce, nil
 }
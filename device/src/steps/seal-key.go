@@ -3,11 +3,10 @@
 package steps
 
 import (
-	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
+	"time"
 
-	"github.com/google/go-attestation/attest"
 	"github.com/google/go-tpm-tools/proto/tpm"
 	"github.com/google/go-tpm-tools/server"
 	"github.com/google/go-tpm/tpm2"
@@ -15,76 +14,90 @@ import (
 
 	"main/src/certs"
 	"main/src/lib"
+	"main/src/pcrsel"
+	"main/src/refvalues"
+	"main/src/verifier"
 )
 
 // === Verifier: seal secret key ===============================================
 
 func SealKey(
 	aesKey []byte, // AES256 key
+	sealedPcrs []tpm2.PCRSelection, // IN
 	verifierSrkPath string, // IN
-	cicdDigestPath string, // IN
+	cicdReferenceValuesPath string, // IN
+	cicdSignerPath string, // IN
 	cicdSealedKeyPath string, // OUT
-) {
+) error {
 
 	lib.PRINT("=== CICD: SEAL SECRET KEY -=====================================================")
 
 	lib.Print("Secret key: %v", aesKey)
 
 	// Read SRK public key from disk
-	srkPublicKey := certs.ReadPublicKey(verifierSrkPath)
+	srkPublicKey, err := certs.ReadPublicKey(verifierSrkPath)
+	if err != nil {
+		return err
+	}
 	lib.Verbose("srkPublicKey: %v", srkPublicKey)
 
-	// Read expected PCRs digest from disk
-	pcrDigest := lib.Read(fmt.Sprintf("%s.bin", cicdDigestPath))
-	lib.Verbose("pcrDigest: %v", pcrDigest)
-
-	// Retrieve events log
-	eventsLog := lib.Read("CICD/cicd-prediction.bin")
-	parsedEventsLog, err := attest.ParseEventLog(eventsLog)
+	// Retrieve the signed reference values. A sealing policy covers a single
+	// set of PCR values: pick the one that became valid last.
+	manifest, err := refvalues.Read(cicdReferenceValuesPath, cicdSignerPath)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrBadReferenceValues, err)
+	}
+	set, err := manifest.Latest(time.Now())
 	if err != nil {
-		lib.Fatal("attest.ParseEventLog() failed: %v", err)
+		return fmt.Errorf("%w: %v", ErrBadReferenceValues, err)
 	}
+	pcrs := set.Banks()
 
-	// Compute expected PCR values
-	pcrs := [][32]byte{}
-	for i := 0; i < 24; i++ {
-		pcrs = append(pcrs, [32]byte{})
-		//lib.Verbose("PCR[%2d]: %v", i, pcrs[i])
-		//lib.Verbose("PCR[%2d]: 0x%s", i, hex.EncodeToString(pcrs[i][:]))
+	// A sealing policy covers a single bank: pick the strongest selected
+	// bank the reference values cover (selections list their banks from the
+	// weakest hash to the strongest)
+	var sel *tpm2.PCRSelection
+	for k := range sealedPcrs {
+		if _, ok := pcrs[sealedPcrs[k].Hash]; ok {
+			sel = &sealedPcrs[k]
+		}
 	}
-	for _, e := range parsedEventsLog.Events(attest.HashAlg(tpm2.AlgSHA256)) {
-		// sudo cat pcr.bin digest.bin | openssl dgst -sha256 -binary > futurepcr.bin
-		i := e.Index
-		pcrs[i] = sha256.Sum256(append(pcrs[i][:], e.Digest...))
-		lib.Verbose("PCR[%2d]+0x%s => 0x%s", i,
-			hex.EncodeToString(e.Digest), hex.EncodeToString(pcrs[i][:]))
+	if sel == nil {
+		return fmt.Errorf("reference values %q have no values for any bank of %s",
+			set.Name, verifier.FormatSelection(sealedPcrs))
 	}
 
 	// Prepare pcrMap for sealing
 	pcrMap := make(map[uint32][]byte)
-	for _, i := range []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 14} {
-		pcrMap[uint32(i)] = pcrs[i][:]
+	for _, i := range sel.PCRs {
+		value, ok := pcrs[sel.Hash][i]
+		if !ok {
+			return fmt.Errorf("reference values %q have no value for %s PCR[%d]",
+				set.Name, pcrsel.Name(sel.Hash), i)
+		}
+		pcrMap[uint32(i)] = value
+		lib.Verbose("%v PCR[%2d]: 0x%s", sel.Hash, i, hex.EncodeToString(value))
 	}
-	lib.Verbose("pcrMap: %v", pcrMap)
+	lib.Print("Sealing to %v PCRs %v of reference values %q", sel.Hash, sel.PCRs, set.Name)
 	selectedPcrs := tpm.PCRs{
-		Hash: tpm.HashAlgo_SHA256,
+		Hash: tpm.HashAlgo(sel.Hash),
 		Pcrs: pcrMap,
 	}
 
 	// Seal AES key
 	sealedBlob, err := server.CreateImportBlob(
-		&srkPublicKey, // crypto.PublicKey
+		srkPublicKey,  // crypto.PublicKey
 		aesKey,        //[]byte
 		&selectedPcrs, // *tpm.PCRs
 	)
 	if err != nil {
-		lib.Fatal("server.CreateImportBlob() failed : %v", err)
+		return fmt.Errorf("server.CreateImportBlob() failed : %w", err)
 	}
 
 	// Write sealed AES key to disk
 	sealedKey, err := proto.Marshal(sealedBlob)
 	if err != nil {
-		lib.Fatal("proto.Marshal() failed: %v", err)
+		return fmt.Errorf("proto.Marshal() failed: %w", err)
 	}
-	lib.Write(fmt.Sprintf("%s.bin", cicdSealedKeyPath), sealedKey, 0644)
+	return lib.Write(fmt.Sprintf("%s.bin", cicdSealedKeyPath), sealedKey, 0644)
 }
@@ -0,0 +1,42 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package steps
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/google/go-tpm/tpm2"
+
+	"main/src/pcrsel"
+	"main/src/teepeem"
+	"main/src/verifier"
+)
+
+// === Attestor: select PCRs ===================================================
+
+// DefaultSelection selects pcrsel.DefaultPCRs in every bank that is active on
+// the TPM, among the PCRs the reference values hold: a set predicted by
+// ./predict may only hold a few.
+func DefaultSelection(
+	rw io.ReadWriter,
+	pcrs verifier.Banks, // IN
+) (pcrsel.Selection, error) {
+
+	active, err := teepeem.PCRBanks(rw)
+	if err != nil {
+		return nil, err
+	}
+	banks := map[tpm2.Algorithm][]int{}
+	for _, alg := range active {
+		for i := range pcrs[alg] {
+			banks[alg] = append(banks[alg], i)
+		}
+	}
+	selection, err := pcrsel.Default(banks)
+	if err != nil {
+		return nil, fmt.Errorf("no PCR among %v both in a bank active on the TPM %v and in the reference values, select some with --pcrs: %w",
+			pcrsel.DefaultPCRs, active, err)
+	}
+	return selection, nil
+}
@@ -0,0 +1,1549 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package steps
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpmutil"
+
+	"main/src/certs"
+	"main/src/lib"
+	"main/src/pcrsel"
+	"main/src/refvalues"
+	"main/src/teepeem"
+	"main/src/truststore"
+	"main/src/uefi"
+	"main/src/verifier"
+)
+
+var quotedPcrs = []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 14}
+
+// quotedSels selects quotedPcrs in the SHA-256 bank.
+var quotedSels = []tpm2.PCRSelection{{Hash: tpm2.AlgSHA256, PCRs: quotedPcrs}}
+
+// === Test fixtures ===========================================================
+
+// testEvent is a measurement of a test events log.
+type testEvent struct {
+	pcr  int
+	typ  uint32
+	data []byte
+}
+
+// testEvents lists a couple of measurements and a separator for each quoted
+// PCR.
+func testEvents() []testEvent {
+	events := []testEvent{}
+	for _, pcr := range quotedPcrs {
+		events = append(events,
+			testEvent{pcr, 0x0d, []byte("measurement #1 for PCR " + string(rune('A'+pcr)))}, // EV_IPL
+			testEvent{pcr, 0x0d, []byte("measurement #2 for PCR " + string(rune('A'+pcr)))},
+			testEvent{pcr, 0x04, []byte{0, 0, 0, 0}}, // EV_SEPARATOR
+		)
+	}
+	return events
+}
+
+// testEventsLog builds the crypto-agile (SHA1 + SHA256) TCG events log of
+// testEvents().
+func testEventsLog() []byte {
+	return buildEventsLog(testEvents())
+}
+
+func buildEventsLog(events []testEvent) []byte {
+	var buf bytes.Buffer
+	le := binary.LittleEndian
+
+	// TCG_PCR_EVENT header carrying the TCG_EfiSpecIDEventStruct
+	var specID bytes.Buffer
+	specID.WriteString("Spec ID Event03\x00")
+	binary.Write(&specID, le, uint32(0))            // platformClass
+	specID.Write([]byte{0, 2, 0, 2})                // minor, major, errata, uintnSize
+	binary.Write(&specID, le, uint32(2))            // numberOfAlgorithms
+	binary.Write(&specID, le, []uint16{0x0004, 20}) // SHA1
+	binary.Write(&specID, le, []uint16{0x000b, 32}) // SHA256
+	specID.WriteByte(0)                             // vendorInfoSize
+	binary.Write(&buf, le, uint32(0))               // pcrIndex
+	binary.Write(&buf, le, uint32(0x03))            // EV_NO_ACTION
+	buf.Write(make([]byte, 20))                     // digest
+	binary.Write(&buf, le, uint32(specID.Len()))    // eventSize
+	buf.Write(specID.Bytes())
+
+	// TCG_PCR_EVENT2 entries; GRUB extends the digest of its strings alone,
+	// without their prefix
+	event := func(pcr int, typ uint32, data []byte) {
+		hashed := data
+		for _, prefix := range []string{"grub_cmd: ", "kernel_cmdline: "} {
+			if pcr == 8 && bytes.HasPrefix(data, []byte(prefix)) {
+				hashed = bytes.TrimSuffix(data[len(prefix):], []byte{0})
+			}
+		}
+		s1 := sha1.Sum(hashed)
+		s256 := sha256.Sum256(hashed)
+		binary.Write(&buf, le, uint32(pcr))
+		binary.Write(&buf, le, typ)
+		binary.Write(&buf, le, uint32(2))
+		binary.Write(&buf, le, uint16(0x0004))
+		buf.Write(s1[:])
+		binary.Write(&buf, le, uint16(0x000b))
+		buf.Write(s256[:])
+		binary.Write(&buf, le, uint32(len(data)))
+		buf.Write(data)
+	}
+	for _, e := range events {
+		event(e.pcr, e.typ, e.data)
+	}
+
+	return buf.Bytes()
+}
+
+// setup moves to a fresh directory holding the role directories and the
+// reference values the CICD signed, then opens a simulated TPM whose PCRs
+// match these values.
+func setup(t *testing.T) io.ReadWriteCloser {
+	t.Helper()
+	return setupMeasured(t, testEventsLog())
+}
+
+// setupMeasured is setup(), with the simulated TPM measuring another events
+// log than the one the reference values are replayed from.
+func setupMeasured(t *testing.T, measuredEventsLog []byte) io.ReadWriteCloser {
+	t.Helper()
+
+	lib.UseLog = true
+	lib.Trace = log.New(ioutil.Discard, "", 0)
+	lib.Error = log.New(ioutil.Discard, "", 0)
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("os.Getwd() failed: %v", err)
+	}
+	dir := t.TempDir()
+	for _, role := range []string{"Manufacturer", "Owner", "CICD", "Verifier", "Attestor"} {
+		if err := os.Mkdir(filepath.Join(dir, role), 0755); err != nil {
+			t.Fatalf("os.Mkdir() failed: %v", err)
+		}
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatalf("os.Chdir() failed: %v", err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	_, err = certs.CreateSigningKey(refvalues.DefaultSignerPath)
+	must(t, err)
+	pcrs, err := verifier.ReplayEventsLog(testEventsLog())
+	must(t, err)
+	publish(t, refvalues.NewSet("release-1", pcrs))
+	must(t, lib.Write("Attestor/events-log.bin", measuredEventsLog, 0644))
+
+	rwc, err := teepeem.Simulator{Seed: teepeem.SimulatorSeed}.Open()
+	if err != nil {
+		t.Fatalf("teepeem.Simulator.Open() failed: %v", err)
+	}
+	t.Cleanup(func() { rwc.Close() })
+	if err := teepeem.ExtendEventsLog(rwc, measuredEventsLog); err != nil {
+		t.Fatalf("teepeem.ExtendEventsLog() failed: %v", err)
+	}
+
+	return rwc
+}
+
+// onboard runs the manufacturer and owner provisioning, up to a verified AK.
+func onboard(t *testing.T, rwc io.ReadWriter) {
+	t.Helper()
+	onboardKeys(t, rwc, teepeem.KeyRSA, teepeem.KeyRSA)
+}
+
+// onboardKeys is onboard(), with EK and AK of some types.
+func onboardKeys(t *testing.T, rwc io.ReadWriter, ekType, akType string) {
+	t.Helper()
+
+	trustManufacturer(t)
+	_, _, err := certs.CreateCACert("Owner", "Owner/owner-ca")
+	must(t, err)
+
+	info, err := teepeem.ReadInfo(rwc)
+	must(t, err)
+	_, _, err = GetEKPub(rwc, ekType, "Manufacturer/ek")
+	must(t, err)
+	must(t, certs.CreateEKCert("Manufacturer/ek", info.ManufacturerID(), info.Model(), info.Version(),
+		"Manufacturer/manufacturer-ca", "Manufacturer/ek"))
+	ekCert, err := certs.ReadCert("Manufacturer/ek")
+	must(t, err)
+	indexes, err := teepeem.EKCertIndexes(ekType)
+	must(t, err)
+	provisionEKCert(t, rwc, indexes[0], ekCert.Raw)
+
+	ekPublicKey, _, err := GetEKPub(rwc, ekType, "Attestor/ek")
+	must(t, err)
+	must(t, GetEKCert(rwc, ekPublicKey, "Attestor/ek"))
+	must(t, VerifyEKPub("Attestor/ek", "Attestor/ek", truststore.DefaultPath, "Verifier/ek"))
+
+	must(t, CreateAK(rwc, akType, "Attestor/ek", "Attestor/ak"))
+	must(t, GenerateCredential("Attestor/ak", "Verifier/ek", "Verifier/nonce", "Verifier/credential"))
+	must(t, ActivateCredential(rwc, "Verifier/credential", "Attestor/ek", "Attestor/ak", "Attestor/attempt"))
+	must(t, VerifyCredential("Attestor/attempt", "Verifier/nonce", "Attestor/ak", "Verifier/ak"))
+}
+
+// trustManufacturer creates the Manufacturer CA, and imports it into the
+// trust store.
+func trustManufacturer(t *testing.T) {
+	t.Helper()
+
+	_, _, err := certs.CreateCACert("Manufacturer", "Manufacturer/manufacturer-ca")
+	must(t, err)
+	store, err := truststore.Open(truststore.DefaultPath)
+	must(t, err)
+	_, err = store.Import(read(t, "Manufacturer/manufacturer-ca.crt"))
+	must(t, err)
+}
+
+// provisionEKCert stores an EK certificate in NV, as TPM manufacturers do.
+func provisionEKCert(t *testing.T, rw io.ReadWriter, index tpmutil.Handle, data []byte) {
+	t.Helper()
+
+	attributes := tpm2.AttrPlatformCreate | tpm2.AttrPPWrite | tpm2.AttrWriteDefine |
+		tpm2.AttrOwnerRead | tpm2.AttrAuthRead | tpm2.AttrNoDA
+	err := tpm2.NVDefineSpace(rw, tpm2.HandlePlatform, index, "", "", nil, attributes, uint16(len(data)))
+	if err != nil {
+		t.Fatalf("tpm2.NVDefineSpace() failed: %v", err)
+	}
+	for offset := 0; offset < len(data); offset += 512 {
+		end := offset + 512
+		if end > len(data) {
+			end = len(data)
+		}
+		err := tpm2.NVWrite(rw, tpm2.HandlePlatform, index, "", data[offset:end], uint16(offset))
+		if err != nil {
+			t.Fatalf("tpm2.NVWrite() failed: %v", err)
+		}
+	}
+}
+
+// quote has the attestor quote the PCRs for a fresh verifier nonce.
+func quote(t *testing.T, rwc io.ReadWriter) (nonce, attestation []byte, signature tpmutil.U16Bytes) {
+	t.Helper()
+
+	nonce, err := RequestQuote("Verifier/nonce-quote")
+	must(t, err)
+	attestation, signature, err = PerformQuote(rwc, "Attestor/ek", "Attestor/ak", quotedSels,
+		"Verifier/nonce-quote", "Attestor/quote")
+	must(t, err)
+
+	return nonce, attestation, signature
+}
+
+// publish has the CICD sign and publish sets of reference values.
+func publish(t *testing.T, sets ...refvalues.Set) {
+	t.Helper()
+
+	manifest := &refvalues.Manifest{Version: refvalues.Version, Sets: sets}
+	must(t, manifest.Write(refvalues.DefaultPath, refvalues.DefaultSignerPath))
+}
+
+// verifyQuote checks a quote against the published reference values.
+func verifyQuote(t *testing.T, sels []tpm2.PCRSelection, nonce, attestation []byte, signature tpmutil.U16Bytes) error {
+	t.Helper()
+
+	return VerifyQuote2(read(t, refvalues.DefaultPath+".json"), read(t, refvalues.DefaultPath+".sig"),
+		read(t, refvalues.DefaultSignerPath+".pub"), sels, nonce, attestation, signature, nil, nil, read(t, "Verifier/ak.pub"))
+}
+
+// read reads a file the steps left behind.
+func read(t *testing.T, path string) []byte {
+	t.Helper()
+	data, err := lib.Read(path)
+	must(t, err)
+	return data
+}
+
+// expectedPCR replays the measurements testEventsLog() puts into a PCR.
+func expectedPCR(pcr int) []byte {
+	val := make([]byte, 32)
+	for _, data := range [][]byte{
+		[]byte("measurement #1 for PCR " + string(rune('A'+pcr))),
+		[]byte("measurement #2 for PCR " + string(rune('A'+pcr))),
+		{0, 0, 0, 0},
+	} {
+		d := sha256.Sum256(data)
+		v := sha256.Sum256(append(val, d[:]...))
+		val = v[:]
+	}
+	return val
+}
+
+// must stops the test on unexpected errors.
+func must(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// mustFail asserts that a step fails for the expected reason.
+func mustFail(t *testing.T, what string, err error, reason error) {
+	t.Helper()
+	if err == nil {
+		t.Errorf("%s: step succeeded, expected failure", what)
+	} else if !errors.Is(err, reason) {
+		t.Errorf("%s: failed with %q, expected %q", what, err, reason)
+	}
+}
+
+// === Positive flows ==========================================================
+
+func TestOnboardQuoteSeal(t *testing.T) {
+	rwc := setup(t)
+	onboard(t, rwc)
+
+	// Quote
+	nonce, attestation, signature := quote(t, rwc)
+	must(t, VerifyQuote("Verifier/ak", "Verifier/nonce-quote", refvalues.DefaultPath, refvalues.DefaultSignerPath,
+		quotedSels, "Attestor/quote", "Attestor/events-log.bin", ""))
+	must(t, verifyQuote(t, quotedSels, nonce, attestation, signature))
+
+	// Seal
+	must(t, CreateSRK(rwc, "Attestor/srk"))
+	must(t, certs.CreateSRKCert("Attestor/srk", "TPM SRK", "Owner/owner-ca", "Verifier/srk"))
+	aesKey := make([]byte, 32)
+	if _, err := rand.Read(aesKey); err != nil {
+		t.Fatalf("rand.Read() failed: %v", err)
+	}
+	must(t, SealKey(aesKey, quotedSels, "Verifier/srk", refvalues.DefaultPath, refvalues.DefaultSignerPath,
+		"CICD/sealed-key"))
+	unsealedKey, err := UnsealKey(rwc, "CICD/sealed-key", "Attestor/unsealed-key")
+	must(t, err)
+	if !bytes.Equal(aesKey, unsealedKey) {
+		t.Errorf("UnsealKey() returned %x, expected %x", unsealedKey, aesKey)
+	}
+}
+
+func TestOnboardQuoteECC(t *testing.T) {
+	for _, tc := range []struct {
+		ekType, akType string
+	}{
+		{teepeem.KeyECCP256, teepeem.KeyECCP256},
+		{teepeem.KeyECCP384, teepeem.KeyECCP384},
+		{teepeem.KeyECCP256, teepeem.KeyRSA},
+		{teepeem.KeyRSA, teepeem.KeyECCP384},
+	} {
+		t.Run("EK "+tc.ekType+" AK "+tc.akType, func(t *testing.T) {
+			rwc := setup(t)
+			onboardKeys(t, rwc, tc.ekType, tc.akType)
+
+			ekCert, err := certs.ReadCert("Attestor/ek")
+			must(t, err)
+			if ekType, err := teepeem.KeyType(ekCert.PublicKey); err != nil || ekType != tc.ekType {
+				t.Errorf("EK certificate holds a %s key (%v), expected %s", ekType, err, tc.ekType)
+			}
+			// The P-384 EK is that of the high-range template H-3
+			ek, err := teepeem.LoadEK(rwc, "Attestor/ek")
+			must(t, err)
+			ekPublic, _, _, err := tpm2.ReadPublic(rwc, ek)
+			must(t, err)
+			must(t, tpm2.FlushContext(rwc, ek))
+			if highRange := tc.ekType == teepeem.KeyECCP384; (ekPublic.NameAlg == tpm2.AlgSHA384) != highRange ||
+				(len(ekPublic.AuthPolicy) == 48) != highRange {
+				t.Errorf("EK has name algorithm %v and a %d-byte policy", ekPublic.NameAlg, len(ekPublic.AuthPolicy))
+			}
+			akPublicKey, err := certs.ReadPublicKey("Verifier/ak")
+			must(t, err)
+			if akType, err := teepeem.KeyType(akPublicKey); err != nil || akType != tc.akType {
+				t.Errorf("AK is a %s key (%v), expected %s", akType, err, tc.akType)
+			}
+
+			nonce, attestation, signature := quote(t, rwc)
+			must(t, verifyQuote(t, quotedSels, nonce, attestation, signature))
+			signature[len(signature)-1] ^= 0x01
+			mustFail(t, "verifyQuote()", verifyQuote(t, quotedSels, nonce, attestation, signature), ErrBadSignature)
+		})
+	}
+}
+
+func TestExtGetTpmQuoteCallerNonce(t *testing.T) {
+	rwc := setup(t)
+	onboard(t, rwc)
+
+	maxNonceSize, err := teepeem.MaxNonceSize(rwc)
+	must(t, err)
+	for _, size := range []int{1, 20, maxNonceSize} {
+		nonce := make([]byte, size)
+		if _, err := rand.Read(nonce); err != nil {
+			t.Fatalf("rand.Read() failed: %v", err)
+		}
+		attestation, signature, err := ExtGetTpmQuote(rwc, "", quotedSels, nonce)
+		must(t, err)
+		must(t, verifyQuote(t, quotedSels, nonce, attestation, signature))
+	}
+
+	for _, size := range []int{0, maxNonceSize + 1} {
+		if _, _, err := ExtGetTpmQuote(rwc, "", quotedSels, make([]byte, size)); err == nil {
+			t.Errorf("ExtGetTpmQuote() accepted a %d-byte nonce", size)
+		}
+	}
+}
+
+// freshProcessEnv has the test binary, run again, act as the native host in
+// TestExtGetTpmQuoteFreshProcess.
+const freshProcessEnv = "STEPS_TEST_FRESH_PROCESS"
+
+func TestExtGetTpmQuoteFreshProcess(t *testing.T) {
+	if os.Getenv(freshProcessEnv) != "" {
+		// The native host, with a simulator of its own
+		backend, err := teepeem.NewBackend("simulator:events-log=Attestor/events-log.bin")
+		must(t, err)
+		rwc, err := backend.Open()
+		must(t, err)
+		defer rwc.Close()
+		_, _, err = ExtGetTpmQuote(rwc, "", quotedSels, read(t, "Verifier/nonce-quote.bin"))
+		must(t, err)
+		return
+	}
+
+	for _, keyType := range []string{teepeem.KeyRSA, teepeem.KeyECCP384} {
+		t.Run(keyType, func(t *testing.T) {
+			rwc := setup(t)
+			onboardKeys(t, rwc, keyType, keyType)
+			nonce, err := RequestQuote("Verifier/nonce-quote")
+			must(t, err)
+
+			executable, err := os.Executable()
+			must(t, err)
+			cmd := exec.Command(executable, "-test.run=^TestExtGetTpmQuoteFreshProcess$")
+			cmd.Env = append(os.Environ(), freshProcessEnv+"=1")
+			if output, err := cmd.CombinedOutput(); err != nil {
+				t.Fatalf("native host failed: %v\n%s", err, output)
+			}
+			must(t, verifyQuote(t, quotedSels, nonce,
+				read(t, "Attestor/quote-attest.bin"), read(t, "Attestor/quote-signature.bin")))
+		})
+	}
+}
+
+func TestExtGetQuoteEvidence(t *testing.T) {
+	rwc := setup(t)
+	onboard(t, rwc)
+
+	nonce, err := verifier.NewNonce()
+	must(t, err)
+	attestation, signature, err := ExtGetTpmQuote(rwc, "", quotedSels, nonce)
+	must(t, err)
+	pcrValues, err := ExtGetPcrValues(rwc, quotedSels)
+	must(t, err)
+	eventsLog, err := ExtGetEventsLog("Attestor/events-log.bin")
+	must(t, err)
+
+	v, err := verifier.New(read(t, "Verifier/ak.pub"))
+	must(t, err)
+	must(t, v.WithEventsLog(eventsLog))
+	evidence := verifier.Evidence{
+		Nonce:       nonce,
+		Attestation: attestation,
+		Signature:   signature,
+		PCRValues:   pcrValues,
+		EventsLog:   eventsLog,
+	}
+	result := v.VerifyEvidence(evidence)
+	must(t, result.Err())
+	if len(result.Checks) != 6 {
+		t.Errorf("VerifyEvidence() performed %d checks, expected 6", len(result.Checks))
+	}
+
+	// PCR values that do not hash to the quoted digest are caught
+	evidence.PCRValues[tpm2.AlgSHA256][14] = make([]byte, 32)
+	mustFail(t, "VerifyEvidence()", v.VerifyEvidence(evidence).Err(), verifier.ErrPCRValuesMismatch)
+}
+
+// changingTPM extends PCR 14 just before each of the first quotes it is sent,
+// as IMA would PCR 10 on a busy host.
+type changingTPM struct {
+	io.ReadWriteCloser
+	changes int
+}
+
+func (c *changingTPM) Write(command []byte) (int, error) {
+	// TPM2_Quote, after the tag and the size of the command
+	if len(command) >= 10 && binary.BigEndian.Uint32(command[6:]) == uint32(tpm2.CmdQuote) && c.changes > 0 {
+		c.changes--
+		digest := sha256.Sum256([]byte("late measurement"))
+		if err := tpm2.PCRExtend(c.ReadWriteCloser, 14, tpm2.AlgSHA256, digest[:], ""); err != nil {
+			return 0, err
+		}
+	}
+	return c.ReadWriteCloser.Write(command)
+}
+
+func TestExtGetTpmQuotePcrValues(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		changes int
+		ok      bool
+	}{
+		{"steady PCRs", 0, true},
+		{"changing PCRs", maxQuoteAttempts - 1, true},
+		{"ever changing PCRs", maxQuoteAttempts, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rwc := &changingTPM{ReadWriteCloser: setup(t)}
+			onboard(t, rwc)
+
+			rwc.changes = tc.changes
+			nonce, err := verifier.NewNonce()
+			must(t, err)
+			attestation, signature, pcrValues, err := ExtGetTpmQuotePcrValues(rwc, "", quotedSels, nonce)
+			if !tc.ok {
+				if err == nil {
+					t.Errorf("ExtGetTpmQuotePcrValues() succeeded, expected failure")
+				}
+				return
+			}
+			must(t, err)
+
+			// The values are those quoted, though PCR 14 no longer holds
+			// its reference value
+			v, err := verifier.New(read(t, "Verifier/ak.pub"))
+			must(t, err)
+			must(t, v.WithEventsLog(testEventsLog()))
+			result := v.VerifyEvidence(verifier.Evidence{
+				Nonce:       nonce,
+				Attestation: attestation,
+				Signature:   signature,
+				PCRValues:   pcrValues,
+			})
+			for _, c := range result.Checks {
+				if c.Name == verifier.CheckPCRValues && !c.Passed {
+					t.Errorf("VerifyEvidence() %s check failed: %s", c.Name, c.Message)
+				}
+			}
+			if tc.changes == 0 {
+				must(t, result.Err())
+			}
+		})
+	}
+}
+
+func TestExtSelfCheck(t *testing.T) {
+	rwc := setup(t)
+
+	selfCheck, err := ExtSelfCheck(rwc, "Attestor/events-log.bin")
+	must(t, err)
+	if !selfCheck.OK() {
+		t.Errorf("ExtSelfCheck() reported %v, expected no mismatch", selfCheck.Mismatches)
+	}
+	if pcrs := selfCheck.Selection[pcrsel.SHA256]; fmt.Sprint(pcrs) != fmt.Sprint(quotedPcrs) {
+		t.Errorf("ExtSelfCheck() compared PCRs %v, expected %v", pcrs, quotedPcrs)
+	}
+	if !strings.Contains(fmt.Sprint(selfCheck.Uncovered), pcrsel.SHA384) {
+		t.Errorf("ExtSelfCheck() reported uncovered banks %v, expected sha384 among them", selfCheck.Uncovered)
+	}
+
+	// A firmware that does not log its PCR 0 measurements
+	events := []testEvent{}
+	for _, e := range testEvents() {
+		if e.pcr != 0 {
+			events = append(events, e)
+		}
+	}
+	must(t, lib.Write("Attestor/partial-events-log.bin", buildEventsLog(events), 0644))
+	selfCheck, err = ExtSelfCheck(rwc, "Attestor/partial-events-log.bin")
+	must(t, err)
+	if selfCheck.OK() || len(selfCheck.Mismatches) != 2 {
+		t.Fatalf("ExtSelfCheck() reported %v, expected PCR[0] in 2 banks", selfCheck.Mismatches)
+	}
+	for _, d := range selfCheck.Mismatches {
+		if d.Index != 0 || !bytes.Equal(d.Expected, make([]byte, len(d.Got))) {
+			t.Errorf("ExtSelfCheck() reported %s PCR[%d] replayed to %x, expected PCR[0] replayed to zeros",
+				d.Bank, d.Index, d.Expected)
+		}
+		if d.Bank == pcrsel.SHA256 && !bytes.Equal(d.Got, expectedPCR(0)) {
+			t.Errorf("ExtSelfCheck() read %x, expected %x", d.Got, expectedPCR(0))
+		}
+	}
+}
+
+func TestMultiBankQuoteSeal(t *testing.T) {
+	rwc := setup(t)
+	onboard(t, rwc)
+
+	banks, err := teepeem.PCRBanks(rwc)
+	must(t, err)
+	if len(banks) < 3 {
+		t.Fatalf("PCRBanks() returned %v, expected at least SHA1, SHA256 and SHA384", banks)
+	}
+
+	// One quote covers both banks of the events log
+	sels := []tpm2.PCRSelection{
+		{Hash: tpm2.AlgSHA1, PCRs: []int{0, 7}},
+		{Hash: tpm2.AlgSHA256, PCRs: quotedPcrs},
+	}
+	nonce, err := verifier.NewNonce()
+	must(t, err)
+	attestation, signature, err := ExtGetTpmQuote(rwc, "", sels, nonce)
+	must(t, err)
+	quote, err := verifier.DecodeQuote(attestation)
+	must(t, err)
+	if verifier.FormatSelection(quote.Selection) != verifier.FormatSelection(sels) {
+		t.Errorf("DecodeQuote() returned selection %s, expected %s",
+			verifier.FormatSelection(quote.Selection), verifier.FormatSelection(sels))
+	}
+	must(t, verifyQuote(t, sels, nonce, attestation, signature))
+	pcrValues, err := ExtGetPcrValues(rwc, sels)
+	must(t, err)
+	if len(pcrValues[tpm2.AlgSHA1][7]) != 20 {
+		t.Errorf("ExtGetPcrValues() returned %x for SHA1 PCR[7]", pcrValues[tpm2.AlgSHA1][7])
+	}
+
+	// The events log predicts no SHA384 values
+	sels = append(sels, tpm2.PCRSelection{Hash: tpm2.AlgSHA384, PCRs: []int{0}})
+	attestation, signature, err = ExtGetTpmQuote(rwc, "", sels, nonce)
+	must(t, err)
+	mustFail(t, "VerifyQuote2()",
+		verifyQuote(t, sels, nonce, attestation, signature),
+		verifier.ErrPCRDigestMismatch)
+
+	// Sealing picks the strongest bank the events log has digests for
+	must(t, CreateSRK(rwc, "Attestor/srk"))
+	must(t, certs.CreateSRKCert("Attestor/srk", "TPM SRK", "Owner/owner-ca", "Verifier/srk"))
+	aesKey := make([]byte, 32)
+	if _, err := rand.Read(aesKey); err != nil {
+		t.Fatalf("rand.Read() failed: %v", err)
+	}
+	must(t, SealKey(aesKey, sels, "Verifier/srk", refvalues.DefaultPath, refvalues.DefaultSignerPath,
+		"CICD/sealed-key"))
+	unsealedKey, err := UnsealKey(rwc, "CICD/sealed-key", "Attestor/unsealed-key")
+	must(t, err)
+	if !bytes.Equal(aesKey, unsealedKey) {
+		t.Errorf("UnsealKey() returned %x, expected %x", unsealedKey, aesKey)
+	}
+}
+
+func TestOnboardSealPredicted(t *testing.T) {
+	rwc := setup(t)
+
+	// The CICD predicted PCRs 4, 7 and 11 alone, as ./predict does
+	predicted := refvalues.Set{Name: "predicted", PCRs: map[string]map[int]refvalues.Hex{pcrsel.SHA256: {
+		4:  expectedPCR(4),
+		7:  expectedPCR(7),
+		11: make([]byte, sha256.Size),
+	}}}
+	publish(t, predicted)
+
+	// Onboarding selects the usual PCRs among them
+	selection, err := DefaultSelection(rwc, predicted.Banks())
+	must(t, err)
+	if selection.String() != "sha256:4,7" {
+		t.Fatalf("DefaultSelection() returned %v, expected sha256:4,7", selection)
+	}
+	sels := selection.TPM()
+	onboard(t, rwc)
+	_, err = RequestQuote("Verifier/nonce-quote")
+	must(t, err)
+	_, _, err = PerformQuote(rwc, "Attestor/ek", "Attestor/ak", sels, "Verifier/nonce-quote", "Attestor/quote")
+	must(t, err)
+	must(t, VerifyQuote("Verifier/ak", "Verifier/nonce-quote", refvalues.DefaultPath, refvalues.DefaultSignerPath,
+		sels, "Attestor/quote", "", ""))
+
+	must(t, CreateSRK(rwc, "Attestor/srk"))
+	must(t, certs.CreateSRKCert("Attestor/srk", "TPM SRK", "Owner/owner-ca", "Verifier/srk"))
+	aesKey := make([]byte, 32)
+	if _, err := rand.Read(aesKey); err != nil {
+		t.Fatalf("rand.Read() failed: %v", err)
+	}
+	must(t, SealKey(aesKey, sels, "Verifier/srk", refvalues.DefaultPath, refvalues.DefaultSignerPath,
+		"CICD/sealed-key"))
+	unsealedKey, err := UnsealKey(rwc, "CICD/sealed-key", "Attestor/unsealed-key")
+	must(t, err)
+	if !bytes.Equal(aesKey, unsealedKey) {
+		t.Errorf("UnsealKey() returned %x, expected %x", unsealedKey, aesKey)
+	}
+
+	// A set holding none of the usual PCRs leaves nothing to select
+	uki := refvalues.Set{Name: "uki", PCRs: map[string]map[int]refvalues.Hex{pcrsel.SHA256: {
+		11: make([]byte, sha256.Size),
+	}}}
+	if selection, err := DefaultSelection(rwc, uki.Banks()); err == nil {
+		t.Errorf("DefaultSelection() returned %v for PCR 11 alone, expected an error", selection)
+	}
+}
+
+func TestReferenceValueSets(t *testing.T) {
+	rwc := setup(t)
+	onboard(t, rwc)
+	nonce, attestation, signature := quote(t, rwc)
+
+	pcrs, err := verifier.ReplayEventsLog(testEventsLog())
+	must(t, err)
+	current := refvalues.NewSet("release-2", pcrs)
+	events := append(testEvents(), testEvent{14, 0x0d, []byte("release-1 measurement")})
+	previousPcrs, err := verifier.ReplayEventsLog(buildEventsLog(events))
+	must(t, err)
+	previous := refvalues.NewSet("release-1", previousPcrs)
+
+	// The quote matches one of the accepted sets, here one that only
+	// publishes the PCRs digest
+	pcrDigest, err := verifier.PCRsDigest(quotedSels, pcrs)
+	must(t, err)
+	digestOnly := refvalues.Set{
+		Name: "release-2-digest",
+		Digests: []refvalues.Digest{
+			{PCRSelection: pcrsel.Selection{pcrsel.SHA256: quotedPcrs}, Digest: pcrDigest},
+		},
+	}
+	publish(t, previous, digestOnly)
+	must(t, verifyQuote(t, quotedSels, nonce, attestation, signature))
+
+	// Sets out of their validity window are not accepted
+	past := time.Now().Add(-time.Hour)
+	expired := current
+	expired.NotAfter = &past
+	publish(t, previous, expired)
+	mustFail(t, "VerifyQuote2()", verifyQuote(t, quotedSels, nonce, attestation, signature),
+		ErrPCRDigestMismatch)
+	publish(t, expired)
+	mustFail(t, "VerifyQuote2()", verifyQuote(t, quotedSels, nonce, attestation, signature),
+		ErrBadReferenceValues)
+
+	// A manifest the CICD did not sign is not used
+	publish(t, previous)
+	forged := bytes.Replace(read(t, refvalues.DefaultPath+".json"),
+		[]byte(hex.EncodeToString(previousPcrs[tpm2.AlgSHA256][14])),
+		[]byte(hex.EncodeToString(pcrs[tpm2.AlgSHA256][14])), 1)
+	must(t, lib.Write(refvalues.DefaultPath+".json", forged, 0644))
+	mustFail(t, "VerifyQuote2()", verifyQuote(t, quotedSels, nonce, attestation, signature),
+		ErrBadReferenceValues)
+
+	// Sealing uses the set that became valid last
+	earlier := time.Now().Add(-2 * time.Hour)
+	previous.NotBefore = &earlier
+	current.NotBefore = &past
+	publish(t, current, previous)
+	must(t, CreateSRK(rwc, "Attestor/srk"))
+	must(t, certs.CreateSRKCert("Attestor/srk", "TPM SRK", "Owner/owner-ca", "Verifier/srk"))
+	aesKey := make([]byte, 32)
+	if _, err := rand.Read(aesKey); err != nil {
+		t.Fatalf("rand.Read() failed: %v", err)
+	}
+	must(t, SealKey(aesKey, quotedSels, "Verifier/srk", refvalues.DefaultPath, refvalues.DefaultSignerPath,
+		"CICD/sealed-key"))
+	unsealedKey, err := UnsealKey(rwc, "CICD/sealed-key", "Attestor/unsealed-key")
+	must(t, err)
+	if !bytes.Equal(aesKey, unsealedKey) {
+		t.Errorf("UnsealKey() returned %x, expected %x", unsealedKey, aesKey)
+	}
+}
+
+func TestExtVerifyTpmQuoteSecureBoot(t *testing.T) {
+	// A boot whose images were verified with a certificate in db
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	must(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "Test UEFI CA"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	must(t, err)
+	owner := uefi.MustParseGUID("77fa9abd-0359-4d32-bd60-28f4e78f784b")
+	signature := append(owner[:], cert...)
+	var esl bytes.Buffer
+	esl.Write(uefi.CertX509[:])
+	binary.Write(&esl, binary.LittleEndian, []uint32{uint32(28 + len(signature)), 0, uint32(len(signature))})
+	esl.Write(signature)
+
+	events := []testEvent{}
+	for _, e := range testEvents() {
+		if e.pcr != 7 {
+			events = append(events, e)
+		}
+	}
+	for _, v := range []struct {
+		vendor uefi.GUID
+		name   string
+		data   []byte
+	}{
+		{uefi.GlobalVariable, "SecureBoot", []byte{1}},
+		{uefi.GlobalVariable, "PK", esl.Bytes()},
+		{uefi.GlobalVariable, "KEK", esl.Bytes()},
+		{uefi.ImageSecurityDatabase, "db", esl.Bytes()},
+		{uefi.ImageSecurityDatabase, "dbx", nil},
+	} {
+		events = append(events, testEvent{7, uefi.EvEFIVariableDriverConfig, uefi.VariableData(v.vendor, v.name, v.data)})
+	}
+	events = append(events,
+		testEvent{7, uefi.EvSeparator, []byte{0, 0, 0, 0}},
+		testEvent{7, uefi.EvEFIVariableAuthority, uefi.VariableData(uefi.ImageSecurityDatabase, "db", signature)})
+	eventsLog := buildEventsLog(events)
+
+	rwc := setupMeasured(t, eventsLog)
+	onboard(t, rwc)
+	nonce, err := verifier.NewNonce()
+	must(t, err)
+	attestation, quoteSignature, err := ExtGetTpmQuote(rwc, "", quotedSels, nonce)
+	must(t, err)
+	verify := func(eventsLog []byte) (bool, string, []verifier.Verdict) {
+		isLegit, message, result := ExtVerifyTpmQuote(refvalues.DefaultPath, refvalues.DefaultSignerPath,
+			quotedSels, nonce, attestation, quoteSignature, eventsLog, nil, string(read(t, "Verifier/ak.pub")))
+		if result == nil {
+			return isLegit, message, nil
+		}
+		return isLegit, message, result.SecureBoot
+	}
+	pcrs, err := verifier.ReplayEventsLog(eventsLog)
+	must(t, err)
+	set := refvalues.NewSet("release-1", pcrs)
+	dbDigest, certDigest := sha256.Sum256(esl.Bytes()), sha256.Sum256(cert)
+	other := sha256.Sum256([]byte("another certificate"))
+
+	// The policy permits the configuration and the signer
+	publishPolicy := func(policy *refvalues.SecureBootPolicy) {
+		manifest := &refvalues.Manifest{Version: refvalues.Version, Sets: []refvalues.Set{set}, SecureBoot: policy}
+		must(t, manifest.Write(refvalues.DefaultPath, refvalues.DefaultSignerPath))
+	}
+	publishPolicy(&refvalues.SecureBootPolicy{
+		Variables:   map[string][]refvalues.Hex{"db": {other[:], dbDigest[:]}},
+		Authorities: []refvalues.Hex{certDigest[:]},
+	})
+	isLegit, message, verdicts := verify(eventsLog)
+	if !isLegit {
+		t.Errorf("ExtVerifyTpmQuote() failed: %s", message)
+	}
+	if len(verdicts) != 4 {
+		t.Errorf("ExtVerifyTpmQuote() returned %d verdicts, expected 4: %+v", len(verdicts), verdicts)
+	}
+
+	// It does not permit the signer
+	publishPolicy(&refvalues.SecureBootPolicy{Authorities: []refvalues.Hex{other[:]}})
+	isLegit, _, verdicts = verify(eventsLog)
+	failed := []string{}
+	for _, v := range verdicts {
+		if !v.Passed {
+			failed = append(failed, v.Rule+":"+v.Subject)
+		}
+	}
+	if isLegit || len(failed) != 1 || failed[0] != verifier.RuleSecureBootAuthority+":Test UEFI CA" {
+		t.Errorf("ExtVerifyTpmQuote() returned %v, with failed verdicts %v", isLegit, failed)
+	}
+
+	// The Secure Boot state cannot be told without the events log
+	isLegit, message, _ = verify(nil)
+	if isLegit || !strings.Contains(message, verifier.ErrSecureBootPolicy.Error()) {
+		t.Errorf("ExtVerifyTpmQuote() returned %v, %q without events log", isLegit, message)
+	}
+}
+
+func TestExtVerifyTpmQuoteEventsPolicy(t *testing.T) {
+	// GRUB boots a kernel whose release and command line change from boot to
+	// boot
+	boot := func(cmdline string, extra ...testEvent) []byte {
+		events := []testEvent{}
+		for _, e := range testEvents() {
+			if e.pcr != 8 {
+				events = append(events, e)
+			}
+		}
+		events = append(events,
+			testEvent{8, uefi.EvIPL, []byte("grub_cmd: set root=hd0,gpt2\x00")},
+			testEvent{8, uefi.EvIPL, []byte("grub_cmd: linux " + cmdline + "\x00")},
+			testEvent{8, uefi.EvIPL, []byte("kernel_cmdline: " + cmdline + "\x00")})
+		return buildEventsLog(append(events, extra...))
+	}
+	policy := &verifier.EventsPolicy{PCRs: []int{8}, Rules: []verifier.EventRule{
+		{Name: "lockdown", Kind: verifier.KindKernelCmdline, Contains: []string{"lockdown=integrity"}},
+		{Name: "no-init", Kind: verifier.KindKernelCmdline, Forbids: []string{"init=*"}},
+		{Name: "kernel", Kind: verifier.KindKernel, Required: true, Match: "/boot/vmlinuz-6.*"},
+	}}
+
+	for _, tc := range []struct {
+		cmdline string
+		extra   []testEvent
+		forged  func([]byte) []byte
+		failed  []string
+	}{
+		{cmdline: "/boot/vmlinuz-6.2.0-39-generic root=/dev/sda2 ro lockdown=integrity"},
+		{cmdline: "/boot/vmlinuz-6.5.0-14-generic root=/dev/sda2 ro quiet lockdown=integrity"},
+		{
+			cmdline: "/boot/vmlinuz-5.15.0-91-generic root=/dev/sda2 ro init=/bin/sh",
+			failed:  []string{"lockdown", "no-init", "kernel"},
+		},
+		{
+			// The command line in the log is not the measured one
+			cmdline: "/boot/vmlinuz-6.2.0-39-generic root=/dev/sda2 ro lockdown=integrity init=/bin/sh",
+			forged: func(eventsLog []byte) []byte {
+				return bytes.Replace(eventsLog, []byte("init=/bin/sh"), []byte("initrd=/x.gz"), -1)
+			},
+			failed: []string{verifier.RuleEvents},
+		},
+		{
+			// A forbidden command line is measured, but logged as something
+			// else
+			cmdline: "/boot/vmlinuz-6.2.0-39-generic root=/dev/sda2 ro lockdown=integrity",
+			extra: []testEvent{{8, uefi.EvIPL,
+				[]byte("kernel_cmdline: /boot/vmlinuz-6.2.0-39-generic root=/dev/sda2 ro init=/bin/sh\x00")}},
+			forged: func(eventsLog []byte) []byte {
+				return bytes.Replace(eventsLog, []byte("kernel_cmdline: /boot/vmlinuz-6.2.0-39-generic root=/dev/sda2 ro init="),
+					[]byte("kernel_options: /boot/vmlinuz-6.2.0-39-generic root=/dev/sda2 ro init="), -1)
+			},
+			failed: []string{verifier.RuleEvents},
+		},
+	} {
+		t.Run(tc.cmdline, func(t *testing.T) {
+			eventsLog := boot(tc.cmdline, tc.extra...)
+			rwc := setupMeasured(t, eventsLog)
+			onboard(t, rwc)
+			nonce, err := verifier.NewNonce()
+			must(t, err)
+			attestation, signature, err := ExtGetTpmQuote(rwc, "", quotedSels, nonce)
+			must(t, err)
+			if tc.forged != nil {
+				eventsLog = tc.forged(eventsLog)
+			}
+			verify := func() (bool, string, *verifier.Result) {
+				return ExtVerifyTpmQuote(refvalues.DefaultPath, refvalues.DefaultSignerPath, quotedSels,
+					nonce, attestation, signature, eventsLog, nil, string(read(t, "Verifier/ak.pub")))
+			}
+
+			// PCR 8 matches no reference value
+			if isLegit, message, _ := verify(); isLegit {
+				t.Errorf("ExtVerifyTpmQuote() returned %v, %q without events policy", isLegit, message)
+			}
+
+			// The policy vouches for it
+			pcrs, err := verifier.ReplayEventsLog(testEventsLog())
+			must(t, err)
+			manifest := &refvalues.Manifest{Version: refvalues.Version,
+				Sets: []refvalues.Set{refvalues.NewSet("release-1", pcrs)}, EventsPolicy: policy}
+			must(t, manifest.Write(refvalues.DefaultPath, refvalues.DefaultSignerPath))
+			isLegit, message, result := verify()
+			failed := []string{}
+			for _, v := range result.EventsPolicy {
+				if !v.Passed {
+					failed = append(failed, v.Rule)
+				}
+			}
+			if isLegit != (len(tc.failed) == 0) || strings.Join(failed, ",") != strings.Join(tc.failed, ",") {
+				t.Errorf("ExtVerifyTpmQuote() returned %v, %q, with failed rules %v, expected %v",
+					isLegit, message, failed, tc.failed)
+			}
+		})
+	}
+}
+
+func TestExtVerifyTpmQuoteFilesPolicy(t *testing.T) {
+	// GRUB loads its configuration, then a kernel and its initrd, which a
+	// kernel update changes
+	file := func(path string) testEvent {
+		return testEvent{9, uefi.EvIPL, []byte("(hd0,gpt2)" + path + "\x00")}
+	}
+	// buildEventsLog() extends the digest of the logged data, standing here
+	// for the content of the file
+	digest := func(path string) string {
+		d := sha256.Sum256(file(path).data)
+		return hex.EncodeToString(d[:])
+	}
+	boot := func(release string) []byte {
+		events := []testEvent{}
+		for _, e := range testEvents() {
+			if e.pcr != 8 && e.pcr != 9 {
+				events = append(events, e)
+			}
+		}
+		cmdline := "/boot/vmlinuz-" + release + " root=/dev/sda2 ro lockdown=integrity"
+		events = append(events,
+			testEvent{8, uefi.EvIPL, []byte("grub_cmd: linux " + cmdline + "\x00")},
+			testEvent{8, uefi.EvIPL, []byte("kernel_cmdline: " + cmdline + "\x00")},
+			file("/boot/grub/grub.cfg"),
+			file("/boot/vmlinuz-"+release),
+			file("/boot/initrd.img-"+release))
+		return buildEventsLog(events)
+	}
+	policy := &verifier.EventsPolicy{PCRs: []int{8, 9}, Rules: []verifier.EventRule{
+		{Name: "lockdown", Kind: verifier.KindKernelCmdline, Contains: []string{"lockdown=integrity"}},
+		{Name: "grub-cfg", Kind: verifier.KindFile, Path: "*/boot/grub/grub.cfg",
+			Digests: []string{digest("/boot/grub/grub.cfg")}},
+		{Name: "kernel", Kind: verifier.KindFile, Path: "*/boot/vmlinuz-*", Required: true,
+			Digests: []string{digest("/boot/vmlinuz-6.2.0-39-generic"), digest("/boot/vmlinuz-6.5.0-14-generic")}},
+		{Name: "initrd", Kind: verifier.KindFile, Path: "*/boot/initrd.img-*", Required: true,
+			Digests: []string{digest("/boot/initrd.img-6.2.0-39-generic"), digest("/boot/initrd.img-6.5.0-14-generic")}},
+	}}
+	must(t, policy.Validate())
+
+	for _, tc := range []struct {
+		release string
+		failed  []string
+	}{
+		{release: "6.2.0-39-generic"},
+		// The kernel update changes PCR 9, that the policy vouches for
+		{release: "6.5.0-14-generic"},
+		{release: "6.6.0-rc1", failed: []string{"kernel", "initrd"}},
+	} {
+		t.Run(tc.release, func(t *testing.T) {
+			eventsLog := boot(tc.release)
+			rwc := setupMeasured(t, eventsLog)
+			onboard(t, rwc)
+			nonce, err := verifier.NewNonce()
+			must(t, err)
+			attestation, signature, err := ExtGetTpmQuote(rwc, "", quotedSels, nonce)
+			must(t, err)
+			verify := func() (bool, string, *verifier.Result) {
+				return ExtVerifyTpmQuote(refvalues.DefaultPath, refvalues.DefaultSignerPath, quotedSels,
+					nonce, attestation, signature, eventsLog, nil, string(read(t, "Verifier/ak.pub")))
+			}
+
+			// The reference values are those of the first release
+			pcrs, err := verifier.ReplayEventsLog(boot("6.2.0-39-generic"))
+			must(t, err)
+			manifest := &refvalues.Manifest{Version: refvalues.Version,
+				Sets: []refvalues.Set{refvalues.NewSet("release-1", pcrs)}}
+			must(t, manifest.Write(refvalues.DefaultPath, refvalues.DefaultSignerPath))
+			if isLegit, message, _ := verify(); isLegit != (tc.release == "6.2.0-39-generic") {
+				t.Errorf("ExtVerifyTpmQuote() returned %v, %q without events policy", isLegit, message)
+			}
+
+			manifest.EventsPolicy = policy
+			must(t, manifest.Write(refvalues.DefaultPath, refvalues.DefaultSignerPath))
+			isLegit, message, result := verify()
+			failed := []string{}
+			for _, v := range result.EventsPolicy {
+				if !v.Passed {
+					failed = append(failed, v.Rule)
+				}
+			}
+			if isLegit != (len(tc.failed) == 0) || strings.Join(failed, ",") != strings.Join(tc.failed, ",") {
+				t.Errorf("ExtVerifyTpmQuote() returned %v, %q, with failed rules %v, expected %v",
+					isLegit, message, failed, tc.failed)
+			}
+		})
+	}
+}
+
+func TestVerifyQuoteEventsPolicy(t *testing.T) {
+	// GRUB boots a kernel the reference values do not know about
+	events := []testEvent{}
+	for _, e := range testEvents() {
+		if e.pcr != 8 {
+			events = append(events, e)
+		}
+	}
+	events = append(events, testEvent{8, uefi.EvIPL,
+		[]byte("kernel_cmdline: /boot/vmlinuz-6.2.0-39-generic root=/dev/sda2 ro lockdown=integrity\x00")})
+	rwc := setupMeasured(t, buildEventsLog(events))
+	onboard(t, rwc)
+	quote(t, rwc)
+	verify := func(eventsLogPath string) error {
+		return VerifyQuote("Verifier/ak", "Verifier/nonce-quote", refvalues.DefaultPath, refvalues.DefaultSignerPath,
+			quotedSels, "Attestor/quote", eventsLogPath, "")
+	}
+	mustFail(t, "VerifyQuote()", verify("Attestor/events-log.bin"), ErrPCRDigestMismatch)
+
+	// Onboarding honours the events policy that vouches for PCR 8, as the
+	// Verifier server does
+	pcrs, err := verifier.ReplayEventsLog(testEventsLog())
+	must(t, err)
+	manifest := &refvalues.Manifest{Version: refvalues.Version,
+		Sets: []refvalues.Set{refvalues.NewSet("release-1", pcrs)},
+		EventsPolicy: &verifier.EventsPolicy{PCRs: []int{8}, Rules: []verifier.EventRule{
+			{Name: "lockdown", Kind: verifier.KindKernelCmdline, Contains: []string{"lockdown=integrity"}},
+		}}}
+	must(t, manifest.Write(refvalues.DefaultPath, refvalues.DefaultSignerPath))
+	must(t, verify("Attestor/events-log.bin"))
+
+	// Which it cannot without the events log
+	mustFail(t, "VerifyQuote()", verify(""), ErrPCRDigestMismatch)
+	mustFail(t, "VerifyQuote()", verify("Attestor/no-events-log.bin"), ErrPCRDigestMismatch)
+
+	// Nor when the policy rejects the events
+	manifest.EventsPolicy.Rules[0].Contains = []string{"lockdown=confidentiality"}
+	must(t, manifest.Write(refvalues.DefaultPath, refvalues.DefaultSignerPath))
+	mustFail(t, "VerifyQuote()", verify("Attestor/events-log.bin"), verifier.ErrEventsPolicy)
+}
+
+// imaEntry encodes an ima-ng entry of the binary IMA runtime measurement list.
+func imaEntry(path string, content []byte) (entry, templateData []byte) {
+	fileDigest := sha256.Sum256(content)
+	field := func(buf *bytes.Buffer, data []byte) {
+		binary.Write(buf, binary.LittleEndian, uint32(len(data)))
+		buf.Write(data)
+	}
+	var data bytes.Buffer
+	field(&data, append([]byte("sha256:\x00"), fileDigest[:]...))
+	field(&data, append([]byte(path), 0))
+	templateDigest := sha1.Sum(data.Bytes())
+
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, uint32(verifier.IMAPCR))
+	buf.Write(templateDigest[:])
+	field(&buf, []byte("ima-ng"))
+	field(&buf, data.Bytes())
+	return buf.Bytes(), data.Bytes()
+}
+
+func TestExtVerifyTpmQuoteIMA(t *testing.T) {
+	rwc := setup(t)
+	onboard(t, rwc)
+
+	// IMA measures a few files into PCR 10, in every bank
+	files := []struct {
+		path    string
+		content []byte
+	}{
+		{"boot_aggregate", []byte("PCRs 0-9")},
+		{"/usr/bin/bash", []byte("bash")},
+		{"/usr/lib/libc.so.6", []byte("libc")},
+		{"/var/log/messages", []byte("log")},
+		{"/tmp/evil", []byte("evil")},
+	}
+	imaLog, lastEntry := []byte{}, []byte{}
+	for _, f := range files {
+		entry, templateData := imaEntry(f.path, f.content)
+		imaLog, lastEntry = append(imaLog, entry...), entry
+		for _, alg := range []tpm2.Algorithm{tpm2.AlgSHA1, tpm2.AlgSHA256} {
+			hash, err := alg.Hash()
+			must(t, err)
+			h := hash.New()
+			h.Write(templateData)
+			must(t, tpm2.PCRExtend(rwc, tpmutil.Handle(verifier.IMAPCR), alg, h.Sum(nil), ""))
+		}
+	}
+	sels := []tpm2.PCRSelection{{Hash: tpm2.AlgSHA256, PCRs: []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 14}}}
+	nonce, err := verifier.NewNonce()
+	must(t, err)
+	attestation, signature, err := ExtGetTpmQuote(rwc, "", sels, nonce)
+	must(t, err)
+	verify := func(imaLog []byte) (bool, string, *verifier.Result) {
+		return ExtVerifyTpmQuote(refvalues.DefaultPath, refvalues.DefaultSignerPath, sels,
+			nonce, attestation, signature, nil, imaLog, string(read(t, "Verifier/ak.pub")))
+	}
+	pcrs, err := verifier.ReplayEventsLog(testEventsLog())
+	must(t, err)
+	digest := func(content string) refvalues.Hex {
+		d := sha256.Sum256([]byte(content))
+		return d[:]
+	}
+	publishPolicy := func(policy *refvalues.IMAPolicy) {
+		manifest := &refvalues.Manifest{Version: refvalues.Version,
+			Sets: []refvalues.Set{refvalues.NewSet("release-1", pcrs)}, IMA: policy}
+		must(t, manifest.Write(refvalues.DefaultPath, refvalues.DefaultSignerPath))
+	}
+
+	// The policy accepts every file, and the log grew since the quote
+	publishPolicy(&refvalues.IMAPolicy{
+		Allowlist: map[string][]refvalues.Hex{
+			"/usr/bin/bash":      {digest("bash")},
+			"/usr/lib/libc.so.6": {digest("libc.old"), digest("libc")},
+			"/tmp/evil":          {digest("evil")},
+		},
+		Exclude: []string{"/var/log/"},
+	})
+	grown, _ := imaEntry("/usr/bin/unknown", []byte("unknown"))
+	isLegit, message, result := verify(append(imaLog, grown...))
+	if !isLegit || result.IMA == nil || result.IMA.Measurements != len(files) {
+		t.Errorf("ExtVerifyTpmQuote() returned %v, %q, %+v", isLegit, message, result)
+	}
+
+	// It denies a file, and does not know of another
+	publishPolicy(&refvalues.IMAPolicy{
+		Allowlist: map[string][]refvalues.Hex{"/usr/bin/bash": {digest("bash")}},
+		Denylist:  []refvalues.Hex{digest("evil")},
+		Exclude:   []string{"/var/log/"},
+	})
+	isLegit, message, result = verify(imaLog)
+	if isLegit || !strings.Contains(message, verifier.ErrIMAPolicy.Error()) || result.IMA == nil ||
+		len(result.IMA.Denied) != 1 || result.IMA.Denied[0].Path != "/tmp/evil" ||
+		len(result.IMA.Unknown) != 1 || result.IMA.Unknown[0].Path != "/usr/lib/libc.so.6" {
+		t.Errorf("ExtVerifyTpmQuote() returned %v, %q, %+v", isLegit, message, result.IMA)
+	}
+
+	// A file renamed in the log no longer matches its template digest, and a
+	// log missing entries cannot lead to the quoted PCR 10
+	for _, badLog := range [][]byte{
+		bytes.Replace(imaLog, []byte("/tmp/evil"), []byte("/tmp/good"), 1),
+		imaLog[:len(imaLog)-len(lastEntry)],
+	} {
+		isLegit, message, result = verify(badLog)
+		imaMismatch := false
+		for _, c := range result.Checks {
+			imaMismatch = imaMismatch || (c.Name == verifier.CheckIMA && errors.Is(c.Err, verifier.ErrIMALogMismatch))
+		}
+		if isLegit || !imaMismatch {
+			t.Errorf("ExtVerifyTpmQuote() returned %v, %q, %+v", isLegit, message, result.Checks)
+		}
+	}
+
+	// Without a policy, the IMA log does not vouch for PCR 10, which must
+	// match the reference values
+	publishPolicy(nil)
+	isLegit, message, result = verify(imaLog)
+	digestMismatch := false
+	for _, c := range result.Checks {
+		digestMismatch = digestMismatch || (c.Name == verifier.CheckPCRDigest && errors.Is(c.Err, verifier.ErrPCRDigestMismatch))
+	}
+	if isLegit || !digestMismatch {
+		t.Errorf("ExtVerifyTpmQuote() returned %v, %q without IMA policy, expected a PCRs digest mismatch", isLegit, message)
+	}
+}
+
+// === Negative flows ==========================================================
+
+func TestVerifyQuoteWrongNonce(t *testing.T) {
+	rwc := setup(t)
+	onboard(t, rwc)
+	_, attestation, signature := quote(t, rwc)
+
+	// The verifier expects a fresh nonce, not the one that was quoted
+	nonce, err := RequestQuote("Verifier/nonce-quote")
+	must(t, err)
+	mustFail(t, "VerifyQuote()",
+		VerifyQuote("Verifier/ak", "Verifier/nonce-quote", refvalues.DefaultPath, refvalues.DefaultSignerPath,
+			quotedSels, "Attestor/quote", "Attestor/events-log.bin", ""),
+		ErrNonceMismatch)
+	mustFail(t, "VerifyQuote2()",
+		verifyQuote(t, quotedSels, nonce, attestation, signature),
+		ErrNonceMismatch)
+}
+
+func TestVerifyQuoteTamperedSignature(t *testing.T) {
+	rwc := setup(t)
+	onboard(t, rwc)
+
+	nonce, attestation, signature := quote(t, rwc)
+	signature[len(signature)/2] ^= 0x01
+	must(t, lib.Write("Attestor/quote-signature.bin", signature, 0644))
+
+	mustFail(t, "VerifyQuote()",
+		VerifyQuote("Verifier/ak", "Verifier/nonce-quote", refvalues.DefaultPath, refvalues.DefaultSignerPath,
+			quotedSels, "Attestor/quote", "Attestor/events-log.bin", ""),
+		ErrBadSignature)
+	mustFail(t, "VerifyQuote2()",
+		verifyQuote(t, quotedSels, nonce, attestation, signature),
+		ErrBadSignature)
+}
+
+func TestVerifyQuotePCRMismatch(t *testing.T) {
+	rwc := setup(t)
+	onboard(t, rwc)
+
+	// Measure something the CICD did not predict
+	digest := sha256.Sum256([]byte("unexpected measurement"))
+	if err := tpm2.PCRExtend(rwc, tpmutil.Handle(14), tpm2.AlgSHA256, digest[:], ""); err != nil {
+		t.Fatalf("tpm2.PCRExtend() failed: %v", err)
+	}
+	nonce, attestation, signature := quote(t, rwc)
+
+	mustFail(t, "VerifyQuote()",
+		VerifyQuote("Verifier/ak", "Verifier/nonce-quote", refvalues.DefaultPath, refvalues.DefaultSignerPath,
+			quotedSels, "Attestor/quote", "Attestor/events-log.bin", ""),
+		ErrPCRDigestMismatch)
+	mustFail(t, "VerifyQuote2()",
+		verifyQuote(t, quotedSels, nonce, attestation, signature),
+		ErrPCRDigestMismatch)
+}
+
+func TestVerifyEvidenceDiagnostics(t *testing.T) {
+	// The device boots something else than predicted: a changed measurement
+	// in PCR 3 and an extra one in PCR 14
+	events := testEvents()
+	for k := range events {
+		if events[k].pcr == 3 && bytes.HasPrefix(events[k].data, []byte("measurement #2")) {
+			events[k].data = []byte("updated firmware")
+		}
+	}
+	events = append(events, testEvent{14, 0x0d, []byte("unexpected measurement")})
+	measuredEventsLog := buildEventsLog(events)
+
+	rwc := setupMeasured(t, measuredEventsLog)
+	onboard(t, rwc)
+	nonce, err := verifier.NewNonce()
+	must(t, err)
+	attestation, signature, err := ExtGetTpmQuote(rwc, "", quotedSels, nonce)
+	must(t, err)
+	pcrValues, err := ExtGetPcrValues(rwc, quotedSels)
+	must(t, err)
+
+	// Values the quote does not vouch for: PCR 0 told as differing
+	tamperedValues := verifier.Banks{}
+	for alg, bank := range pcrValues {
+		tamperedValues[alg] = map[int][]byte{}
+		for pcr, value := range bank {
+			tamperedValues[alg][pcr] = value
+		}
+		tamperedValues[alg][0] = bytes.Repeat([]byte{0xff}, len(bank[0]))
+	}
+	// An events log the quote does not vouch for: only PCR 14 told as differing
+	forgedEventsLog := buildEventsLog(append(testEvents(), testEvent{14, 0x0d, []byte("forged measurement")}))
+
+	v, err := verifier.New(read(t, "Verifier/ak.pub"))
+	must(t, err)
+	must(t, v.WithEventsLog(testEventsLog()))
+	for _, tc := range []struct {
+		name      string
+		pcrValues verifier.Banks
+		eventsLog []byte
+		verified  bool
+		pcrs      []int
+	}{
+		{"PCR values", pcrValues, measuredEventsLog, true, []int{3, 14}},
+		{"events log", nil, measuredEventsLog, true, []int{3, 14}},
+		{"tampered PCR values", tamperedValues, measuredEventsLog, true, []int{3, 14}},
+		{"tampered PCR values only", tamperedValues, nil, false, nil},
+		{"forged events log", nil, forgedEventsLog, false, []int{14}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			result := v.VerifyEvidence(verifier.Evidence{
+				Nonce:       nonce,
+				Attestation: attestation,
+				Signature:   signature,
+				PCRValues:   tc.pcrValues,
+				EventsLog:   tc.eventsLog,
+			})
+			mustFail(t, "VerifyEvidence()", result.Err(), verifier.ErrPCRDigestMismatch)
+
+			d := result.Diagnostics
+			if tc.pcrs == nil {
+				if d != nil {
+					t.Fatalf("VerifyEvidence() returned diagnostics %v, expected none", d.Differing())
+				}
+				return
+			}
+			if d == nil {
+				t.Fatalf("VerifyEvidence() returned no diagnostics")
+			}
+			if d.Verified != tc.verified {
+				t.Errorf("Diagnose() returned verified %v, expected %v", d.Verified, tc.verified)
+			}
+			if len(d.PCRs) != len(tc.pcrs) {
+				t.Fatalf("Diagnose() reported PCRs %v, expected %v", d.Differing(), tc.pcrs)
+			}
+			for k, pcr := range tc.pcrs {
+				if d.PCRs[k].Index != pcr {
+					t.Fatalf("Diagnose() reported PCRs %v, expected %v", d.Differing(), tc.pcrs)
+				}
+			}
+			if !tc.verified {
+				return
+			}
+			for k, expected := range []verifier.EventDiff{
+				{Change: verifier.EventChanged, Position: 1, Type: "EV_IPL"},
+				{Change: verifier.EventAdded, Position: 3, Type: "EV_IPL"},
+			} {
+				got := d.PCRs[k].Events
+				if len(got) != 1 || got[0].Change != expected.Change ||
+					got[0].Position != expected.Position || got[0].Type != expected.Type {
+					t.Errorf("Diagnose() reported %+v for PCR[%d], expected one %s event at #%d",
+						got, d.PCRs[k].Index, expected.Change, expected.Position)
+				}
+			}
+		})
+	}
+}
+
+func TestVerifyEKPubWrongCert(t *testing.T) {
+	rwc := setup(t)
+	onboard(t, rwc)
+
+	// Certify some other key as if it were the TPM EK
+	must(t, certs.CreateEKCert("Attestor/ak", "id:474F4F47", "Shielded VM vTPM", "id:00010001",
+		"Manufacturer/manufacturer-ca", "Manufacturer/ek"))
+
+	mustFail(t, "VerifyEKPub()",
+		VerifyEKPub("Attestor/ek", "Manufacturer/ek", truststore.DefaultPath, "Verifier/ek"),
+		ErrEKCertInvalid)
+}
+
+func TestGetEKCert(t *testing.T) {
+	padding := func(b byte) []byte { return bytes.Repeat([]byte{b}, 100) }
+	for _, tc := range []struct {
+		name string
+		wrap func(der []byte) []byte
+	}{
+		{"DER", func(der []byte) []byte { return der }},
+		{"zero padding", func(der []byte) []byte { return append(der, padding(0x00)...) }},
+		{"0xff padding", func(der []byte) []byte { return append(der, padding(0xff)...) }},
+		{"NV header", func(der []byte) []byte {
+			header := append([]byte{0x10, 0x01, 0x00}, byte(len(der)>>8), byte(len(der)))
+			return append(append(header, der...), padding(0xff)...)
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rwc := setup(t)
+
+			trustManufacturer(t)
+			_, _, err := GetEKPub(rwc, teepeem.KeyRSA, "Manufacturer/ek")
+			must(t, err)
+			must(t, certs.CreateEKCert("Manufacturer/ek", "id:474F4F47", "Shielded VM vTPM", "id:00010001",
+				"Manufacturer/manufacturer-ca", "Manufacturer/ek"))
+			ekCert, err := certs.ReadCert("Manufacturer/ek")
+			must(t, err)
+			provisionEKCert(t, rwc, 0x01C00002, tc.wrap(ekCert.Raw))
+
+			ekPublicKey, _, err := GetEKPub(rwc, teepeem.KeyRSA, "Attestor/ek")
+			must(t, err)
+			must(t, GetEKCert(rwc, ekPublicKey, "Attestor/ek"))
+			nvCert, err := certs.ReadCert("Attestor/ek")
+			must(t, err)
+			if !bytes.Equal(nvCert.Raw, ekCert.Raw) {
+				t.Errorf("GetEKCert() wrote another certificate than the one in NV")
+			}
+			must(t, VerifyEKPub("Attestor/ek", "Attestor/ek", truststore.DefaultPath, "Verifier/ek"))
+		})
+	}
+}
+
+func TestGetEKCertIndexes(t *testing.T) {
+	rwc := setup(t)
+
+	trustManufacturer(t)
+	ekPublicKey, _, err := GetEKPub(rwc, teepeem.KeyRSA, "Attestor/ek")
+	must(t, err)
+	mustFail(t, "GetEKCert()", GetEKCert(rwc, ekPublicKey, "Attestor/ek"), teepeem.ErrNoEKCert)
+
+	// Certify the EK, and some other key as if it were the TPM EK
+	must(t, CreateAK(rwc, teepeem.KeyRSA, "Attestor/ek", "Attestor/ak"))
+	for _, key := range []string{"Attestor/ek", "Attestor/ak"} {
+		must(t, certs.CreateEKCert(key, "id:474F4F47", "Shielded VM vTPM", "id:00010001",
+			"Manufacturer/manufacturer-ca", "Manufacturer/"+filepath.Base(key)))
+	}
+	akCert, err := certs.ReadCert("Manufacturer/ak")
+	must(t, err)
+	ekCert, err := certs.ReadCert("Manufacturer/ek")
+	must(t, err)
+
+	// The certificate of the EK is kept, whichever index it is stored at
+	provisionEKCert(t, rwc, 0x01C00002, akCert.Raw)
+	provisionEKCert(t, rwc, 0x01C00012, ekCert.Raw)
+	must(t, GetEKCert(rwc, ekPublicKey, "Attestor/ek"))
+	must(t, VerifyEKPub("Attestor/ek", "Attestor/ek", truststore.DefaultPath, "Verifier/ek"))
+
+	// None is kept if no certificate certifies the EK
+	must(t, tpm2.NVUndefineSpace(rwc, "", tpm2.HandlePlatform, 0x01C00012))
+	mustFail(t, "GetEKCert()", GetEKCert(rwc, ekPublicKey, "Attestor/ek"), ErrEKCertInvalid)
+}
+
+func TestGetEKCertChain(t *testing.T) {
+	rwc := setup(t)
+	trustManufacturer(t)
+
+	// The Manufacturer root CA certifies an intermediate CA, that certifies
+	// the EK
+	rootCert, err := certs.ReadCert("Manufacturer/manufacturer-ca")
+	must(t, err)
+	rootKey, err := certs.ReadSigner("Manufacturer/manufacturer-ca")
+	must(t, err)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	must(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(2),
+		Subject:               pkix.Name{Organization: []string{"Manufacturer"}, CommonName: "EK CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(1, 0, 0),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, &rootCert, &key.PublicKey, rootKey)
+	must(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	must(t, err)
+	must(t, lib.Write("Manufacturer/ek-ca.crt", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644))
+	must(t, lib.Write("Manufacturer/ek-ca.key", pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+
+	_, _, err = GetEKPub(rwc, teepeem.KeyRSA, "Manufacturer/ek")
+	must(t, err)
+	must(t, certs.CreateEKCert("Manufacturer/ek", "id:474F4F47", "Shielded VM vTPM", "id:00010001",
+		"Manufacturer/ek-ca", "Manufacturer/ek"))
+	ekCert, err := certs.ReadCert("Manufacturer/ek")
+	must(t, err)
+	provisionEKCert(t, rwc, 0x01C00002, ekCert.Raw)
+
+	// The intermediate CA is shipped in an EK certificate chain index
+	provisionEKCert(t, rwc, 0x01C00100, append(der, make([]byte, 64)...))
+	ekPublicKey, _, err := GetEKPub(rwc, teepeem.KeyRSA, "Attestor/ek")
+	must(t, err)
+	must(t, GetEKCert(rwc, ekPublicKey, "Attestor/ek"))
+	ekCerts, err := certs.ReadCerts("Attestor/ek")
+	must(t, err)
+	if len(ekCerts) != 2 || !bytes.Equal(ekCerts[1].Raw, der) {
+		t.Errorf("GetEKCert() wrote %d certificates, expected the EK certificate and its CA", len(ekCerts))
+	}
+	must(t, VerifyEKPub("Attestor/ek", "Attestor/ek", truststore.DefaultPath, "Verifier/ek"))
+
+	// Without it, the EK certificate does not chain up
+	must(t, tpm2.NVUndefineSpace(rwc, "", tpm2.HandlePlatform, 0x01C00100))
+	must(t, GetEKCert(rwc, ekPublicKey, "Attestor/ek"))
+	err = VerifyEKPub("Attestor/ek", "Attestor/ek", truststore.DefaultPath, "Verifier/ek")
+	mustFail(t, "VerifyEKPub()", err, ErrEKCertInvalid)
+	if !strings.Contains(err.Error(), truststore.ErrNoIssuer.Error()) {
+		t.Errorf("VerifyEKPub() returned %v, expected %v", err, truststore.ErrNoIssuer)
+	}
+}
+
+func TestVerifyEKPubPolicy(t *testing.T) {
+	rwc := setup(t)
+	onboard(t, rwc)
+
+	for _, tc := range []struct {
+		policy string
+		err    error
+	}{
+		{`{"manufacturers": {"Microsoft": {"min-firmware-version": "id:20170619"}}}`, nil},
+		{`{"manufacturers": {"INTC": {}, "IFX": {}}}`, ErrManufacturerNotAllowed},
+		{`{"manufacturers": {"MSFT": {"min-firmware-version": "id:20180000"}}}`, ErrFirmwareTooOld},
+	} {
+		must(t, lib.Write(filepath.Join(truststore.DefaultPath, "policy.json"), []byte(tc.policy), 0644))
+		err := VerifyEKPub("Attestor/ek", "Attestor/ek", truststore.DefaultPath, "Verifier/ek")
+		if tc.err == nil {
+			must(t, err)
+			continue
+		}
+		mustFail(t, "VerifyEKPub()", err, tc.err)
+	}
+}
+
+func TestReadInfo(t *testing.T) {
+	rwc := setup(t)
+	info, err := teepeem.ReadInfo(rwc)
+	must(t, err)
+	if info.VendorName() != "Microsoft" || info.ManufacturerID() != "id:4D534654" || info.SpecFamily != "2.0" {
+		t.Errorf("ReadInfo() returned %s (%s) family %q, expected the simulator: Microsoft (id:4D534654) family \"2.0\"",
+			info.VendorName(), info.ManufacturerID(), info.SpecFamily)
+	}
+
+	// Certificates issued during onboarding tell the TPM as it is
+	onboard(t, rwc)
+	must(t, certs.CreateAKCert("Verifier/ak", "TPM AK", info.ManufacturerID(), info.Model(), info.Version(),
+		"Manufacturer/manufacturer-ca", "Verifier/ak"))
+	for _, path := range []string{"Manufacturer/ek", "Verifier/ak"} {
+		cert, err := certs.ReadCert(path)
+		must(t, err)
+		id, err := certs.ParseTPMIdentity(cert)
+		must(t, err)
+		if id.Manufacturer() != info.ManufacturerID() || id.Model != info.Model() || id.Version() != info.Version() {
+			t.Errorf("%s certificate tells %s, expected %s (%s) model %q firmware %s", path, id,
+				info.VendorName(), info.ManufacturerID(), info.Model(), info.Version())
+		}
+	}
+}
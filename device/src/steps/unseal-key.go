@@ -19,7 +19,7 @@ func UnsealKey(
 	rw io.ReadWriter,
 	sealedKeyPath string, // IN
 	attestorUnsealedKeyPath string, // OUT
-) []byte {
+) ([]byte, error) {
 
 	lib.PRINT("=== ATTESTOR: UNSEAL SECRET KEY ================================================")
 
@@ -29,20 +29,24 @@ func UnsealKey(
 	//)
 	srkClient, err := client.StorageRootKeyRSA(rw)
 	if err != nil {
-		lib.Fatal("client.StorageRootKeyRSA() failed: %v", err)
+		return nil, fmt.Errorf("client.StorageRootKeyRSA() failed: %w", err)
 	}
+	defer srkClient.Close()
 
 	blob := &tpm.ImportBlob{}
-	sealedKey := lib.Read(fmt.Sprintf("%s.bin", sealedKeyPath))
+	sealedKey, err := lib.Read(fmt.Sprintf("%s.bin", sealedKeyPath))
+	if err != nil {
+		return nil, err
+	}
 	err = proto.Unmarshal(sealedKey, blob)
 	if err != nil {
-		lib.Fatal("proto.Unmarshal() failed: %v", err)
+		return nil, fmt.Errorf("proto.Unmarshal() failed: %w", err)
 	}
 	unsealedKey, err := srkClient.Import(blob)
 	if err != nil {
-		lib.Fatal("srkClient.Import() failed: %v", err)
+		return nil, fmt.Errorf("srkClient.Import() failed: %w", err)
 	}
 	lib.Print("Unsealed secret: %v", unsealedKey)
 
-	return unsealedKey
+	return unsealedKey, nil
 }
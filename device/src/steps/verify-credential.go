@@ -16,22 +16,31 @@ func VerifyCredential(
 	verifierNoncePath string, // IN
 	attestorAkPath string, // IN
 	verifierAkPath string, // OUT
-) {
+) error {
 
 	lib.PRINT("=== VERIFIER: VERIFY CREDENTIAL ================================================")
 
 	// Retrieve Attestor attempt
-	attempt := lib.Read(fmt.Sprintf("%s.bin", attestorAttemptPath))
+	attempt, err := lib.Read(fmt.Sprintf("%s.bin", attestorAttemptPath))
+	if err != nil {
+		return err
+	}
 
 	// Retrieve Verifier nonce
-	nonce := lib.Read(fmt.Sprintf("%s.bin", verifierNoncePath))
+	nonce, err := lib.Read(fmt.Sprintf("%s.bin", verifierNoncePath))
+	if err != nil {
+		return err
+	}
 
 	if !bytes.Equal(nonce, attempt) {
-		lib.Fatal("Attestor attempt does not match, aborting onboarding")
+		return fmt.Errorf("%w: Attestor attempt does not match, aborting onboarding", ErrCredentialMismatch)
 	}
 	lib.Print("Attestor attempt matches Verifier nonce")
 
 	// Copy AK Pub to Verifier directory
-	akPub := lib.Read(fmt.Sprintf("%s.pub", attestorAkPath))
-	lib.Write(fmt.Sprintf("%s.pub", verifierAkPath), akPub, 0644)
+	akPub, err := lib.Read(fmt.Sprintf("%s.pub", attestorAkPath))
+	if err != nil {
+		return err
+	}
+	return lib.Write(fmt.Sprintf("%s.pub", verifierAkPath), akPub, 0644)
 }
@@ -5,13 +5,13 @@ package steps
 import (
 	"bytes"
 	"crypto/x509"
-	"crypto/x509/pkix"
-	"encoding/asn1"
 	"encoding/pem"
 	"fmt"
+	"time"
 
 	"main/src/certs"
 	"main/src/lib"
+	"main/src/truststore"
 )
 
 // ### Verifier: verify EK Pub #################################################
@@ -19,62 +19,77 @@ import (
 func VerifyEKPub(
 	ekPublicKeyPath string,
 	ekCertPath string,
-	manufacturerCertPath string,
+	trustStorePath string,
 	ekVerifierPath string,
-) {
+) error {
 
 	lib.PRINT("=== VERIFIER: VERIFY EK PUB ====================================================")
 
 	// Retrieve EK public key
-	ekPublicKey := certs.ReadPublicKey(ekPublicKeyPath)
+	ekPublicKey, err := certs.ReadPublicKey(ekPublicKeyPath)
+	if err != nil {
+		return err
+	}
 
-	// Retrieve EK certificate
-	ekCert := certs.ReadCert(ekCertPath)
+	// Retrieve EK certificate, and the intermediate CAs it is shipped with
+	ekCerts, err := certs.ReadCerts(ekCertPath)
+	if err != nil {
+		return err
+	}
+	ekCert := ekCerts[0]
 
-	// Retrieve Manufacturer CA certificate
-	manufacturerCert := certs.ReadCert(manufacturerCertPath)
+	// Retrieve TPM vendor CAs
+	store, err := truststore.Open(trustStorePath)
+	if err != nil {
+		return err
+	}
 
-	// Verify EK certificate against Manufacturer CA certificate
-	certs.VerifyCert(manufacturerCert, manufacturerCert)
-	certs.VerifyCert(ekCert, manufacturerCert)
+	// Verify EK certificate chains up to a TPM vendor root
+	chain, err := store.VerifyEKCert(ekCert, ekCerts[1:], time.Now())
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrEKCertInvalid, err)
+	}
+	for k, cert := range chain[1:] {
+		lib.Verbose("EK certificate chain #%d: %q", k+1, cert.Subject.String())
+	}
+	lib.Print("EK certificate chains up to %q", chain[len(chain)-1].Subject.String())
 
-	// Verify SAN in EK cert
-	expectedSAN := pkix.Extension(
-		*certs.CreateSubjectAltName(
-			[]byte("id: Google"),
-			[]byte("Shielded VM vTPM"),
-			[]byte("id: 00010001"),
-		),
-	)
-	badSAN := true
+	// Verify SAN in EK cert: it holds the TPM manufacturer, model and
+	// firmware version, and must be critical when the subject is empty
 	for _, ext := range ekCert.Extensions {
 		lib.Verbose("extension %s", ext.Id.String())
-		if ext.Id.Equal(asn1.ObjectIdentifier{2, 5, 29, 17}) {
-			if !ext.Critical {
-				lib.Fatal("SAN should be critical")
-			}
-			if !ext.Id.Equal(expectedSAN.Id) || !bytes.Equal(ext.Value, expectedSAN.Value) {
-				lib.Fatal("SAN has unexpected value: %v", ext)
-			}
-			badSAN = false
+		if ext.Id.Equal(certs.OIDSubjectAltName) && !ext.Critical && len(ekCert.Subject.Names) == 0 {
+			return fmt.Errorf("%w: SAN should be critical", ErrEKCertInvalid)
 		}
 	}
-	if badSAN {
-		lib.Fatal("SAN is not properly set")
+	tpmIdentity, err := certs.ParseTPMIdentity(*ekCert)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrEKCertInvalid, err)
+	}
+	lib.Print("EK certificate issued for TPM %s", tpmIdentity)
+
+	// Verify the TPM manufacturer and firmware version are allowed
+	policy, err := store.ReadPolicy()
+	if err != nil {
+		return err
+	}
+	err = policy.Check(tpmIdentity)
+	if err != nil {
+		return err
 	}
 
 	// Verify EK Pub matches EK cert
-	ekPublicBytes, err := x509.MarshalPKIXPublicKey(&ekPublicKey)
+	ekPublicBytes, err := x509.MarshalPKIXPublicKey(ekPublicKey)
 	if err != nil {
-		lib.Fatal("x509.MarshalPKIXPublicKey() failed: %v", err)
+		return fmt.Errorf("x509.MarshalPKIXPublicKey() failed: %w", err)
 	}
 	ekCertPublicBytes, err := x509.MarshalPKIXPublicKey(ekCert.PublicKey)
 	if err != nil {
-		lib.Fatal("x509.MarshalPKIXPublicKey() failed: %v", err)
+		return fmt.Errorf("x509.MarshalPKIXPublicKey() failed: %w", err)
 	}
 
 	if !bytes.Equal(ekPublicBytes, ekCertPublicBytes) {
-		lib.Fatal("EK Pub does not match EK Cert")
+		return fmt.Errorf("%w: EK Pub does not match EK Cert", ErrEKCertInvalid)
 	}
 	lib.Print("EK Pub matches TPM certificate")
 
@@ -85,5 +100,5 @@ func VerifyEKPub(
 		},
 	)
 
-	lib.Write(fmt.Sprintf("%s.pub", ekVerifierPath), ekPubPem, 0644)
+	return lib.Write(fmt.Sprintf("%s.pub", ekVerifierPath), ekPubPem, 0644)
 }
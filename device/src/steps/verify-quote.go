@@ -3,18 +3,17 @@
 package steps
 
 import (
-	"bytes"
-	"crypto"
-	"crypto/rsa"
-	"crypto/sha256"
-	"encoding/hex"
+	"errors"
 	"fmt"
-	"main/src/certs"
-	"main/src/lib"
+	"io/fs"
+	"time"
 
-	"github.com/google/go-attestation/attest"
 	"github.com/google/go-tpm/tpm2"
 	"github.com/google/go-tpm/tpmutil"
+
+	"main/src/lib"
+	"main/src/refvalues"
+	"main/src/verifier"
 )
 
 // === Verifier: verify quote ==================================================
@@ -22,165 +21,180 @@ import (
 func VerifyQuote(
 	verifierAkPath string, // IN
 	verifierNoncePath string, // IN
-	cicdDigestPath string, // IN
+	cicdReferenceValuesPath string, // IN
+	cicdSignerPath string, // IN
+	sels []tpm2.PCRSelection, // IN
 	attestorQuotePath string, // IN
-) {
-
-	lib.PRINT("=== VERIFIER: VERIFY QUOTE =====================================================")
-
-	// Read nonce, attestation and signature from disk
-	nonce := lib.Read(fmt.Sprintf("%s.bin", verifierNoncePath))
-	attestation := lib.Read(fmt.Sprintf("%s-attest.bin", attestorQuotePath))
-	signature := lib.Read(fmt.Sprintf("%s-signature.bin", attestorQuotePath))
+	attestorEventsLogPath string, // IN
+	attestorImaLogPath string, // IN
+) error {
 
-	att, err := tpm2.DecodeAttestationData(attestation)
+	// Read AK, nonce, reference values, attestation and signature from disk
+	akPubPEM, err := lib.Read(fmt.Sprintf("%s.pub", verifierAkPath))
 	if err != nil {
-		lib.Fatal("DecodeAttestationData() failed: %v", err)
+		return err
 	}
-
-	lib.Verbose("Attestation ExtraData (nonce): 0x%s ", hex.EncodeToString(att.ExtraData))
-	lib.Verbose("Attestation PCR#: %v ", att.AttestedQuoteInfo.PCRSelection.PCRs)
-	lib.Verbose("Attestation Hash: 0x%s ", hex.EncodeToString(att.AttestedQuoteInfo.PCRDigest))
-
-	// Compare the nonce that is embedded within the attestation. This should
-	// match the one we sent in earlier.
-	if !bytes.Equal(nonce, att.ExtraData) {
-		lib.Fatal("Nonce Value mismatch Got: (0x%s) Expected: (0x%s)",
-			hex.EncodeToString(att.ExtraData), hex.EncodeToString(nonce))
+	nonce, err := lib.Read(fmt.Sprintf("%s.bin", verifierNoncePath))
+	if err != nil {
+		return err
 	}
-	lib.Print("Nonce from Quote matches expected nonce")
-
-	sigL := tpm2.SignatureRSA{
-		HashAlg:   tpm2.AlgSHA256,
-		Signature: signature,
+	referenceValues, referenceValuesSignature, cicdPubPEM, err := refvalues.ReadFiles(
+		cicdReferenceValuesPath, cicdSignerPath)
+	if err != nil {
+		return err
+	}
+	attestation, err := lib.Read(fmt.Sprintf("%s-attest.bin", attestorQuotePath))
+	if err != nil {
+		return err
+	}
+	signature, err := lib.Read(fmt.Sprintf("%s-signature.bin", attestorQuotePath))
+	if err != nil {
+		return err
+	}
+	eventsLog, err := readLog(attestorEventsLogPath)
+	if err != nil {
+		return err
+	}
+	imaLog, err := readLog(attestorImaLogPath)
+	if err != nil {
+		return err
 	}
-	lib.Verbose("sigL: %v", sigL)
 
-	// Read expected PCRs digest from disk
-	pcrDigest := lib.Read(fmt.Sprintf("%s.bin", cicdDigestPath))
+	return VerifyQuote2(
+		referenceValues,          // IN
+		referenceValuesSignature, // IN
+		cicdPubPEM,               // IN
+		sels,                     // IN
+		nonce,                    // IN
+		attestation,              // IN
+		signature,                // IN
+		eventsLog,                // IN
+		imaLog,                   // IN
+		akPubPEM,                 // IN
+	)
+}
 
-	//_, pcrHash, err := getPCRMap(tpm.HashAlgo_SHA256)
-	//if err != nil {
-	//	glog.Fatalf("Error getting PCRMap: %v", err)
-	//}
-	//glog.V(5).Infof("     sha256 of Expected PCR Value: --> %x", pcrHash)
+// readLog reads the events or IMA log sent along with a quote, if any: a path
+// that is empty, or that does not exist on a machine without such a log,
+// sends none.
+func readLog(
+	path string, // IN
+) ([]byte, error) {
 
-	if !bytes.Equal(pcrDigest[:], att.AttestedQuoteInfo.PCRDigest) {
-		lib.Fatal("Unexpected PCR hash Value Got 0x%s Expected: 0x%s",
-			hex.EncodeToString(att.AttestedQuoteInfo.PCRDigest), hex.EncodeToString(pcrDigest[:]))
+	if path == "" {
+		return nil, nil
 	}
-	lib.Print("PCRs digest from Quote matches expected digest")
-
-	// Verify AK signature
-	// use the AK from the original attestation to verify the signature of the Attestation
-	// rsaPub := rsa.PublicKey{E: int(tPub.RSAParameters.Exponent()), N: tPub.RSAParameters.Modulus()}
-	akPublicKey := certs.ReadPublicKey(verifierAkPath)
-	hsh := crypto.SHA256.New()
-	hsh.Write(attestation)
-	err = rsa.VerifyPKCS1v15(
-		&akPublicKey,
-		crypto.SHA256,
-		hsh.Sum(nil),
-		sigL.Signature,
-	)
-	if err != nil {
-		lib.Fatal("rsa.VerifyPKCS1v15() failed: %v", err)
+	data, err := lib.Read(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		lib.Comment("No %s, sending no log", path)
+		return nil, nil
 	}
-	lib.Print("Quote signature is valid")
+	return data, err
 }
 
 // === Verifier: verify quote2 =================================================
 
 func VerifyQuote2(
-	eventsLog []byte, // IN
-	pcrs []int, // IN
+	referenceValues []byte, // IN
+	referenceValuesSignature []byte, // IN
+	cicdPubPEM []byte, // IN
+	sels []tpm2.PCRSelection, // IN
 	nonce []byte, // IN
 	attestation []byte, // IN
 	signature tpmutil.U16Bytes, // IN
+	eventsLog []byte, // IN
+	imaLog []byte, // IN
 	akPubPEM []byte, // In
-) (
-	isLegit bool,
-	message string,
-) {
-	lib.Trace.Print("Even deeper.")
-	parsedEventsLog, err := attest.ParseEventLog(eventsLog)
+) error {
+
+	lib.PRINT("=== VERIFIER: VERIFY QUOTE =====================================================")
+
+	v, err := newVerifier(referenceValues, referenceValuesSignature, cicdPubPEM, sels, akPubPEM)
 	if err != nil {
-		lib.Fatal("attest.ParseEventLog() failed: %v", err)
+		return err
 	}
 
-	// Compute expected PCR values
-	allpcrs := [][32]byte{}
-	for i := 0; i < 24; i++ {
-		allpcrs = append(allpcrs, [32]byte{})
-		lib.Verbose("PCR[%2d]: 0x%s", i, hex.EncodeToString(allpcrs[i][:]))
-	}
-	for _, e := range parsedEventsLog.Events(attest.HashAlg(tpm2.AlgSHA256)) {
-		// sudo cat pcr.bin digest.bin | openssl dgst -sha256 -binary > futurepcr.bin
-		i := e.Index
-		allpcrs[i] = sha256.Sum256(append(allpcrs[i][:], e.Digest...))
-		lib.Verbose("PCR[%2d]+0x%s => 0x%s", i,
-			hex.EncodeToString(e.Digest), hex.EncodeToString(allpcrs[i][:]))
+	return printResult(v.VerifyEvidence(verifier.Evidence{
+		Nonce:       nonce,
+		Attestation: attestation,
+		Signature:   signature,
+		EventsLog:   eventsLog,
+		IMALog:      imaLog,
+	}))
+}
+
+// newVerifier returns a verifier of quotes over sels signed by the AK,
+// against the reference values and the policies of the manifest valid now.
+func newVerifier(
+	referenceValues []byte, // IN
+	referenceValuesSignature []byte, // IN
+	cicdPubPEM []byte, // IN
+	sels []tpm2.PCRSelection, // IN
+	akPubPEM []byte, // IN
+) (*verifier.Verifier, error) {
+
+	v, err := verifier.New(akPubPEM)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot decode AK: %w", err)
 	}
+	v.Selection = sels
 
-	// Compute attestation digest
-	lib.PRINT("=== INIT: PREDICT ATTESTATION DIGEST ===========================================")
+	// Only trust reference values the CICD signed
+	manifest, err := refvalues.Parse(referenceValues, referenceValuesSignature, cicdPubPEM)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBadReferenceValues, err)
+	}
+	v.References, err = manifest.References(time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBadReferenceValues, err)
+	}
 
-	pcrsConcat := []byte{}
-	for _, i := range pcrs {
-		pcrsConcat = append(pcrsConcat, allpcrs[i][:]...)
+	// Check what was booted when the CICD says what may be: the Secure Boot
+	// state is read from the events log sent along with the quote
+	if manifest.SecureBoot != nil {
+		v.SecureBootPolicy = manifest.SecureBoot.Policy()
+	}
+	// So are the events of the PCRs the rules vouch for
+	v.EventsPolicy = manifest.EventsPolicy
+	// So are the files IMA measured, read from the IMA log
+	if manifest.IMA != nil {
+		v.IMAPolicy = manifest.IMA.Policy()
 	}
-	pcrDigest := sha256.Sum256(pcrsConcat)
 
-	lib.PRINT("=== VERIFIER: VERIFY QUOTE =====================================================")
+	return v, nil
+}
 
-	att, err := tpm2.DecodeAttestationData(attestation)
-	if err != nil {
-		lib.Fatal("DecodeAttestationData() failed: %v", err)
-	}
-
-	lib.Verbose("Attestation ExtraData (nonce): 0x%s ", hex.EncodeToString(att.ExtraData))
-	lib.Verbose("Attestation PCR#: %v ", att.AttestedQuoteInfo.PCRSelection.PCRs)
-	lib.Verbose("Attestation Hash: 0x%s ", hex.EncodeToString(att.AttestedQuoteInfo.PCRDigest))
-
-	// Compare the nonce that is embedded within the attestation. This should
-	// match the one we sent in earlier.
-	if !bytes.Equal(nonce, att.ExtraData) {
-		lib.Fatal("Nonce Value mismatch Got: (0x%s) Expected: (0x%s)",
-			hex.EncodeToString(att.ExtraData), hex.EncodeToString(nonce))
-	}
-	lib.Print("Nonce from Quote matches expected nonce")
-
-	sigL := tpm2.SignatureRSA{
-		HashAlg:   tpm2.AlgSHA256,
-		Signature: signature,
-	}
-	lib.Verbose("sigL: %v", sigL)
-
-	if !bytes.Equal(pcrDigest[:], att.AttestedQuoteInfo.PCRDigest) {
-		lib.Fatal("Unexpected PCR hash Value Got 0x%s Expected: 0x%s",
-			hex.EncodeToString(att.AttestedQuoteInfo.PCRDigest), hex.EncodeToString(pcrDigest[:]))
-	}
-	lib.Print("PCRs digest from Quote matches expected digest")
-
-	// Verify AK signature
-	// use the AK from the original attestation to verify the signature of the Attestation
-	// rsaPub := rsa.PublicKey{E: int(tPub.RSAParameters.Exponent()), N: tPub.RSAParameters.Modulus()}
-	akPublicKey := certs.ReadPublicKeyPEM(akPubPEM)
-	//fatal, msg, akPublicKey := certs.ReadPublicKeyPEM(akPubPEM)
-	//if fatal {
-	//	lib.Fatal("Cannot decode AK: %s", msg)
-	//}
-	hsh := crypto.SHA256.New()
-	hsh.Write(attestation)
-	err = rsa.VerifyPKCS1v15(
-		&akPublicKey,
-		crypto.SHA256,
-		hsh.Sum(nil),
-		sigL.Signature,
-	)
-	if err != nil {
-		lib.Fatal("rsa.VerifyPKCS1v15() failed: %v", err)
+func printResult(
+	result *verifier.Result, // IN
+) error {
+
+	for _, verdict := range result.SecureBoot {
+		if !verdict.Passed {
+			lib.Comment("Secure Boot %s: %s", verdict.Rule, verdict.Message)
+		}
+	}
+	for _, verdict := range result.EventsPolicy {
+		if !verdict.Passed {
+			lib.Comment("Events policy %s: %s", verdict.Rule, verdict.Message)
+		}
+	}
+	if result.IMA != nil {
+		for _, file := range result.IMA.Unknown {
+			lib.Comment("IMA unknown file: %s (%s)", file.Path, file.Digest)
+		}
+		for _, file := range result.IMA.Denied {
+			lib.Comment("IMA denied file: %s (%s)", file.Path, file.Digest)
+		}
+	}
+	for _, c := range result.Checks {
+		if c.Passed {
+			lib.Print("%s", c.Message)
+		} else {
+			lib.Comment("%s check failed: %s", c.Name, c.Message)
+		}
+	}
+	if result.Diagnostics != nil {
+		lib.Comment("%s", result.Diagnostics)
 	}
-	lib.Print("Quote signature is valid")
-	return true, "All good ;)"
+	return result.Err()
 }
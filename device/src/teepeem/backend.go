@@ -0,0 +1,176 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package teepeem
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-attestation/attest"
+	"github.com/google/go-tpm-tools/simulator"
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpmutil"
+
+	"main/src/lib"
+	"main/src/replay"
+)
+
+// SimulatorPath is the --tpm-path value selecting the in-process simulator.
+// It can be followed by the options ":seed=<seed>" and ":events-log=<path>",
+// the latter last, e.g. "simulator", "simulator:seed=42" or
+// "simulator:seed=42:events-log=/tmp/binary_bios_measurements".
+const SimulatorPath = "simulator"
+
+// KernelEventsLogPath is where the kernel exposes the TCG events log of the
+// boot it measured.
+const KernelEventsLogPath = "/sys/kernel/security/tpm0/binary_bios_measurements"
+
+// SimulatorSeed is the default seed for the simulator hierarchies. Fixing it
+// keeps the EK and SRK identical from one process to the next.
+const SimulatorSeed int64 = 0x5EED
+
+// Backend opens a connection to a TPM.
+type Backend interface {
+	Open() (io.ReadWriteCloser, error)
+}
+
+// === Device backend (character device or swtpm Unix socket) ==================
+
+type Device struct {
+	Path string
+}
+
+func (d Device) Open() (io.ReadWriteCloser, error) {
+	return tpm2.OpenTPM(d.Path)
+}
+
+// === Simulator backend (Microsoft TPM 2.0 reference, in process) =============
+
+type Simulator struct {
+	// Seed all hierarchy seeds are derived from (insecure, for testing only)
+	Seed int64
+	// Optional events log replayed into the PCRs once the simulator is up, so
+	// that quotes and sealing policies agree with that log
+	EventsLogPath string
+}
+
+func (s Simulator) Open() (io.ReadWriteCloser, error) {
+	sim, err := simulator.GetWithFixedSeedInsecure(s.Seed)
+	if err != nil {
+		return nil, fmt.Errorf("simulator.GetWithFixedSeedInsecure() failed: %v", err)
+	}
+	lib.Comment("Opened TPM simulator with seed 0x%x", s.Seed)
+
+	if s.EventsLogPath != "" {
+		eventsLog, err := ioutil.ReadFile(s.EventsLogPath)
+		if err != nil {
+			sim.Close()
+			return nil, fmt.Errorf("ioutil.ReadFile() failed: %v", err)
+		}
+		if err := ExtendEventsLog(sim, eventsLog); err != nil {
+			sim.Close()
+			return nil, err
+		}
+		lib.Comment("Replayed %s into simulator PCRs", s.EventsLogPath)
+	}
+
+	return sim, nil
+}
+
+// ExtendEventsLog extends every measured event of an events log into the
+// matching PCR bank. It is meant for simulated TPMs, whose PCRs start empty.
+func ExtendEventsLog(
+	rw io.ReadWriter,
+	eventsLog []byte,
+) error {
+
+	parsedEventsLog, err := attest.ParseEventLog(eventsLog)
+	if err != nil {
+		return fmt.Errorf("attest.ParseEventLog() failed: %v", err)
+	}
+
+	// The simulator starts up at locality 0: PCR 0 of an H-CRTM platform
+	// cannot be reproduced
+	locality, err := replay.StartupLocality(parsedEventsLog.Events(parsedEventsLog.Algs[0]))
+	if err != nil {
+		return err
+	}
+	if locality != 0 {
+		return fmt.Errorf("events log starts up at locality %d, the simulator at locality 0", locality)
+	}
+
+	for _, alg := range parsedEventsLog.Algs {
+		for _, e := range parsedEventsLog.Events(alg) {
+			if e.Type == replay.EvNoAction || len(e.Digest) == 0 {
+				continue
+			}
+			err := tpm2.PCRExtend(
+				rw,
+				tpmutil.Handle(e.Index),
+				tpm2.Algorithm(alg),
+				e.Digest,
+				"", // password
+			)
+			if err != nil {
+				return fmt.Errorf("tpm2.PCRExtend() failed for PCR[%d]: %v", e.Index, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// NewBackend maps a --tpm-path value to a Backend: the simulator if it is
+// SimulatorPath, with its options, else the device at that path.
+func NewBackend(tpmPath string) (Backend, error) {
+	if !IsSimulator(tpmPath) {
+		return Device{Path: tpmPath}, nil
+	}
+
+	sim := Simulator{Seed: SimulatorSeed}
+	options := strings.TrimPrefix(strings.TrimPrefix(tpmPath, SimulatorPath), ":")
+	for options != "" {
+		name, value, _ := strings.Cut(options, "=")
+		switch name {
+		case "seed":
+			value, options, _ = strings.Cut(value, ":")
+			seed, err := strconv.ParseInt(value, 0, 64)
+			if err != nil {
+				return nil, fmt.Errorf("simulator seed %q: strconv.ParseInt() failed: %w", value, err)
+			}
+			sim.Seed = seed
+		case "events-log":
+			// The path runs to the end, colons included
+			if value == "" {
+				return nil, fmt.Errorf("simulator events-log option has no path")
+			}
+			sim.EventsLogPath, options = value, ""
+		default:
+			return nil, fmt.Errorf("unknown simulator option %q, expected seed=<seed> or events-log=<path>", options)
+		}
+	}
+
+	return sim, nil
+}
+
+// IsSimulator tells whether a --tpm-path value selects the simulator, which
+// has no EK certificate in NV.
+func IsSimulator(tpmPath string) bool {
+	return tpmPath == SimulatorPath || strings.HasPrefix(tpmPath, SimulatorPath+":")
+}
+
+// EventsLogPath returns the events log the PCRs of a --tpm-path value result
+// from: that of the simulator's events-log option, if any, or the kernel's.
+func EventsLogPath(tpmPath string) (string, error) {
+	backend, err := NewBackend(tpmPath)
+	if err != nil {
+		return "", err
+	}
+	if sim, ok := backend.(Simulator); ok {
+		return sim.EventsLogPath, nil
+	}
+	return KernelEventsLogPath, nil
+}
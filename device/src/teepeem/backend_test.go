@@ -0,0 +1,39 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package teepeem
+
+import (
+	"testing"
+)
+
+func TestNewBackend(t *testing.T) {
+	for _, tc := range []struct {
+		tpmPath string
+		backend Backend
+	}{
+		{"/dev/tpmrm0", Device{Path: "/dev/tpmrm0"}},
+		{"/run/swtpm/simulator.sock", Device{Path: "/run/swtpm/simulator.sock"}},
+		{"simulator", Simulator{Seed: SimulatorSeed}},
+		{"simulator:seed=42", Simulator{Seed: 42}},
+		{"simulator:seed=0x5eed:events-log=/tmp/a:b.log", Simulator{Seed: 0x5eed, EventsLogPath: "/tmp/a:b.log"}},
+		{"simulator:events-log=42", Simulator{Seed: SimulatorSeed, EventsLogPath: "42"}},
+		{"simulator:events-log=/tmp/a.log:seed=42", Simulator{Seed: SimulatorSeed, EventsLogPath: "/tmp/a.log:seed=42"}},
+	} {
+		backend, err := NewBackend(tc.tpmPath)
+		if err != nil || backend != tc.backend {
+			t.Errorf("NewBackend(%q) returned %+v, %v, expected %+v", tc.tpmPath, backend, err, tc.backend)
+		}
+	}
+
+	// Seeds and paths are never guessed from their contents
+	for _, tpmPath := range []string{
+		"simulator:42",
+		"simulator:/tmp/binary_bios_measurements",
+		"simulator:seed=/tmp/binary_bios_measurements",
+		"simulator:events-log=",
+	} {
+		if backend, err := NewBackend(tpmPath); err == nil {
+			t.Errorf("NewBackend(%q) returned %+v, expected an error", tpmPath, backend)
+		}
+	}
+}
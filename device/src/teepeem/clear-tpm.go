@@ -3,15 +3,21 @@
 package teepeem
 
 import (
+	"fmt"
 	"io"
 
-	"github.com/golang/glog"
 	"github.com/google/go-tpm/tpm2"
 )
 
 // ### Clear TPM (on Attestor) #################################################
 
-func Clear(rwc io.ReadWriter) {
+func Clear(rwc io.ReadWriter) error {
+	// A simulator is freshly manufactured on every run, and clearing it would
+	// draw a random owner seed that the next run cannot reproduce
+	if IsSimulatorTPM(rwc) {
+		return nil
+	}
+
 	err := tpm2.Clear(
 		rwc,
 		tpm2.HandleLockout,
@@ -23,6 +29,8 @@ func Clear(rwc io.ReadWriter) {
 		},
 	)
 	if err != nil {
-		glog.Fatalf("tpm2.Clear() failed: %v", err)
+		return fmt.Errorf("tpm2.Clear() failed: %w", err)
 	}
+
+	return nil
 }
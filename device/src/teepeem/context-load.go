@@ -3,6 +3,7 @@
 package teepeem
 
 import (
+	"fmt"
 	"io"
 
 	"github.com/google/go-tpm/tpm2"
@@ -16,13 +17,13 @@ import (
 func ContextLoad(
 	rw io.ReadWriter,
 	saveArea []byte,
-) tpmutil.Handle {
+) (tpmutil.Handle, error) {
 
 	ek, err := tpm2.ContextLoad(rw, saveArea)
 	if err != nil {
-		lib.Fatal("tpm2.ContextLoad() failed: %v", err)
+		return 0, fmt.Errorf("tpm2.ContextLoad() failed: %w", err)
 	}
 	lib.Verbose("tpm2.ContextLoad() returned %v", ek)
 
-	return ek
+	return ek, nil
 }
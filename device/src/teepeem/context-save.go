@@ -4,6 +4,7 @@ package teepeem
 
 import (
 	"encoding/hex"
+	"fmt"
 	"io"
 
 	"github.com/google/go-tpm/tpm2"
@@ -17,13 +18,13 @@ import (
 func ContextSave(
 	rw io.ReadWriter,
 	handle tpmutil.Handle,
-) []byte {
+) ([]byte, error) {
 
 	ekCtx, err := tpm2.ContextSave(rw, handle)
 	if err != nil {
-		lib.Fatal("tpm2.ContextSave() failed: %v", err)
+		return nil, fmt.Errorf("tpm2.ContextSave() failed: %w", err)
 	}
 	lib.Verbose("tpm2.ContextSave() returned 0x%s", hex.EncodeToString(ekCtx))
 
-	return ekCtx
+	return ekCtx, nil
 }
@@ -2,26 +2,33 @@ package teepeem
 
 import (
 	"crypto"
+	"fmt"
 	"io"
 
-	"github.com/google/go-tpm-tools/client"
 	"github.com/google/go-tpm/tpm2"
 	"github.com/google/go-tpm/tpmutil"
 )
 
 // ### CreateEK (on attestor) ##################################################
 
-func CreateEK(rwc io.ReadWriter) (tpmutil.Handle, crypto.PublicKey, error) {
+func CreateEK(rwc io.ReadWriter, keyType string) (tpmutil.Handle, crypto.PublicKey, error) {
 
 	// === Create EK ===========================================================
 
+	template, err := EKTemplate(keyType)
+	if err != nil {
+		return 0, nil, err
+	}
 	ek, pub, err := tpm2.CreatePrimary(
 		rwc,
 		tpm2.HandleEndorsement,
 		tpm2.PCRSelection{},
 		"", "",
-		client.DefaultEKTemplateRSA(),
+		template,
 	)
+	if err != nil {
+		return 0, nil, fmt.Errorf("tpm2.CreatePrimary() failed for EK: %w", err)
+	}
 
-	return ek, pub, err
+	return ek, pub, nil
 }
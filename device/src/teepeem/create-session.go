@@ -4,6 +4,7 @@ package teepeem
 
 import (
 	"encoding/hex"
+	"fmt"
 	"io"
 
 	"github.com/google/go-tpm/tpm2"
@@ -17,7 +18,7 @@ import (
 func CreateSession(
 	rwc io.ReadWriter,
 	sessionType tpmutil.Handle,
-) tpmutil.Handle {
+) (tpmutil.Handle, error) {
 
 	// --- Start auth session for creating AK ----------------------------------
 	// (Auth sessions are required for EK children)
@@ -32,7 +33,7 @@ func CreateSession(
 		tpm2.AlgSHA256,     // hash algorithm
 	)
 	if err != nil {
-		lib.Fatal("tpm2.StartAuthSession() failed: %v", err)
+		return 0, fmt.Errorf("tpm2.StartAuthSession() failed: %w", err)
 	}
 	//defer tpm2.FlushContext(rwc, createSession)
 	lib.Verbose("session: 0x%08x", session)
@@ -52,8 +53,44 @@ func CreateSession(
 		0,       // expiry
 	)
 	if err != nil {
-		lib.Fatal("tpm2.PolicySecret() failed for create session: %v", err)
+		tpm2.FlushContext(rwc, session)
+		return 0, fmt.Errorf("tpm2.PolicySecret() failed for create session: %w", err)
 	}
 
-	return session
+	return session, nil
+}
+
+// === Start EK session ========================================================
+
+// CreateEKSession returns the session authorizing the use of an EK, e.g. as
+// the parent of the AK. The low-range templates only allow it by policy: a
+// policy session satisfying PolicyA, PolicySecret(TPM_RH_ENDORSEMENT), is
+// started, to be flushed. The high-range ones (the P-384 EK) allow it by
+// password: the password session is returned, not to be flushed.
+func CreateEKSession(
+	rw io.ReadWriter,
+	ek tpmutil.Handle, // IN
+) (tpmutil.Handle, error) {
+
+	public, _, _, err := tpm2.ReadPublic(rw, ek)
+	if err != nil {
+		return 0, fmt.Errorf("tpm2.ReadPublic() failed for EK: %w", err)
+	}
+	if public.Attributes&tpm2.FlagUserWithAuth != 0 {
+		return tpm2.HandlePasswordSession, nil
+	}
+
+	return CreateSession(rw, tpm2.HandlePasswordSession)
+}
+
+// FlushSession flushes a session CreateEKSession() started, if any.
+func FlushSession(
+	rw io.ReadWriter,
+	session tpmutil.Handle, // IN
+) error {
+
+	if session == tpm2.HandlePasswordSession {
+		return nil
+	}
+	return FlushContext(rw, session)
 }
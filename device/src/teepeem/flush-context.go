@@ -3,12 +3,11 @@
 package teepeem
 
 import (
+	"fmt"
 	"io"
 
 	"github.com/google/go-tpm/tpm2"
 	"github.com/google/go-tpm/tpmutil"
-
-	"main/src/lib"
 )
 
 // === Flush TPM context =======================================================
@@ -16,10 +15,12 @@ import (
 func FlushContext(
 	rw io.ReadWriter,
 	handle tpmutil.Handle,
-) {
+) error {
 
 	err := tpm2.FlushContext(rw, handle)
 	if err != nil {
-		lib.Fatal("tpm2.FlushContext() failed: %v", err)
+		return fmt.Errorf("tpm2.FlushContext() failed: %w", err)
 	}
+
+	return nil
 }
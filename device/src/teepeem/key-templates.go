@@ -0,0 +1,116 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package teepeem
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"fmt"
+
+	"github.com/google/go-tpm-tools/client"
+	"github.com/google/go-tpm/tpm2"
+)
+
+// === EK and AK templates =====================================================
+
+// Key types of EKs and AKs
+const (
+	KeyRSA     = "rsa"      // RSA 2048
+	KeyECCP256 = "ecc-p256" // ECC NIST P-256
+	KeyECCP384 = "ecc-p384" // ECC NIST P-384
+)
+
+// KeyTypes lists the supported key types.
+var KeyTypes = []string{KeyRSA, KeyECCP256, KeyECCP384}
+
+// PolicyB, the authorization policy of the high-range EK templates, in
+// SHA-384: PolicyOR of PolicyA, PolicySecret(TPM_RH_ENDORSEMENT), and PolicyC,
+// PolicyAuthorizeNV(0x01C07F02) (TCG EK Credential Profile 2.5, section B.6)
+var policyBSHA384 = []byte{
+	0xB2, 0x6E, 0x7D, 0x28, 0xD1, 0x1A, 0x50, 0xBC, 0x53, 0xD8, 0x82, 0xBC,
+	0xF5, 0xFD, 0x3A, 0x1A, 0x07, 0x41, 0x48, 0xBB, 0x35, 0xD3, 0xB4, 0xE4,
+	0xCB, 0x1C, 0x0A, 0xD9, 0xBD, 0xE4, 0x19, 0xCA, 0xCB, 0x47, 0xBA, 0x09,
+	0x69, 0x96, 0x46, 0x15, 0x0F, 0x9F, 0xC0, 0x00, 0xF3, 0xF8, 0x0E, 0x12,
+}
+
+// EKTemplate returns the template of an EK, that of the TCG EK Credential
+// Profile EK certificates are issued for. RSA 2048 and ECC P-256 EKs are made
+// from the low-range templates (L-1 and L-2), ECC P-384 EKs from the
+// high-range template H-3: SHA-384 name algorithm and PolicyB, AES-256
+// symmetric key, empty unique field. Unlike the low-range templates, H-3
+// allows user authorization by password (see CreateEKSession).
+func EKTemplate(
+	keyType string, // IN
+) (tpm2.Public, error) {
+
+	switch keyType {
+	case KeyRSA:
+		return client.DefaultEKTemplateRSA(), nil
+	case KeyECCP256:
+		return client.DefaultEKTemplateECC(), nil
+	case KeyECCP384:
+		return tpm2.Public{
+			Type:    tpm2.AlgECC,
+			NameAlg: tpm2.AlgSHA384,
+			Attributes: tpm2.FlagFixedTPM | tpm2.FlagFixedParent | tpm2.FlagSensitiveDataOrigin |
+				tpm2.FlagUserWithAuth | tpm2.FlagAdminWithPolicy | tpm2.FlagRestricted | tpm2.FlagDecrypt,
+			AuthPolicy: policyBSHA384,
+			ECCParameters: &tpm2.ECCParams{
+				Symmetric: &tpm2.SymScheme{
+					Alg:     tpm2.AlgAES,
+					KeyBits: 256,
+					Mode:    tpm2.AlgCFB,
+				},
+				CurveID: tpm2.CurveNISTP384,
+			},
+		}, nil
+	}
+	return tpm2.Public{}, fmt.Errorf("unknown key type %q, expected one of %v", keyType, KeyTypes)
+}
+
+// AKTemplate returns the template of an AK. All AKs sign with SHA-256, which
+// quotes then digest the PCRs with: RSASSA-PKCS1-v1_5 for RSA keys, ECDSA for
+// ECC keys.
+func AKTemplate(
+	keyType string, // IN
+) (tpm2.Public, error) {
+
+	switch keyType {
+	case KeyRSA:
+		return client.AKTemplateRSA(), nil
+	case KeyECCP256:
+		return client.AKTemplateECC(), nil
+	case KeyECCP384:
+		template := client.AKTemplateECC()
+		template.ECCParameters.CurveID = tpm2.CurveNISTP384
+		template.ECCParameters.Point = tpm2.ECPoint{XRaw: make([]byte, 48), YRaw: make([]byte, 48)}
+		return template, nil
+	}
+	return tpm2.Public{}, fmt.Errorf("unknown key type %q, expected one of %v", keyType, KeyTypes)
+}
+
+// KeyType returns the type of a public key, e.g. of an EK read back from its
+// PEM file.
+func KeyType(
+	publicKey crypto.PublicKey, // IN
+) (string, error) {
+
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		if key.N.BitLen() == 2048 {
+			return KeyRSA, nil
+		}
+		return "", fmt.Errorf("RSA key of %d bits, expected 2048", key.N.BitLen())
+	case *ecdsa.PublicKey:
+		switch key.Curve {
+		case elliptic.P256():
+			return KeyECCP256, nil
+		case elliptic.P384():
+			return KeyECCP384, nil
+		}
+		return "", fmt.Errorf("ECC key on curve %s, expected P-256 or P-384", key.Curve.Params().Name)
+	}
+	return "", fmt.Errorf("unsupported key type %T", publicKey)
+}
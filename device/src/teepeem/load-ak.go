@@ -22,21 +22,35 @@ func LoadAK(
 ) (
 	ak tpmutil.Handle,
 	akName []byte,
+	err error,
 ) {
 
 	// Read AK public and private blobs from disk
-	akPublicBlob := lib.Read(fmt.Sprintf("%s-pub.blob", attestorAkPath))
-	akPrivateBlob := lib.Read(fmt.Sprintf("%s-priv.blob", attestorAkPath))
+	akPublicBlob, err := lib.Read(fmt.Sprintf("%s-pub.blob", attestorAkPath))
+	if err != nil {
+		return 0, nil, err
+	}
+	if IsSimulatorTPM(rw) {
+		// The simulator AK is not a child of the EK, see CreateSimulatorAK()
+		return loadSimulatorAK(rw, akPublicBlob)
+	}
+	akPrivateBlob, err := lib.Read(fmt.Sprintf("%s-priv.blob", attestorAkPath))
+	if err != nil {
+		return 0, nil, err
+	}
 
 	// Start auth session for loading AK
-	session := CreateSession(
+	session, err := CreateEKSession(
 		rw,
-		tpm2.HandlePasswordSession,
+		ek, // IN
 	)
-	defer tpm2.FlushContext(rw, session)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer FlushSession(rw, session)
 
 	// Load AK
-	ak, akName, err := tpm2.LoadUsingAuth(
+	ak, akName, err = tpm2.LoadUsingAuth(
 		rw,
 		ek, // parentHandle
 		tpm2.AuthCommand{
@@ -47,7 +61,7 @@ func LoadAK(
 		akPrivateBlob, // privateBlob
 	)
 	if err != nil {
-		lib.Fatal("tpm2.LoadUsingAuth() failed: %v", err)
+		return 0, nil, fmt.Errorf("tpm2.LoadUsingAuth() failed: %w", err)
 	}
 	lib.Verbose("ak: 0x%08x", ak)
 	// akName consists of 36 bytes:
@@ -57,7 +71,7 @@ func LoadAK(
 	// See https://github.com/tpm2-software/tpm2-tools/issues/1872
 	lib.Verbose("akName: 0x%s", hex.EncodeToString(akName))
 
-	return ak, akName
+	return ak, akName, nil
 	// defer tpm2.FlushContext(rw, ak)
 }
 
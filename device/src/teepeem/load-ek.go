@@ -6,8 +6,10 @@ import (
 	"fmt"
 	"io"
 
+	"github.com/google/go-tpm/tpm2"
 	"github.com/google/go-tpm/tpmutil"
 
+	"main/src/certs"
 	"main/src/lib"
 )
 
@@ -18,10 +20,37 @@ func LoadEK(
 	attestorEkPath string, // IN
 ) (
 	ek tpmutil.Handle,
+	err error,
 ) {
 
-	ekCtx := lib.Read(fmt.Sprintf("%s.ctx", attestorEkPath))
-	ek = ContextLoad(rw, ekCtx)
+	ekCtx, err := lib.Read(fmt.Sprintf("%s.ctx", attestorEkPath))
+	if err != nil {
+		return 0, err
+	}
+	ek, err = tpm2.ContextLoad(rw, ekCtx)
+	if err != nil {
+		// On a real TPM, the saved context holds: failing to load it tells
+		// of another TPM, a lockout or a corrupted context
+		if !IsSimulatorTPM(rw) {
+			return 0, fmt.Errorf("tpm2.ContextLoad() failed for EK: %w", err)
+		}
+		// The simulator is fresh in every process, so saved contexts do not
+		// survive, but the EK is a primary key: recreating it from the
+		// template of its type yields the same key.
+		lib.Comment("tpm2.ContextLoad() failed (%v), recreating EK on the simulator", err)
+		ekPublicKey, err := certs.ReadPublicKey(attestorEkPath)
+		if err != nil {
+			return 0, err
+		}
+		keyType, err := KeyType(ekPublicKey)
+		if err != nil {
+			return 0, fmt.Errorf("EK: %w", err)
+		}
+		ek, _, err = CreateEK(rw, keyType)
+		if err != nil {
+			return 0, err
+		}
+	}
 	//defer tpm2.FlushContext(rw, ek)
-	return ek
+	return ek, nil
 }
@@ -0,0 +1,39 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package teepeem
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/google/go-tpm/tpm2"
+)
+
+// === Read TPM2B_DATA size ====================================================
+
+// MaxNonceSize returns the largest qualifying data (nonce) the TPM accepts for
+// a quote. TPM2B_DATA is sized after TPMT_HA: a 2-byte algorithm identifier
+// followed by the largest digest the TPM implements.
+func MaxNonceSize(
+	rw io.ReadWriter,
+) (int, error) {
+
+	caps, _, err := tpm2.GetCapability(
+		rw,
+		tpm2.CapabilityTPMProperties,
+		1,                          // count
+		uint32(tpm2.DigestMaxSize), // property
+	)
+	if err != nil {
+		return 0, fmt.Errorf("tpm2.GetCapability() failed: %w", err)
+	}
+	if len(caps) != 1 {
+		return 0, fmt.Errorf("tpm2.GetCapability() returned %d properties", len(caps))
+	}
+	prop, ok := caps[0].(tpm2.TaggedProperty)
+	if !ok || prop.Tag != tpm2.DigestMaxSize {
+		return 0, fmt.Errorf("tpm2.GetCapability() did not return TPM_PT_MAX_DIGEST: %v", caps[0])
+	}
+
+	return 2 + int(prop.Value), nil
+}
@@ -3,6 +3,7 @@
 package teepeem
 
 import (
+	"fmt"
 	"io"
 
 	"github.com/golang/glog"
@@ -19,28 +20,34 @@ var handleNames = map[string][]tpm2.HandleType{
 
 // === Clear TPM (on Attestor) =================================================
 
-func OpenFlush(tpmPath string, flush string) (rwc io.ReadWriteCloser) {
+func OpenFlush(tpmPath string, flush string) (rwc io.ReadWriteCloser, err error) {
 
-	// Open TPM device and flush key handles
-	rwc, err := tpm2.OpenTPM(tpmPath)
+	// Open TPM (device, socket or simulator) and flush key handles
+	backend, err := NewBackend(tpmPath)
 	if err != nil {
-		glog.Fatalf("can't open TPM %q: %v", tpmPath, err)
+		return nil, fmt.Errorf("can't open TPM %q: %w", tpmPath, err)
+	}
+	rwc, err = backend.Open()
+	if err != nil {
+		return nil, fmt.Errorf("can't open TPM %q: %w", tpmPath, err)
 	}
 
 	totalHandles := 0
 	for _, handleType := range handleNames[flush] {
 		handles, err := client.Handles(rwc, handleType)
 		if err != nil {
-			glog.Fatalf("getting handles: %v", err)
+			rwc.Close()
+			return nil, fmt.Errorf("getting handles: %w", err)
 		}
 		for _, handle := range handles {
 			if err = tpm2.FlushContext(rwc, handle); err != nil {
-				glog.Fatalf("flushing handle 0x%x: %v", handle, err)
+				rwc.Close()
+				return nil, fmt.Errorf("flushing handle 0x%x: %w", handle, err)
 			}
 			glog.V(2).Infof("Handle 0x%x flushed\n", handle)
 			totalHandles++
 		}
 	}
 
-	return rwc
+	return rwc, nil
 }
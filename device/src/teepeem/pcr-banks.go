@@ -0,0 +1,65 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package teepeem
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/google/go-tpm/tpm2"
+)
+
+// === Discover PCR banks ======================================================
+
+// PCRBanks returns the hash algorithms of the PCR banks the TPM has active,
+// i.e. allocated with at least one PCR.
+func PCRBanks(
+	rw io.ReadWriter,
+) ([]tpm2.Algorithm, error) {
+
+	caps, _, err := tpm2.GetCapability(
+		rw,
+		tpm2.CapabilityPCRs,
+		1024, // count
+		0,    // property
+	)
+	if err != nil {
+		return nil, fmt.Errorf("tpm2.GetCapability() failed: %w", err)
+	}
+
+	banks := []tpm2.Algorithm{}
+	for _, c := range caps {
+		sel, ok := c.(tpm2.PCRSelection)
+		if !ok {
+			return nil, fmt.Errorf("tpm2.GetCapability() returned %v, expected a PCR selection", c)
+		}
+		if len(sel.PCRs) != 0 {
+			banks = append(banks, sel.Hash)
+		}
+	}
+
+	return banks, nil
+}
+
+// CheckPCRBanks fails unless every bank of a selection is active on the TPM.
+func CheckPCRBanks(
+	rw io.ReadWriter,
+	sels []tpm2.PCRSelection,
+) error {
+
+	banks, err := PCRBanks(rw)
+	if err != nil {
+		return err
+	}
+	for _, sel := range sels {
+		active := false
+		for _, alg := range banks {
+			active = active || alg == sel.Hash
+		}
+		if !active {
+			return fmt.Errorf("the %v PCR bank is not active on this TPM, active banks are %v", sel.Hash, banks)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,122 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package teepeem
+
+import (
+	"bytes"
+	"encoding/asn1"
+	"fmt"
+	"io"
+	"math/big"
+
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpmutil"
+)
+
+// === Quote PCRs ==============================================================
+
+// Quote runs TPM2_Quote() on PCRs of one or more banks. tpm2.Quote() only
+// takes a single PCRSelection, hence this command is encoded here. The AK
+// signs with its own scheme, and must have an empty password.
+func Quote(
+	rw io.ReadWriter,
+	ak tpmutil.Handle,
+	nonce []byte,
+	sels []tpm2.PCRSelection,
+) (
+	attestation []byte,
+	signature *tpm2.Signature,
+	err error,
+) {
+
+	pcrSelect, err := EncodePCRSelection(sels)
+	if err != nil {
+		return nil, nil, err
+	}
+	// TPMS_AUTH_COMMAND for the password session: handle, nonce,
+	// attributes (continueSession), hmac
+	auth, err := tpmutil.Pack(tpm2.HandlePasswordSession, tpmutil.U16Bytes(nil),
+		tpm2.AttrContinueSession, tpmutil.U16Bytes(nil))
+	if err != nil {
+		return nil, nil, fmt.Errorf("tpmutil.Pack() failed: %w", err)
+	}
+
+	resp, code, err := tpmutil.RunCommand(
+		rw,
+		tpm2.TagSessions,
+		tpm2.CmdQuote,
+		ak,
+		tpmutil.U32Bytes(auth),
+		tpmutil.U16Bytes(nonce), // qualifyingData
+		tpm2.AlgNull,            // inScheme
+		tpmutil.RawBytes(pcrSelect),
+	)
+	if err != nil {
+		return nil, nil, fmt.Errorf("tpmutil.RunCommand() failed: %w", err)
+	}
+	if code != tpmutil.RCSuccess {
+		return nil, nil, fmt.Errorf("TPM2_Quote() failed: response code 0x%x", uint32(code))
+	}
+
+	// Response parameters: size, TPM2B_ATTEST, TPMT_SIGNATURE
+	buf := bytes.NewBuffer(resp)
+	var paramSize uint32
+	var quoted tpmutil.U16Bytes
+	if err := tpmutil.UnpackBuf(buf, &paramSize, &quoted); err != nil {
+		return nil, nil, fmt.Errorf("tpmutil.UnpackBuf() failed: %w", err)
+	}
+	signature, err = tpm2.DecodeSignature(buf)
+	if err != nil {
+		return nil, nil, fmt.Errorf("tpm2.DecodeSignature() failed: %w", err)
+	}
+
+	return quoted, signature, nil
+}
+
+// EncodePCRSelection encodes a TPML_PCR_SELECTION, with a 3-byte bitmap
+// (24 PCRs) per bank.
+func EncodePCRSelection(
+	sels []tpm2.PCRSelection,
+) ([]byte, error) {
+
+	out, err := tpmutil.Pack(uint32(len(sels)))
+	if err != nil {
+		return nil, fmt.Errorf("tpmutil.Pack() failed: %w", err)
+	}
+	for _, sel := range sels {
+		bitmap := make([]byte, 3)
+		for _, i := range sel.PCRs {
+			if i < 0 || i >= 8*len(bitmap) {
+				return nil, fmt.Errorf("PCR[%d] is out of range", i)
+			}
+			bitmap[i/8] |= 1 << (i % 8)
+		}
+		s, err := tpmutil.Pack(sel.Hash, uint8(len(bitmap)), tpmutil.RawBytes(bitmap))
+		if err != nil {
+			return nil, fmt.Errorf("tpmutil.Pack() failed: %w", err)
+		}
+		out = append(out, s...)
+	}
+
+	return out, nil
+}
+
+// EncodeSignature returns the bytes of a quote signature: the signature
+// itself for RSASSA, the ASN.1 DER encoding of (r, s) for ECDSA, as
+// crypto/ecdsa verifies it.
+func EncodeSignature(
+	sig *tpm2.Signature,
+) ([]byte, error) {
+
+	switch {
+	case sig.RSA != nil:
+		return sig.RSA.Signature, nil
+	case sig.ECC != nil:
+		signature, err := asn1.Marshal(struct{ R, S *big.Int }{sig.ECC.R, sig.ECC.S})
+		if err != nil {
+			return nil, fmt.Errorf("asn1.Marshal() failed: %w", err)
+		}
+		return signature, nil
+	}
+	return nil, fmt.Errorf("unsupported signature algorithm %v", sig.Alg)
+}
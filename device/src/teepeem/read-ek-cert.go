@@ -0,0 +1,161 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package teepeem
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpmutil"
+)
+
+// === Read EK certificates from NV ============================================
+
+// NV indexes TPM manufacturers provision EK certificates at, by key type (TCG
+// EK Credential Profile 2.5, section 2.2.1.5): the low range (L-1, L-2)
+// first, then the high range (H-1 to H-3). A certificate of the high range
+// certifies the EK of the matching high-range template.
+var ekCertIndexes = map[string][]tpmutil.Handle{
+	KeyRSA:     {0x01C00002, 0x01C00012},
+	KeyECCP256: {0x01C0000A, 0x01C00014},
+	KeyECCP384: {0x01C00016},
+}
+
+// NV indexes of EK certificate chains
+const (
+	ekCertChainIndexFirst tpmutil.Handle = 0x01C00100
+	ekCertChainIndexLast  tpmutil.Handle = 0x01C001FF
+)
+
+// ErrNoEKCert tells that none of the NV indexes of a key type is defined,
+// as on TPMs provisioned without EK certificates and on the simulator.
+var ErrNoEKCert = errors.New("no EK certificate in NV")
+
+// EKCertIndexes returns the NV indexes EK certificates of a key type are
+// stored at, in the order they are looked up.
+func EKCertIndexes(
+	keyType string, // IN
+) ([]tpmutil.Handle, error) {
+
+	indexes, ok := ekCertIndexes[keyType]
+	if !ok {
+		return nil, fmt.Errorf("unknown key type %q, expected one of %v", keyType, KeyTypes)
+	}
+	return indexes, nil
+}
+
+// NVCert is the content of an NV index holding a certificate, as stored:
+// it may not be plain DER (see certs.ParseEKCert).
+type NVCert struct {
+	Index tpmutil.Handle
+	Data  []byte
+}
+
+// ReadEKCerts reads the EK certificates of a key type that are stored in NV,
+// in the order of EKCertIndexes(). ErrNoEKCert is returned if none is stored.
+func ReadEKCerts(
+	rw io.ReadWriter,
+	keyType string, // IN
+) ([]NVCert, error) {
+
+	indexes, err := EKCertIndexes(keyType)
+	if err != nil {
+		return nil, err
+	}
+
+	ekCerts := []NVCert{}
+	for _, index := range indexes {
+		nvCert, err := readNVCert(rw, index)
+		if err != nil {
+			return nil, err
+		}
+		if nvCert != nil {
+			ekCerts = append(ekCerts, *nvCert)
+		}
+	}
+	if len(ekCerts) == 0 {
+		return nil, fmt.Errorf("%w: indexes %s", ErrNoEKCert, formatHandles(indexes))
+	}
+
+	return ekCerts, nil
+}
+
+// ReadEKCertChain reads the EK certificate chain indexes that are defined
+// (0x01C00100 to 0x01C001FF, TCG EK Credential Profile 2.5, section 2.2.1.5):
+// the intermediate CAs some TPM manufacturers ship EK certificates with.
+func ReadEKCertChain(
+	rw io.ReadWriter,
+) ([]NVCert, error) {
+
+	handles, _, err := tpm2.GetCapability(
+		rw,
+		tpm2.CapabilityHandles,
+		256,                           // count
+		uint32(ekCertChainIndexFirst), // property
+	)
+	if err != nil {
+		return nil, fmt.Errorf("tpm2.GetCapability() failed: %w", err)
+	}
+
+	chain := []NVCert{}
+	for _, h := range handles {
+		index, ok := h.(tpmutil.Handle)
+		if !ok {
+			return nil, fmt.Errorf("tpm2.GetCapability() returned %v, expected a handle", h)
+		}
+		if index < ekCertChainIndexFirst || index > ekCertChainIndexLast {
+			continue
+		}
+		nvCert, err := readNVCert(rw, index)
+		if err != nil {
+			return nil, err
+		}
+		if nvCert != nil {
+			chain = append(chain, *nvCert)
+		}
+	}
+
+	return chain, nil
+}
+
+// readNVCert reads an NV index, or returns nil if it is not defined.
+func readNVCert(
+	rw io.ReadWriter,
+	index tpmutil.Handle,
+) (*NVCert, error) {
+
+	nvPublic, err := tpm2.NVReadPublic(rw, index)
+	var handleErr tpm2.HandleError
+	if errors.As(err, &handleErr) && handleErr.Code == tpm2.RCHandle {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("tpm2.NVReadPublic() failed for 0x%08x: %w", index, err)
+	}
+
+	// Certificates are readable with the empty Owner authorization, or with
+	// that of the index itself
+	authHandle := tpm2.HandleOwner
+	if nvPublic.Attributes&tpm2.AttrOwnerRead == 0 {
+		authHandle = index
+	}
+	data, err := tpm2.NVReadEx(rw, index, authHandle, "", 0)
+	if err != nil {
+		return nil, fmt.Errorf("tpm2.NVReadEx() failed for 0x%08x: %w", index, err)
+	}
+
+	return &NVCert{Index: index, Data: data}, nil
+}
+
+func formatHandles(handles []tpmutil.Handle) string {
+	s := ""
+	for k, h := range handles {
+		if k > 0 {
+			s += ", "
+		}
+		s += fmt.Sprintf("0x%08x", uint32(h))
+	}
+	return s
+}
@@ -0,0 +1,129 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package teepeem
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/google/go-tpm/tpm2"
+
+	"main/src/certs"
+)
+
+// === Read TPM properties =====================================================
+
+// Info holds the fixed properties of a TPM that tell what it is: its
+// manufacturer, model and firmware, and the TPM specification it implements.
+type Info struct {
+	// TPM_PT_MANUFACTURER: TCG vendor ID, e.g. 0x494E5443 for "INTC"
+	Manufacturer uint32
+	// TPM_PT_VENDOR_STRING_1 to 4, e.g. the model
+	VendorString string
+	// TPM_PT_FIRMWARE_VERSION_1 and 2, vendor-specific
+	FirmwareVersion1 uint32
+	FirmwareVersion2 uint32
+	// TPM_PT_FAMILY_INDICATOR, e.g. "2.0"
+	SpecFamily string
+	// TPM_PT_LEVEL and TPM_PT_REVISION (100 times the revision, e.g. 138)
+	SpecLevel    uint32
+	SpecRevision uint32
+	// TPM_PT_YEAR and TPM_PT_DAY_OF_YEAR of the specification
+	SpecYear      uint32
+	SpecDayOfYear uint32
+}
+
+// ReadInfo reads the TPM_PT_FAMILY_INDICATOR to TPM_PT_FIRMWARE_VERSION_2
+// properties of the TPM.
+func ReadInfo(
+	rw io.ReadWriter,
+) (*Info, error) {
+
+	caps, _, err := tpm2.GetCapability(
+		rw,
+		tpm2.CapabilityTPMProperties,
+		uint32(tpm2.FirmwareVersion2-tpm2.FamilyIndicator+1), // count
+		uint32(tpm2.FamilyIndicator),                         // property
+	)
+	if err != nil {
+		return nil, fmt.Errorf("tpm2.GetCapability() failed: %w", err)
+	}
+
+	properties := map[tpm2.TPMProp]uint32{}
+	for _, c := range caps {
+		p, ok := c.(tpm2.TaggedProperty)
+		if !ok {
+			return nil, fmt.Errorf("tpm2.GetCapability() returned %v, expected a TPM property", c)
+		}
+		properties[p.Tag] = p.Value
+	}
+	for _, tag := range []tpm2.TPMProp{tpm2.FamilyIndicator, tpm2.Manufacturer, tpm2.FirmwareVersion1} {
+		if _, ok := properties[tag]; !ok {
+			return nil, fmt.Errorf("tpm2.GetCapability() did not return property 0x%x", uint32(tag))
+		}
+	}
+
+	vendorString := ""
+	for _, tag := range []tpm2.TPMProp{tpm2.VendorString1, tpm2.VendorString2, tpm2.VendorString3, tpm2.VendorString4} {
+		vendorString += propertyString(properties[tag])
+	}
+
+	return &Info{
+		Manufacturer:     properties[tpm2.Manufacturer],
+		VendorString:     strings.TrimSpace(vendorString),
+		FirmwareVersion1: properties[tpm2.FirmwareVersion1],
+		FirmwareVersion2: properties[tpm2.FirmwareVersion2],
+		SpecFamily:       propertyString(properties[tpm2.FamilyIndicator]),
+		SpecLevel:        properties[tpm2.SpecLevel],
+		SpecRevision:     properties[tpm2.SpecRevision],
+		SpecYear:         properties[tpm2.SpecYear],
+		SpecDayOfYear:    properties[tpm2.SpecDayOfYear],
+	}, nil
+}
+
+// VendorName returns the name of the manufacturer, e.g. "Intel".
+func (i *Info) VendorName() string {
+	return certs.VendorName(i.Manufacturer)
+}
+
+// ManufacturerID returns the manufacturer as EK certificates write it, e.g.
+// "id:494E5443".
+func (i *Info) ManufacturerID() string {
+	return fmt.Sprintf("id:%08X", i.Manufacturer)
+}
+
+// Model returns the model as EK certificates write it: the vendor string,
+// or the vendor name if the TPM has none.
+func (i *Info) Model() string {
+	if i.VendorString == "" {
+		return i.VendorName()
+	}
+	return i.VendorString
+}
+
+// Version returns the firmware version as EK certificates write it, e.g.
+// "id:00070055": TPM_PT_FIRMWARE_VERSION_1, in hex.
+func (i *Info) Version() string {
+	return fmt.Sprintf("id:%08X", i.FirmwareVersion1)
+}
+
+// FirmwareVersion returns the firmware version as most vendors write it:
+// the 16-bit halves of TPM_PT_FIRMWARE_VERSION_1 and 2, e.g. "7.85.4555.0".
+func (i *Info) FirmwareVersion() string {
+	return fmt.Sprintf("%d.%d.%d.%d", i.FirmwareVersion1>>16, i.FirmwareVersion1&0xffff,
+		i.FirmwareVersion2>>16, i.FirmwareVersion2&0xffff)
+}
+
+// SpecVersion returns the TPM specification version, e.g. "2.0 level 0
+// revision 1.38 (2016, day 273)".
+func (i *Info) SpecVersion() string {
+	return fmt.Sprintf("%s level %d revision %d.%02d (%d, day %d)", i.SpecFamily, i.SpecLevel,
+		i.SpecRevision/100, i.SpecRevision%100, i.SpecYear, i.SpecDayOfYear)
+}
+
+// propertyString decodes a property holding up to 4 ASCII characters.
+func propertyString(value uint32) string {
+	b := []byte{byte(value >> 24), byte(value >> 16), byte(value >> 8), byte(value)}
+	return strings.TrimRight(string(b), "\x00")
+}
@@ -4,6 +4,7 @@ package teepeem
 
 import (
 	"encoding/hex"
+	"fmt"
 	"io"
 
 	"github.com/google/go-tpm/tpm2"
@@ -11,17 +12,51 @@ import (
 	"main/src/lib"
 )
 
-// === Flush TPM context =======================================================
+// === Read PCR ================================================================
 
 func ReadPCR(
 	rw io.ReadWriter,
+	alg tpm2.Algorithm,
 	pcr int,
-) []byte {
+) ([]byte, error) {
 
-	val, err := tpm2.ReadPCR(rw, pcr, tpm2.AlgSHA256)
+	val, err := tpm2.ReadPCR(rw, pcr, alg)
 	if err != nil {
-		lib.Fatal("tpm2.ReadPCR() failed: %v", err)
+		return nil, fmt.Errorf("tpm2.ReadPCR() failed: %w", err)
 	}
-	lib.Comment("PCR[%2d] == %v ", pcr, hex.EncodeToString(val))
-	return val
+	lib.Comment("%v PCR[%2d] == %v ", alg, pcr, hex.EncodeToString(val))
+	return val, nil
+}
+
+// ReadPCRSelection reads the PCRs of a bank with as few TPM2_PCR_Read() as
+// the TPM allows, which returns at most 8 PCRs at once, so that they change as
+// little as possible in between.
+func ReadPCRSelection(
+	rw io.ReadWriter,
+	sel tpm2.PCRSelection,
+) (map[int][]byte, error) {
+
+	values := map[int][]byte{}
+	remaining := append([]int{}, sel.PCRs...)
+	for len(remaining) != 0 {
+		read, err := tpm2.ReadPCRs(rw, tpm2.PCRSelection{Hash: sel.Hash, PCRs: remaining})
+		if err != nil {
+			return nil, fmt.Errorf("tpm2.ReadPCRs() failed: %w", err)
+		}
+		unread := []int{}
+		for _, pcr := range remaining {
+			value, ok := read[pcr]
+			if !ok {
+				unread = append(unread, pcr)
+				continue
+			}
+			lib.Comment("%v PCR[%2d] == %v ", sel.Hash, pcr, hex.EncodeToString(value))
+			values[pcr] = value
+		}
+		if len(unread) == len(remaining) {
+			return nil, fmt.Errorf("tpm2.ReadPCRs() returned none of %v PCRs %v", sel.Hash, remaining)
+		}
+		remaining = unread
+	}
+	return values, nil
 }
@@ -7,9 +7,7 @@ import (
 	"encoding/hex"
 	"fmt"
 	"io"
-	"io/ioutil"
 
-	"github.com/golang/glog"
 	"github.com/google/go-tpm/tpm2"
 
 	"main/src/lib"
@@ -17,36 +15,44 @@ import (
 
 // === Retrieve PCRs values ====================================================
 
+// ReadPCRs reads PCRs of a bank, in order, and returns their values along
+// with the digest a quote of them holds. With a file prefix, the values and
+// the digest are also written to <prefix>-<n>.bin and <prefix>-digest.bin.
 func ReadPCRs(
 	rwc io.ReadWriter,
+	alg tpm2.Algorithm,
 	pcrsList []int,
 	filePrefix string,
-) [32]byte {
+) ([][]byte, [32]byte, error) {
 
-	pcrsExpected := make([][]byte, len(pcrsList))
+	pcrsValues := make([][]byte, len(pcrsList))
 	pcrsConcat := []byte{}
 	for ndx, val := range pcrsList {
-		pcr, err := tpm2.ReadPCR(rwc, val, tpm2.AlgSHA256)
+		pcr, err := tpm2.ReadPCR(rwc, val, alg)
 		if err != nil {
-			glog.Fatalf("ERROR:   Unable to  ReadPCR : %v", err)
+			return nil, [32]byte{}, fmt.Errorf("tpm2.ReadPCR() failed: %w", err)
 		}
-		lib.Comment("PCR [%d] Value %v ", ndx, hex.EncodeToString(pcr))
-		err = ioutil.WriteFile(fmt.Sprintf("%s-%d.bin", filePrefix, ndx),
-			pcr, 0644)
-		if err != nil {
-			lib.Fatal("ioutil.WriteFile() failed: %v", err)
+		lib.Comment("%v PCR[%2d] == %v ", alg, val, hex.EncodeToString(pcr))
+		if filePrefix != "" {
+			err = lib.Write(fmt.Sprintf("%s-%d.bin", filePrefix, ndx), pcr, 0644)
+			if err != nil {
+				return nil, [32]byte{}, err
+			}
 		}
-		pcrsExpected[ndx] = pcr
+		pcrsValues[ndx] = pcr
 		pcrsConcat = append(pcrsConcat, pcr...)
 	}
+	// The digest of a quote is made with the AK's hash, SHA-256 for ours,
+	// whatever the bank
 	pcrsDigest := sha256.Sum256(pcrsConcat)
 	lib.Comment("PCRs digest %s ", hex.EncodeToString(pcrsDigest[:]))
 
-	err := ioutil.WriteFile(filePrefix+"-digest.bin",
-		pcrsDigest[:], 0644)
-	if err != nil {
-		lib.Fatal("ioutil.WriteFile() failed: %v", err)
+	if filePrefix != "" {
+		err := lib.Write(filePrefix+"-digest.bin", pcrsDigest[:], 0644)
+		if err != nil {
+			return nil, [32]byte{}, err
+		}
 	}
 
-	return pcrsDigest
+	return pcrsValues, pcrsDigest, nil
 }
@@ -3,12 +3,11 @@
 package teepeem
 
 import (
+	"fmt"
 	"io"
 
 	"github.com/google/go-tpm/tpm2"
 	"github.com/google/go-tpm/tpmutil"
-
-	"main/src/lib"
 )
 
 // === Save TPM context ========================================================
@@ -20,15 +19,16 @@ func ReadPublic(
 	publicKey tpm2.Public,
 	name []byte,
 	qualName []byte,
+	err error,
 ) {
 
-	publicKey, name, qualName, err := tpm2.ReadPublic(
+	publicKey, name, qualName, err = tpm2.ReadPublic(
 		rw,
 		handle,
 	)
 	if err != nil {
-		lib.Fatal("tpm2.ReadPublic() failed: %v", err)
+		return tpm2.Public{}, nil, nil, fmt.Errorf("tpm2.ReadPublic() failed: %w", err)
 	}
 
-	return publicKey, name, qualName
+	return publicKey, name, qualName, nil
 }
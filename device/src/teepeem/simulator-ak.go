@@ -0,0 +1,101 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package teepeem
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/google/go-tpm-tools/simulator"
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpmutil"
+)
+
+// === Simulator AK ============================================================
+
+// The simulator draws its ehProof and shProof afresh in every process, and
+// stirs them into the seed protecting the children of the EK: an AK created
+// under the EK fails the integrity check when another process loads it. On
+// the simulator, the AK is rather a primary key of the owner hierarchy, whose
+// seed is fixed, so that every process re-derives the same AK from its
+// template.
+
+// IsSimulatorTPM tells whether a TPM connection is to the simulator.
+func IsSimulatorTPM(
+	rw io.ReadWriter, // IN
+) bool {
+
+	_, ok := rw.(*simulator.Simulator)
+	return ok
+}
+
+// CreateSimulatorAK creates the AK of a template as a primary key of the owner
+// hierarchy, and returns its public blob and its name, size first as
+// tpm2.LoadUsingAuth() returns it.
+func CreateSimulatorAK(
+	rw io.ReadWriter, // IN
+	template tpm2.Public, // IN
+) (
+	ak tpmutil.Handle,
+	akPublicBlob []byte,
+	akName []byte,
+	err error,
+) {
+
+	ak, akPublicBlob, _, _, _, name, err := tpm2.CreatePrimaryEx(
+		rw,
+		tpm2.HandleOwner,
+		tpm2.PCRSelection{},
+		"", "",
+		template,
+	)
+	if err != nil {
+		return 0, nil, nil, fmt.Errorf("tpm2.CreatePrimaryEx() failed for AK: %w", err)
+	}
+	akName, err = tpmutil.Pack(tpmutil.U16Bytes(name))
+	if err != nil {
+		tpm2.FlushContext(rw, ak)
+		return 0, nil, nil, fmt.Errorf("tpmutil.Pack() failed: %w", err)
+	}
+	return ak, akPublicBlob, akName, nil
+}
+
+// loadSimulatorAK re-derives the AK of a public blob from the template of its
+// type, and checks that it is the same key.
+func loadSimulatorAK(
+	rw io.ReadWriter, // IN
+	akPublicBlob []byte, // IN
+) (
+	ak tpmutil.Handle,
+	akName []byte,
+	err error,
+) {
+
+	akPublic, err := tpm2.DecodePublic(akPublicBlob)
+	if err != nil {
+		return 0, nil, fmt.Errorf("tpm2.DecodePublic() failed for AK: %w", err)
+	}
+	akPublicKey, err := akPublic.Key()
+	if err != nil {
+		return 0, nil, fmt.Errorf("akPublic.Key() failed: %w", err)
+	}
+	keyType, err := KeyType(akPublicKey)
+	if err != nil {
+		return 0, nil, fmt.Errorf("AK: %w", err)
+	}
+	template, err := AKTemplate(keyType)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	ak, publicBlob, akName, err := CreateSimulatorAK(rw, template)
+	if err != nil {
+		return 0, nil, err
+	}
+	if !bytes.Equal(publicBlob, akPublicBlob) {
+		tpm2.FlushContext(rw, ak)
+		return 0, nil, fmt.Errorf("re-derived AK differs from the onboarded one: was the simulator seed changed?")
+	}
+	return ak, akName, nil
+}
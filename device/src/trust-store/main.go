@@ -0,0 +1,89 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"runtime/debug"
+
+	"github.com/golang/glog"
+
+	"main/src/lib"
+	"main/src/truststore"
+)
+
+var (
+	trustStorePath = flag.String("trust-store", truststore.DefaultPath, "Directory of the TPM vendor CAs EK certificates are checked against.")
+)
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), `Usage: %s [flags] <command>
+
+Manage the TPM vendor CAs EK certificates are checked against.
+
+Commands:
+  import <PEM file>...    import root (self-signed) and intermediate CAs
+  list                    list CAs, with their SHA-256 fingerprints
+  remove <fingerprint>    remove the CA a fingerprint (or a prefix) designates
+
+Flags:
+`, os.Args[0])
+	flag.PrintDefaults()
+}
+
+// ### Main ####################################################################
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+
+	// Global panic handler
+	defer func() {
+		if message := recover(); message != nil {
+			glog.V(0).Infof("%s%s%s", lib.RED, message, lib.RESET)
+			glog.V(0).Infof("%s%s%s", lib.PURPLE, debug.Stack(), lib.RESET)
+		}
+	}()
+
+	lib.PRINT("### VERIFIER: MANAGE TPM VENDOR CAS ############################################")
+
+	store, err := truststore.Open(*trustStorePath)
+	if err != nil {
+		lib.Fatal("%v", err)
+	}
+
+	switch {
+	case flag.Arg(0) == "import" && flag.NArg() >= 2:
+		for _, path := range flag.Args()[1:] {
+			data, err := lib.Read(path)
+			if err != nil {
+				lib.Fatal("%v", err)
+			}
+			entries, err := store.Import(data)
+			if err != nil {
+				lib.Fatal("%s: %v", path, err)
+			}
+			for _, e := range entries {
+				fmt.Println(e)
+			}
+		}
+
+	case flag.Arg(0) == "list" && flag.NArg() == 1:
+		for _, e := range store.Entries {
+			fmt.Println(e)
+		}
+
+	case flag.Arg(0) == "remove" && flag.NArg() == 2:
+		e, err := store.Remove(flag.Arg(1))
+		if err != nil {
+			lib.Fatal("%v", err)
+		}
+		fmt.Println(e)
+
+	default:
+		usage()
+		os.Exit(2)
+	}
+}
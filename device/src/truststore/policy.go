@@ -0,0 +1,114 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package truststore
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"main/src/certs"
+	"main/src/lib"
+)
+
+// Name of the manufacturer policy file of a trust store
+const policyFile = "policy.json"
+
+// Policy failures
+var (
+	ErrManufacturerNotAllowed = errors.New("TPM manufacturer not allowed")
+	ErrFirmwareTooOld         = errors.New("TPM firmware too old")
+)
+
+// === Manufacturer policy =====================================================
+
+// Policy tells which TPMs EK certificates may be issued for, as their SAN
+// tells. In JSON, it reads as:
+//
+//	{"manufacturers": {"INTC": {"min-firmware-version": "id:00070055"},
+//	  "IFX": {}, "id:4E544300": {"min-firmware-version": "00070002"}}}
+type Policy struct {
+	// Manufacturers allowed, by vendor code ("INTC"), name ("Intel") or ID
+	// as EK certificates write it ("id:494E5443"); when none is listed, any
+	// manufacturer is
+	Manufacturers map[string]ManufacturerPolicy `json:"manufacturers,omitempty"`
+}
+
+// ManufacturerPolicy constrains the TPMs of a manufacturer.
+type ManufacturerPolicy struct {
+	// Minimum firmware version, in hex as EK certificates write it, with or
+	// without the "id:" prefix, e.g. "id:00070055"
+	MinFirmwareVersion string `json:"min-firmware-version,omitempty"`
+}
+
+// ReadPolicy reads the manufacturer policy of a trust store, if any.
+func (s *Store) ReadPolicy() (*Policy, error) {
+	path := filepath.Join(s.Dir, policyFile)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil, nil
+	}
+	data, err := lib.Read(path)
+	if err != nil {
+		return nil, err
+	}
+	p := &Policy{}
+	if err := json.Unmarshal(data, p); err != nil {
+		return nil, fmt.Errorf("%s: json.Unmarshal() failed: %w", path, err)
+	}
+	if err := p.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return p, nil
+}
+
+// Validate checks the minimum firmware versions of a policy.
+func (p *Policy) Validate() error {
+	for name, m := range p.Manufacturers {
+		if m.MinFirmwareVersion == "" {
+			continue
+		}
+		if _, err := certs.ParseFirmwareVersion(m.MinFirmwareVersion); err != nil {
+			return fmt.Errorf("manufacturer %q: min-firmware-version %q: %w", name, m.MinFirmwareVersion, err)
+		}
+	}
+	return nil
+}
+
+// Check fails unless a policy allows the TPM an EK certificate is issued for.
+func (p *Policy) Check(
+	id *certs.TPMIdentity, // IN
+) error {
+
+	if p == nil || len(p.Manufacturers) == 0 {
+		return nil
+	}
+
+	allowed := []string{}
+	for name, m := range p.Manufacturers {
+		allowed = append(allowed, name)
+		if !strings.EqualFold(name, id.VendorCode()) && !strings.EqualFold(name, id.VendorName()) &&
+			!strings.EqualFold(name, id.Manufacturer()) {
+			continue
+		}
+		if m.MinFirmwareVersion == "" {
+			return nil
+		}
+		min, err := certs.ParseFirmwareVersion(m.MinFirmwareVersion)
+		if err != nil {
+			return err
+		}
+		if id.FirmwareVersion < min {
+			return fmt.Errorf("%w: %s firmware %s, expected %s or later",
+				ErrFirmwareTooOld, id.VendorName(), id.Version(), m.MinFirmwareVersion)
+		}
+		return nil
+	}
+	sort.Strings(allowed)
+
+	return fmt.Errorf("%w: %s (%s), expected one of %v",
+		ErrManufacturerNotAllowed, id.VendorName(), id.Manufacturer(), allowed)
+}
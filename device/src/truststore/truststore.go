@@ -0,0 +1,225 @@
+// SPDX-License-Identifier: Apache-2.0
+
+// Package truststore manages the CAs of TPM vendors that EK certificates are
+// checked against: roots, which are trusted, and intermediates, which chains
+// are built through along with the ones shipped with an EK certificate.
+// They are kept in a directory, one PEM file per certificate, named after
+// its SHA-256 fingerprint:
+//
+//	Manufacturer/trust-store/roots/<fingerprint>.crt
+//	Manufacturer/trust-store/intermediates/<fingerprint>.crt
+package truststore
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"main/src/lib"
+)
+
+// Where the Verifier keeps the CAs of TPM vendors
+const DefaultPath = "Manufacturer/trust-store"
+
+// Kind tells whether a CA is trusted, or only links EK certificates to one.
+type Kind string
+
+const (
+	Root         Kind = "root"
+	Intermediate Kind = "intermediate"
+)
+
+// Subdirectories of the trust store, by kind
+var kindDirs = map[Kind]string{Root: "roots", Intermediate: "intermediates"}
+
+// Minimum length of the fingerprint prefix a CA is designated by
+const minFingerprintLen = 8
+
+// === Trust store =============================================================
+
+// Entry is a CA of the trust store.
+type Entry struct {
+	Kind Kind
+	// SHA-256 of the DER certificate, in hex
+	Fingerprint string
+	Cert        *x509.Certificate
+}
+
+func (e *Entry) String() string {
+	return fmt.Sprintf("%-12s %s %q (valid %s to %s)", e.Kind, e.Fingerprint[:16],
+		e.Cert.Subject.String(), e.Cert.NotBefore.Format(time.RFC3339), e.Cert.NotAfter.Format(time.RFC3339))
+}
+
+// Store is a trust store, as read from its directory.
+type Store struct {
+	Dir string
+	// Roots first, then intermediates, each by subject
+	Entries []*Entry
+}
+
+// Open reads the trust store of a directory. A directory that does not exist
+// is an empty trust store.
+func Open(
+	dir string, // IN
+) (*Store, error) {
+
+	s := &Store{Dir: dir, Entries: []*Entry{}}
+	for _, kind := range []Kind{Root, Intermediate} {
+		files, err := ioutil.ReadDir(filepath.Join(dir, kindDirs[kind]))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("ioutil.ReadDir() failed: %w", err)
+		}
+		for _, file := range files {
+			if file.IsDir() || !strings.HasSuffix(file.Name(), ".crt") {
+				continue
+			}
+			path := filepath.Join(dir, kindDirs[kind], file.Name())
+			data, err := lib.Read(path)
+			if err != nil {
+				return nil, err
+			}
+			block, _ := pem.Decode(data)
+			if block == nil || block.Type != "CERTIFICATE" {
+				return nil, fmt.Errorf("%s: no PEM certificate", path)
+			}
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("%s: x509.ParseCertificate() failed: %w", path, err)
+			}
+			s.Entries = append(s.Entries, &Entry{Kind: kind, Fingerprint: Fingerprint(cert), Cert: cert})
+		}
+	}
+	s.sort()
+
+	return s, nil
+}
+
+// Import adds the CA certificates of PEM data to the trust store: roots if
+// self-signed, intermediates otherwise. CAs already there are left as is.
+// The entries of all the certificates are returned.
+func (s *Store) Import(
+	pemData []byte, // IN
+) ([]*Entry, error) {
+
+	entries := []*Entry{}
+	for block, rest := pem.Decode(pemData); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("x509.ParseCertificate() failed: %w", err)
+		}
+		if !cert.BasicConstraintsValid || !cert.IsCA {
+			return nil, fmt.Errorf("%q is not a CA certificate", cert.Subject.String())
+		}
+
+		entry := s.Lookup(cert)
+		if entry == nil {
+			entry = &Entry{Kind: Intermediate, Fingerprint: Fingerprint(cert), Cert: cert}
+			if selfSigned(cert) {
+				entry.Kind = Root
+			}
+			dir := filepath.Join(s.Dir, kindDirs[entry.Kind])
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				return nil, fmt.Errorf("os.MkdirAll() failed: %w", err)
+			}
+			certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+			err = lib.Write(filepath.Join(dir, entry.Fingerprint+".crt"), certPEM, 0644)
+			if err != nil {
+				return nil, err
+			}
+			s.Entries = append(s.Entries, entry)
+		}
+		entries = append(entries, entry)
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("no PEM certificate to import")
+	}
+	s.sort()
+
+	return entries, nil
+}
+
+// Remove removes the CA a fingerprint, or a prefix of it, designates.
+func (s *Store) Remove(
+	fingerprint string, // IN
+) (*Entry, error) {
+
+	fingerprint = strings.ToLower(fingerprint)
+	if len(fingerprint) < minFingerprintLen {
+		return nil, fmt.Errorf("fingerprint %q is too short, expected at least %d hex digits",
+			fingerprint, minFingerprintLen)
+	}
+	var found []int
+	for k, e := range s.Entries {
+		if strings.HasPrefix(e.Fingerprint, fingerprint) {
+			found = append(found, k)
+		}
+	}
+	switch {
+	case len(found) == 0:
+		return nil, fmt.Errorf("no CA of fingerprint %s", fingerprint)
+	case len(found) > 1:
+		return nil, fmt.Errorf("%d CAs of fingerprint %s", len(found), fingerprint)
+	}
+
+	entry := s.Entries[found[0]]
+	path := filepath.Join(s.Dir, kindDirs[entry.Kind], entry.Fingerprint+".crt")
+	if err := os.Remove(path); err != nil {
+		return nil, fmt.Errorf("os.Remove() failed: %w", err)
+	}
+	lib.Comment("Removed %s", path)
+	s.Entries = append(s.Entries[:found[0]], s.Entries[found[0]+1:]...)
+
+	return entry, nil
+}
+
+// Lookup returns the entry of a certificate, if it is in the trust store.
+func (s *Store) Lookup(
+	cert *x509.Certificate, // IN
+) *Entry {
+
+	for _, e := range s.Entries {
+		if bytes.Equal(e.Cert.Raw, cert.Raw) {
+			return e
+		}
+	}
+	return nil
+}
+
+func (s *Store) sort() {
+	sort.SliceStable(s.Entries, func(i, j int) bool {
+		if s.Entries[i].Kind != s.Entries[j].Kind {
+			return s.Entries[i].Kind == Root
+		}
+		return s.Entries[i].Cert.Subject.String() < s.Entries[j].Cert.Subject.String()
+	})
+}
+
+// Fingerprint returns the SHA-256 of a DER certificate, in hex.
+func Fingerprint(
+	cert *x509.Certificate, // IN
+) string {
+
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// selfSigned tells whether a certificate is issued by, and signed with the
+// key of, its subject.
+func selfSigned(cert *x509.Certificate) bool {
+	return bytes.Equal(cert.RawSubject, cert.RawIssuer) && cert.CheckSignatureFrom(cert) == nil
+}
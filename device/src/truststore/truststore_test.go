@@ -0,0 +1,272 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package truststore
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"main/src/certs"
+)
+
+var now = time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+
+// testCert is a certificate of a test chain, with its key.
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// issue issues a certificate from a template, signed by a parent (or self-
+// signed), valid for a year around now unless the template tells otherwise.
+func issue(t *testing.T, template *x509.Certificate, parent *testCert) *testCert {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("ecdsa.GenerateKey() failed: %v", err)
+	}
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	if template.NotBefore.IsZero() {
+		template.NotBefore = now.AddDate(0, -6, 0)
+		template.NotAfter = now.AddDate(0, 6, 0)
+	}
+	signer, signerCert := key, template
+	if parent != nil {
+		signer, signerCert = parent.key, parent.cert
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signerCert, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatalf("x509.CreateCertificate() failed: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("x509.ParseCertificate() failed: %v", err)
+	}
+	return &testCert{cert, key}
+}
+
+func ca(name string) *x509.Certificate {
+	return &x509.Certificate{
+		Subject:               pkix.Name{CommonName: name},
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+}
+
+// ekCert is the template of an EK certificate, with an empty subject and a
+// critical TCG subject alternative name.
+func ekCert(t *testing.T) *x509.Certificate {
+	t.Helper()
+
+	san, err := certs.CreateSubjectAltName([]byte("id:494E5443"), []byte("TPM"), []byte("id:00010002"))
+	if err != nil {
+		t.Fatalf("CreateSubjectAltName() failed: %v", err)
+	}
+	return &x509.Certificate{
+		KeyUsage:           x509.KeyUsageKeyAgreement,
+		UnknownExtKeyUsage: []asn1.ObjectIdentifier{certs.OIDEKCertificate},
+		ExtraExtensions:    []pkix.Extension{*san},
+	}
+}
+
+func pemOf(certs ...*testCert) []byte {
+	data := []byte{}
+	for _, c := range certs {
+		data = append(data, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw})...)
+	}
+	return data
+}
+
+func TestImportListRemove(t *testing.T) {
+	root := issue(t, ca("Vendor Root CA"), nil)
+	intermediate := issue(t, ca("Vendor EK CA"), root)
+	leaf := issue(t, ekCert(t), intermediate)
+
+	dir := t.TempDir()
+	store, err := Open(dir)
+	if err != nil || len(store.Entries) != 0 {
+		t.Fatalf("Open() returned %v, %v, expected an empty trust store", store, err)
+	}
+	entries, err := store.Import(pemOf(intermediate, root))
+	if err != nil {
+		t.Fatalf("Import() failed: %v", err)
+	}
+	if len(entries) != 2 || entries[0].Kind != Intermediate || entries[1].Kind != Root {
+		t.Errorf("Import() returned %v, expected an intermediate and a root", entries)
+	}
+	if _, err := store.Import(pemOf(root)); err != nil {
+		t.Errorf("Import() failed for a CA already there: %v", err)
+	}
+	if _, err := store.Import(pemOf(leaf)); err == nil {
+		t.Errorf("Import() accepted a certificate that is not a CA")
+	}
+
+	// The trust store reads back as it was left
+	store, err = Open(dir)
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	if len(store.Entries) != 2 || store.Entries[0].Kind != Root || store.Entries[1].Kind != Intermediate {
+		t.Fatalf("Open() listed %v, expected a root then an intermediate", store.Entries)
+	}
+
+	if _, err := store.Remove(Fingerprint(root.cert)[:4]); err == nil {
+		t.Errorf("Remove() accepted a fingerprint prefix of 4 digits")
+	}
+	if _, err := store.Remove(Fingerprint(root.cert)[:8]); err != nil {
+		t.Errorf("Remove() failed: %v", err)
+	}
+	if store, err = Open(dir); err != nil || len(store.Entries) != 1 || store.Entries[0].Kind != Intermediate {
+		t.Errorf("Open() listed %v (%v) after Remove(), expected the intermediate", store.Entries, err)
+	}
+}
+
+func TestVerifyEKCert(t *testing.T) {
+	root := issue(t, ca("Vendor Root CA"), nil)
+	intermediate := issue(t, ca("Vendor EK CA"), root)
+	leaf := issue(t, ekCert(t), intermediate)
+
+	otherRoot := issue(t, ca("Vendor Root CA"), nil)
+	expired := ca("Vendor EK CA")
+	expired.NotBefore, expired.NotAfter = now.AddDate(-2, 0, 0), now.AddDate(-1, 0, 0)
+	expiredIntermediate := issue(t, expired, root)
+	notCA := ca("Vendor EK CA")
+	notCA.IsCA = false
+	notCAIntermediate := issue(t, notCA, root)
+	noEKU := ekCert(t)
+	noEKU.UnknownExtKeyUsage = nil
+
+	for _, tc := range []struct {
+		name          string
+		trusted       []*testCert
+		ekCert        *testCert
+		intermediates []*testCert
+		depth         int
+		err           error
+	}{
+		{"intermediate shipped", []*testCert{root}, leaf, []*testCert{intermediate}, 0, nil},
+		{"intermediate trusted", []*testCert{root, intermediate}, leaf, nil, 0, nil},
+		{"intermediate missing", []*testCert{root}, leaf, nil, 0, ErrNoIssuer},
+		{"root missing", []*testCert{otherRoot}, leaf, []*testCert{intermediate}, 1, ErrBadSignature},
+		{"root shipped", nil, leaf, []*testCert{intermediate, root}, 2, ErrUntrustedRoot},
+		{"intermediate expired", []*testCert{root}, issue(t, ekCert(t), expiredIntermediate),
+			[]*testCert{expiredIntermediate}, 1, ErrExpired},
+		{"intermediate not a CA", []*testCert{root}, issue(t, ekCert(t), notCAIntermediate),
+			[]*testCert{notCAIntermediate}, 0, ErrNotCA},
+		{"no EKU", []*testCert{root, intermediate}, issue(t, noEKU, intermediate), nil, 0, ErrNotEKCert},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			store, err := Open(t.TempDir())
+			if err != nil {
+				t.Fatalf("Open() failed: %v", err)
+			}
+			for _, c := range tc.trusted {
+				if _, err := store.Import(pemOf(c)); err != nil {
+					t.Fatalf("Import() failed: %v", err)
+				}
+			}
+			intermediates := []*x509.Certificate{}
+			for _, c := range tc.intermediates {
+				intermediates = append(intermediates, c.cert)
+			}
+
+			chain, err := store.VerifyEKCert(tc.ekCert.cert, intermediates, now)
+			if tc.err == nil {
+				if err != nil || len(chain) != 3 {
+					t.Errorf("VerifyEKCert() returned a chain of %d certificates, %v, expected 3", len(chain), err)
+				}
+				return
+			}
+			var linkErr *LinkError
+			if !errors.Is(err, tc.err) || !errors.As(err, &linkErr) || linkErr.Depth != tc.depth {
+				t.Errorf("VerifyEKCert() returned %v, expected %v at link #%d", err, tc.err, tc.depth)
+			}
+		})
+	}
+
+	// Chains are only valid within the validity of all their certificates
+	store, _ := Open(t.TempDir())
+	store.Import(pemOf(root, intermediate))
+	_, err := store.VerifyEKCert(leaf.cert, nil, now.AddDate(2, 0, 0))
+	if !errors.Is(err, ErrExpired) {
+		t.Errorf("VerifyEKCert() returned %v in two years, expected %v", err, ErrExpired)
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	root := issue(t, ca("Vendor Root CA"), nil)
+	id, err := certs.ParseTPMIdentity(*issue(t, ekCert(t), root).cert)
+	if err != nil {
+		t.Fatalf("ParseTPMIdentity() failed: %v", err)
+	}
+	if id.VendorCode() != "INTC" || id.VendorName() != "Intel" || id.Model != "TPM" ||
+		id.FirmwareVersion != 0x00010002 || id.Version() != "id:00010002" {
+		t.Errorf("ParseTPMIdentity() returned %s, expected Intel (id:494E5443) model \"TPM\" firmware id:00010002", id)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		policy string
+		err    error
+	}{
+		{"no policy", ``, nil},
+		{"any manufacturer", `{}`, nil},
+		{"vendor code", `{"manufacturers": {"IFX": {}, "INTC": {}}}`, nil},
+		{"vendor name", `{"manufacturers": {"intel": {"min-firmware-version": "id:00010002"}}}`, nil},
+		{"vendor ID", `{"manufacturers": {"id:494E5443": {"min-firmware-version": "00010001"}}}`, nil},
+		{"other manufacturer", `{"manufacturers": {"IFX": {}}}`, ErrManufacturerNotAllowed},
+		{"old firmware", `{"manufacturers": {"INTC": {"min-firmware-version": "id:00010003"}}}`, ErrFirmwareTooOld},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			store, err := Open(t.TempDir())
+			if err != nil {
+				t.Fatalf("Open() failed: %v", err)
+			}
+			if tc.policy != "" {
+				if err := os.WriteFile(filepath.Join(store.Dir, "policy.json"), []byte(tc.policy), 0644); err != nil {
+					t.Fatalf("os.WriteFile() failed: %v", err)
+				}
+			}
+			policy, err := store.ReadPolicy()
+			if err != nil {
+				t.Fatalf("ReadPolicy() failed: %v", err)
+			}
+			if err := policy.Check(id); !errors.Is(err, tc.err) {
+				t.Errorf("Check() returned %v, expected %v", err, tc.err)
+			}
+		})
+	}
+
+	// Malformed policies and SANs are rejected
+	bad := &Policy{Manufacturers: map[string]ManufacturerPolicy{"INTC": {MinFirmwareVersion: "7.85"}}}
+	if err := bad.Validate(); err == nil {
+		t.Errorf("Validate() accepted min-firmware-version \"7.85\"")
+	}
+	for _, values := range [][3]string{
+		{"id: Intel", "TPM", "id:00010002"},
+		{"id:494E5443", "TPM", "1.2"},
+	} {
+		template := ekCert(t)
+		san, err := certs.CreateSubjectAltName([]byte(values[0]), []byte(values[1]), []byte(values[2]))
+		if err != nil {
+			t.Fatalf("CreateSubjectAltName() failed: %v", err)
+		}
+		template.ExtraExtensions = []pkix.Extension{*san}
+		if _, err := certs.ParseTPMIdentity(*issue(t, template, root).cert); err == nil {
+			t.Errorf("ParseTPMIdentity() accepted SAN %q", values)
+		}
+	}
+}
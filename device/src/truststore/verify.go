@@ -0,0 +1,177 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package truststore
+
+import (
+	"bytes"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"time"
+
+	"main/src/certs"
+)
+
+// Longest chain built, from the EK certificate to a root
+const maxChainLen = 8
+
+// Link failures, that LinkError wraps
+var (
+	// No certificate of the trust store, nor any of those shipped with the
+	// EK certificate, is issued to the issuer of the certificate
+	ErrNoIssuer = errors.New("issuer not found")
+	// The certificate is self-signed, but not a root of the trust store
+	ErrUntrustedRoot = errors.New("self-signed, but not a root of the trust store")
+	ErrNotYetValid   = errors.New("not yet valid")
+	ErrExpired       = errors.New("expired")
+	// The issuer is not a CA, or may not sign certificates
+	ErrNotCA        = errors.New("issuer is not a CA")
+	ErrBadSignature = errors.New("bad signature")
+	// The EK certificate lacks the TCG EK certificate extended key usage
+	ErrNotEKCert = errors.New("not an EK certificate")
+	// The certificate has a critical extension it cannot be checked against
+	ErrUnhandledExtension = errors.New("unhandled critical extension")
+	ErrChainTooLong       = errors.New("chain too long")
+)
+
+// LinkError tells which link of a chain fails.
+type LinkError struct {
+	// Position of the certificate in the chain: 0 for the EK certificate, 1
+	// for its issuer, and so on
+	Depth   int
+	Subject string
+	Issuer  string
+	Err     error
+}
+
+func (e *LinkError) Error() string {
+	subject := "EK certificate"
+	if e.Depth > 0 {
+		subject = fmt.Sprintf("CA %q", e.Subject)
+	}
+	return fmt.Sprintf("link #%d (%s issued by %q): %v", e.Depth, subject, e.Issuer, e.Err)
+}
+
+func (e *LinkError) Unwrap() error {
+	return e.Err
+}
+
+// === Chain validation ========================================================
+
+// VerifyEKCert builds a chain from an EK certificate up to a root of the trust
+// store, through its intermediates and those shipped with the EK certificate,
+// and returns it, EK certificate first. Every certificate must be valid at a
+// given time, and the EK certificate must have the TCG EK certificate
+// extended key usage. If no chain holds, the error of the deepest link that
+// fails is returned, as a *LinkError.
+func (s *Store) VerifyEKCert(
+	ekCert *x509.Certificate, // IN
+	intermediates []*x509.Certificate, // IN
+	at time.Time, // IN
+) ([]*x509.Certificate, error) {
+
+	hasEKU := false
+	for _, eku := range ekCert.UnknownExtKeyUsage {
+		hasEKU = hasEKU || eku.Equal(certs.OIDEKCertificate)
+	}
+	if !hasEKU {
+		return nil, link(0, ekCert, fmt.Errorf("%w: no extended key usage %v", ErrNotEKCert, certs.OIDEKCertificate))
+	}
+
+	candidates := append([]*x509.Certificate{}, intermediates...)
+	for _, e := range s.Entries {
+		candidates = append(candidates, e.Cert)
+	}
+	return s.chain(ekCert, 0, candidates, at, map[string]bool{})
+}
+
+// chain builds the chain of a certificate at some depth, trying its
+// candidate issuers in turn.
+func (s *Store) chain(
+	cert *x509.Certificate,
+	depth int,
+	candidates []*x509.Certificate,
+	at time.Time,
+	visited map[string]bool,
+) ([]*x509.Certificate, error) {
+
+	if at.Before(cert.NotBefore) {
+		return nil, link(depth, cert, fmt.Errorf("%w: until %s", ErrNotYetValid, cert.NotBefore.Format(time.RFC3339)))
+	}
+	if at.After(cert.NotAfter) {
+		return nil, link(depth, cert, fmt.Errorf("%w: since %s", ErrExpired, cert.NotAfter.Format(time.RFC3339)))
+	}
+	if err := checkCriticalExtensions(cert, depth); err != nil {
+		return nil, link(depth, cert, err)
+	}
+	if e := s.Lookup(cert); e != nil && e.Kind == Root {
+		return []*x509.Certificate{cert}, nil
+	}
+	if selfSigned(cert) {
+		return nil, link(depth, cert, ErrUntrustedRoot)
+	}
+	if depth+1 >= maxChainLen {
+		return nil, link(depth, cert, fmt.Errorf("%w: %d certificates", ErrChainTooLong, depth+1))
+	}
+
+	visited[Fingerprint(cert)] = true
+	defer delete(visited, Fingerprint(cert))
+
+	var linkErr *LinkError
+	for _, issuer := range candidates {
+		if !bytes.Equal(issuer.RawSubject, cert.RawIssuer) || visited[Fingerprint(issuer)] {
+			continue
+		}
+		var err error
+		switch {
+		case !issuer.BasicConstraintsValid || !issuer.IsCA:
+			err = link(depth, cert, ErrNotCA)
+		case issuer.KeyUsage != 0 && issuer.KeyUsage&x509.KeyUsageCertSign == 0:
+			err = link(depth, cert, fmt.Errorf("%w: may not sign certificates", ErrNotCA))
+		default:
+			if err = cert.CheckSignatureFrom(issuer); err != nil {
+				err = link(depth, cert, fmt.Errorf("%w: %v", ErrBadSignature, err))
+			}
+		}
+		if err == nil {
+			var chain []*x509.Certificate
+			chain, err = s.chain(issuer, depth+1, candidates, at, visited)
+			if err == nil {
+				return append([]*x509.Certificate{cert}, chain...), nil
+			}
+		}
+		var e *LinkError
+		if errors.As(err, &e) && (linkErr == nil || e.Depth > linkErr.Depth) {
+			linkErr = e
+		}
+	}
+	if linkErr == nil {
+		return nil, link(depth, cert, ErrNoIssuer)
+	}
+
+	return nil, linkErr
+}
+
+// checkCriticalExtensions fails on the critical extensions Go does not
+// handle, but for the subject alternative name of EK certificates, whose TCG
+// directory name it does not decode.
+func checkCriticalExtensions(
+	cert *x509.Certificate,
+	depth int,
+) error {
+
+	for _, oid := range cert.UnhandledCriticalExtensions {
+		if depth == 0 && oid.Equal(certs.OIDSubjectAltName) {
+			if err := certs.CheckDirectoryNameSAN(*cert); err != nil {
+				return fmt.Errorf("%w: subject alternative name: %v", ErrUnhandledExtension, err)
+			}
+			continue
+		}
+		return fmt.Errorf("%w: %v", ErrUnhandledExtension, oid)
+	}
+	return nil
+}
+
+func link(depth int, cert *x509.Certificate, err error) *LinkError {
+	return &LinkError{Depth: depth, Subject: cert.Subject.String(), Issuer: cert.Issuer.String(), Err: err}
+}
@@ -0,0 +1,67 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package uefi
+
+import (
+	"crypto"
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+// === Authenticode ============================================================
+
+// Index of the certificate table among the data directories
+const certificateTable = 4
+
+// AuthenticodeHash computes the Authenticode digest of a PE image, which the
+// firmware (or shim) measures when loading an EFI application. It hashes the
+// headers but for the checksum and the certificate table entry, then the
+// sections by file offset, then any trailing data but for the certificate
+// table, as EDK2 does.
+func AuthenticodeHash(
+	image []byte, // IN
+	hash crypto.Hash, // IN
+) ([]byte, error) {
+
+	p, err := parsePE(image)
+	if err != nil {
+		return nil, err
+	}
+
+	h := hash.New()
+	h.Write(image[:p.checksumOffset])
+	certificateTableSize := 0
+	if p.numberOfRvaAndSizes > certificateTable {
+		entryOffset := p.dataDirectoriesOffset + 8*certificateTable
+		if entryOffset+8 > p.sizeOfHeaders || entryOffset+8 > p.optOffset+p.sizeOfOptionalHeader {
+			return nil, fmt.Errorf("certificate table entry is out of the headers")
+		}
+		certificateTableSize = int(binary.LittleEndian.Uint32(image[entryOffset+4:]))
+		h.Write(image[p.checksumOffset+4 : entryOffset])
+		h.Write(image[entryOffset+8 : p.sizeOfHeaders])
+	} else {
+		h.Write(image[p.checksumOffset+4 : p.sizeOfHeaders])
+	}
+
+	// Sections, by file offset
+	sections := []peSection{}
+	for _, s := range p.sections {
+		if s.size != 0 {
+			sections = append(sections, s)
+		}
+	}
+	sort.SliceStable(sections, func(i, j int) bool { return sections[i].offset < sections[j].offset })
+	sumOfBytesHashed := p.sizeOfHeaders
+	for _, s := range sections {
+		h.Write(image[s.offset : s.offset+s.size])
+		sumOfBytesHashed += s.size
+	}
+
+	// Trailing data, the signatures excepted
+	if len(image) > sumOfBytesHashed+certificateTableSize {
+		h.Write(image[sumOfBytesHashed : len(image)-certificateTableSize])
+	}
+
+	return h.Sum(nil), nil
+}
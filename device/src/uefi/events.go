@@ -0,0 +1,192 @@
+// SPDX-License-Identifier: Apache-2.0
+
+// Package uefi predicts the measurements UEFI firmware, shim and systemd-stub
+// make while booting, from the assets the CICD builds: the EFI applications
+// (PCR 4), the Secure Boot configuration (PCR 7) and the sections of a Unified
+// Kernel Image (PCR 11). It computes PE Authenticode digests and encodes EFI
+// variables and signature lists as firmware measures them.
+package uefi
+
+import (
+	"crypto"
+	"fmt"
+)
+
+// === Events ==================================================================
+
+// Event types, see the TCG PC Client Platform Firmware Profile
+const (
+	EvSeparator                  = 0x00000004
+	EvIPL                        = 0x0000000d
+	EvEFIVariableDriverConfig    = 0x80000001
+	EvEFIBootServicesApplication = 0x80000003
+	EvEFIAction                  = 0x80000007
+	EvEFIVariableAuthority       = 0x800000e0
+)
+
+// EV_EFI_ACTION firmware measures before starting a boot option
+const callingEFIApplicationFromBoot = "Calling EFI Application from Boot Option"
+
+// Event is a predicted measurement.
+type Event struct {
+	PCR         int
+	Type        uint32
+	Description string
+	// What the digest is the hash of: the event data, or the image of an
+	// EV_EFI_BOOT_SERVICES_APPLICATION event
+	data  []byte
+	image []byte
+}
+
+// Digest returns the digest the event extends its PCR with.
+func (e *Event) Digest(
+	hash crypto.Hash, // IN
+) ([]byte, error) {
+
+	if e.image != nil {
+		digest, err := AuthenticodeHash(e.image, hash)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", e.Description, err)
+		}
+		return digest, nil
+	}
+	h := hash.New()
+	h.Write(e.data)
+	return h.Sum(nil), nil
+}
+
+// Boot describes what a device boots, as the CICD built it.
+type Boot struct {
+	// EFI applications, in the order they are loaded and measured into
+	// PCR 4, e.g. shim, GRUB and the kernel, or a UKI
+	Applications []Application
+	// Whether firmware measures EV_EFI_ACTION "Calling EFI Application from
+	// Boot Option" before the first application, as most do
+	BootOptionAction bool
+	// Secure Boot configuration: the SecureBoot variable, and the content
+	// of PK, KEK, db and dbx (EFI_SIGNATURE_LISTs)
+	SecureBoot       bool
+	PK, KEK, DB, DBX []byte
+	// Entries the applications are verified with, in the order they are
+	// measured into PCR 7
+	Authorities []Authority
+}
+
+// Application is an EFI application image.
+type Application struct {
+	Name  string
+	Image []byte
+}
+
+// Events lists the measurements of a boot into PCR 4 and PCR 7, in the
+// order firmware and shim make them.
+func (b *Boot) Events() []Event {
+	events := []Event{}
+	separator := func(pcr int) Event {
+		return Event{PCR: pcr, Type: EvSeparator, Description: "separator", data: []byte{0, 0, 0, 0}}
+	}
+
+	// PCR 7: Secure Boot configuration, then the authorities the images are
+	// verified with
+	secureBoot := []byte{0}
+	if b.SecureBoot {
+		secureBoot = []byte{1}
+	}
+	for _, v := range []struct {
+		vendor GUID
+		name   string
+		data   []byte
+	}{
+		{GlobalVariable, "SecureBoot", secureBoot},
+		{GlobalVariable, "PK", b.PK},
+		{GlobalVariable, "KEK", b.KEK},
+		{ImageSecurityDatabase, "db", b.DB},
+		{ImageSecurityDatabase, "dbx", b.DBX},
+	} {
+		events = append(events, Event{
+			PCR:         7,
+			Type:        EvEFIVariableDriverConfig,
+			Description: v.name,
+			data:        VariableData(v.vendor, v.name, v.data),
+		})
+	}
+	events = append(events, separator(7))
+
+	// PCR 4: boot option, then applications
+	if b.BootOptionAction {
+		events = append(events, Event{
+			PCR:         4,
+			Type:        EvEFIAction,
+			Description: callingEFIApplicationFromBoot,
+			data:        []byte(callingEFIApplicationFromBoot),
+		})
+	}
+	events = append(events, separator(4))
+	for _, a := range b.Applications {
+		events = append(events, Event{
+			PCR:         4,
+			Type:        EvEFIBootServicesApplication,
+			Description: a.Name,
+			image:       a.Image,
+		})
+	}
+
+	for _, a := range b.Authorities {
+		events = append(events, Event{
+			PCR:         7,
+			Type:        EvEFIVariableAuthority,
+			Description: a.Name,
+			data:        VariableData(a.Vendor, a.Name, a.Data),
+		})
+	}
+
+	return events
+}
+
+// Replay computes the PCR values a list of events leads to, from PCRs reset
+// to zeros.
+func Replay(
+	events []Event, // IN
+	hash crypto.Hash, // IN
+) (map[int][]byte, error) {
+
+	pcrs := map[int][]byte{}
+	for k := range events {
+		e := &events[k]
+		digest, err := e.Digest(hash)
+		if err != nil {
+			return nil, err
+		}
+		value, ok := pcrs[e.PCR]
+		if !ok {
+			value = make([]byte, hash.Size())
+		}
+		h := hash.New()
+		h.Write(value)
+		h.Write(digest)
+		pcrs[e.PCR] = h.Sum(nil)
+	}
+	return pcrs, nil
+}
+
+// TypeName names an event type, e.g. "EV_SEPARATOR".
+func TypeName(
+	typ uint32, // IN
+) string {
+
+	switch typ {
+	case EvSeparator:
+		return "EV_SEPARATOR"
+	case EvIPL:
+		return "EV_IPL"
+	case EvEFIVariableDriverConfig:
+		return "EV_EFI_VARIABLE_DRIVER_CONFIG"
+	case EvEFIBootServicesApplication:
+		return "EV_EFI_BOOT_SERVICES_APPLICATION"
+	case EvEFIAction:
+		return "EV_EFI_ACTION"
+	case EvEFIVariableAuthority:
+		return "EV_EFI_VARIABLE_AUTHORITY"
+	}
+	return fmt.Sprintf("0x%08x", typ)
+}
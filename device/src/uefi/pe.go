@@ -0,0 +1,115 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package uefi
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// === PE images ===============================================================
+
+// Optional header magics
+const (
+	pe32Magic     = 0x10b
+	pe32PlusMagic = 0x20b
+)
+
+// peImage locates the headers of a PE image.
+type peImage struct {
+	image []byte
+	// Offsets of the optional header, its CheckSum field and its data
+	// directories
+	optOffset             int
+	checksumOffset        int
+	dataDirectoriesOffset int
+	sizeOfOptionalHeader  int
+	numberOfRvaAndSizes   int
+	sizeOfHeaders         int
+	sections              []peSection
+}
+
+// peSection is an entry of the section table.
+type peSection struct {
+	name        string
+	virtualSize int
+	// Where the section is in the file
+	offset int
+	size   int
+}
+
+// in tells whether a range lies within the image.
+func (p *peImage) in(
+	offset int, // IN
+	size int, // IN
+) bool {
+
+	return offset >= 0 && size >= 0 && offset+size <= len(p.image)
+}
+
+// parsePE reads the headers and the section table of a PE32 or PE32+ image,
+// checking they lie within the image.
+func parsePE(
+	image []byte, // IN
+) (*peImage, error) {
+
+	le := binary.LittleEndian
+	p := &peImage{image: image}
+
+	// DOS header, PE signature and COFF header
+	if !p.in(0, 0x40) || image[0] != 'M' || image[1] != 'Z' {
+		return nil, fmt.Errorf("no DOS header")
+	}
+	peOffset := int(le.Uint32(image[0x3c:]))
+	if !p.in(peOffset, 24) || string(image[peOffset:peOffset+4]) != "PE\x00\x00" {
+		return nil, fmt.Errorf("no PE signature at 0x%x", peOffset)
+	}
+	numberOfSections := int(le.Uint16(image[peOffset+6:]))
+	p.sizeOfOptionalHeader = int(le.Uint16(image[peOffset+20:]))
+
+	// Optional header
+	p.optOffset = peOffset + 24
+	if !p.in(p.optOffset, p.sizeOfOptionalHeader) || p.sizeOfOptionalHeader < 2 {
+		return nil, fmt.Errorf("optional header is truncated")
+	}
+	var numberOfRvaAndSizesOffset int
+	switch magic := le.Uint16(image[p.optOffset:]); magic {
+	case pe32Magic:
+		numberOfRvaAndSizesOffset, p.dataDirectoriesOffset = p.optOffset+92, p.optOffset+96
+	case pe32PlusMagic:
+		numberOfRvaAndSizesOffset, p.dataDirectoriesOffset = p.optOffset+108, p.optOffset+112
+	default:
+		return nil, fmt.Errorf("unknown optional header magic 0x%x", magic)
+	}
+	if p.dataDirectoriesOffset > p.optOffset+p.sizeOfOptionalHeader {
+		return nil, fmt.Errorf("optional header is truncated")
+	}
+	p.checksumOffset = p.optOffset + 64
+	p.sizeOfHeaders = int(le.Uint32(image[p.optOffset+60:]))
+	p.numberOfRvaAndSizes = int(le.Uint32(image[numberOfRvaAndSizesOffset:]))
+	if !p.in(0, p.sizeOfHeaders) || p.sizeOfHeaders < p.dataDirectoriesOffset {
+		return nil, fmt.Errorf("SizeOfHeaders 0x%x is out of range", p.sizeOfHeaders)
+	}
+
+	// Section table
+	sectionsOffset := p.optOffset + p.sizeOfOptionalHeader
+	if !p.in(sectionsOffset, 40*numberOfSections) {
+		return nil, fmt.Errorf("section table is truncated")
+	}
+	for k := 0; k < numberOfSections; k++ {
+		header := image[sectionsOffset+40*k:]
+		s := peSection{
+			name:        strings.TrimRight(string(header[:8]), "\x00"),
+			virtualSize: int(le.Uint32(header[8:])),
+			offset:      int(le.Uint32(header[20:])),
+			size:        int(le.Uint32(header[16:])),
+		}
+		if s.size != 0 && !p.in(s.offset, s.size) {
+			return nil, fmt.Errorf("section #%d is out of the image", k)
+		}
+		p.sections = append(p.sections, s)
+	}
+
+	return p, nil
+}
@@ -0,0 +1,251 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package uefi
+
+import (
+	"bytes"
+	"crypto"
+	_ "crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"testing"
+)
+
+// Digests logged by a Google Shielded VM booting Ubuntu 21.04 (see the
+// go-attestation test data)
+func TestEventDigests(t *testing.T) {
+	b := Boot{
+		BootOptionAction: true,
+		Authorities:      []Authority{SbatLevelAuthority([]byte("sbat,1,2021030218\n"))},
+	}
+	expected := map[string]string{
+		"SecureBoot":                  "115aa827dbccfb44d216ad9ecfda56bdea620b860a94bed5b7a27bba1c4d02d8",
+		"separator":                   "df3f619804a92fdb4057192dc43dd748ea778adc52bc498ce80524c014b81119",
+		callingEFIApplicationFromBoot: "3d6772b4f84ed47595d72a2c4c5ffd15f5bb72c7507fe26f2aaee2c69d5633ba",
+		"SbatLevel":                   "922e939a5565798a5ef12fe09d8b49bf951a8e7f89a0cca7a51636693d41a34d",
+	}
+	for _, e := range b.Events() {
+		digest, err := e.Digest(crypto.SHA256)
+		if err != nil {
+			t.Fatalf("Digest() failed: %v", err)
+		}
+		if want, ok := expected[e.Description]; ok && hex.EncodeToString(digest) != want {
+			t.Errorf("%s PCR[%d] %s digest is %x, expected %s", TypeName(e.Type), e.PCR, e.Description, digest, want)
+		}
+	}
+
+	// Secure Boot enabled
+	b.SecureBoot = true
+	digest, err := b.Events()[0].Digest(crypto.SHA256)
+	if err != nil || hex.EncodeToString(digest) != "ccfc4bb32888a345bc8aeadaba552b627d99348c767681ab3141f5b01e40a40e" {
+		t.Errorf("SecureBoot digest is %x, %v", digest, err)
+	}
+}
+
+func TestSignatureLists(t *testing.T) {
+	owner := MustParseGUID("77fa9abd-0359-4d32-bd60-28f4e78f784b")
+	if owner.String() != "77fa9abd-0359-4d32-bd60-28f4e78f784b" {
+		t.Errorf("String() returned %s", owner)
+	}
+
+	// A list of two certificates, then a list of one image digest
+	var db bytes.Buffer
+	list := func(typ GUID, signatures ...[]byte) {
+		db.Write(typ[:])
+		binary.Write(&db, binary.LittleEndian, []uint32{
+			uint32(28 + len(signatures)*(16+len(signatures[0]))), 0, uint32(16 + len(signatures[0]))})
+		for _, s := range signatures {
+			db.Write(owner[:])
+			db.Write(s)
+		}
+	}
+	list(CertX509, []byte("certificate #1"), []byte("certificate #2"))
+	list(CertSHA256, make([]byte, 32))
+
+	lists, err := ParseSignatureLists(db.Bytes())
+	if err != nil {
+		t.Fatalf("ParseSignatureLists() failed: %v", err)
+	}
+	if len(lists) != 2 || len(lists[0].Signatures) != 2 || lists[1].Type != CertSHA256 {
+		t.Errorf("ParseSignatureLists() returned %v", lists)
+	}
+	authority, err := DBAuthority(db.Bytes(), []byte("certificate #2"))
+	if err != nil {
+		t.Fatalf("DBAuthority() failed: %v", err)
+	}
+	if authority.Name != "db" || !bytes.Equal(authority.Data, append(owner[:], "certificate #2"...)) {
+		t.Errorf("DBAuthority() returned %+v", authority)
+	}
+	if _, err := DBAuthority(db.Bytes(), []byte("certificate #3")); err == nil {
+		t.Errorf("DBAuthority() found a certificate db does not hold")
+	}
+	if _, err := ParseSignatureLists(db.Bytes()[:db.Len()-1]); err == nil {
+		t.Errorf("ParseSignatureLists() accepted a truncated list")
+	}
+}
+
+// testImage builds a PE32+ image with two sections, listed out of file
+// order, some trailing data and a certificate table.
+func testImage() (image []byte, checksumOffset, certificateEntryOffset, certificateOffset int) {
+	le := binary.LittleEndian
+	const (
+		peOffset      = 0x40
+		optOffset     = peOffset + 24
+		sizeOfOptHdr  = 112 + 16*8
+		sizeOfHeaders = 0x200
+	)
+	image = make([]byte, 0x600)
+	copy(image, "MZ")
+	le.PutUint32(image[0x3c:], peOffset)
+	copy(image[peOffset:], "PE\x00\x00")
+	le.PutUint16(image[peOffset+6:], 2)
+	le.PutUint16(image[peOffset+20:], sizeOfOptHdr)
+	le.PutUint16(image[optOffset:], pe32PlusMagic)
+	le.PutUint32(image[optOffset+60:], sizeOfHeaders)
+	le.PutUint32(image[optOffset+108:], 16)
+	sections := optOffset + sizeOfOptHdr
+	le.PutUint32(image[sections+16:], 0x200)    // SizeOfRawData
+	le.PutUint32(image[sections+20:], 0x400)    // PointerToRawData
+	le.PutUint32(image[sections+40+16:], 0x200) // SizeOfRawData
+	le.PutUint32(image[sections+40+20:], 0x200) // PointerToRawData
+	for i := sizeOfHeaders; i < len(image); i++ {
+		image[i] = byte(i / 0x100)
+	}
+
+	// Trailing data, then the certificate table
+	image = append(image, []byte("trailing data")...)
+	certificateOffset = len(image)
+	image = append(image, []byte("signatures")...)
+	certificateEntryOffset = optOffset + 112 + 8*certificateTable
+	le.PutUint32(image[certificateEntryOffset:], uint32(certificateOffset))
+	le.PutUint32(image[certificateEntryOffset+4:], uint32(len(image)-certificateOffset))
+
+	return image, optOffset + 64, certificateEntryOffset, certificateOffset
+}
+
+func TestAuthenticodeHash(t *testing.T) {
+	image, checksumOffset, certificateEntryOffset, certificateOffset := testImage()
+	digest, err := AuthenticodeHash(image, crypto.SHA256)
+	if err != nil {
+		t.Fatalf("AuthenticodeHash() failed: %v", err)
+	}
+
+	h := crypto.SHA256.New()
+	h.Write(image[:checksumOffset])
+	h.Write(image[checksumOffset+4 : certificateEntryOffset])
+	h.Write(image[certificateEntryOffset+8 : 0x200])
+	h.Write(image[0x200:0x400])
+	h.Write(image[0x400:0x600])
+	h.Write(image[0x600:certificateOffset])
+	if expected := h.Sum(nil); !bytes.Equal(digest, expected) {
+		t.Errorf("AuthenticodeHash() returned %x, expected %x", digest, expected)
+	}
+
+	// Signing an image changes neither its checksum nor its digest
+	signed := append([]byte{}, image...)
+	signed[checksumOffset] ^= 0xff
+	signed = append(signed, []byte(" and more signatures")...)
+	binary.LittleEndian.PutUint32(signed[certificateEntryOffset+4:], uint32(len(signed)-certificateOffset))
+	if d, err := AuthenticodeHash(signed, crypto.SHA256); err != nil || !bytes.Equal(d, digest) {
+		t.Errorf("AuthenticodeHash() of the signed image returned %x, %v, expected %x", d, err, digest)
+	}
+
+	// Code does change it
+	image[0x480] ^= 0xff
+	if d, err := AuthenticodeHash(image, crypto.SHA256); err != nil || bytes.Equal(d, digest) {
+		t.Errorf("AuthenticodeHash() ignores changes to sections")
+	}
+
+	for _, truncated := range [][]byte{image[:0x30], image[:0x100], image[:0x500]} {
+		if _, err := AuthenticodeHash(truncated, crypto.SHA256); err == nil {
+			t.Errorf("AuthenticodeHash() accepted a %d-byte truncated image", len(truncated))
+		}
+	}
+}
+
+// testPE builds a PE32+ image holding named sections, in the given order,
+// each with its raw data and virtual size.
+func testPE(sections ...testSection) []byte {
+	le := binary.LittleEndian
+	const (
+		peOffset      = 0x40
+		optOffset     = peOffset + 24
+		sizeOfOptHdr  = 112
+		sizeOfHeaders = 0x200
+	)
+	image := make([]byte, sizeOfHeaders)
+	copy(image, "MZ")
+	le.PutUint32(image[0x3c:], peOffset)
+	copy(image[peOffset:], "PE\x00\x00")
+	le.PutUint16(image[peOffset+6:], uint16(len(sections)))
+	le.PutUint16(image[peOffset+20:], sizeOfOptHdr)
+	le.PutUint16(image[optOffset:], pe32PlusMagic)
+	le.PutUint32(image[optOffset+60:], sizeOfHeaders)
+	for k, s := range sections {
+		header := image[optOffset+sizeOfOptHdr+40*k:]
+		copy(header, s.name)
+		le.PutUint32(header[8:], uint32(s.virtualSize))
+		le.PutUint32(header[16:], uint32(len(s.data)))
+		le.PutUint32(header[20:], uint32(len(image)))
+		image = append(image, s.data...)
+	}
+	return image
+}
+
+type testSection struct {
+	name        string
+	data        []byte
+	virtualSize int
+}
+
+func TestUKIEvents(t *testing.T) {
+	// .cmdline comes first in the file, but is measured after .linux; the
+	// raw data of .linux is padded to its virtual size, the one of .cmdline
+	// truncated
+	image := testPE(
+		testSection{".cmdline", []byte("console=ttyS0\x00\x00\x00"), 13},
+		testSection{".text", []byte("stub"), 4},
+		testSection{".pcrsig", []byte("{}"), 2},
+		testSection{".linux", []byte("kernel"), 8},
+	)
+	events, err := UKIEvents(image, []string{"enter-initrd"})
+	if err != nil {
+		t.Fatalf("UKIEvents() failed: %v", err)
+	}
+	expected := [][]byte{
+		[]byte(".linux\x00"), []byte("kernel\x00\x00"),
+		[]byte(".cmdline\x00"), []byte("console=ttyS0"),
+		[]byte("enter-initrd"),
+	}
+	if len(events) != len(expected) {
+		t.Fatalf("UKIEvents() returned %d events, expected %d", len(events), len(expected))
+	}
+	for k, e := range events {
+		if e.PCR != 11 || !bytes.Equal(e.data, expected[k]) {
+			t.Errorf("event #%d is PCR[%d] %q, expected PCR[11] %q", k, e.PCR, e.data, expected[k])
+		}
+	}
+
+	// As systemd-measure calculate --linux --cmdline --phase=enter-initrd
+	// computes it, from the section contents
+	pcrs, err := Replay(events, crypto.SHA256)
+	pcr11 := "4206e289c22de8058383f0104cccea8bae2bd8e57796672fed542ee61ce71ac7"
+	if err != nil || hex.EncodeToString(pcrs[11]) != pcr11 {
+		t.Errorf("Replay() returned PCR[11] %x, %v, expected %s", pcrs[11], err, pcr11)
+	}
+
+	if _, err := UKIEvents(image[:len(image)-1], nil); err == nil {
+		t.Errorf("UKIEvents() accepted a truncated image")
+	}
+}
+
+func TestVariableData(t *testing.T) {
+	data := VariableData(GlobalVariable, "SecureBoot", []byte{1})
+	vendor, name, value, err := ParseVariableData(data)
+	if err != nil || vendor != GlobalVariable || name != "SecureBoot" || !bytes.Equal(value, []byte{1}) {
+		t.Errorf("ParseVariableData() returned %s, %q, %x, %v", vendor, name, value, err)
+	}
+	if _, _, _, err := ParseVariableData(data[:len(data)-1]); err == nil {
+		t.Errorf("ParseVariableData() accepted a truncated variable")
+	}
+}
@@ -0,0 +1,67 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package uefi
+
+import (
+	"fmt"
+)
+
+// === Unified Kernel Images ===================================================
+
+// Sections of a UKI systemd-stub measures into PCR 11, in the order it
+// measures them whatever their order in the image. .pcrsig, which holds
+// signatures of PCR 11, is not measured.
+var UKISections = []string{
+	".linux", ".osrel", ".cmdline", ".initrd", ".ucode", ".splash", ".dtb", ".uname", ".sbat", ".pcrpkey",
+}
+
+// Boot phases systemd-pcrphase measures into PCR 11 by the time the system is
+// up, as systemd-measure(1) names them
+var BootedPhases = []string{"enter-initrd", "leave-initrd", "sysinit", "ready"}
+
+// UKIEvents lists the measurements into PCR 11 of booting a UKI: for each
+// section systemd-stub knows of, its name (NUL included) then its content as
+// loaded in memory, then the boot phases userspace goes through.
+func UKIEvents(
+	image []byte, // IN
+	phases []string, // IN
+) ([]Event, error) {
+
+	p, err := parsePE(image)
+	if err != nil {
+		return nil, fmt.Errorf("UKI: %w", err)
+	}
+
+	events := []Event{}
+	for _, name := range UKISections {
+		// The first section of the name counts
+		var section *peSection
+		for k := range p.sections {
+			if p.sections[k].name == name {
+				section = &p.sections[k]
+				break
+			}
+		}
+		if section == nil || section.virtualSize == 0 {
+			continue
+		}
+		// In memory, a section spans VirtualSize bytes: its raw data, padded
+		// with zeros or truncated
+		content := make([]byte, section.virtualSize)
+		raw := section.size
+		if raw > section.virtualSize {
+			raw = section.virtualSize
+		}
+		copy(content, image[section.offset:section.offset+raw])
+		events = append(events,
+			Event{PCR: 11, Type: EvIPL, Description: name, data: append([]byte(name), 0)},
+			Event{PCR: 11, Type: EvIPL, Description: name, data: content},
+		)
+	}
+
+	for _, phase := range phases {
+		events = append(events, Event{PCR: 11, Type: EvIPL, Description: phase, data: []byte(phase)})
+	}
+
+	return events, nil
+}
@@ -0,0 +1,231 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package uefi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"unicode/utf16"
+)
+
+// === GUIDs ===================================================================
+
+// GUID is an EFI_GUID, in its in-memory byte order: the first three fields
+// are little-endian.
+type GUID [16]byte
+
+var (
+	// EFI_GLOBAL_VARIABLE: SecureBoot, PK, KEK
+	GlobalVariable = MustParseGUID("8be4df61-93ca-11d2-aa0d-00e098032b8c")
+	// EFI_IMAGE_SECURITY_DATABASE_GUID: db, dbx
+	ImageSecurityDatabase = MustParseGUID("d719b2cb-3d3a-4596-a3bc-dad00e67656f")
+	// SHIM_LOCK_GUID: variables shim measures
+	ShimLock = MustParseGUID("605dab50-e046-4300-abb6-3dd810dd8b23")
+	// EFI_CERT_X509_GUID: signature lists of DER certificates
+	CertX509 = MustParseGUID("a5c059a1-94e4-4aa7-87b5-ab155c2bf072")
+	// EFI_CERT_SHA256_GUID: signature lists of image digests
+	CertSHA256 = MustParseGUID("c1c41626-504c-4092-aca9-41f936934328")
+)
+
+// ParseGUID reads a GUID in its registry format, e.g.
+// "8be4df61-93ca-11d2-aa0d-00e098032b8c".
+func ParseGUID(
+	s string, // IN
+) (GUID, error) {
+
+	var g GUID
+	fields := strings.Split(s, "-")
+	if len(fields) != 5 || len(fields[0]) != 8 || len(fields[1]) != 4 || len(fields[2]) != 4 ||
+		len(fields[3]) != 4 || len(fields[4]) != 12 {
+		return g, fmt.Errorf("malformed GUID %q", s)
+	}
+	b, err := hex.DecodeString(strings.Join(fields, ""))
+	if err != nil {
+		return g, fmt.Errorf("malformed GUID %q: %w", s, err)
+	}
+	copy(g[:], b)
+	// Data1, Data2 and Data3 are stored little-endian
+	g[0], g[1], g[2], g[3] = g[3], g[2], g[1], g[0]
+	g[4], g[5] = g[5], g[4]
+	g[6], g[7] = g[7], g[6]
+	return g, nil
+}
+
+// MustParseGUID is ParseGUID() for constants.
+func MustParseGUID(
+	s string, // IN
+) GUID {
+
+	g, err := ParseGUID(s)
+	if err != nil {
+		panic(err)
+	}
+	return g
+}
+
+func (g GUID) String() string {
+	return fmt.Sprintf("%08x-%04x-%04x-%x-%x",
+		binary.LittleEndian.Uint32(g[0:4]), binary.LittleEndian.Uint16(g[4:6]),
+		binary.LittleEndian.Uint16(g[6:8]), g[8:10], g[10:16])
+}
+
+// === Variables ===============================================================
+
+// VariableData encodes the UEFI_VARIABLE_DATA of a variable, which firmware
+// hashes into PCR 7 for EV_EFI_VARIABLE_DRIVER_CONFIG and
+// EV_EFI_VARIABLE_AUTHORITY events.
+func VariableData(
+	vendor GUID, // IN
+	name string, // IN
+	data []byte, // IN
+) []byte {
+
+	unicodeName := utf16.Encode([]rune(name))
+	var buf bytes.Buffer
+	buf.Write(vendor[:])
+	binary.Write(&buf, binary.LittleEndian, uint64(len(unicodeName)))
+	binary.Write(&buf, binary.LittleEndian, uint64(len(data)))
+	binary.Write(&buf, binary.LittleEndian, unicodeName)
+	buf.Write(data)
+	return buf.Bytes()
+}
+
+// ParseVariableData decodes a UEFI_VARIABLE_DATA, as logged with
+// EV_EFI_VARIABLE_DRIVER_CONFIG and EV_EFI_VARIABLE_AUTHORITY events.
+func ParseVariableData(
+	variableData []byte, // IN
+) (
+	vendor GUID,
+	name string,
+	data []byte,
+	err error,
+) {
+
+	le := binary.LittleEndian
+	if len(variableData) < 32 {
+		return vendor, "", nil, fmt.Errorf("UEFI_VARIABLE_DATA is truncated")
+	}
+	copy(vendor[:], variableData)
+	nameLength := le.Uint64(variableData[16:])
+	dataLength := le.Uint64(variableData[24:])
+	rest := uint64(len(variableData) - 32)
+	if nameLength > rest/2 || dataLength > rest-2*nameLength {
+		return vendor, "", nil, fmt.Errorf("UEFI_VARIABLE_DATA is truncated")
+	}
+	unicodeName := make([]uint16, nameLength)
+	for k := range unicodeName {
+		unicodeName[k] = le.Uint16(variableData[32+2*k:])
+	}
+	offset := 32 + 2*nameLength
+	return vendor, string(utf16.Decode(unicodeName)), variableData[offset : offset+dataLength], nil
+}
+
+// === Signature lists =========================================================
+
+// SignatureList is an EFI_SIGNATURE_LIST, as found in PK, KEK, db and dbx.
+type SignatureList struct {
+	Type       GUID
+	Header     []byte
+	Signatures []Signature
+}
+
+// Signature is an EFI_SIGNATURE_DATA: the owner of the entry and a
+// certificate or a digest, depending on the type of its list.
+type Signature struct {
+	Owner GUID
+	Data  []byte
+}
+
+// Bytes encodes an EFI_SIGNATURE_DATA.
+func (s *Signature) Bytes() []byte {
+	return append(append([]byte{}, s.Owner[:]...), s.Data...)
+}
+
+// ParseSignatureLists decodes the content of a signature database, e.g. a
+// .esl file as efi-updatevar(1) takes.
+func ParseSignatureLists(
+	data []byte, // IN
+) ([]SignatureList, error) {
+
+	le := binary.LittleEndian
+	lists := []SignatureList{}
+	for offset := 0; offset < len(data); {
+		// EFI_SIGNATURE_LIST header: SignatureType, SignatureListSize,
+		// SignatureHeaderSize, SignatureSize
+		if len(data)-offset < 28 {
+			return nil, fmt.Errorf("signature list #%d is truncated", len(lists))
+		}
+		var l SignatureList
+		copy(l.Type[:], data[offset:])
+		listSize := int(le.Uint32(data[offset+16:]))
+		headerSize := int(le.Uint32(data[offset+20:]))
+		signatureSize := int(le.Uint32(data[offset+24:]))
+		if listSize < 28 || listSize > len(data)-offset || headerSize < 0 || headerSize > listSize-28 ||
+			signatureSize < 16 || (listSize-28-headerSize)%signatureSize != 0 {
+			return nil, fmt.Errorf("signature list #%d is malformed", len(lists))
+		}
+		l.Header = data[offset+28 : offset+28+headerSize]
+		for p := offset + 28 + headerSize; p < offset+listSize; p += signatureSize {
+			var s Signature
+			copy(s.Owner[:], data[p:])
+			s.Data = data[p+16 : p+signatureSize]
+			l.Signatures = append(l.Signatures, s)
+		}
+		lists = append(lists, l)
+		offset += listSize
+	}
+	return lists, nil
+}
+
+// === Authorities =============================================================
+
+// Authority is a variable entry an image was verified with, which is measured
+// into PCR 7 as an EV_EFI_VARIABLE_AUTHORITY event.
+type Authority struct {
+	Vendor GUID
+	Name   string
+	Data   []byte
+}
+
+// DBAuthority returns the entry of db holding a certificate (or an image
+// digest), which firmware measures when it verifies an image with it.
+func DBAuthority(
+	db []byte, // IN
+	data []byte, // IN
+) (Authority, error) {
+
+	lists, err := ParseSignatureLists(db)
+	if err != nil {
+		return Authority{}, fmt.Errorf("db: %w", err)
+	}
+	for _, l := range lists {
+		for _, s := range l.Signatures {
+			if bytes.Equal(s.Data, data) {
+				return Authority{Vendor: ImageSecurityDatabase, Name: "db", Data: s.Bytes()}, nil
+			}
+		}
+	}
+	return Authority{}, fmt.Errorf("db holds no such entry")
+}
+
+// ShimAuthority is what shim measures when it verifies an image with the
+// certificate built into it. Early shim releases measured a few bytes past the
+// end of the certificate, which cannot be predicted.
+func ShimAuthority(
+	vendorCert []byte, // IN
+) Authority {
+
+	return Authority{Vendor: ShimLock, Name: "Shim", Data: vendorCert}
+}
+
+// SbatLevelAuthority is what shim measures of the SBAT revocation level it
+// enforces, e.g. "sbat,1,2021030218\n".
+func SbatLevelAuthority(
+	sbatLevel []byte, // IN
+) Authority {
+
+	return Authority{Vendor: ShimLock, Name: "SbatLevel", Data: sbatLevel}
+}
@@ -0,0 +1,286 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"main/src/lib"
+	"main/src/pcrsel"
+	"main/src/refvalues"
+	"main/src/verifier"
+)
+
+var (
+	listen              = flag.String("listen", "localhost:8080", "Address the verifier listens on.")
+	akPath              = flag.String("ak-path", "Verifier/ak", "Path prefix of the AK public key registered at onboarding.")
+	referenceValuesPath = flag.String("reference-values", refvalues.DefaultPath, "Path prefix of the signed manifest of the reference values the PCRs are checked against.")
+	cicdSignerPath      = flag.String("cicd-signer", refvalues.DefaultSignerPath, "Path prefix of the CICD public key the manifest is signed with.")
+	nonceTTL            = flag.Duration("nonce-ttl", 2*time.Minute, "How long an issued nonce remains valid.")
+	maxChallenges       = flag.Int("max-challenges", 10000, "How many issued nonces may be outstanding at once; further challenges are refused until some are redeemed or expire.")
+	allowOrigin         = flag.String("allow-origin", "http://localhost:8000", "Origin of the web page allowed to call the verifier.")
+	pcrsSpec            = flag.String("pcrs", "", "PCRs to challenge for, per bank, e.g. sha256:0,1,2, in place of the selection recorded at onboarding.")
+	pcrsConfig          = flag.String("pcrs-config", pcrsel.DefaultPath, "JSON file holding the PCR selection recorded at onboarding.")
+)
+
+// Requests carry a nonce, a TPMS_ATTEST, a signature and possibly an events
+// log, which the native host cannot send beyond 1 MB
+const maxBodySize = 2 << 20
+
+// ChallengeRequest is the body of POST /api/challenge. It may name the PCRs
+// to quote, by bank, which must then be the selected ones.
+type ChallengeRequest struct {
+	PcrSelection map[string][]int `json:"pcr-selection"`
+}
+
+// ChallengeResponse carries the nonce the attestor must quote with.
+type ChallengeResponse struct {
+	Nonce        []byte           `json:"nonce"`
+	PcrSelection pcrsel.Selection `json:"pcr-selection"`
+	Expires      time.Time        `json:"expires"`
+}
+
+// VerifyRequest is the body of POST /api/verify.
+type VerifyRequest struct {
+	Nonce       []byte `json:"nonce"`
+	Attestation []byte `json:"attestation"`
+	Signature   []byte `json:"signature"`
+	// Optional PCR values, by bank name and PCR index, events log and IMA
+	// log returned along with the quote
+	PcrValues map[string]map[int][]byte `json:"pcr-values,omitempty"`
+	EventsLog []byte                    `json:"events-log,omitempty"`
+	ImaLog    []byte                    `json:"ima-log,omitempty"`
+}
+
+// ErrorResponse is returned when a request cannot be served.
+type ErrorResponse struct {
+	Message string `json:"message"`
+}
+
+// VerifyResponse reports the outcome of every check.
+type VerifyResponse struct {
+	IsLegit      bool                  `json:"is-legit"`
+	Message      string                `json:"message"`
+	Checks       []verifier.Check      `json:"checks"`
+	Diagnostics  *verifier.Diagnostics `json:"diagnostics,omitempty"`
+	SecureBoot   []verifier.Verdict    `json:"secure-boot,omitempty"`
+	EventsPolicy []verifier.Verdict    `json:"events-policy,omitempty"`
+	Ima          *verifier.IMAReport   `json:"ima,omitempty"`
+}
+
+type server struct {
+	nonces    *verifier.NonceStore
+	selection pcrsel.Selection
+	reference verifier.Verifier
+	// Sets of reference values, whose validity is checked at each quote
+	manifest *refvalues.Manifest
+}
+
+// ### Main ####################################################################
+
+func main() {
+	flag.Parse()
+
+	lib.PRINT("=== VERIFIER: LOAD REFERENCES ==================================================")
+
+	selection, err := pcrsel.Load(*pcrsSpec, *pcrsConfig)
+	if err != nil {
+		lib.Fatal("%v", err)
+	}
+	lib.Print("PCR selection: %s", selection)
+
+	akPubPEM, err := lib.Read(fmt.Sprintf("%s.pub", *akPath))
+	if err != nil {
+		lib.Fatal("%v", err)
+	}
+	reference, err := verifier.New(akPubPEM)
+	if err != nil {
+		lib.Fatal("verifier.New() failed: %v", err)
+	}
+	manifest, err := refvalues.Read(*referenceValuesPath, *cicdSignerPath)
+	if err != nil {
+		lib.Fatal("%v", err)
+	}
+	for _, set := range manifest.Sets {
+		lib.Print("Reference values: %q", set.Name)
+	}
+
+	s := &server{
+		nonces:    verifier.NewNonceStore(*nonceTTL, *maxChallenges),
+		selection: selection,
+		reference: *reference,
+		manifest:  manifest,
+	}
+
+	lib.PRINT("=== VERIFIER: SERVE ON %s ==============================================", *listen)
+	if err := http.ListenAndServe(*listen, s.routes()); err != nil {
+		lib.Fatal("http.ListenAndServe() failed: %v", err)
+	}
+}
+
+// routes maps the API endpoints to their handlers.
+func (s *server) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/challenge", s.handle(s.challenge))
+	mux.HandleFunc("/api/verify", s.handle(s.verify))
+	return mux
+}
+
+// handle passes the body of a POST request to an endpoint, and encodes
+// whatever the endpoint returns as JSON.
+func (s *server) handle(
+	endpoint func(body []byte) (interface{}, int),
+) http.HandlerFunc {
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", *allowOrigin)
+		w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if r.Method != http.MethodPost {
+			http.Error(w, "POST expected", http.StatusMethodNotAllowed)
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		response, status := endpoint(body)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			lib.Comment("json.Encode() failed: %v", err)
+		}
+	}
+}
+
+// === Verifier: issue challenge ===============================================
+
+func (s *server) challenge(
+	body []byte, // IN
+) (interface{}, int) {
+
+	req := ChallengeRequest{}
+	if len(body) != 0 {
+		if err := json.Unmarshal(body, &req); err != nil {
+			return errorResponse(fmt.Errorf("json.Unmarshal() failed: %w", err)), http.StatusBadRequest
+		}
+	}
+	if req.PcrSelection != nil {
+		requested, err := pcrsel.New(req.PcrSelection)
+		if err != nil {
+			return errorResponse(err), http.StatusBadRequest
+		}
+		if !requested.Equal(s.selection) {
+			return errorResponse(fmt.Errorf("PCR selection %s differs from the selected PCRs %s",
+				requested, s.selection)), http.StatusBadRequest
+		}
+	}
+
+	challenge, err := s.nonces.Issue(s.selection.TPM())
+	if errors.Is(err, verifier.ErrTooManyChallenges) {
+		lib.Print("Refused challenge: %v", err)
+		return errorResponse(err), http.StatusServiceUnavailable
+	}
+	if err != nil {
+		return errorResponse(err), http.StatusInternalServerError
+	}
+	lib.Print("Issued nonce 0x%x for PCRs %s", challenge.Nonce, s.selection)
+
+	return ChallengeResponse{
+		Nonce:        challenge.Nonce,
+		PcrSelection: s.selection,
+		Expires:      challenge.Expires,
+	}, http.StatusOK
+}
+
+// === Verifier: verify quote ==================================================
+
+func (s *server) verify(
+	body []byte, // IN
+) (interface{}, int) {
+
+	req := VerifyRequest{}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return errorResponse(fmt.Errorf("json.Unmarshal() failed: %w", err)), http.StatusBadRequest
+	}
+	var pcrValues verifier.Banks
+	if req.PcrValues != nil {
+		pcrValues = verifier.Banks{}
+		for bank, values := range req.PcrValues {
+			alg, err := pcrsel.Alg(bank)
+			if err != nil {
+				return errorResponse(err), http.StatusBadRequest
+			}
+			pcrValues[alg] = values
+		}
+	}
+
+	// The nonce must be one we issued, and can only be used once
+	challenge, err := s.nonces.Redeem(req.Nonce)
+	if err != nil {
+		lib.Print("Rejected quote: %v", err)
+		return VerifyResponse{IsLegit: false, Message: err.Error()}, http.StatusOK
+	}
+
+	v := s.reference
+	v.Selection = challenge.Selection
+	v.References, err = s.manifest.References(time.Now())
+	if err != nil {
+		lib.Print("Rejected quote: %v", err)
+		return VerifyResponse{IsLegit: false, Message: err.Error()}, http.StatusOK
+	}
+	if s.manifest.SecureBoot != nil {
+		v.SecureBootPolicy = s.manifest.SecureBoot.Policy()
+	}
+	v.EventsPolicy = s.manifest.EventsPolicy
+	if s.manifest.IMA != nil {
+		v.IMAPolicy = s.manifest.IMA.Policy()
+	}
+	result := v.VerifyEvidence(verifier.Evidence{
+		Nonce:       challenge.Nonce,
+		Attestation: req.Attestation,
+		Signature:   req.Signature,
+		PCRValues:   pcrValues,
+		EventsLog:   req.EventsLog,
+		IMALog:      req.ImaLog,
+	})
+
+	response := VerifyResponse{
+		IsLegit:      result.OK(),
+		Message:      "All good ;)",
+		Checks:       result.Checks,
+		Diagnostics:  result.Diagnostics,
+		SecureBoot:   result.SecureBoot,
+		EventsPolicy: result.EventsPolicy,
+		Ima:          result.IMA,
+	}
+	if err := result.Err(); err != nil {
+		response.Message = err.Error()
+	}
+	if result.Diagnostics != nil {
+		response.Message += "\n" + result.Diagnostics.String()
+	}
+	lib.Print("Quote for nonce 0x%x: %s", challenge.Nonce, response.Message)
+
+	return response, http.StatusOK
+}
+
+func errorResponse(
+	err error, // IN
+) ErrorResponse {
+
+	return ErrorResponse{Message: err.Error()}
+}
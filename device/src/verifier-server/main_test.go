@@ -0,0 +1,228 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpmutil"
+
+	"main/src/lib"
+	"main/src/pcrsel"
+	"main/src/refvalues"
+	"main/src/verifier"
+)
+
+// testPCRs are the PCR values of the attestor, and the reference values.
+var testPCRs = verifier.Banks{tpm2.AlgSHA256: {
+	0: bytes.Repeat([]byte{0x00}, sha256.Size),
+	7: bytes.Repeat([]byte{0x07}, sha256.Size),
+}}
+
+// testServer serves the API with an AK of its own, which quote() signs with.
+type testServer struct {
+	*httptest.Server
+	ak *ecdsa.PrivateKey
+}
+
+func newTestServer(t *testing.T, ttl time.Duration, maxChallenges int) *testServer {
+	t.Helper()
+
+	lib.UseLog = true
+	lib.Trace = log.New(ioutil.Discard, "", 0)
+	lib.Error = log.New(ioutil.Discard, "", 0)
+
+	ak, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("ecdsa.GenerateKey() failed: %v", err)
+	}
+	akPublicKeyDER, err := x509.MarshalPKIXPublicKey(&ak.PublicKey)
+	if err != nil {
+		t.Fatalf("x509.MarshalPKIXPublicKey() failed: %v", err)
+	}
+	reference, err := verifier.New(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: akPublicKeyDER}))
+	if err != nil {
+		t.Fatalf("verifier.New() failed: %v", err)
+	}
+
+	s := &server{
+		nonces:    verifier.NewNonceStore(ttl, maxChallenges),
+		selection: pcrsel.Selection{pcrsel.SHA256: {0, 7}},
+		reference: *reference,
+		manifest: &refvalues.Manifest{
+			Version: refvalues.Version,
+			Sets:    []refvalues.Set{refvalues.NewSet("release-1", testPCRs)},
+		},
+	}
+	ts := &testServer{Server: httptest.NewServer(s.routes()), ak: ak}
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+// post sends a request to an endpoint, and decodes its response.
+func (ts *testServer) post(t *testing.T, endpoint string, request interface{}, response interface{}) int {
+	t.Helper()
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		t.Fatalf("json.Marshal() failed: %v", err)
+	}
+	resp, err := http.Post(ts.URL+endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("http.Post() failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(response); err != nil {
+		t.Fatalf("json.Decode() failed: %v", err)
+	}
+	return resp.StatusCode
+}
+
+// challenge asks for a nonce.
+func (ts *testServer) challenge(t *testing.T) []byte {
+	t.Helper()
+
+	response := ChallengeResponse{}
+	if status := ts.post(t, "/api/challenge", ChallengeRequest{}, &response); status != http.StatusOK {
+		t.Fatalf("/api/challenge returned status %d", status)
+	}
+	if len(response.Nonce) != verifier.NonceSize {
+		t.Fatalf("/api/challenge returned a %d-byte nonce, expected %d", len(response.Nonce), verifier.NonceSize)
+	}
+	return response.Nonce
+}
+
+// quote returns the TPMS_ATTEST of a quote of testPCRs over a nonce, as
+// TPM2_Quote() returns it, and its signature by the AK.
+func (ts *testServer) quote(t *testing.T, nonce []byte) VerifyRequest {
+	t.Helper()
+
+	sels := []tpm2.PCRSelection{{Hash: tpm2.AlgSHA256, PCRs: []int{0, 7}}}
+	pcrDigest, err := verifier.PCRsDigest(sels, testPCRs)
+	if err != nil {
+		t.Fatalf("verifier.PCRsDigest() failed: %v", err)
+	}
+	attestation, err := tpmutil.Pack(uint32(0xff544347), tpm2.TagAttestQuote, // TPM_GENERATED_VALUE
+		tpmutil.U16Bytes("qualified signer"), tpmutil.U16Bytes(nonce),
+		uint64(1000), uint32(1), uint32(0), uint8(1), uint64(0), // clock info, firmware
+		uint32(1), tpm2.AlgSHA256, uint8(3), []byte{0x81, 0x00, 0x00}, // PCRs 0 and 7
+		tpmutil.U16Bytes(pcrDigest))
+	if err != nil {
+		t.Fatalf("tpmutil.Pack() failed: %v", err)
+	}
+	digest := sha256.Sum256(attestation)
+	signature, err := ecdsa.SignASN1(rand.Reader, ts.ak, digest[:])
+	if err != nil {
+		t.Fatalf("ecdsa.SignASN1() failed: %v", err)
+	}
+	return VerifyRequest{Nonce: nonce, Attestation: attestation, Signature: signature}
+}
+
+// verify submits a quote.
+func (ts *testServer) verify(t *testing.T, request VerifyRequest) VerifyResponse {
+	t.Helper()
+
+	response := VerifyResponse{}
+	if status := ts.post(t, "/api/verify", request, &response); status != http.StatusOK {
+		t.Fatalf("/api/verify returned status %d", status)
+	}
+	return response
+}
+
+func TestChallengeVerify(t *testing.T) {
+	ts := newTestServer(t, time.Minute, 10)
+
+	nonce := ts.challenge(t)
+	if response := ts.verify(t, ts.quote(t, nonce)); !response.IsLegit {
+		t.Fatalf("/api/verify rejected the quote: %s", response.Message)
+	}
+
+	// A nonce is redeemed once
+	response := ts.verify(t, ts.quote(t, nonce))
+	if response.IsLegit || !strings.Contains(response.Message, verifier.ErrUnknownNonce.Error()) {
+		t.Errorf("/api/verify returned %v %q for a reused nonce, expected %q",
+			response.IsLegit, response.Message, verifier.ErrUnknownNonce)
+	}
+
+	// A nonce the server never issued is not accepted
+	forged, err := verifier.NewNonce()
+	if err != nil {
+		t.Fatalf("verifier.NewNonce() failed: %v", err)
+	}
+	response = ts.verify(t, ts.quote(t, forged))
+	if response.IsLegit || !strings.Contains(response.Message, verifier.ErrUnknownNonce.Error()) {
+		t.Errorf("/api/verify returned %v %q for a forged nonce, expected %q",
+			response.IsLegit, response.Message, verifier.ErrUnknownNonce)
+	}
+
+	// Nor a quote over another nonce than the one redeemed
+	request := ts.quote(t, ts.challenge(t))
+	request.Nonce = ts.challenge(t)
+	if response := ts.verify(t, request); response.IsLegit {
+		t.Errorf("/api/verify accepted a quote over another nonce")
+	}
+}
+
+func TestChallengeExpiry(t *testing.T) {
+	ts := newTestServer(t, 10*time.Millisecond, 10)
+
+	nonce := ts.challenge(t)
+	time.Sleep(20 * time.Millisecond)
+	response := ts.verify(t, ts.quote(t, nonce))
+	if response.IsLegit || !strings.Contains(response.Message, verifier.ErrNonceExpired.Error()) {
+		t.Errorf("/api/verify returned %v %q for an expired nonce, expected %q",
+			response.IsLegit, response.Message, verifier.ErrNonceExpired)
+	}
+}
+
+func TestChallengeSelection(t *testing.T) {
+	ts := newTestServer(t, time.Minute, 10)
+
+	for _, tc := range []struct {
+		name      string
+		selection map[string][]int
+		status    int
+	}{
+		{"selected PCRs", map[string][]int{pcrsel.SHA256: {7, 0}}, http.StatusOK},
+		{"other PCRs", map[string][]int{pcrsel.SHA256: {0, 1}}, http.StatusBadRequest},
+		{"other bank", map[string][]int{pcrsel.SHA1: {0, 7}}, http.StatusBadRequest},
+		{"unknown bank", map[string][]int{"md5": {0, 7}}, http.StatusBadRequest},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			response := map[string]interface{}{}
+			if status := ts.post(t, "/api/challenge", ChallengeRequest{PcrSelection: tc.selection}, &response); status != tc.status {
+				t.Errorf("/api/challenge returned status %d %v, expected %d", status, response, tc.status)
+			}
+		})
+	}
+}
+
+func TestChallengeLimit(t *testing.T) {
+	ts := newTestServer(t, time.Minute, 2)
+
+	first := ts.challenge(t)
+	ts.challenge(t)
+	response := ErrorResponse{}
+	if status := ts.post(t, "/api/challenge", ChallengeRequest{}, &response); status != http.StatusServiceUnavailable {
+		t.Fatalf("/api/challenge returned status %d beyond the limit, expected %d", status, http.StatusServiceUnavailable)
+	}
+
+	// Redeeming a nonce makes room for another challenge
+	ts.verify(t, ts.quote(t, first))
+	ts.challenge(t)
+}
@@ -0,0 +1,329 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package verifier
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/google/go-attestation/attest"
+	"github.com/google/go-tpm/tpm2"
+
+	"main/src/pcrsel"
+	"main/src/replay"
+)
+
+// === Diagnostics =============================================================
+
+// Changes an event can have undergone, relative to the reference events log
+const (
+	EventAdded   = "added"
+	EventMissing = "missing"
+	EventChanged = "changed"
+)
+
+// Diagnostics explain why the quoted PCRs are not the expected ones.
+type Diagnostics struct {
+	// Name of the set of reference values the PCRs are compared with: the
+	// closest one when several are accepted
+	Reference string `json:"reference,omitempty"`
+	// Whether the PCR values compared hash to the quoted digest: else they
+	// are those an events log replays to, which the device may have forged
+	Verified bool      `json:"verified"`
+	PCRs     []PCRDiff `json:"pcrs"`
+}
+
+// PCRDiff reports a PCR whose value is not the expected one.
+type PCRDiff struct {
+	Bank     string `json:"bank"`
+	Index    int    `json:"index"`
+	Expected []byte `json:"expected"`
+	Got      []byte `json:"got"`
+	// Events that differ from the reference events log, when both logs are
+	// at hand
+	Events []EventDiff `json:"events,omitempty"`
+}
+
+// EventDiff reports an event added, missing or changed relative to the
+// reference events log.
+type EventDiff struct {
+	Change string `json:"change"`
+	// Position among the events of the PCR: in the quoted events log for
+	// added and changed events, in the reference events log for missing ones
+	Position    int    `json:"position"`
+	Type        string `json:"type"`
+	Description string `json:"description"`
+	// Digest in the reference events log (missing, changed)
+	ExpectedDigest []byte `json:"expected-digest,omitempty"`
+	// Digest in the quoted events log (added, changed)
+	Digest []byte `json:"digest,omitempty"`
+}
+
+// Differing lists the PCRs that differ, as "sha256:3".
+func (d *Diagnostics) Differing() []string {
+	pcrs := []string{}
+	for _, p := range d.PCRs {
+		pcrs = append(pcrs, fmt.Sprintf("%s:%d", p.Bank, p.Index))
+	}
+	return pcrs
+}
+
+// String summarizes the diagnostics on one line per PCR.
+func (d *Diagnostics) String() string {
+	lines := []string{}
+	if !d.Verified {
+		lines = append(lines, "Unverified: the events log does not replay to the quote")
+	}
+	if d.Reference != "" {
+		lines = append(lines, fmt.Sprintf("Closest reference values: %q", d.Reference))
+	}
+	for _, p := range d.PCRs {
+		events := []string{}
+		for _, e := range p.Events {
+			events = append(events, fmt.Sprintf("%s #%d %s %s", e.Change, e.Position, e.Type, e.Description))
+		}
+		line := fmt.Sprintf("%s PCR[%d] is 0x%x, expected 0x%x", p.Bank, p.Index, p.Got, p.Expected)
+		if len(events) != 0 {
+			line += ": " + strings.Join(events, "; ")
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Diagnose compares the PCR values of some evidence with the reference
+// values, PCR per PCR. The PCR values are only used if they hash to the
+// quoted digest, else those the events log replays to are, and the
+// diagnostics are only verified if these do. When both the evidence and the
+// reference values come with an events log, it also tells which events
+// differ in the PCRs that do. Among several sets of reference values, it
+// reports on the one the fewest PCRs differ from.
+func (v *Verifier) Diagnose(
+	sels []tpm2.PCRSelection, // IN
+	quotedDigest []byte, // IN
+	evidence Evidence, // IN
+) (*Diagnostics, error) {
+
+	references := []Reference{}
+	for _, r := range v.references() {
+		if r.PCRs != nil {
+			references = append(references, r)
+		}
+	}
+	if len(references) == 0 {
+		return nil, fmt.Errorf("no reference PCR values")
+	}
+	got, verified := evidence.PCRValues, false
+	if got != nil {
+		err := checkPCRValues(sels, got, quotedDigest)
+		if err != nil && evidence.EventsLog == nil {
+			return nil, err
+		}
+		verified = err == nil
+	}
+	if !verified {
+		if evidence.EventsLog == nil {
+			return nil, fmt.Errorf("no PCR values nor events log in evidence")
+		}
+		var err error
+		got, err = ReplayEventsLog(evidence.EventsLog)
+		if err != nil {
+			return nil, err
+		}
+		verified = checkEventsLog(sels, evidence.EventsLog, quotedDigest, nil) == nil
+	}
+
+	var closest *Diagnostics
+	for _, r := range references {
+		d, err := diagnose(sels, evidence.EventsLog, got, r)
+		if err != nil {
+			return nil, err
+		}
+		if closest == nil || len(d.PCRs) < len(closest.PCRs) {
+			closest = d
+		}
+	}
+	closest.Verified = verified
+	return closest, nil
+}
+
+// diagnose compares PCR values with one set of reference values.
+func diagnose(
+	sels []tpm2.PCRSelection, // IN
+	eventsLog []byte, // IN
+	got Banks, // IN
+	r Reference, // IN
+) (*Diagnostics, error) {
+
+	d := &Diagnostics{Reference: r.Name, PCRs: []PCRDiff{}}
+	for _, sel := range sels {
+		var gotEvents, expectedEvents map[int][]attest.Event
+		if eventsLog != nil && r.EventsLog != nil {
+			var err error
+			if gotEvents, err = eventsByPCR(eventsLog, sel.Hash); err != nil {
+				return nil, err
+			}
+			if expectedEvents, err = eventsByPCR(r.EventsLog, sel.Hash); err != nil {
+				return nil, err
+			}
+		}
+
+		for _, i := range sel.PCRs {
+			expected, value := r.PCRs[sel.Hash][i], got[sel.Hash][i]
+			if bytes.Equal(value, expected) {
+				continue
+			}
+			p := PCRDiff{
+				Bank:     pcrsel.Name(sel.Hash),
+				Index:    i,
+				Expected: expected,
+				Got:      value,
+			}
+			if gotEvents != nil {
+				p.Events = diffEvents(expectedEvents[i], gotEvents[i])
+			}
+			d.PCRs = append(d.PCRs, p)
+		}
+	}
+
+	return d, nil
+}
+
+// eventsByPCR sorts the measured events of a log by PCR, with their digests
+// in one bank.
+func eventsByPCR(
+	eventsLog []byte, // IN
+	alg tpm2.Algorithm, // IN
+) (map[int][]attest.Event, error) {
+
+	parsedEventsLog, err := attest.ParseEventLog(eventsLog)
+	if err != nil {
+		return nil, fmt.Errorf("attest.ParseEventLog() failed: %w", err)
+	}
+	events := map[int][]attest.Event{}
+	for _, e := range parsedEventsLog.Events(attest.HashAlg(alg)) {
+		if e.Type == replay.EvNoAction { // not measured
+			continue
+		}
+		events[e.Index] = append(events[e.Index], e)
+	}
+	return events, nil
+}
+
+// diffEvents aligns two event sequences on their digests (longest common
+// subsequence). Between two aligned events, missing and added events are
+// paired up as changed events.
+func diffEvents(
+	expected []attest.Event, // IN
+	got []attest.Event, // IN
+) []EventDiff {
+
+	// lcs[i][j] is the length of the longest common subsequence of
+	// expected[i:] and got[j:]
+	lcs := make([][]int, len(expected)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(got)+1)
+	}
+	for i := len(expected) - 1; i >= 0; i-- {
+		for j := len(got) - 1; j >= 0; j-- {
+			if bytes.Equal(expected[i].Digest, got[j].Digest) {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	diffs := []EventDiff{}
+	missing, added := []int{}, []int{}
+	flush := func() {
+		n := len(missing)
+		if len(added) < n {
+			n = len(added)
+		}
+		for k := 0; k < n; k++ {
+			e, g := expected[missing[k]], got[added[k]]
+			diffs = append(diffs, EventDiff{
+				Change:         EventChanged,
+				Position:       added[k],
+				Type:           g.Type.String(),
+				Description:    describe(g),
+				ExpectedDigest: e.Digest,
+				Digest:         g.Digest,
+			})
+		}
+		for _, i := range missing[n:] {
+			diffs = append(diffs, EventDiff{
+				Change:         EventMissing,
+				Position:       i,
+				Type:           expected[i].Type.String(),
+				Description:    describe(expected[i]),
+				ExpectedDigest: expected[i].Digest,
+			})
+		}
+		for _, j := range added[n:] {
+			diffs = append(diffs, EventDiff{
+				Change:      EventAdded,
+				Position:    j,
+				Type:        got[j].Type.String(),
+				Description: describe(got[j]),
+				Digest:      got[j].Digest,
+			})
+		}
+		missing, added = missing[:0], added[:0]
+	}
+
+	i, j := 0, 0
+	for i < len(expected) || j < len(got) {
+		switch {
+		case i < len(expected) && j < len(got) && bytes.Equal(expected[i].Digest, got[j].Digest):
+			flush()
+			i, j = i+1, j+1
+		case j == len(got) || (i < len(expected) && lcs[i+1][j] >= lcs[i][j+1]):
+			missing = append(missing, i)
+			i++
+		default:
+			added = append(added, j)
+			j++
+		}
+	}
+	flush()
+
+	return diffs
+}
+
+// describe renders the data of an event, as text when it looks like text
+// (possibly UTF-16), as a hex prefix otherwise.
+func describe(
+	e attest.Event, // IN
+) string {
+
+	const maxLen = 64
+	text := strings.Map(func(r rune) rune {
+		if r == 0 {
+			return -1
+		}
+		return r
+	}, string(e.Data))
+	printable := 0
+	for _, r := range text {
+		if unicode.IsPrint(r) {
+			printable++
+		}
+	}
+	if len(text) != 0 && printable*10 >= len([]rune(text))*9 {
+		if len(text) > maxLen {
+			text = text[:maxLen] + "..."
+		}
+		return fmt.Sprintf("%q", text)
+	}
+
+	if len(e.Data) > maxLen/2 {
+		return fmt.Sprintf("%d bytes 0x%x...", len(e.Data), e.Data[:maxLen/2])
+	}
+	return fmt.Sprintf("%d bytes 0x%x", len(e.Data), e.Data)
+}
@@ -0,0 +1,355 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package verifier
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/google/go-attestation/attest"
+	"github.com/google/go-tpm/tpm2"
+
+	"main/src/pcrsel"
+	"main/src/uefi"
+)
+
+// === Events policy ===========================================================
+
+// Kinds of measurements events policy rules apply to. GRUB measures its
+// commands and the command lines it boots with into PCR 8, as strings, and
+// the files it loads, kernel and initrd included, into PCR 9.
+const (
+	// A GRUB command, e.g. "linux /boot/vmlinuz-6.2.0 root=/dev/sda1 ro"
+	KindGrubCmd = "grub-cmd"
+	// The command line of the kernel, its path first
+	KindKernelCmdline = "kernel-cmdline"
+	// The path of the kernel, as the kernel command line starts with it
+	KindKernel = "kernel"
+	// The command line of a multiboot module
+	KindModuleCmdline = "module-cmdline"
+	// A file GRUB loaded, by its path, e.g. "(hd0,gpt2)/boot/vmlinuz-6.2.0"
+	KindFile = "file"
+)
+
+// Rule of the events policy that the events are quoted and well-formed,
+// besides the named rules
+const RuleEvents = "events"
+
+// Kinds of measurements events policy rules may apply to
+var EventKinds = []string{KindGrubCmd, KindKernelCmdline, KindKernel, KindModuleCmdline, KindFile}
+
+// Description prefixes of the GRUB PCR 8 events, by kind
+var grubPrefixes = map[string]string{
+	"grub_cmd: ":       KindGrubCmd,
+	"kernel_cmdline: ": KindKernelCmdline,
+	"module_cmdline: ": KindModuleCmdline,
+}
+
+// GRUB measures its strings into grubPCR, and the files it loads into
+// grubFilesPCR
+const (
+	grubPCR      = 8
+	grubFilesPCR = 9
+)
+
+// EventsPolicy tells what the measurements of some PCRs must mean, in place of
+// reference values for them: the quote must match the reference values for
+// the other PCRs only, and the events log those PCRs replay from.
+type EventsPolicy struct {
+	// PCRs the rules vouch for: PCR 8 and PCR 9, whose events the rules read
+	PCRs []int `json:"pcrs"`
+	// Rules every boot must comply with
+	Rules []EventRule `json:"rules"`
+}
+
+// EventRule constrains the measurements of a kind. Patterns are globs, where
+// '*' matches any string and '?' any character, e.g. "/boot/vmlinuz-6.*" or
+// "init=*"; command lines are matched argument by argument. File rules may
+// apply to some paths only, and check the digests the files were measured
+// with.
+type EventRule struct {
+	// Name of the rule, reported in verdicts
+	Name string `json:"name"`
+	// Kind of measurements the rule applies to
+	Kind string `json:"kind"`
+	// At least one measurement of the kind must be found
+	Required bool `json:"required,omitempty"`
+	// Pattern every measurement must match
+	Match string `json:"match,omitempty"`
+	// Patterns each of which an argument must match
+	Contains []string `json:"contains,omitempty"`
+	// Patterns no argument may match
+	Forbids []string `json:"forbids,omitempty"`
+	// Pattern of the paths of the files a file rule applies to, all if empty
+	Path string `json:"path,omitempty"`
+	// Hex digests, one of which every file the rule applies to must have been
+	// measured with, in the bank the events are read from
+	Digests []string `json:"digests,omitempty"`
+}
+
+// Validate checks that the rules are well-formed.
+func (p *EventsPolicy) Validate() error {
+	if len(p.PCRs) == 0 {
+		return fmt.Errorf("no PCR to vouch for")
+	}
+	// Rules only read the PCR 8 and PCR 9 events: any other PCR vouched for
+	// would be left unchecked
+	for _, pcr := range p.PCRs {
+		if pcr != grubPCR && pcr != grubFilesPCR {
+			return fmt.Errorf("PCR[%d] cannot be vouched for, rules only read PCR[%d] and PCR[%d]",
+				pcr, grubPCR, grubFilesPCR)
+		}
+	}
+	names := map[string]bool{}
+	for k, rule := range p.Rules {
+		if rule.Name == "" {
+			return fmt.Errorf("rule #%d has no name", k)
+		}
+		if rule.Name == RuleEvents {
+			return fmt.Errorf("rule #%d: %q is reserved", k, RuleEvents)
+		}
+		if names[rule.Name] {
+			return fmt.Errorf("rule %q is listed twice", rule.Name)
+		}
+		names[rule.Name] = true
+		known := false
+		for _, kind := range EventKinds {
+			known = known || kind == rule.Kind
+		}
+		if !known {
+			return fmt.Errorf("rule %q: unknown kind %q, expected one of %v", rule.Name, rule.Kind, EventKinds)
+		}
+		if pcr := rule.pcr(); !containsInt(p.PCRs, pcr) {
+			return fmt.Errorf("rule %q reads PCR[%d], which is not vouched for", rule.Name, pcr)
+		}
+		if rule.Kind != KindFile && (rule.Path != "" || len(rule.Digests) != 0) {
+			return fmt.Errorf("rule %q: only %s rules have a path and digests", rule.Name, KindFile)
+		}
+		for _, digest := range rule.Digests {
+			if _, err := hex.DecodeString(digest); err != nil || digest == "" {
+				return fmt.Errorf("rule %q: digest %q is not hex", rule.Name, digest)
+			}
+		}
+		if !rule.Required && rule.Match == "" && len(rule.Contains) == 0 && len(rule.Forbids) == 0 &&
+			len(rule.Digests) == 0 {
+			return fmt.Errorf("rule %q checks nothing", rule.Name)
+		}
+	}
+	// Any file GRUB loads would do, kernel included, without a file rule
+	if containsInt(p.PCRs, grubFilesPCR) {
+		files := false
+		for _, rule := range p.Rules {
+			files = files || rule.Kind == KindFile
+		}
+		if !files {
+			return fmt.Errorf("PCR[%d] is vouched for, but no %s rule reads it", grubFilesPCR, KindFile)
+		}
+	}
+	return nil
+}
+
+// pcr returns the PCR GRUB measures the events a rule reads into.
+func (rule *EventRule) pcr() int {
+	if rule.Kind == KindFile {
+		return grubFilesPCR
+	}
+	return grubPCR
+}
+
+// Measurement is a string GRUB measured, of some kind, or the path of a file
+// it measured, with the digest of the file.
+type Measurement struct {
+	Kind   string
+	Value  string
+	Digest []byte
+}
+
+// GrubMeasurements reads what GRUB measured from the events of an events log
+// in some PCRs, in some bank. GRUB logs the strings of PCR 8 with a prefix
+// telling their kind, and extends the digest of the string alone: events of
+// unknown kinds, and events whose digest does not match, are reported, for
+// what they measured cannot be checked. It logs the files of PCR 9 by their
+// path, and extends the digest of their content.
+func GrubMeasurements(
+	events []attest.Event, // IN
+	alg tpm2.Algorithm, // IN
+	pcrs []int, // IN
+) ([]Measurement, error) {
+
+	hash, err := alg.Hash()
+	if err != nil {
+		return nil, fmt.Errorf("%v bank: %w", alg, err)
+	}
+	measurements := []Measurement{}
+	for k, e := range events {
+		if !containsInt(pcrs, e.Index) {
+			continue
+		}
+		description := string(bytes.TrimRight(e.Data, "\x00"))
+		if e.Index == grubFilesPCR {
+			if e.Type != attest.EventType(uefi.EvIPL) || description == "" {
+				return nil, fmt.Errorf("event #%d: %q is not a GRUB file measurement", k, description)
+			}
+			measurements = append(measurements, Measurement{Kind: KindFile, Value: description, Digest: e.Digest})
+			continue
+		}
+		if e.Index != grubPCR {
+			continue
+		}
+		known := false
+		for prefix, kind := range grubPrefixes {
+			if !strings.HasPrefix(description, prefix) {
+				continue
+			}
+			known = true
+			value := strings.TrimPrefix(description, prefix)
+			h := hash.New()
+			h.Write([]byte(value))
+			if !bytes.Equal(h.Sum(nil), e.Digest) {
+				return nil, fmt.Errorf("event #%d: digest 0x%x is not that of %q", k, e.Digest, description)
+			}
+			measurements = append(measurements, Measurement{Kind: kind, Value: value})
+			if kind == KindKernelCmdline {
+				kernel := ""
+				if fields := strings.Fields(value); len(fields) != 0 {
+					kernel = fields[0]
+				}
+				measurements = append(measurements, Measurement{Kind: KindKernel, Value: kernel})
+			}
+		}
+		if !known {
+			return nil, fmt.Errorf("event #%d: %q is not a GRUB measurement", k, description)
+		}
+	}
+	return measurements, nil
+}
+
+// Check evaluates the rules against the events of an events log, which must
+// replay to the quoted PCRs. It returns, for each rule, a verdict per
+// measurement breaking it, or a single verdict that it holds.
+func (p *EventsPolicy) Check(
+	sels []tpm2.PCRSelection, // IN
+	eventsLog []byte, // IN
+) []Verdict {
+
+	verdicts := []Verdict{}
+	verdict := func(rule, subject string, passed bool, format string, a ...interface{}) {
+		verdicts = append(verdicts, Verdict{
+			Rule:    rule,
+			Subject: subject,
+			Passed:  passed,
+			Message: fmt.Sprintf(format, a...),
+		})
+	}
+
+	// Only quoted events are trusted: read them in the strongest bank all the
+	// PCRs vouched for are quoted in
+	parsedEventsLog, err := attest.ParseEventLog(eventsLog)
+	if err != nil {
+		verdict(RuleEvents, "", false, "attest.ParseEventLog() failed: %v", err)
+		return verdicts
+	}
+	alg := tpm2.AlgUnknown
+	for _, sel := range sels {
+		for _, a := range parsedEventsLog.Algs {
+			if tpm2.Algorithm(a) == sel.Hash && containsAllInts(sel.PCRs, p.PCRs) {
+				alg = sel.Hash
+			}
+		}
+	}
+	if alg == tpm2.AlgUnknown {
+		verdict(RuleEvents, "", false, "PCRs %v are not quoted in a bank the events log has digests for", p.PCRs)
+		return verdicts
+	}
+	measurements, err := GrubMeasurements(parsedEventsLog.Events(attest.HashAlg(alg)), alg, p.PCRs)
+	if err != nil {
+		verdict(RuleEvents, "", false, "%v", err)
+		return verdicts
+	}
+	verdict(RuleEvents, "", true, "%s PCRs %v events are well-formed", pcrsel.Name(alg), p.PCRs)
+
+	for _, rule := range p.Rules {
+		// Validate() made sure the digests are hex
+		digests := [][]byte{}
+		for _, d := range rule.Digests {
+			digest, _ := hex.DecodeString(d)
+			digests = append(digests, digest)
+		}
+		broken, count := false, 0
+		fail := func(subject string, format string, a ...interface{}) {
+			broken = true
+			verdict(rule.Name, subject, false, format, a...)
+		}
+		for _, m := range measurements {
+			if m.Kind != rule.Kind || rule.Path != "" && !globMatch(rule.Path, m.Value) {
+				continue
+			}
+			count++
+			if rule.Match != "" && !globMatch(rule.Match, m.Value) {
+				fail(m.Value, "%s %q does not match %q", rule.Kind, m.Value, rule.Match)
+			}
+			args := strings.Fields(m.Value)
+			for _, pattern := range rule.Contains {
+				if !anyGlobMatch(pattern, args) {
+					fail(m.Value, "%s %q has no argument matching %q", rule.Kind, m.Value, pattern)
+				}
+			}
+			for _, pattern := range rule.Forbids {
+				if anyGlobMatch(pattern, args) {
+					fail(m.Value, "%s %q has an argument matching %q", rule.Kind, m.Value, pattern)
+				}
+			}
+			if len(rule.Digests) != 0 && !containsDigest(digests, m.Digest) {
+				fail(m.Value, "%s %q has digest %x, which is not allowed", rule.Kind, m.Value, m.Digest)
+			}
+		}
+		switch {
+		case rule.Required && count == 0 && rule.Path != "":
+			fail("", "no %s matching %q is measured", rule.Kind, rule.Path)
+		case rule.Required && count == 0:
+			fail("", "no %s is measured", rule.Kind)
+		case !broken:
+			verdict(rule.Name, "", true, "%d %s measurements comply", count, rule.Kind)
+		}
+	}
+
+	return verdicts
+}
+
+// globMatch tells whether a value matches a glob, where '*' matches any
+// string, '/' included, and '?' any character.
+func globMatch(
+	pattern string, // IN
+	value string, // IN
+) bool {
+
+	expr := regexp.QuoteMeta(pattern)
+	expr = strings.ReplaceAll(expr, `\*`, `.*`)
+	expr = strings.ReplaceAll(expr, `\?`, `.`)
+	return regexp.MustCompile("^(?s:" + expr + ")$").MatchString(value)
+}
+
+func anyGlobMatch(
+	pattern string, // IN
+	values []string, // IN
+) bool {
+
+	for _, value := range values {
+		if globMatch(pattern, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsAllInts(ints []int, subset []int) bool {
+	for _, i := range subset {
+		if !containsInt(ints, i) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,287 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package verifier
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/google/go-tpm/tpm2"
+)
+
+// === IMA =====================================================================
+
+// PCR Linux IMA extends with its runtime measurements
+const IMAPCR = 10
+
+// IMAEntry is an entry of the IMA runtime measurement list, as
+// /sys/kernel/security/ima/binary_runtime_measurements exposes it.
+type IMAEntry struct {
+	PCR int
+	// SHA-1 digest of the template data, all zeros for a violation
+	TemplateDigest []byte
+	TemplateName   string
+	TemplateData   []byte
+	// Fields of the ima-ng and ima-sig templates: the file digest and its
+	// algorithm (e.g. "sha256"), the file path, and the file signature
+	FileDigestAlg string
+	FileDigest    []byte
+	Path          string
+	Signature     []byte
+}
+
+// violation tells whether the entry records a measurement violation, which
+// IMA extends as all ones instead of its template digest.
+func (e *IMAEntry) violation() bool {
+	return bytes.Equal(e.TemplateDigest, make([]byte, sha1.Size))
+}
+
+// ParseIMALog decodes an IMA runtime measurement list in the binary format,
+// in little-endian byte order. Only the ima-ng and ima-sig templates are
+// supported.
+func ParseIMALog(
+	imaLog []byte, // IN
+) ([]IMAEntry, error) {
+
+	le := binary.LittleEndian
+	entries := []IMAEntry{}
+	next := func(data []byte, what string) ([]byte, []byte, error) {
+		if len(data) < 4 || uint64(le.Uint32(data)) > uint64(len(data)-4) {
+			return nil, nil, fmt.Errorf("IMA entry #%d: %s is truncated", len(entries), what)
+		}
+		size := le.Uint32(data)
+		return data[4 : 4+size], data[4+size:], nil
+	}
+
+	for rest := imaLog; len(rest) != 0; {
+		e := IMAEntry{}
+		if len(rest) < 4+sha1.Size {
+			return nil, fmt.Errorf("IMA entry #%d is truncated", len(entries))
+		}
+		e.PCR = int(le.Uint32(rest))
+		e.TemplateDigest = rest[4 : 4+sha1.Size]
+		rest = rest[4+sha1.Size:]
+		name, rest2, err := next(rest, "template name")
+		if err != nil {
+			return nil, err
+		}
+		e.TemplateName = string(name)
+		if e.TemplateData, rest, err = next(rest2, "template data"); err != nil {
+			return nil, err
+		}
+		if e.TemplateName != "ima-ng" && e.TemplateName != "ima-sig" {
+			return nil, fmt.Errorf("IMA entry #%d: unsupported template %q", len(entries), e.TemplateName)
+		}
+
+		// The template digest covers the template data, unless the entry is
+		// a violation
+		if digest := sha1.Sum(e.TemplateData); !e.violation() && !bytes.Equal(digest[:], e.TemplateDigest) {
+			return nil, fmt.Errorf("IMA entry #%d: template data does not match its digest 0x%x",
+				len(entries), e.TemplateDigest)
+		}
+
+		// d-ng: <algorithm>:\0<digest>, n-ng: <path>\0, sig: <signature>
+		fields := e.TemplateData
+		digest, fields, err := next(fields, "file digest")
+		if err != nil {
+			return nil, err
+		}
+		alg, fileDigest, found := bytes.Cut(digest, []byte(":\x00"))
+		if !found {
+			return nil, fmt.Errorf("IMA entry #%d: malformed file digest", len(entries))
+		}
+		e.FileDigestAlg, e.FileDigest = string(alg), fileDigest
+		path, fields, err := next(fields, "file path")
+		if err != nil {
+			return nil, err
+		}
+		e.Path = strings.TrimRight(string(path), "\x00")
+		if e.TemplateName == "ima-sig" {
+			if e.Signature, _, err = next(fields, "file signature"); err != nil {
+				return nil, err
+			}
+		}
+
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// ReplayIMALog computes the values of PCR 10 in a bank, from its reset value
+// to its value after the last entry: the value after the first n entries is
+// at index n. In the SHA-1 bank, IMA extends the template digest; in other
+// banks, the digest of the template data with the hash of the bank.
+func ReplayIMALog(
+	entries []IMAEntry, // IN
+	alg tpm2.Algorithm, // IN
+) ([][]byte, error) {
+
+	hash, err := alg.Hash()
+	if err != nil {
+		return nil, fmt.Errorf("%v bank: %w", alg, err)
+	}
+	pcr := make([]byte, hash.Size())
+	values := [][]byte{pcr}
+	for k := range entries {
+		e := &entries[k]
+		if e.PCR != IMAPCR {
+			return nil, fmt.Errorf("IMA entry #%d extends PCR[%d], expected PCR[%d]", k, e.PCR, IMAPCR)
+		}
+		var digest []byte
+		if e.violation() {
+			digest = bytes.Repeat([]byte{0xff}, hash.Size())
+		} else {
+			h := hash.New()
+			h.Write(e.TemplateData)
+			digest = h.Sum(nil)
+		}
+		h := hash.New()
+		h.Write(pcr)
+		h.Write(digest)
+		pcr = h.Sum(nil)
+		values = append(values, pcr)
+	}
+	return values, nil
+}
+
+// IMAPolicy tells which files the IMA measurements may be of.
+type IMAPolicy struct {
+	// Accepted digests, by file path
+	Allowlist map[string][][]byte
+	// Digests of files that must not have been loaded, whatever their path
+	Denylist [][]byte
+	// Path prefixes of files that are not checked, e.g. "/var/log/"
+	Exclude []string
+}
+
+// IMAReport tells which measured files the policy does not accept.
+type IMAReport struct {
+	// Entries of the IMA log the quote covers
+	Measurements int `json:"measurements"`
+	// Files the allowlist does not hold, with their measured digest
+	Unknown []IMAFile `json:"unknown"`
+	// Files the denylist holds
+	Denied []IMAFile `json:"denied"`
+}
+
+// IMAFile is a measured file.
+type IMAFile struct {
+	Path string `json:"path"`
+	// Digest, as "<algorithm>:<hex>"
+	Digest string `json:"digest"`
+}
+
+// OK tells whether all the measured files are accepted.
+func (r *IMAReport) OK() bool {
+	return len(r.Unknown) == 0 && len(r.Denied) == 0
+}
+
+// Check matches IMA entries against the policy. The boot_aggregate entry,
+// which covers the boot PCRs, and violations are not files.
+func (p *IMAPolicy) Check(
+	entries []IMAEntry, // IN
+) *IMAReport {
+
+	report := &IMAReport{Measurements: len(entries), Unknown: []IMAFile{}, Denied: []IMAFile{}}
+	for k := range entries {
+		e := &entries[k]
+		if e.Path == "boot_aggregate" || e.violation() || p.excluded(e.Path) {
+			continue
+		}
+		file := IMAFile{Path: e.Path, Digest: e.FileDigestAlg + ":" + hex.EncodeToString(e.FileDigest)}
+		switch {
+		case containsDigest(p.Denylist, e.FileDigest):
+			report.Denied = append(report.Denied, file)
+		case !containsDigest(p.Allowlist[e.Path], e.FileDigest):
+			report.Unknown = append(report.Unknown, file)
+		}
+	}
+	for _, files := range [][]IMAFile{report.Unknown, report.Denied} {
+		sort.SliceStable(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	}
+	return report
+}
+
+func (p *IMAPolicy) excluded(
+	path string, // IN
+) bool {
+
+	for _, prefix := range p.Exclude {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// imaReplay holds the values of PCR 10 an IMA log leads to, entry after
+// entry, in the quoted banks.
+type imaReplay struct {
+	entries []IMAEntry
+	values  map[tpm2.Algorithm][][]byte
+	// Number of entries the quote covers, once known, or -1
+	quoted int
+}
+
+func replayIMA(
+	sels []tpm2.PCRSelection, // IN
+	imaLog []byte, // IN
+) (*imaReplay, error) {
+
+	entries, err := ParseIMALog(imaLog)
+	if err != nil {
+		return nil, err
+	}
+	r := &imaReplay{entries: entries, values: map[tpm2.Algorithm][][]byte{}, quoted: -1}
+	for _, sel := range sels {
+		if !containsInt(sel.PCRs, IMAPCR) {
+			continue
+		}
+		if r.values[sel.Hash], err = ReplayIMALog(entries, sel.Hash); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// pcr10 returns the values of PCR 10 after the first n entries.
+func (r *imaReplay) pcr10(
+	n int, // IN
+) Banks {
+
+	banks := Banks{}
+	for alg, values := range r.values {
+		banks[alg] = map[int][]byte{IMAPCR: values[n]}
+	}
+	return banks
+}
+
+// match runs check with the values of PCR 10 after the whole log, then after
+// fewer and fewer entries until it passes, which tells how many entries the
+// quote covers. It returns the error check fails with on the whole log. With
+// no IMA log, check runs once with no values.
+func (r *imaReplay) match(
+	check func(pcr10 Banks) error, // IN
+) error {
+
+	if r == nil || len(r.values) == 0 {
+		return check(nil)
+	}
+	err := check(r.pcr10(len(r.entries)))
+	if err == nil {
+		r.quoted = len(r.entries)
+		return nil
+	}
+	for n := len(r.entries) - 1; n >= 0; n-- {
+		if check(r.pcr10(n)) == nil {
+			r.quoted = n
+			return nil
+		}
+	}
+	return err
+}
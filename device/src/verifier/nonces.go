@@ -0,0 +1,123 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package verifier
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/go-tpm/tpm2"
+)
+
+// NonceSize is the size of the nonces issued by the verifier.
+const NonceSize = 32
+
+var (
+	// The nonce was never issued, or was already redeemed
+	ErrUnknownNonce = errors.New("unknown nonce")
+	// The nonce was issued but its TTL has elapsed
+	ErrNonceExpired = errors.New("nonce expired")
+	// As many challenges as the store can hold are outstanding
+	ErrTooManyChallenges = errors.New("too many outstanding challenges")
+)
+
+// NewNonce draws a fresh random nonce.
+func NewNonce() ([]byte, error) {
+	nonce := make([]byte, NonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("rand.Read() failed: %w", err)
+	}
+	return nonce, nil
+}
+
+// === Nonce store =============================================================
+
+// Challenge is a quote request issued to an attestor.
+type Challenge struct {
+	Nonce     []byte
+	Selection []tpm2.PCRSelection
+	Expires   time.Time
+}
+
+// NonceStore keeps track of the challenges issued and not yet redeemed. Each
+// nonce can be redeemed once, before its TTL elapses. Anyone may ask for a
+// challenge, so the store holds at most a given number of them, expired ones
+// aside.
+type NonceStore struct {
+	ttl        time.Duration
+	max        int
+	mu         sync.Mutex
+	challenges map[string]Challenge
+	// Clock, which tests may set
+	now func() time.Time
+}
+
+func NewNonceStore(
+	ttl time.Duration, // IN
+	max int, // IN
+) *NonceStore {
+
+	return &NonceStore{
+		ttl:        ttl,
+		max:        max,
+		challenges: map[string]Challenge{},
+		now:        time.Now,
+	}
+}
+
+// Issue draws a nonce and records a challenge for the given PCRs.
+func (s *NonceStore) Issue(
+	sels []tpm2.PCRSelection, // IN
+) (Challenge, error) {
+
+	nonce, err := NewNonce()
+	if err != nil {
+		return Challenge{}, err
+	}
+	now := s.now()
+	challenge := Challenge{
+		Nonce:     nonce,
+		Selection: append([]tpm2.PCRSelection{}, sels...),
+		Expires:   now.Add(s.ttl),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, c := range s.challenges {
+		if now.After(c.Expires) {
+			delete(s.challenges, key)
+		}
+	}
+	if len(s.challenges) >= s.max {
+		return Challenge{}, fmt.Errorf("%w: %d", ErrTooManyChallenges, len(s.challenges))
+	}
+	s.challenges[hex.EncodeToString(nonce)] = challenge
+
+	return challenge, nil
+}
+
+// Redeem returns the challenge a nonce was issued for and forgets it.
+func (s *NonceStore) Redeem(
+	nonce []byte, // IN
+) (Challenge, error) {
+
+	key := hex.EncodeToString(nonce)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	challenge, ok := s.challenges[key]
+	if !ok {
+		return Challenge{}, fmt.Errorf("%w: 0x%s", ErrUnknownNonce, key)
+	}
+	delete(s.challenges, key)
+	if s.now().After(challenge.Expires) {
+		return Challenge{}, fmt.Errorf("%w: 0x%s expired at %s", ErrNonceExpired, key,
+			challenge.Expires.Format(time.RFC3339))
+	}
+
+	return challenge, nil
+}
@@ -0,0 +1,144 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package verifier
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpmutil"
+
+	"main/src/pcrsel"
+)
+
+// === Quote decoding ==========================================================
+
+// TPM_GENERATED_VALUE, which starts every TPMS_ATTEST the TPM signs
+const generatedValue = 0xff544347
+
+// Quote is the content of a TPMS_ATTEST produced by TPM2_Quote().
+type Quote struct {
+	ExtraData []byte
+	// Quoted PCRs, bank by bank, in the order they were hashed
+	Selection []tpm2.PCRSelection
+	PCRDigest []byte
+}
+
+// DecodeQuote decodes a TPM2_Quote() attestation. Unlike
+// tpm2.DecodeAttestationData(), it accepts quotes over several banks.
+func DecodeQuote(
+	attestation []byte, // IN
+) (*Quote, error) {
+
+	buf := bytes.NewBuffer(attestation)
+	var (
+		magic           uint32
+		typ             tpmutil.Tag
+		qualifiedSigner tpmutil.U16Bytes
+		extraData       tpmutil.U16Bytes
+		// TPMS_CLOCK_INFO: clock, resetCount, restartCount, safe
+		clock        uint64
+		resetCount   uint32
+		restartCount uint32
+		safe         uint8
+		firmware     uint64
+	)
+	if err := tpmutil.UnpackBuf(buf, &magic, &typ, &qualifiedSigner, &extraData,
+		&clock, &resetCount, &restartCount, &safe, &firmware); err != nil {
+		return nil, fmt.Errorf("tpmutil.UnpackBuf() failed: %w", err)
+	}
+	if magic != generatedValue {
+		return nil, fmt.Errorf("magic is 0x%x, expected 0x%x", magic, generatedValue)
+	}
+	if typ != tpm2.TagAttestQuote {
+		return nil, fmt.Errorf("attestation type is 0x%x, expected 0x%x", typ, tpm2.TagAttestQuote)
+	}
+
+	// TPMS_QUOTE_INFO: TPML_PCR_SELECTION, TPM2B_DIGEST
+	var count uint32
+	if err := tpmutil.UnpackBuf(buf, &count); err != nil {
+		return nil, fmt.Errorf("tpmutil.UnpackBuf() failed: %w", err)
+	}
+	// TPML_PCR_SELECTION holds at most HASH_COUNT entries
+	if count > 16 {
+		return nil, fmt.Errorf("%d banks in PCR selection", count)
+	}
+	sels := []tpm2.PCRSelection{}
+	for k := uint32(0); k < count; k++ {
+		var hash tpm2.Algorithm
+		var size uint8
+		if err := tpmutil.UnpackBuf(buf, &hash, &size); err != nil {
+			return nil, fmt.Errorf("tpmutil.UnpackBuf() failed: %w", err)
+		}
+		bitmap := buf.Next(int(size))
+		if len(bitmap) != int(size) {
+			return nil, fmt.Errorf("PCR selection bitmap is truncated")
+		}
+		sel := tpm2.PCRSelection{Hash: hash, PCRs: []int{}}
+		for i := 0; i < 8*len(bitmap); i++ {
+			if bitmap[i/8]&(1<<(i%8)) != 0 {
+				sel.PCRs = append(sel.PCRs, i)
+			}
+		}
+		sels = append(sels, sel)
+	}
+	var pcrDigest tpmutil.U16Bytes
+	if err := tpmutil.UnpackBuf(buf, &pcrDigest); err != nil {
+		return nil, fmt.Errorf("tpmutil.UnpackBuf() failed: %w", err)
+	}
+	if buf.Len() != 0 {
+		return nil, fmt.Errorf("%d trailing bytes", buf.Len())
+	}
+
+	return &Quote{
+		ExtraData: extraData,
+		Selection: sels,
+		PCRDigest: pcrDigest,
+	}, nil
+}
+
+// === Selections ==============================================================
+
+// FormatSelection renders a selection as "sha1:0,7+sha256:0,1,2".
+func FormatSelection(
+	sels []tpm2.PCRSelection, // IN
+) string {
+
+	banks := []string{}
+	for _, sel := range sels {
+		indexes := []string{}
+		for _, i := range sel.PCRs {
+			indexes = append(indexes, strconv.Itoa(i))
+		}
+		banks = append(banks, pcrsel.Name(sel.Hash)+":"+strings.Join(indexes, ","))
+	}
+	return strings.Join(banks, "+")
+}
+
+// equalSelections tells whether two selections cover the same PCRs, whatever
+// the order of their banks.
+func equalSelections(
+	a []tpm2.PCRSelection, // IN
+	b []tpm2.PCRSelection, // IN
+) bool {
+
+	sorted := func(sels []tpm2.PCRSelection) []tpm2.PCRSelection {
+		sels = append([]tpm2.PCRSelection{}, sels...)
+		sort.Slice(sels, func(i, j int) bool { return sels[i].Hash < sels[j].Hash })
+		return sels
+	}
+	a, b = sorted(a), sorted(b)
+	if len(a) != len(b) {
+		return false
+	}
+	for k := range a {
+		if a[k].Hash != b[k].Hash || !equalInts(a[k].PCRs, b[k].PCRs) {
+			return false
+		}
+	}
+	return true
+}
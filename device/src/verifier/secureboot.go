@@ -0,0 +1,185 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package verifier
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"fmt"
+	"sort"
+
+	"github.com/google/go-attestation/attest"
+	"github.com/google/go-tpm/tpm2"
+
+	"main/src/pcrsel"
+	"main/src/uefi"
+)
+
+// === Secure Boot =============================================================
+
+// Rules of the Secure Boot policy
+const (
+	// PCR 7 events are quoted and well-formed
+	RuleSecureBootEvents = "events"
+	// SecureBoot is enabled
+	RuleSecureBootEnabled = "enabled"
+	// PK, KEK, db or dbx holds an accepted content
+	RuleSecureBootVariable = "variable"
+	// An image was verified with a permitted certificate
+	RuleSecureBootAuthority = "authority"
+)
+
+// Secure Boot variables whose content a policy can check
+var SecureBootVariables = []string{"PK", "KEK", "db", "dbx"}
+
+// SecureBootPolicy tells which Secure Boot configurations a device may boot
+// with, as the events log of PCR 7 tells them.
+type SecureBootPolicy struct {
+	// Accepted contents of PK, KEK, db and dbx (EFI_SIGNATURE_LISTs), as
+	// SHA-256 digests, by variable name; variables not listed are not checked
+	Variables map[string][][]byte
+	// Certificates images may be verified with (db entries, shim's built-in
+	// certificate...), as SHA-256 digests of their DER encoding
+	Authorities [][]byte
+}
+
+// Verdict is the outcome of one rule of a policy.
+type Verdict struct {
+	Rule string `json:"rule"`
+	// What the rule applies to, e.g. a variable name or a certificate
+	// subject
+	Subject string `json:"subject,omitempty"`
+	Passed  bool   `json:"passed"`
+	Message string `json:"message"`
+}
+
+// Check evaluates the policy against the PCR 7 events of an events log, which
+// must replay to the quoted PCRs. It returns a verdict per rule and subject.
+func (p *SecureBootPolicy) Check(
+	sels []tpm2.PCRSelection, // IN
+	eventsLog []byte, // IN
+) []Verdict {
+
+	verdicts := []Verdict{}
+	verdict := func(rule, subject string, passed bool, format string, a ...interface{}) {
+		verdicts = append(verdicts, Verdict{
+			Rule:    rule,
+			Subject: subject,
+			Passed:  passed,
+			Message: fmt.Sprintf(format, a...),
+		})
+	}
+
+	// Only quoted events are trusted: read them in the strongest bank PCR 7
+	// is quoted in
+	parsedEventsLog, err := attest.ParseEventLog(eventsLog)
+	if err != nil {
+		verdict(RuleSecureBootEvents, "", false, "attest.ParseEventLog() failed: %v", err)
+		return verdicts
+	}
+	alg := tpm2.AlgUnknown
+	for _, sel := range sels {
+		for _, a := range parsedEventsLog.Algs {
+			if tpm2.Algorithm(a) == sel.Hash && containsInt(sel.PCRs, 7) {
+				alg = sel.Hash
+			}
+		}
+	}
+	if alg == tpm2.AlgUnknown {
+		verdict(RuleSecureBootEvents, "", false, "PCR 7 is not quoted in a bank the events log has digests for")
+		return verdicts
+	}
+	events := parsedEventsLog.Events(attest.HashAlg(alg))
+	state, err := attest.ParseSecurebootState(events)
+	if err != nil {
+		verdict(RuleSecureBootEvents, "", false, "attest.ParseSecurebootState() failed: %v", err)
+		return verdicts
+	}
+	verdict(RuleSecureBootEvents, "", true, "%s PCR 7 events are well-formed", pcrsel.Name(alg))
+
+	if state.Enabled {
+		verdict(RuleSecureBootEnabled, "SecureBoot", true, "Secure Boot is enabled")
+	} else {
+		verdict(RuleSecureBootEnabled, "SecureBoot", false, "Secure Boot is disabled")
+	}
+
+	// Content of the Secure Boot variables
+	measured := map[string][]byte{}
+	for _, e := range events {
+		if e.Index != 7 || e.Type != attest.EventType(uefi.EvEFIVariableDriverConfig) {
+			continue
+		}
+		_, name, data, err := uefi.ParseVariableData(e.Data)
+		if err != nil {
+			verdict(RuleSecureBootEvents, "", false, "%s", err)
+			continue
+		}
+		digest := sha256.Sum256(data)
+		measured[name] = digest[:]
+	}
+	names := []string{}
+	for name := range p.Variables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		digest, ok := measured[name]
+		switch {
+		case !ok:
+			verdict(RuleSecureBootVariable, name, false, "%s is not measured", name)
+		case !containsDigest(p.Variables[name], digest):
+			verdict(RuleSecureBootVariable, name, false, "%s content 0x%x is not an accepted one", name, digest)
+		default:
+			verdict(RuleSecureBootVariable, name, true, "%s content 0x%x is accepted", name, digest)
+		}
+	}
+
+	// Certificates the boot images were verified with
+	authorities := append(state.PreSeparatorAuthority, state.PostSeparatorAuthority...)
+	for k := range authorities {
+		c := &authorities[k]
+		digest := sha256.Sum256(c.Raw)
+		if containsDigest(p.Authorities, digest[:]) {
+			verdict(RuleSecureBootAuthority, subject(c), true, "Images verified with %q (0x%x) are permitted",
+				subject(c), digest)
+		} else {
+			verdict(RuleSecureBootAuthority, subject(c), false, "Images verified with %q (0x%x) are not permitted",
+				subject(c), digest)
+		}
+	}
+	if state.Enabled && len(authorities) == 0 {
+		verdict(RuleSecureBootAuthority, "", false, "No image was verified with a certificate")
+	}
+
+	return verdicts
+}
+
+// subject names the subject of a certificate.
+func subject(
+	c *x509.Certificate, // IN
+) string {
+
+	if c.Subject.CommonName != "" {
+		return c.Subject.CommonName
+	}
+	return c.Subject.String()
+}
+
+func containsDigest(digests [][]byte, digest []byte) bool {
+	for _, d := range digests {
+		if bytes.Equal(d, digest) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsInt(ints []int, i int) bool {
+	for _, n := range ints {
+		if n == i {
+			return true
+		}
+	}
+	return false
+}
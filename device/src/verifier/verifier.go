@@ -0,0 +1,685 @@
+// SPDX-License-Identifier: Apache-2.0
+
+// Package verifier checks TPM quotes from in-memory inputs. It does no file
+// I/O and no logging, so that it can be embedded in a server as well as used
+// by the device-side steps.
+package verifier
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/go-tpm/tpm2"
+
+	"main/src/pcrsel"
+	"main/src/replay"
+)
+
+// === Errors ==================================================================
+
+// Failed checks wrap these errors, so that callers can tell them apart with
+// errors.Is.
+var (
+	// The quote cannot be decoded or is not a TPM2_Quote() attestation
+	ErrBadAttestation = errors.New("bad attestation")
+	// The nonce embedded in a quote is not the one the verifier expects
+	ErrNonceMismatch = errors.New("nonce mismatch")
+	// The quote does not cover the expected PCRs
+	ErrPCRSelectionMismatch = errors.New("PCR selection mismatch")
+	// The PCRs digest in a quote is not the predicted one
+	ErrPCRDigestMismatch = errors.New("PCR digest mismatch")
+	// A quote signature does not verify with the AK public key
+	ErrBadSignature = errors.New("bad signature")
+	// The PCR values sent along with a quote are not the quoted ones
+	ErrPCRValuesMismatch = errors.New("PCR values mismatch")
+	// The events log sent along with a quote does not lead to the quoted PCRs
+	ErrEventsLogMismatch = errors.New("events log mismatch")
+	// The Secure Boot state the events log tells breaks the policy, or cannot
+	// be told
+	ErrSecureBootPolicy = errors.New("Secure Boot policy violation")
+	// The events the events log tells break the events policy, or cannot be
+	// told
+	ErrEventsPolicy = errors.New("events policy violation")
+	// The IMA log sent along with a quote does not lead to the quoted PCR 10
+	ErrIMALogMismatch = errors.New("IMA log mismatch")
+	// The files the IMA log tells were measured break the policy
+	ErrIMAPolicy = errors.New("IMA policy violation")
+)
+
+// === Result ==================================================================
+
+// Names of the checks, in the order they are performed
+const (
+	CheckAttestation  = "attestation"
+	CheckNonce        = "nonce"
+	CheckPCRSelection = "pcr-selection"
+	CheckPCRDigest    = "pcr-digest"
+	CheckSignature    = "signature"
+	CheckPCRValues    = "pcr-values"
+	CheckEventsLog    = "events-log"
+	CheckSecureBoot   = "secure-boot"
+	CheckEventsPolicy = "events-policy"
+	CheckIMA          = "ima"
+)
+
+// Check is the outcome of one verification step.
+type Check struct {
+	Name    string `json:"name"`
+	Passed  bool   `json:"passed"`
+	Message string `json:"message"`
+	Err     error  `json:"-"`
+}
+
+// Result lists every check performed on a quote, passed or failed.
+type Result struct {
+	Checks []Check `json:"checks"`
+	// Set when the PCRs differ from the reference values and the evidence
+	// tells how
+	Diagnostics *Diagnostics `json:"diagnostics,omitempty"`
+	// Verdicts of the Secure Boot policy, when there is one
+	SecureBoot []Verdict `json:"secure-boot,omitempty"`
+	// Verdicts of the events policy, when there is one
+	EventsPolicy []Verdict `json:"events-policy,omitempty"`
+	// Files the IMA policy does not accept, when there is one
+	IMA *IMAReport `json:"ima,omitempty"`
+}
+
+func (r *Result) pass(name string, format string, a ...interface{}) {
+	r.Checks = append(r.Checks, Check{
+		Name:    name,
+		Passed:  true,
+		Message: fmt.Sprintf(format, a...),
+	})
+}
+
+func (r *Result) fail(name string, err error) {
+	r.Checks = append(r.Checks, Check{
+		Name:    name,
+		Passed:  false,
+		Message: err.Error(),
+		Err:     err,
+	})
+}
+
+// OK tells whether all checks passed.
+func (r *Result) OK() bool {
+	return r.Err() == nil
+}
+
+// Err returns the error of the first failed check, or nil.
+func (r *Result) Err() error {
+	for _, c := range r.Checks {
+		if !c.Passed {
+			return fmt.Errorf("%s check failed: %w", c.Name, c.Err)
+		}
+	}
+	return nil
+}
+
+// === Verifier ================================================================
+
+// Banks holds PCR values by bank and PCR index.
+type Banks map[tpm2.Algorithm]map[int][]byte
+
+// Reference is one accepted set of reference values, e.g. the PCR values of
+// one firmware release.
+type Reference struct {
+	// Name of the set, reported when a quote matches it
+	Name string
+	// Expected PCR values
+	PCRs Banks
+	// Expected PCRs digests of some selections, for PCRs whose values are not
+	// published
+	Digests []Digest
+	// Reference events log the PCR values result from, if any, to tell which
+	// events differ
+	EventsLog []byte
+}
+
+// Digest is the expected PCRs digest of a quote over some selection.
+type Digest struct {
+	Selection []tpm2.PCRSelection
+	Value     []byte
+}
+
+// digest returns the expected PCRs digest of a quote over sels.
+func (r *Reference) digest(
+	sels []tpm2.PCRSelection, // IN
+) ([]byte, error) {
+
+	for _, d := range r.Digests {
+		if equalSelections(d.Selection, sels) {
+			return d.Value, nil
+		}
+	}
+	if r.PCRs == nil {
+		return nil, fmt.Errorf("no digest for PCRs %s", FormatSelection(sels))
+	}
+	return PCRsDigest(sels, r.PCRs)
+}
+
+// Verifier holds the references a quote is checked against. Exactly one of
+// PCRDigest, PCRs and References provides the reference values.
+type Verifier struct {
+	// AK public key the quotes must be signed with
+	AKPublicKey crypto.PublicKey
+	// PCRs the quotes must cover, bank by bank; when empty, the selection is
+	// not checked
+	Selection []tpm2.PCRSelection
+	// Expected PCRs digest, e.g. as predicted by the CICD
+	PCRDigest []byte
+	// Expected PCR values, e.g. as returned by ReplayEventsLog()
+	PCRs Banks
+	// Reference events log the PCR values result from, if any, to tell
+	// which events differ
+	EventsLog []byte
+	// Accepted sets of reference values, e.g. from a reference-value
+	// manifest: a quote passes if it matches any of them
+	References []Reference
+	// Secure Boot configurations the events log must tell, if any
+	SecureBootPolicy *SecureBootPolicy
+	// Rules the events of some PCRs must comply with, in place of reference
+	// values for these PCRs, if any
+	EventsPolicy *EventsPolicy
+	// Files the IMA log may tell were measured, if any
+	IMAPolicy *IMAPolicy
+}
+
+// New returns a Verifier for quotes signed by a PEM-encoded AK public key.
+func New(
+	akPubPEM []byte, // IN
+) (*Verifier, error) {
+
+	akPublicKey, err := ParsePublicKeyPEM(akPubPEM)
+	if err != nil {
+		return nil, err
+	}
+	return &Verifier{AKPublicKey: akPublicKey}, nil
+}
+
+// WithEventsLog sets the reference PCR values from an events log replay.
+func (v *Verifier) WithEventsLog(
+	eventsLog []byte, // IN
+) error {
+
+	pcrs, err := ReplayEventsLog(eventsLog)
+	if err != nil {
+		return err
+	}
+	v.PCRs = pcrs
+	v.EventsLog = eventsLog
+	return nil
+}
+
+// Evidence is what an attestor sends back for a challenge.
+type Evidence struct {
+	// TPM2_Quote() output
+	Nonce       []byte
+	Attestation []byte
+	Signature   []byte
+	// Optional PCR values read along with the quote
+	PCRValues Banks
+	// Optional raw TCG events log the PCR values result from
+	EventsLog []byte
+	// Optional IMA runtime measurement list, in the binary format, PCR 10
+	// results from
+	IMALog []byte
+}
+
+// VerifyQuote checks a TPM2_Quote() attestation and its signature.
+func (v *Verifier) VerifyQuote(
+	nonce []byte, // IN
+	attestation []byte, // IN
+	signature []byte, // IN
+) *Result {
+
+	return v.VerifyEvidence(Evidence{
+		Nonce:       nonce,
+		Attestation: attestation,
+		Signature:   signature,
+	})
+}
+
+// VerifyEvidence checks a TPM2_Quote() attestation and its signature, then
+// whatever PCR values and events log come with it. All checks are performed
+// even when some fail; only an undecodable attestation stops the verification
+// early.
+func (v *Verifier) VerifyEvidence(
+	evidence Evidence, // IN
+) *Result {
+
+	result := &Result{}
+	nonce := evidence.Nonce
+
+	att, err := DecodeQuote(evidence.Attestation)
+	if err != nil {
+		result.fail(CheckAttestation, fmt.Errorf("%w: DecodeQuote() failed: %v", ErrBadAttestation, err))
+		return result
+	}
+	result.pass(CheckAttestation, "Attestation is a quote")
+
+	// Compare the nonce that is embedded within the attestation. This should
+	// match the one we sent in earlier.
+	if !bytes.Equal(nonce, att.ExtraData) {
+		result.fail(CheckNonce, fmt.Errorf("%w: got 0x%s, expected 0x%s", ErrNonceMismatch,
+			hex.EncodeToString(att.ExtraData), hex.EncodeToString(nonce)))
+	} else {
+		result.pass(CheckNonce, "Nonce from quote matches expected nonce")
+	}
+
+	quoted := att.Selection
+	if len(v.Selection) != 0 {
+		if !equalSelections(quoted, v.Selection) {
+			result.fail(CheckPCRSelection, fmt.Errorf("%w: got %s, expected %s",
+				ErrPCRSelectionMismatch, FormatSelection(quoted), FormatSelection(v.Selection)))
+		} else {
+			result.pass(CheckPCRSelection, "Quote covers PCRs %s", FormatSelection(quoted))
+		}
+	}
+
+	// IMA extends PCR 10 at runtime: its expected value is the one the IMA
+	// log replays to, after as many entries as the quote covers, for the log
+	// may have grown since
+	var ima *imaReplay
+	var imaErr error
+	if evidence.IMALog != nil {
+		ima, imaErr = replayIMA(quoted, evidence.IMALog)
+	}
+
+	// The PCRs the events policy vouches for take the values the events log
+	// replays to, for the policy checks what these values mean
+	var overrides Banks
+	if v.EventsPolicy != nil && evidence.EventsLog != nil {
+		if replayed, err := ReplayEventsLog(evidence.EventsLog); err == nil {
+			overrides = Banks{}
+			for alg, pcrs := range replayed {
+				overrides[alg] = map[int][]byte{}
+				for _, i := range v.EventsPolicy.PCRs {
+					overrides[alg][i] = pcrs[i]
+				}
+			}
+		}
+	}
+
+	// Only the IMA policy vouches for the PCR 10 the IMA log replays to:
+	// without one, PCR 10 keeps its reference values
+	digestIMA := ima
+	if v.IMAPolicy == nil {
+		digestIMA = nil
+	}
+
+	if matched, err := v.checkPCRDigest(quoted, att.PCRDigest, overrides, digestIMA); err != nil {
+		// Tell which PCRs, and which events, differ when the evidence allows
+		if errors.Is(err, ErrPCRDigestMismatch) {
+			if diagnostics, diagErr := v.Diagnose(quoted, att.PCRDigest, evidence); diagErr == nil {
+				result.Diagnostics = diagnostics
+				if diagnostics.Verified {
+					err = fmt.Errorf("%w; PCRs %v differ", err, diagnostics.Differing())
+				} else {
+					err = fmt.Errorf("%w; PCRs %v seem to differ, as told by an events log that does not replay to the quote",
+						err, diagnostics.Differing())
+				}
+			}
+		}
+		result.fail(CheckPCRDigest, err)
+	} else if matched != "" {
+		result.pass(CheckPCRDigest, "PCRs digest from quote matches reference values %q", matched)
+	} else {
+		result.pass(CheckPCRDigest, "PCRs digest from quote matches expected digest")
+	}
+
+	if err := v.verifySignature(evidence.Attestation, evidence.Signature); err != nil {
+		result.fail(CheckSignature, err)
+	} else {
+		result.pass(CheckSignature, "Quote signature is valid")
+	}
+
+	// The PCR values are only as good as the quote they hash to
+	if evidence.PCRValues != nil {
+		if err := checkPCRValues(quoted, evidence.PCRValues, att.PCRDigest); err != nil {
+			result.fail(CheckPCRValues, err)
+		} else {
+			result.pass(CheckPCRValues, "PCR values match the quoted digest")
+		}
+	}
+
+	var eventsLogErr error
+	if evidence.EventsLog != nil {
+		eventsLogErr = ima.match(func(pcr10 Banks) error {
+			return checkEventsLog(quoted, evidence.EventsLog, att.PCRDigest, pcr10)
+		})
+		if eventsLogErr != nil {
+			result.fail(CheckEventsLog, eventsLogErr)
+		} else {
+			result.pass(CheckEventsLog, "Events log replays to the quoted digest")
+		}
+	}
+
+	// The Secure Boot state is only as good as the events log it is read
+	// from, which must replay to the quote
+	if v.SecureBootPolicy != nil {
+		switch {
+		case evidence.EventsLog == nil:
+			result.fail(CheckSecureBoot, fmt.Errorf("%w: no events log to read the Secure Boot state from",
+				ErrSecureBootPolicy))
+		case eventsLogErr != nil:
+			result.fail(CheckSecureBoot, fmt.Errorf("%w: the events log is not the quoted one",
+				ErrSecureBootPolicy))
+		default:
+			result.SecureBoot = v.SecureBootPolicy.Check(quoted, evidence.EventsLog)
+			failed := []string{}
+			for _, verdict := range result.SecureBoot {
+				if !verdict.Passed {
+					failed = append(failed, verdict.Message)
+				}
+			}
+			if len(failed) != 0 {
+				result.fail(CheckSecureBoot, fmt.Errorf("%w: %s", ErrSecureBootPolicy, strings.Join(failed, "; ")))
+			} else {
+				result.pass(CheckSecureBoot, "Secure Boot state complies with the policy")
+			}
+		}
+	}
+
+	// So are the events the events policy vouches for
+	if v.EventsPolicy != nil {
+		switch {
+		case evidence.EventsLog == nil:
+			result.fail(CheckEventsPolicy, fmt.Errorf("%w: no events log to read the events from", ErrEventsPolicy))
+		case eventsLogErr != nil:
+			result.fail(CheckEventsPolicy, fmt.Errorf("%w: the events log is not the quoted one", ErrEventsPolicy))
+		default:
+			result.EventsPolicy = v.EventsPolicy.Check(quoted, evidence.EventsLog)
+			failed := []string{}
+			for _, verdict := range result.EventsPolicy {
+				if !verdict.Passed {
+					failed = append(failed, verdict.Message)
+				}
+			}
+			if len(failed) != 0 {
+				result.fail(CheckEventsPolicy, fmt.Errorf("%w: %s", ErrEventsPolicy, strings.Join(failed, "; ")))
+			} else {
+				result.pass(CheckEventsPolicy, "Events of PCRs %v comply with the policy", v.EventsPolicy.PCRs)
+			}
+		}
+	}
+
+	// So are the IMA measurements, up to the entries the quote covers
+	if evidence.IMALog != nil || v.IMAPolicy != nil {
+		switch {
+		case !quotesPCR(quoted, IMAPCR):
+			result.fail(CheckIMA, fmt.Errorf("%w: PCR[%d] is not quoted", ErrIMALogMismatch, IMAPCR))
+		case evidence.IMALog == nil:
+			result.fail(CheckIMA, fmt.Errorf("%w: no IMA log to read the measured files from", ErrIMAPolicy))
+		case imaErr != nil:
+			result.fail(CheckIMA, fmt.Errorf("%w: %v", ErrIMALogMismatch, imaErr))
+		case ima.quoted < 0:
+			result.fail(CheckIMA, fmt.Errorf("%w: no prefix of the IMA log replays to the quoted PCR[%d]",
+				ErrIMALogMismatch, IMAPCR))
+		case v.IMAPolicy == nil:
+			result.pass(CheckIMA, "IMA log replays to the quoted PCR[%d] after %d of its %d entries",
+				IMAPCR, ima.quoted, len(ima.entries))
+		default:
+			result.IMA = v.IMAPolicy.Check(ima.entries[:ima.quoted])
+			if !result.IMA.OK() {
+				result.fail(CheckIMA, fmt.Errorf("%w: %d unknown and %d denied files out of %d measurements",
+					ErrIMAPolicy, len(result.IMA.Unknown), len(result.IMA.Denied), result.IMA.Measurements))
+			} else {
+				result.pass(CheckIMA, "All %d IMA measurements comply with the policy", result.IMA.Measurements)
+			}
+		}
+	}
+
+	return result
+}
+
+// checkPCRDigest compares the quoted PCRs digest with the digests expected
+// from each set of reference values, and returns the name of the set it
+// matches. Overrides replace the values of some PCRs in the references, and
+// the PCR 10 values are those the IMA log replays to, if given.
+func (v *Verifier) checkPCRDigest(
+	sels []tpm2.PCRSelection, // IN
+	quotedDigest []byte, // IN
+	overrides Banks, // IN
+	ima *imaReplay, // IN
+) (string, error) {
+
+	matched := ""
+	err := ima.match(func(pcr10 Banks) error {
+		var err error
+		matched, err = v.matchPCRDigest(sels, quotedDigest, overrides.with(pcr10))
+		return err
+	})
+	return matched, err
+}
+
+func (v *Verifier) matchPCRDigest(
+	sels []tpm2.PCRSelection, // IN
+	quotedDigest []byte, // IN
+	overrides Banks, // IN
+) (string, error) {
+
+	if v.PCRDigest != nil {
+		if !bytes.Equal(v.PCRDigest, quotedDigest) {
+			return "", fmt.Errorf("%w: got 0x%s, expected 0x%s", ErrPCRDigestMismatch,
+				hex.EncodeToString(quotedDigest), hex.EncodeToString(v.PCRDigest))
+		}
+		return "", nil
+	}
+	references := v.references()
+	if len(references) == 0 {
+		return "", fmt.Errorf("%w: no reference values", ErrPCRDigestMismatch)
+	}
+
+	expected := []string{}
+	var lastErr error
+	for _, r := range references {
+		if r.PCRs != nil && len(overrides) != 0 {
+			r.PCRs = r.PCRs.with(overrides)
+		}
+		pcrDigest, err := r.digest(sels)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if bytes.Equal(pcrDigest, quotedDigest) {
+			return r.Name, nil
+		}
+		expected = append(expected, "0x"+hex.EncodeToString(pcrDigest))
+	}
+	if len(expected) == 0 {
+		return "", fmt.Errorf("%w: no reference value: %v", ErrPCRDigestMismatch, lastErr)
+	}
+	return "", fmt.Errorf("%w: got 0x%s, expected %s", ErrPCRDigestMismatch,
+		hex.EncodeToString(quotedDigest), strings.Join(expected, " or "))
+}
+
+// references lists the accepted sets of reference values, the PCR values set
+// by WithEventsLog() first.
+func (v *Verifier) references() []Reference {
+	references := []Reference{}
+	if v.PCRs != nil {
+		references = append(references, Reference{PCRs: v.PCRs, EventsLog: v.EventsLog})
+	}
+	return append(references, v.References...)
+}
+
+func (v *Verifier) verifySignature(
+	attestation []byte, // IN
+	signature []byte, // IN
+) error {
+
+	hsh := crypto.SHA256.New()
+	hsh.Write(attestation)
+
+	switch akPublicKey := v.AKPublicKey.(type) {
+	case *rsa.PublicKey:
+		err := rsa.VerifyPKCS1v15(akPublicKey, crypto.SHA256, hsh.Sum(nil), signature)
+		if err != nil {
+			return fmt.Errorf("%w: rsa.VerifyPKCS1v15() failed: %v", ErrBadSignature, err)
+		}
+		return nil
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(akPublicKey, hsh.Sum(nil), signature) {
+			return fmt.Errorf("%w: ecdsa.VerifyASN1() failed", ErrBadSignature)
+		}
+		return nil
+	default:
+		return fmt.Errorf("%w: unsupported AK type %T", ErrBadSignature, v.AKPublicKey)
+	}
+}
+
+// === Helpers =================================================================
+
+// PCRsDigest hashes PCR values in the order TPM2_Quote() concatenates them:
+// bank after bank, by increasing PCR index. The digest is made with the hash
+// of the AK signing scheme, SHA-256, whatever the banks.
+func PCRsDigest(
+	sels []tpm2.PCRSelection, // IN
+	pcrValues Banks, // IN
+) ([]byte, error) {
+
+	pcrsConcat := []byte{}
+	for _, sel := range sels {
+		for _, i := range sel.PCRs {
+			value, ok := pcrValues[sel.Hash][i]
+			if !ok {
+				return nil, fmt.Errorf("no %s value for PCR[%d]", pcrsel.Name(sel.Hash), i)
+			}
+			pcrsConcat = append(pcrsConcat, value...)
+		}
+	}
+	digest := sha256.Sum256(pcrsConcat)
+	return digest[:], nil
+}
+
+func checkPCRValues(
+	sels []tpm2.PCRSelection, // IN
+	pcrValues Banks, // IN
+	quotedDigest []byte, // IN
+) error {
+
+	digest, err := PCRsDigest(sels, pcrValues)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrPCRValuesMismatch, err)
+	}
+	if !bytes.Equal(digest, quotedDigest) {
+		return fmt.Errorf("%w: PCR values hash to 0x%s, quote has 0x%s", ErrPCRValuesMismatch,
+			hex.EncodeToString(digest), hex.EncodeToString(quotedDigest))
+	}
+	return nil
+}
+
+// checkEventsLog replays an events log to the quoted digest; overrides
+// provides the values of PCRs the events log does not tell, if any.
+func checkEventsLog(
+	sels []tpm2.PCRSelection, // IN
+	eventsLog []byte, // IN
+	quotedDigest []byte, // IN
+	overrides Banks, // IN
+) error {
+
+	replayed, err := ReplayEventsLog(eventsLog)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrEventsLogMismatch, err)
+	}
+	replayed = replayed.with(overrides)
+	digest, err := PCRsDigest(sels, replayed)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrEventsLogMismatch, err)
+	}
+	if !bytes.Equal(digest, quotedDigest) {
+		return fmt.Errorf("%w: events log replays to 0x%s, quote has 0x%s", ErrEventsLogMismatch,
+			hex.EncodeToString(digest), hex.EncodeToString(quotedDigest))
+	}
+	return nil
+}
+
+// ParsePublicKeyPEM decodes a PEM-encoded "PUBLIC KEY" block.
+func ParsePublicKeyPEM(
+	publicKeyPEM []byte, // IN
+) (crypto.PublicKey, error) {
+
+	publicKeyBlock, _ := pem.Decode(publicKeyPEM)
+	if publicKeyBlock == nil {
+		return nil, fmt.Errorf("pem.Decode() failed")
+	}
+	if publicKeyBlock.Type != "PUBLIC KEY" {
+		return nil, fmt.Errorf("Block is not of type PUBLIC KEY: %v", publicKeyBlock.Type)
+	}
+	publicKey, err := x509.ParsePKIXPublicKey(publicKeyBlock.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("x509.ParsePKIXPublicKey() failed: %w", err)
+	}
+	return publicKey, nil
+}
+
+// ReplayEventsLog computes the PCR values an events log leads to, in every
+// bank the log has digests for, see replay.EventsLog().
+func ReplayEventsLog(
+	eventsLog []byte, // IN
+) (Banks, error) {
+
+	banks, err := replay.EventsLog(eventsLog)
+	if err != nil {
+		return nil, err
+	}
+	return Banks(banks), nil
+}
+
+// with returns a copy of banks where the values of overrides replace theirs.
+func (banks Banks) with(
+	overrides Banks, // IN
+) Banks {
+
+	merged := Banks{}
+	for alg, pcrs := range banks {
+		merged[alg] = map[int][]byte{}
+		for i, value := range pcrs {
+			merged[alg][i] = value
+		}
+	}
+	for alg, pcrs := range overrides {
+		if merged[alg] == nil {
+			merged[alg] = map[int][]byte{}
+		}
+		for i, value := range pcrs {
+			merged[alg][i] = value
+		}
+	}
+	return merged
+}
+
+func quotesPCR(
+	sels []tpm2.PCRSelection, // IN
+	pcr int, // IN
+) bool {
+
+	for _, sel := range sels {
+		if containsInt(sel.PCRs, pcr) {
+			return true
+		}
+	}
+	return false
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
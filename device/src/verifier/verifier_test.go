@@ -0,0 +1,393 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package verifier
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-attestation/attest"
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpmutil"
+)
+
+// value returns a PCR value of a bank filled with b.
+func value(alg tpm2.Algorithm, b byte) []byte {
+	if alg == tpm2.AlgSHA1 {
+		return bytes.Repeat([]byte{b}, sha1.Size)
+	}
+	return bytes.Repeat([]byte{b}, sha256.Size)
+}
+
+// encodeQuote encodes a TPMS_ATTEST of a quote, as TPM2_Quote() returns it.
+func encodeQuote(t *testing.T, extraData []byte, sels []tpm2.PCRSelection, pcrDigest []byte) []byte {
+	t.Helper()
+
+	attestation, err := tpmutil.Pack(uint32(generatedValue), tpm2.TagAttestQuote,
+		tpmutil.U16Bytes("qualified signer"), tpmutil.U16Bytes(extraData),
+		uint64(1000), uint32(1), uint32(0), uint8(1), // TPMS_CLOCK_INFO
+		uint64(0x20191023), // firmwareVersion
+		uint32(len(sels)))
+	if err != nil {
+		t.Fatalf("tpmutil.Pack() failed: %v", err)
+	}
+	for _, sel := range sels {
+		bitmap := make([]byte, 3)
+		for _, i := range sel.PCRs {
+			bitmap[i/8] |= 1 << (i % 8)
+		}
+		attestation = append(attestation, byte(sel.Hash>>8), byte(sel.Hash), byte(len(bitmap)))
+		attestation = append(attestation, bitmap...)
+	}
+	digest, err := tpmutil.Pack(tpmutil.U16Bytes(pcrDigest))
+	if err != nil {
+		t.Fatalf("tpmutil.Pack() failed: %v", err)
+	}
+	return append(attestation, digest...)
+}
+
+// === Quote decoding ==========================================================
+
+func TestDecodeQuote(t *testing.T) {
+	nonce := []byte("nonce")
+	sels := []tpm2.PCRSelection{
+		{Hash: tpm2.AlgSHA1, PCRs: []int{0, 7}},
+		{Hash: tpm2.AlgSHA256, PCRs: []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 14, 23}},
+	}
+	pcrDigest := value(tpm2.AlgSHA256, 0xaa)
+	valid := encodeQuote(t, nonce, sels, pcrDigest)
+
+	quote, err := DecodeQuote(valid)
+	if err != nil {
+		t.Fatalf("DecodeQuote() failed: %v", err)
+	}
+	if !bytes.Equal(quote.ExtraData, nonce) || !bytes.Equal(quote.PCRDigest, pcrDigest) ||
+		!equalSelections(quote.Selection, sels) {
+		t.Errorf("DecodeQuote() returned %s %x %x, expected %s %x %x", FormatSelection(quote.Selection),
+			quote.ExtraData, quote.PCRDigest, FormatSelection(sels), nonce, pcrDigest)
+	}
+
+	// Every truncation of a valid quote is rejected
+	for n := 0; n < len(valid); n++ {
+		if _, err := DecodeQuote(valid[:n]); err == nil {
+			t.Errorf("DecodeQuote() accepted a quote truncated to %d of %d bytes", n, len(valid))
+		}
+	}
+
+	// Offset of the TPML_PCR_SELECTION count in valid
+	countOffset := 4 + 2 + 2 + len("qualified signer") + 2 + len(nonce) + 17 + 8
+	if count := binary.BigEndian.Uint32(valid[countOffset:]); count != uint32(len(sels)) {
+		t.Fatalf("PCR selection count at offset %d is %d, expected %d", countOffset, count, len(sels))
+	}
+	patch := func(offset int, data ...byte) []byte {
+		patched := append([]byte{}, valid...)
+		copy(patched[offset:], data)
+		return patched
+	}
+	for _, tc := range []struct {
+		name        string
+		attestation []byte
+	}{
+		{"empty", nil},
+		{"bad magic", patch(0, 0xde, 0xad, 0xbe, 0xef)},
+		{"certify", patch(4, 0x80, 0x17)},
+		{"oversized extra data", patch(4+2+2+len("qualified signer"), 0xff, 0xff)},
+		{"oversized selection", patch(countOffset, 0xff, 0xff, 0xff, 0xff)},
+		{"17 banks", patch(countOffset, 0, 0, 0, 17)},
+		{"oversized bitmap", patch(countOffset+4+2, 0xff)},
+		{"oversized digest", patch(len(valid)-len(pcrDigest)-2, 0xff, 0xff)},
+		{"trailing bytes", append(append([]byte{}, valid...), 0)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := DecodeQuote(tc.attestation); err == nil {
+				t.Errorf("DecodeQuote() accepted a malformed quote")
+			}
+		})
+	}
+}
+
+// === PCRs digest =============================================================
+
+func TestPCRsDigest(t *testing.T) {
+	banks := Banks{
+		tpm2.AlgSHA1:   {0: value(tpm2.AlgSHA1, 1), 7: value(tpm2.AlgSHA1, 2)},
+		tpm2.AlgSHA256: {0: value(tpm2.AlgSHA256, 3), 7: value(tpm2.AlgSHA256, 4)},
+	}
+	concat := func(values ...[]byte) []byte {
+		digest := sha256.Sum256(bytes.Join(values, nil))
+		return digest[:]
+	}
+	for _, tc := range []struct {
+		name     string
+		sels     []tpm2.PCRSelection
+		expected []byte // Nil if no digest can be computed
+	}{
+		{"one bank", []tpm2.PCRSelection{{Hash: tpm2.AlgSHA256, PCRs: []int{0, 7}}},
+			concat(banks[tpm2.AlgSHA256][0], banks[tpm2.AlgSHA256][7])},
+		{"two banks", []tpm2.PCRSelection{
+			{Hash: tpm2.AlgSHA1, PCRs: []int{0, 7}},
+			{Hash: tpm2.AlgSHA256, PCRs: []int{7}},
+		}, concat(banks[tpm2.AlgSHA1][0], banks[tpm2.AlgSHA1][7], banks[tpm2.AlgSHA256][7])},
+		{"two banks, SHA-256 first", []tpm2.PCRSelection{
+			{Hash: tpm2.AlgSHA256, PCRs: []int{7}},
+			{Hash: tpm2.AlgSHA1, PCRs: []int{0, 7}},
+		}, concat(banks[tpm2.AlgSHA256][7], banks[tpm2.AlgSHA1][0], banks[tpm2.AlgSHA1][7])},
+		{"missing PCR", []tpm2.PCRSelection{{Hash: tpm2.AlgSHA1, PCRs: []int{0, 8}}}, nil},
+		{"missing bank", []tpm2.PCRSelection{{Hash: tpm2.AlgSHA384, PCRs: []int{0}}}, nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			digest, err := PCRsDigest(tc.sels, banks)
+			if tc.expected == nil {
+				if err == nil {
+					t.Errorf("PCRsDigest() succeeded, expected failure")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("PCRsDigest() failed: %v", err)
+			}
+			if !bytes.Equal(digest, tc.expected) {
+				t.Errorf("PCRsDigest() returned 0x%x, expected 0x%x", digest, tc.expected)
+			}
+		})
+	}
+}
+
+// imaEntry encodes an ima-ng entry of the binary IMA runtime measurement list.
+func imaEntry(path string) []byte {
+	le := binary.LittleEndian
+	field := func(buf *bytes.Buffer, data []byte) {
+		binary.Write(buf, le, uint32(len(data)))
+		buf.Write(data)
+	}
+	fileDigest := sha256.Sum256([]byte(path))
+	var data bytes.Buffer
+	field(&data, append([]byte("sha256:\x00"), fileDigest[:]...))
+	field(&data, append([]byte(path), 0))
+	templateDigest := sha1.Sum(data.Bytes())
+
+	var buf bytes.Buffer
+	binary.Write(&buf, le, uint32(IMAPCR))
+	buf.Write(templateDigest[:])
+	field(&buf, []byte("ima-ng"))
+	field(&buf, data.Bytes())
+	return buf.Bytes()
+}
+
+func TestCheckPCRDigest(t *testing.T) {
+	sels := []tpm2.PCRSelection{{Hash: tpm2.AlgSHA256, PCRs: []int{0, IMAPCR}}}
+	reference := func(name string, b byte) Reference {
+		return Reference{Name: name, PCRs: Banks{tpm2.AlgSHA256: {
+			0:      value(tpm2.AlgSHA256, b),
+			IMAPCR: value(tpm2.AlgSHA256, 0),
+		}}}
+	}
+	v := &Verifier{References: []Reference{reference("release-1", 1), reference("release-2", 2)}}
+
+	imaLog := append(append(imaEntry("boot_aggregate"), imaEntry("/usr/bin/bash")...), imaEntry("/tmp/late")...)
+	entries, err := ParseIMALog(imaLog)
+	if err != nil {
+		t.Fatalf("ParseIMALog() failed: %v", err)
+	}
+	pcr10, err := ReplayIMALog(entries, tpm2.AlgSHA256)
+	if err != nil {
+		t.Fatalf("ReplayIMALog() failed: %v", err)
+	}
+
+	// quoted returns the digest a quote over sels has with some PCR values
+	quoted := func(pcr0 byte, pcr10 []byte) []byte {
+		digest, err := PCRsDigest(sels, Banks{tpm2.AlgSHA256: {0: value(tpm2.AlgSHA256, pcr0), IMAPCR: pcr10}})
+		if err != nil {
+			t.Fatalf("PCRsDigest() failed: %v", err)
+		}
+		return digest
+	}
+	for _, tc := range []struct {
+		name      string
+		digest    []byte
+		overrides Banks
+		imaLog    []byte
+		matched   string // Empty if no reference matches
+		quotedIMA int    // Number of IMA entries the quote covers
+	}{
+		{"first reference", quoted(1, value(tpm2.AlgSHA256, 0)), nil, nil, "release-1", -1},
+		{"second reference", quoted(2, value(tpm2.AlgSHA256, 0)), nil, nil, "release-2", -1},
+		{"no reference", quoted(3, value(tpm2.AlgSHA256, 0)), nil, nil, "", -1},
+		{"override", quoted(3, value(tpm2.AlgSHA256, 0)),
+			Banks{tpm2.AlgSHA256: {0: value(tpm2.AlgSHA256, 3)}}, nil, "release-1", -1},
+		{"override of another bank", quoted(3, value(tpm2.AlgSHA256, 0)),
+			Banks{tpm2.AlgSHA1: {0: value(tpm2.AlgSHA1, 3)}}, nil, "", -1},
+		{"whole IMA log", quoted(2, pcr10[3]), nil, imaLog, "release-2", 3},
+		{"IMA log prefix", quoted(1, pcr10[1]), nil, imaLog, "release-1", 1},
+		{"empty IMA log prefix", quoted(1, pcr10[0]), nil, imaLog, "release-1", 0},
+		{"IMA log prefix and override", quoted(3, pcr10[2]),
+			Banks{tpm2.AlgSHA256: {0: value(tpm2.AlgSHA256, 3)}}, imaLog, "release-1", 2},
+		{"IMA log overrides PCR 10", quoted(1, value(tpm2.AlgSHA256, 0)),
+			Banks{tpm2.AlgSHA256: {IMAPCR: value(tpm2.AlgSHA256, 0)}}, imaLog, "release-1", 0},
+		{"no IMA log prefix", quoted(1, value(tpm2.AlgSHA256, 0xff)), nil, imaLog, "", -1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var ima *imaReplay
+			if tc.imaLog != nil {
+				if ima, err = replayIMA(sels, tc.imaLog); err != nil {
+					t.Fatalf("replayIMA() failed: %v", err)
+				}
+			}
+			matched, err := v.checkPCRDigest(sels, tc.digest, tc.overrides, ima)
+			if tc.matched == "" {
+				if !errors.Is(err, ErrPCRDigestMismatch) {
+					t.Errorf("checkPCRDigest() returned %v, expected %v", err, ErrPCRDigestMismatch)
+				}
+			} else if err != nil {
+				t.Errorf("checkPCRDigest() failed: %v", err)
+			} else if matched != tc.matched {
+				t.Errorf("checkPCRDigest() matched %q, expected %q", matched, tc.matched)
+			}
+			if ima != nil && ima.quoted != tc.quotedIMA {
+				t.Errorf("checkPCRDigest() found %d IMA entries quoted, expected %d", ima.quoted, tc.quotedIMA)
+			}
+		})
+	}
+
+	// A predicted digest, in place of reference values
+	predicted := &Verifier{PCRDigest: quoted(1, value(tpm2.AlgSHA256, 0))}
+	if _, err := predicted.checkPCRDigest(sels, quoted(1, value(tpm2.AlgSHA256, 0)), nil, nil); err != nil {
+		t.Errorf("checkPCRDigest() failed: %v", err)
+	}
+	if _, err := predicted.checkPCRDigest(sels, quoted(2, value(tpm2.AlgSHA256, 0)), nil, nil); !errors.Is(err, ErrPCRDigestMismatch) {
+		t.Errorf("checkPCRDigest() returned %v, expected %v", err, ErrPCRDigestMismatch)
+	}
+	if _, err := (&Verifier{}).checkPCRDigest(sels, quoted(1, value(tpm2.AlgSHA256, 0)), nil, nil); !errors.Is(err, ErrPCRDigestMismatch) {
+		t.Errorf("checkPCRDigest() with no reference values returned %v, expected %v", err, ErrPCRDigestMismatch)
+	}
+}
+
+// === Diagnostics =============================================================
+
+func TestDiffEvents(t *testing.T) {
+	event := func(data string) attest.Event {
+		digest := sha256.Sum256([]byte(data))
+		return attest.Event{Type: attest.EventType(0x0d), Data: []byte(data), Digest: digest[:]} // EV_IPL
+	}
+	events := func(data ...string) []attest.Event {
+		e := []attest.Event{}
+		for _, d := range data {
+			e = append(e, event(d))
+		}
+		return e
+	}
+	for _, tc := range []struct {
+		name     string
+		expected []attest.Event
+		got      []attest.Event
+		diffs    []EventDiff // Change and Position only
+	}{
+		{"same", events("a", "b", "c"), events("a", "b", "c"), nil},
+		{"none", events(), events(), nil},
+		{"changed", events("a", "b", "c"), events("a", "x", "c"),
+			[]EventDiff{{Change: EventChanged, Position: 1}}},
+		{"added", events("a", "b"), events("a", "b", "x"),
+			[]EventDiff{{Change: EventAdded, Position: 2}}},
+		{"added first", events("a", "b"), events("x", "a", "b"),
+			[]EventDiff{{Change: EventAdded, Position: 0}}},
+		{"missing", events("a", "b", "c"), events("a", "c"),
+			[]EventDiff{{Change: EventMissing, Position: 1}}},
+		{"all added", events(), events("x", "y"),
+			[]EventDiff{{Change: EventAdded, Position: 0}, {Change: EventAdded, Position: 1}}},
+		{"all missing", events("a", "b"), events(),
+			[]EventDiff{{Change: EventMissing, Position: 0}, {Change: EventMissing, Position: 1}}},
+		{"changed and missing", events("a", "b", "c", "d"), events("a", "x", "d"),
+			[]EventDiff{{Change: EventChanged, Position: 1}, {Change: EventMissing, Position: 2}}},
+		{"changed and added", events("a", "b", "d"), events("a", "x", "y", "d"),
+			[]EventDiff{{Change: EventChanged, Position: 1}, {Change: EventAdded, Position: 2}}},
+		{"moved", events("a", "b", "c"), events("b", "c", "a"),
+			[]EventDiff{{Change: EventMissing, Position: 0}, {Change: EventAdded, Position: 2}}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			diffs := diffEvents(tc.expected, tc.got)
+			if len(diffs) != len(tc.diffs) {
+				t.Fatalf("diffEvents() returned %+v, expected %+v", diffs, tc.diffs)
+			}
+			for k, d := range diffs {
+				if d.Change != tc.diffs[k].Change || d.Position != tc.diffs[k].Position {
+					t.Errorf("diffEvents() returned %+v, expected %+v", diffs, tc.diffs)
+				}
+				// Missing events are positioned in the expected events, others
+				// in the events got
+				if d.Change == EventMissing {
+					if !bytes.Equal(d.ExpectedDigest, tc.expected[d.Position].Digest) {
+						t.Errorf("diffEvents() reported expected digest 0x%x for event #%d",
+							d.ExpectedDigest, d.Position)
+					}
+				} else if !bytes.Equal(d.Digest, tc.got[d.Position].Digest) {
+					t.Errorf("diffEvents() reported digest 0x%x for event #%d", d.Digest, d.Position)
+				}
+			}
+		})
+	}
+}
+
+// === Nonce store =============================================================
+
+func TestNonceStore(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := NewNonceStore(time.Minute, 2)
+	s.now = func() time.Time { return now }
+	sels := []tpm2.PCRSelection{{Hash: tpm2.AlgSHA256, PCRs: []int{0, 7}}}
+
+	issue := func() []byte {
+		t.Helper()
+		challenge, err := s.Issue(sels)
+		if err != nil {
+			t.Fatalf("Issue() failed: %v", err)
+		}
+		if len(challenge.Nonce) != NonceSize || !challenge.Expires.Equal(now.Add(time.Minute)) {
+			t.Fatalf("Issue() returned a %d-byte nonce expiring at %s", len(challenge.Nonce), challenge.Expires)
+		}
+		return challenge.Nonce
+	}
+	redeem := func(nonce []byte, reason error) {
+		t.Helper()
+		challenge, err := s.Redeem(nonce)
+		if reason != nil {
+			if !errors.Is(err, reason) {
+				t.Errorf("Redeem() returned %v, expected %v", err, reason)
+			}
+			return
+		}
+		if err != nil {
+			t.Fatalf("Redeem() failed: %v", err)
+		}
+		if !bytes.Equal(challenge.Nonce, nonce) || !equalSelections(challenge.Selection, sels) {
+			t.Errorf("Redeem() returned the challenge of nonce 0x%x for %s", challenge.Nonce,
+				FormatSelection(challenge.Selection))
+		}
+	}
+
+	// A nonce is redeemed once
+	first := issue()
+	redeem(first, nil)
+	redeem(first, ErrUnknownNonce)
+
+	// Nonces expire after their TTL
+	second := issue()
+	now = now.Add(time.Minute + time.Second)
+	redeem(second, ErrNonceExpired)
+
+	// At most 2 challenges are outstanding, expired ones aside
+	third := issue()
+	issue()
+	if _, err := s.Issue(sels); !errors.Is(err, ErrTooManyChallenges) {
+		t.Errorf("Issue() returned %v, expected %v", err, ErrTooManyChallenges)
+	}
+	redeem(third, nil)
+	issue()
+	now = now.Add(2 * time.Minute)
+	issue()
+	issue()
+}